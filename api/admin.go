@@ -0,0 +1,34 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"log"
+	"stoo-kv/internal/auth"
+)
+
+// ReloadHandler re-reads the configurations like SIGHUP does. It requires an authenticated caller
+// with the admin permission and is disabled while no access tokens or certificate identities are
+// configured.
+func (h Handler) ReloadHandler(c *gin.Context) {
+	if !AuthConfigured(h.config) {
+		HandleError(c, StatusNotFound, "Reload endpoint is not enabled without authentication")
+		return
+	}
+	identity := auth.FromContext(c.Request.Context())
+	if identity == nil {
+		HandleUnauthorized(c, "Reloading the configurations requires authentication")
+		return
+	}
+	if !identity.Can(auth.PermissionAdmin) {
+		HandleForbidden(c, "Reloading the configurations requires the admin permission")
+		return
+	}
+	report, err := h.config.Reload()
+	if err != nil {
+		log.Printf("Failed to reload configurations: %v", err)
+		HandleGeneralError(c, err.Error())
+		return
+	}
+	log.Printf("Reloaded configurations: %v, restart required for %v", report.Reloaded, report.RestartRequired)
+	HandleSuccess(c, report)
+}
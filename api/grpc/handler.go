@@ -2,8 +2,9 @@ package grpc
 
 import (
 	"context"
-	"encoding/hex"
+	"errors"
 	"fmt"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
@@ -14,41 +15,76 @@ import (
 	"stoo-kv/api"
 	"stoo-kv/api/grpc/proto"
 	"stoo-kv/config"
+	"stoo-kv/internal/auth"
+	"stoo-kv/internal/certs"
+	"stoo-kv/internal/content"
 	"stoo-kv/internal/crypto"
+	"stoo-kv/internal/schema"
 	"stoo-kv/internal/store"
+	"stoo-kv/internal/webhook"
 )
 
 type Server struct {
-	storage store.Store
-	config  *config.Config
+	storage  store.Store
+	config   *config.Config
+	schemas  *schema.Registry
+	webhooks *webhook.Dispatcher
 	proto.UnimplementedKVServiceServer
 }
 
-func NewGrpcServer(storage store.Store, config *config.Config) *Server {
+func NewGrpcServer(storage store.Store, config *config.Config, dispatcher *webhook.Dispatcher) *Server {
 	return &Server{
-		config:  config,
-		storage: storage,
+		config:   config,
+		storage:  storage,
+		schemas:  schema.NewRegistry(storage),
+		webhooks: dispatcher,
 	}
 }
 func (s *Server) GetService(ctx context.Context, request *proto.GetRequest) (*proto.GetResponse, error) {
+	if err := checkNamespace(request.Namespace); err != nil {
+		return nil, err
+	}
+	if err := api.CheckReveal(s.config, auth.FromContext(ctx), request.Reveal); err != nil {
+		log.Printf("Secrets reveal denied: %v", err)
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
 	value, err := s.storage.Get(fmt.Sprintf("%s::%s::%s", request.Namespace, request.Profile, request.Key))
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, status.Error(codes.NotFound, "key not found from storage")
+	}
 	if err != nil {
-		message := fmt.Sprintf("Failed to read keys from storage: %v", err)
-		log.Printf(message)
-		return nil, status.Errorf(codes.Aborted, message)
+		log.Printf("Failed to read keys from storage: %v", err)
+		return nil, storageError(err)
 	}
-
-	if value == "" {
-		message := "key not found from storage"
-		log.Printf(message)
-		return nil, status.Errorf(codes.NotFound, message)
+	contentType, payload, err := api.ReadValue(s.storage, s.config, auth.FromContext(ctx), request.Namespace, request.Profile, request.Key, value, request.Raw, request.Reveal)
+	if errors.Is(err, api.ErrRevealDenied) {
+		log.Printf("Secrets reveal denied: %v", err)
+		return nil, status.Error(codes.PermissionDenied, err.Error())
 	}
-	value, err = api.CheckEncryption(value, s.config)
 	if err != nil {
-		log.Printf("Failed to decrypt the value: %v", err)
-		return nil, status.Errorf(codes.Aborted, "data decryption failed")
+		log.Printf("Failed to read the value: %v", err)
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+
+	if request.Path != "" && contentType != content.TypeJSON {
+		return nil, status.Errorf(codes.InvalidArgument, "path can only query %s values, not %s", content.TypeJSON, contentType)
+	}
+	switch {
+	case contentType == content.TypeBinary:
+		data, err := content.Bytes(contentType, payload)
+		if err != nil {
+			log.Printf("Failed to decode the value: %v", err)
+			return nil, status.Error(codes.DataLoss, err.Error())
+		}
+		return &proto.GetResponse{ContentType: contentType, BinaryData: data}, nil
+	case contentType == content.TypeJSON && request.Path != "":
+		data, err := content.Query(payload, request.Path)
+		if err != nil {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+		payload = string(data)
 	}
-	return &proto.GetResponse{Data: value}, nil
+	return &proto.GetResponse{Data: payload, ContentType: contentType}, nil
 }
 
 //func (s *Server) GetAllService(ctx context.Context, request *proto.GetAllRequest) (*proto.GetAllResponse, error) {
@@ -65,76 +101,179 @@ func (s *Server) GetService(ctx context.Context, request *proto.GetRequest) (*pr
 //}
 
 func (s *Server) GetServiceByNamespaceAndProfile(ctx context.Context, request *proto.GetByNamespaceAndProfileRequest) (*proto.GetByNamespaceAndProfileResponse, error) {
-	values, err := s.storage.GetByNameSpaceAndProfile(request.Namespace, request.Profile)
+	if err := checkNamespace(request.Namespace); err != nil {
+		return nil, err
+	}
+	if err := api.CheckReveal(s.config, auth.FromContext(ctx), request.Reveal); err != nil {
+		log.Printf("Secrets reveal denied: %v", err)
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	response := &proto.GetByNamespaceAndProfileResponse{}
+	values, err := s.list(request, response)
 	if err != nil {
-		message := fmt.Sprintf("Failed to read keys from storage: %v", err)
-		log.Printf(message)
-		return nil, status.Errorf(codes.Aborted, message)
+		log.Printf("Failed to read keys from storage: %v", err)
+		return nil, storageError(err)
 	}
 	if len(values) == 0 {
 		message := "keys not found from storage"
 		log.Printf(message)
 		return nil, status.Errorf(codes.NotFound, message)
 	}
-	return &proto.GetByNamespaceAndProfileResponse{Data: api.ParseValues(values, s.config)}, nil
+	values, response.Secrets = api.ReadValues(s.storage, s.config, auth.FromContext(ctx), request.Namespace, request.Profile, values, request.Raw, request.Reveal)
+	response.ContentTypes = make(map[string]string)
+	for k, v := range values {
+		contentType, payload := content.Decode(v)
+		if contentType != content.TypeText {
+			response.ContentTypes[k] = contentType
+		}
+		values[k] = payload
+	}
+	response.Data = values
+	return response, nil
+}
+
+func (s *Server) list(request *proto.GetByNamespaceAndProfileRequest, response *proto.GetByNamespaceAndProfileResponse) (map[string]string, error) {
+	if request.Prefix == "" && request.Glob == "" && request.Regex == "" && request.Limit == 0 && request.Cursor == "" && !request.Descending {
+		return s.storage.GetByNameSpaceAndProfile(request.Namespace, request.Profile)
+	}
+	options, err := api.NewListOptions(request.Prefix, request.Glob, request.Regex, int(request.Limit), request.Cursor, request.Descending)
+	if err != nil {
+		return nil, store.Invalid(err)
+	}
+	page, err := s.storage.List(request.Namespace, request.Profile, options)
+	if err != nil {
+		return nil, err
+	}
+	values, keys := api.PageValues(page)
+	response.Keys = keys
+	response.NextCursor = page.NextCursor
+	return values, nil
 }
 
 func (s *Server) SetKeyService(ctx context.Context, request *proto.SetKeyRequest) (*proto.SetKeyResponse, error) {
-	return s.set(request, false)
+	return s.set(ctx, request, false)
 }
 
 func (s *Server) SetSecretKeyService(ctx context.Context, request *proto.SetKeyRequest) (*proto.SetKeyResponse, error) {
-	return s.set(request, true)
+	return s.set(ctx, request, true)
 }
 
-func (s *Server) set(request *proto.SetKeyRequest, isSecret bool) (*proto.SetKeyResponse, error) {
-	value := request.Value
+func (s *Server) set(ctx context.Context, request *proto.SetKeyRequest, isSecret bool) (*proto.SetKeyResponse, error) {
+	if err := checkNamespace(request.Namespace); err != nil {
+		return nil, err
+	}
+	contentType, value, err := content.FromString(request.Value, request.ContentType)
+	if len(request.BinaryValue) > 0 {
+		contentType, value, err = content.FromBytes(request.BinaryValue)
+	}
+	if err != nil {
+		log.Printf("Failed to decode value: %v", err)
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	resolve := api.ReferenceResolver(s.storage, s.config, auth.FromContext(ctx), request.Namespace, request.Profile, request.Key)
+	if err := s.schemas.Validate(request.Namespace, request.Key, contentType, value, resolve); err != nil {
+		log.Printf("Failed to validate data: %v", err)
+		return nil, validationError(err)
+	}
 	if isSecret {
-		ciphertext, err := crypto.Encrypt([]byte(value), s.config.Application.EncryptKey)
+		sealed, err := crypto.Seal(value, s.config.Application.EncryptKey, s.config.Application.EncryptPrefix)
 		if err != nil {
 			log.Printf("Failed to encrypt data: %v", err)
 			return nil, status.Error(codes.Internal, err.Error())
 		}
-
-		encPrefix := s.config.Application.EncryptPrefix
-		if encPrefix == "" {
-			encPrefix = "{ENC} "
-		}
-
-		value = encPrefix + hex.EncodeToString(ciphertext)
+		value = sealed
 	}
-	if err := s.storage.Set(fmt.Sprintf("%s::%s::%s", request.Namespace, request.Profile, request.Key), value); err != nil {
+	if err := s.storage.Set(fmt.Sprintf("%s::%s::%s", request.Namespace, request.Profile, request.Key), content.Encode(contentType, value)); err != nil {
 		log.Printf("Failed to store data into storage: %v", err)
-		return nil, status.Error(codes.Aborted, err.Error())
+		return nil, storageError(err)
 	}
+	s.webhooks.Notify(api.NewEvent(ctx, webhook.EventKeySet, request.Namespace, request.Profile, request.Key, isSecret))
 	return &proto.SetKeyResponse{Data: "Data saved successfully"}, nil
 }
 
 func (s *Server) DeleteKeyService(ctx context.Context, request *proto.DeleteKeyRequest) (*proto.DeleteKeyResponse, error) {
-	if err := s.storage.Delete(fmt.Sprintf("%s::%s::%s", request.Namespace, request.Profile, request.Key)); err != nil {
+	if err := checkNamespace(request.Namespace); err != nil {
+		return nil, err
+	}
+	if request.Key == "" {
+		return nil, status.Error(codes.InvalidArgument, "key name cannot be empty")
+	}
+	key := fmt.Sprintf("%s::%s::%s", request.Namespace, request.Profile, request.Key)
+	event := api.NewEvent(ctx, webhook.EventKeyDeleted, request.Namespace, request.Profile, request.Key, false)
+	event.Secret = api.DeletesSecret(s.storage, s.config, s.webhooks, event, key)
+	err := s.storage.Delete(key)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, status.Error(codes.NotFound, "key not found from storage")
+	}
+	if err != nil {
 		log.Printf("Failed to remove data from storage: %v", err)
-		return nil, status.Error(codes.Aborted, err.Error())
+		return nil, storageError(err)
 	}
+	s.webhooks.Notify(event)
 	return &proto.DeleteKeyResponse{Data: "Key removed successfully"}, nil
 }
 
-func RunGrpcServer(cfg *config.Config, storage store.Store) error {
+func checkNamespace(namespace string) error {
+	if store.IsReserved(namespace) {
+		return status.Errorf(codes.PermissionDenied, "namespace %s is reserved", namespace)
+	}
+	return nil
+}
+
+// storageError converts an error of the storage into the code of its kind, like the REST status.
+func storageError(err error) error {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, store.ErrInvalid):
+		return validationError(err)
+	case errors.Is(err, store.ErrConflict):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, store.ErrUnavailable):
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+func validationError(err error) error {
+	var validationErr *schema.ValidationError
+	if !errors.As(err, &validationErr) {
+		if errors.Is(err, store.ErrInvalid) {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+		return storageError(err)
+	}
+	violations := make([]*errdetails.BadRequest_FieldViolation, 0, len(validationErr.Errors))
+	for _, fieldErr := range validationErr.Errors {
+		violations = append(violations, &errdetails.BadRequest_FieldViolation{Field: fieldErr.Field, Description: fieldErr.Message})
+	}
+	st, detailsErr := status.New(codes.InvalidArgument, err.Error()).WithDetails(&errdetails.BadRequest{FieldViolations: violations})
+	if detailsErr != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return st.Err()
+}
+
+func RunGrpcServer(cfg *config.Config, storage store.Store, dispatcher *webhook.Dispatcher) error {
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%s", cfg.Application.GrpcPort))
 	if err != nil {
 		return err
 	}
 	var options []grpc.ServerOption
 	if cfg.Application.GrpcUseTls {
-		creds, err := credentials.NewServerTLSFromFile(cfg.Application.GrpcServerCert, cfg.Application.GrpcServerKey)
+		reloader, err := certs.NewServerReloader(cfg, func(app *config.ApplicationConfig) (string, string) {
+			return app.GrpcServerCert, app.GrpcServerKey
+		})
 		if err != nil {
-			log.Fatalf("Failed to create grpc credentials: %v", err)
+			return fmt.Errorf("failed to create grpc credentials: %v", err)
 		}
-		options = []grpc.ServerOption{grpc.Creds(creds)}
+		options = []grpc.ServerOption{grpc.Creds(credentials.NewTLS(reloader.TLSConfig()))}
 	}
 
+	options = append(options, grpc.UnaryInterceptor(authenticate(auth.NewAuthenticator(cfg))))
 	s := grpc.NewServer(options...)
 	reflection.Register(s)
-	proto.RegisterKVServiceServer(s, NewGrpcServer(storage, cfg))
+	proto.RegisterKVServiceServer(s, NewGrpcServer(storage, cfg, dispatcher))
 	go func() {
 		if err := s.Serve(lis); err != nil {
 			log.Fatalf("Failed to start grpc server: %v", err)
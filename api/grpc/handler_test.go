@@ -0,0 +1,48 @@
+package grpc
+
+import (
+	"errors"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"stoo-kv/internal/provider"
+	"stoo-kv/internal/schema"
+	"stoo-kv/internal/store"
+	"syscall"
+	"testing"
+)
+
+func TestStorageError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		wantCode codes.Code
+		// wantViolations is the number of field violations in the details, when the code carries them.
+		wantViolations int
+	}{
+		{name: "not found", err: store.ErrNotFound, wantCode: codes.NotFound},
+		{name: "invalid", err: provider.Invalidf("invalid cursor"), wantCode: codes.InvalidArgument},
+		{name: "schema violation", err: &schema.ValidationError{Errors: []schema.FieldError{{Field: "port", Message: "not a valid int"}, {Field: "host", Message: "not declared"}}}, wantCode: codes.InvalidArgument, wantViolations: 2},
+		{name: "conflict", err: provider.Mark(errors.New("duplicate key"), store.ErrConflict), wantCode: codes.AlreadyExists},
+		{name: "unavailable", err: provider.Mark(syscall.ECONNREFUSED, store.ErrUnavailable), wantCode: codes.Unavailable},
+		{name: "circuit open", err: store.ErrCircuitOpen, wantCode: codes.Unavailable},
+		{name: "unexpected", err: errors.New("disk full"), wantCode: codes.Internal},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			st := status.Convert(storageError(test.err))
+			if st.Code() != test.wantCode {
+				t.Errorf("got code %v, want %v", st.Code(), test.wantCode)
+			}
+			violations := 0
+			for _, detail := range st.Details() {
+				if badRequest, ok := detail.(*errdetails.BadRequest); ok {
+					violations += len(badRequest.FieldViolations)
+				}
+			}
+			if violations != test.wantViolations {
+				t.Errorf("got %d field violations, want %d", violations, test.wantViolations)
+			}
+		})
+	}
+}
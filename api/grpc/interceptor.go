@@ -0,0 +1,51 @@
+package grpc
+
+import (
+	"context"
+	"crypto/x509"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"stoo-kv/internal/auth"
+)
+
+// authenticate resolves the caller identity from a bearer access token in the request
+// metadata, or else from the subject of a verified client certificate. Requests without either
+// proceed anonymously, while unknown tokens are rejected.
+func authenticate(authenticator *auth.Authenticator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		headers := md.Get("authorization")
+		if len(headers) == 0 {
+			if identity := authenticator.FromCertificate(peerCertificate(ctx)); identity != nil {
+				ctx = auth.WithIdentity(ctx, identity)
+			}
+			return handler(ctx, req)
+		}
+		token, ok := auth.BearerToken(headers[0])
+		if !ok {
+			return nil, status.Error(codes.Unauthenticated, "authorization metadata must be a bearer token")
+		}
+		identity := authenticator.FromToken(token)
+		if identity == nil {
+			return nil, status.Error(codes.Unauthenticated, "invalid access token")
+		}
+		return handler(auth.WithIdentity(ctx, identity), req)
+	}
+}
+
+// peerCertificate returns the verified client certificate of a TLS connection.
+func peerCertificate(ctx context.Context) *x509.Certificate {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 {
+		return nil
+	}
+	return tlsInfo.State.VerifiedChains[0][0]
+}
@@ -36,7 +36,7 @@ type KVServiceClient interface {
 	GetServiceByNamespaceAndProfile(ctx context.Context, in *GetByNamespaceAndProfileRequest, opts ...grpc.CallOption) (*GetByNamespaceAndProfileResponse, error)
 	//Set a plain key
 	SetKeyService(ctx context.Context, in *SetKeyRequest, opts ...grpc.CallOption) (*SetKeyResponse, error)
-	//Set a plain key
+	//Set a secret key
 	SetSecretKeyService(ctx context.Context, in *SetKeyRequest, opts ...grpc.CallOption) (*SetKeyResponse, error)
 	//Delete a key
 	DeleteKeyService(ctx context.Context, in *DeleteKeyRequest, opts ...grpc.CallOption) (*DeleteKeyResponse, error)
@@ -105,7 +105,7 @@ type KVServiceServer interface {
 	GetServiceByNamespaceAndProfile(context.Context, *GetByNamespaceAndProfileRequest) (*GetByNamespaceAndProfileResponse, error)
 	//Set a plain key
 	SetKeyService(context.Context, *SetKeyRequest) (*SetKeyResponse, error)
-	//Set a plain key
+	//Set a secret key
 	SetSecretKeyService(context.Context, *SetKeyRequest) (*SetKeyResponse, error)
 	//Delete a key
 	DeleteKeyService(context.Context, *DeleteKeyRequest) (*DeleteKeyResponse, error)
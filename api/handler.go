@@ -2,60 +2,129 @@ package api
 
 import (
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"log"
 	"net/http"
 	"stoo-kv/config"
+	"stoo-kv/internal/auth"
+	"stoo-kv/internal/content"
 	"stoo-kv/internal/crypto"
+	"stoo-kv/internal/schema"
+	"stoo-kv/internal/secrets"
 	"stoo-kv/internal/store"
+	"stoo-kv/internal/webhook"
 )
 
 type Handler struct {
-	storage store.Store
-	config  *config.Config
+	storage  store.Store
+	config   *config.Config
+	schemas  *schema.Registry
+	secrets  *secrets.Engine
+	webhooks *webhook.Dispatcher
 }
 
 type KV struct {
-	Key   string `json:"key"`
-	Value string `json:"value"`
+	Key         string          `json:"key"`
+	Value       json.RawMessage `json:"value"`
+	ContentType string          `json:"content_type,omitempty"`
 }
 
-func NewHandler(storage store.Store, config *config.Config) *Handler {
+func NewHandler(storage store.Store, config *config.Config, engine *secrets.Engine, dispatcher *webhook.Dispatcher) *Handler {
 	return &Handler{
-		config:  config,
-		storage: storage,
+		config:   config,
+		storage:  storage,
+		schemas:  schema.NewRegistry(storage),
+		secrets:  engine,
+		webhooks: dispatcher,
 	}
 }
 func (h Handler) GetHandler(c *gin.Context) {
 	namespace := c.Param("namespace")
 	profile := c.Param("profile")
 	key := c.Param("key")
+	if !CheckNamespace(c, namespace) {
+		return
+	}
+	if err := CheckReveal(h.config, auth.FromContext(c.Request.Context()), IsReveal(c)); err != nil {
+		log.Printf("Secrets reveal denied: %v", err)
+		HandleForbidden(c, err.Error())
+		return
+	}
 	value, err := h.storage.Get(fmt.Sprintf("%s::%s::%s", namespace, profile, key))
+	if errors.Is(err, store.ErrNotFound) {
+		HandleError(c, StatusNotFound, "Data not found from storage")
+		return
+	}
 	if err != nil {
 		log.Printf("Failed to read key from storage: %v", err)
-		HandleGeneralError(c, err.Error())
+		HandleStorageError(c, err)
 		return
 	}
-	if value == "" {
-		HandleError(c, StatusNotFound, "Data not found from storage")
+
+	contentType, payload, err := ReadValue(h.storage, h.config, auth.FromContext(c.Request.Context()), namespace, profile, key, value, IsRaw(c), IsReveal(c))
+	if errors.Is(err, ErrRevealDenied) {
+		log.Printf("Secrets reveal denied: %v", err)
+		HandleForbidden(c, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to read the value: %v", err)
+		HandleGeneralError(c, err.Error())
 		return
 	}
 
-	value, err = CheckEncryption(value, h.config)
+	var data any
+	if path := c.Query("path"); path != "" {
+		if contentType != content.TypeJSON {
+			HandleInvalid(c, fmt.Sprintf("path can only query %s values, not %s", content.TypeJSON, contentType))
+			return
+		}
+		data, err = content.Query(payload, path)
+	} else {
+		data, err = content.Render(contentType, payload)
+	}
 	if err != nil {
-		log.Printf("Failed to decrypt the value: %v", err)
+		log.Printf("Failed to render the value: %v", err)
 		HandleGeneralError(c, err.Error())
 		return
 	}
-	HandleSuccess(c, value)
+	c.Header(ContentTypeHeader, contentType)
+	HandleSuccess(c, data)
 }
 
 func (h Handler) GetByNamespaceAndProfileHandler(c *gin.Context) {
 	namespace := c.Param("namespace")
 	profile := c.Param("profile")
-	values, err := h.storage.GetByNameSpaceAndProfile(namespace, profile)
-	h.valuesProcessor(c, values, err)
+	if !CheckNamespace(c, namespace) {
+		return
+	}
+	if err := CheckReveal(h.config, auth.FromContext(c.Request.Context()), IsReveal(c)); err != nil {
+		log.Printf("Secrets reveal denied: %v", err)
+		HandleForbidden(c, err.Error())
+		return
+	}
+	options, paged, err := ParseListOptions(c)
+	if err != nil {
+		log.Printf("Invalid list options: %v", err)
+		HandleInvalid(c, err.Error())
+		return
+	}
+	if !paged {
+		values, err := h.storage.GetByNameSpaceAndProfile(namespace, profile)
+		h.valuesProcessor(c, namespace, profile, values, err, gin.H{})
+		return
+	}
+
+	page, err := h.storage.List(namespace, profile, options)
+	if err != nil {
+		h.valuesProcessor(c, namespace, profile, nil, err, nil)
+		return
+	}
+	values, keys := PageValues(page)
+	h.valuesProcessor(c, namespace, profile, values, nil, gin.H{"keys": keys, "next_cursor": page.NextCursor})
 }
 
 //
@@ -75,42 +144,53 @@ func (h Handler) SetSecretHandler(c *gin.Context) {
 func (h Handler) set(c *gin.Context, isSecret bool) {
 	namespace := c.Param("namespace")
 	profile := c.Param("profile")
+	if !CheckNamespace(c, namespace) {
+		return
+	}
 	kv := &KV{}
 	if err := c.ShouldBindJSON(kv); err != nil {
 		log.Printf("Failed to decode data: %v", err)
-		HandleGeneralError(c, err.Error())
+		HandleInvalid(c, err.Error())
 		return
 	}
 
-	value := kv.Value
 	key := kv.Key
 	if key == "" {
 		log.Printf("Key cannot be empty")
-		HandleGeneralError(c, "Key name cannot be empty")
+		HandleInvalid(c, "Key name cannot be empty")
+		return
+	}
+
+	contentType, value, err := content.FromJSON(kv.Value, kv.ContentType)
+	if err != nil {
+		log.Printf("Failed to decode value: %v", err)
+		HandleInvalid(c, err.Error())
+		return
+	}
+
+	resolve := ReferenceResolver(h.storage, h.config, auth.FromContext(c.Request.Context()), namespace, profile, key)
+	if err := h.schemas.Validate(namespace, key, contentType, value, resolve); err != nil {
+		log.Printf("Failed to validate data: %v", err)
+		HandleValidationError(c, err)
 		return
 	}
 
 	if isSecret {
-		ciphertext, err := crypto.Encrypt([]byte(value), h.config.Application.EncryptKey)
+		sealed, err := crypto.Seal(value, h.config.Application.EncryptKey, h.config.Application.EncryptPrefix)
 		if err != nil {
 			log.Printf("Failed to encrypt data: %v", err)
 			HandleGeneralError(c, err.Error())
 			return
 		}
-
-		encPrefix := h.config.Application.EncryptPrefix
-		if encPrefix == "" {
-			encPrefix = "{ENC} "
-		}
-
-		value = encPrefix + hex.EncodeToString(ciphertext)
+		value = sealed
 	}
 
-	if err := h.storage.Set(fmt.Sprintf("%s::%s::%s", namespace, profile, key), value); err != nil {
+	if err := h.storage.Set(fmt.Sprintf("%s::%s::%s", namespace, profile, key), content.Encode(contentType, value)); err != nil {
 		log.Printf("Failed to store data into storage: %v", err)
-		HandleGeneralError(c, err.Error())
+		HandleStorageError(c, err)
 		return
 	}
+	h.webhooks.Notify(NewEvent(c.Request.Context(), webhook.EventKeySet, namespace, profile, key, isSecret))
 	HandleSuccess(c, "Key set successfully")
 }
 
@@ -118,11 +198,28 @@ func (h Handler) DeleteHandler(c *gin.Context) {
 	namespace := c.Param("namespace")
 	profile := c.Param("profile")
 	key := c.Request.URL.Query().Get("key")
-	if err := h.storage.Delete(fmt.Sprintf("%s::%s::%s", namespace, profile, key)); err != nil {
+	if !CheckNamespace(c, namespace) {
+		return
+	}
+	if key == "" {
+		log.Printf("Key cannot be empty")
+		HandleInvalid(c, "Key name cannot be empty")
+		return
+	}
+	fullKey := fmt.Sprintf("%s::%s::%s", namespace, profile, key)
+	event := NewEvent(c.Request.Context(), webhook.EventKeyDeleted, namespace, profile, key, false)
+	event.Secret = DeletesSecret(h.storage, h.config, h.webhooks, event, fullKey)
+	err := h.storage.Delete(fullKey)
+	if errors.Is(err, store.ErrNotFound) {
+		HandleError(c, StatusNotFound, "Data not found from storage")
+		return
+	}
+	if err != nil {
 		log.Printf("Failed to remove data from storage: %v", err)
-		HandleGeneralError(c, err.Error())
+		HandleStorageError(c, err)
 		return
 	}
+	h.webhooks.Notify(event)
 	HandleSuccess(c, "Key removed successfully")
 }
 
@@ -130,7 +227,7 @@ func (h Handler) EncryptHandler(c *gin.Context) {
 	data, err := c.GetRawData()
 	if err != nil {
 		log.Printf("Failed to parse data: %v", err)
-		HandleGeneralError(c, err.Error())
+		HandleInvalid(c, err.Error())
 		return
 	}
 
@@ -144,26 +241,30 @@ func (h Handler) EncryptHandler(c *gin.Context) {
 }
 
 func (h Handler) DecryptHandler(c *gin.Context) {
+	if !h.config.Current().EnableDecryptEndpoint {
+		HandleError(c, StatusNotFound, "Decrypt endpoint is not enabled")
+		return
+	}
 	data, err := c.GetRawData()
 	if err != nil {
 		log.Printf("Failed to parse data: %v", err)
-		HandleGeneralError(c, err.Error())
+		HandleInvalid(c, err.Error())
 		return
 	}
 
 	plaintext, err := crypto.Decrypt(data, h.config.Application.EncryptKey)
 	if err != nil {
 		log.Printf("Failed to decrypt data: %v", err)
-		HandleGeneralError(c, err.Error())
+		HandleInvalid(c, err.Error())
 		return
 	}
 	c.String(http.StatusOK, string(plaintext))
 }
 
-func (h Handler) valuesProcessor(c *gin.Context, values map[string]string, err error) {
+func (h Handler) valuesProcessor(c *gin.Context, namespace, profile string, values map[string]string, err error, meta gin.H) {
 	if err != nil {
 		log.Printf("Failed to read keys from storage: %v", err)
-		HandleGeneralError(c, err.Error())
+		HandleStorageError(c, err)
 		return
 	}
 	if len(values) == 0 {
@@ -172,5 +273,11 @@ func (h Handler) valuesProcessor(c *gin.Context, values map[string]string, err e
 		return
 	}
 
-	HandleSuccess(c, ParseValues(values, h.config))
+	values, secrets := ReadValues(h.storage, h.config, auth.FromContext(c.Request.Context()), namespace, profile, values, IsRaw(c), IsReveal(c))
+	renderedValues, contentTypes := RenderValues(values)
+	if len(contentTypes) > 0 {
+		meta["content_types"] = contentTypes
+	}
+	meta["secrets"] = secrets
+	HandleSuccessWithMeta(c, renderedValues, meta)
 }
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"errors"
+	"reflect"
+	"stoo-kv/config"
+	"stoo-kv/internal/auth"
+	"stoo-kv/internal/crypto"
+	"stoo-kv/internal/provider"
+	"testing"
+)
+
+const testEncryptKey = "0123456789abcdef0123456789abcdef"
+
+func maskingConfig(masking string) *config.Config {
+	return &config.Config{Application: &config.ApplicationConfig{EncryptKey: testEncryptKey, SecretMasking: masking}}
+}
+
+// maskingValues stores a secret, a value referencing it and a plain value in app::prod.
+func maskingValues(t *testing.T) (*provider.Memory, map[string]string) {
+	t.Helper()
+	sealed, err := crypto.Seal("s3cret", testEncryptKey, "")
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	values := map[string]string{
+		"password": sealed,
+		"url":      "postgres://app:${password}@db",
+		"host":     "db",
+	}
+	storage := provider.NewMemory()
+	for key, value := range values {
+		if err := storage.Set("app::prod::"+key, value); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+	}
+	return storage, values
+}
+
+func TestReadValuesMasking(t *testing.T) {
+	tests := []struct {
+		name    string
+		masking string
+		reveal  bool
+		want    map[string]string
+	}{
+		{
+			name:    "masking disabled",
+			masking: config.SecretMaskingNone,
+			want:    map[string]string{"password": "s3cret", "url": "postgres://app:s3cret@db", "host": "db"},
+		},
+		{
+			name:    "secrets masked",
+			masking: config.SecretMaskingMask,
+			want:    map[string]string{"password": MaskedSecret, "url": "postgres://app:" + MaskedSecret + "@db", "host": "db"},
+		},
+		{
+			name:    "secrets masked as references",
+			masking: config.SecretMaskingReference,
+			want:    map[string]string{"password": "secret://app/prod/password", "url": "postgres://app:secret://app/prod/password@db", "host": "db"},
+		},
+		{
+			name:    "secrets revealed",
+			masking: config.SecretMaskingMask,
+			reveal:  true,
+			want:    map[string]string{"password": "s3cret", "url": "postgres://app:s3cret@db", "host": "db"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			storage, values := maskingValues(t)
+			cfg := maskingConfig(test.masking)
+			got, secrets := ReadValues(storage, cfg, nil, "app", "prod", values, false, test.reveal)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+			if !reflect.DeepEqual(secrets, []string{"password"}) {
+				t.Errorf("got secrets %v, want [password]", secrets)
+			}
+			if !IsSecret(values["password"], cfg) {
+				t.Errorf("ReadValues changed the values it was given")
+			}
+
+			for key, want := range test.want {
+				_, payload, err := ReadValue(storage, cfg, nil, "app", "prod", key, values[key], false, test.reveal)
+				if err != nil {
+					t.Fatalf("ReadValue %s: %v", key, err)
+				}
+				if payload != want {
+					t.Errorf("ReadValue %s: got %q, want %q", key, payload, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckReveal(t *testing.T) {
+	revealer := &auth.Identity{Name: "ops", Permissions: []string{auth.PermissionRevealSecrets}}
+	reader := &auth.Identity{Name: "dev"}
+	tests := []struct {
+		name     string
+		masking  string
+		identity *auth.Identity
+		reveal   bool
+		wantErr  error
+	}{
+		{name: "not revealing", masking: config.SecretMaskingMask, identity: reader},
+		{name: "revealing with the permission", masking: config.SecretMaskingMask, identity: revealer, reveal: true},
+		{name: "revealing without the permission", masking: config.SecretMaskingMask, identity: reader, reveal: true, wantErr: ErrRevealDenied},
+		{name: "revealing anonymously", masking: config.SecretMaskingReference, reveal: true, wantErr: ErrRevealDenied},
+		{name: "nothing to reveal without masking", masking: config.SecretMaskingNone, identity: reader, reveal: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := CheckReveal(maskingConfig(test.masking), test.identity, test.reveal); !errors.Is(err, test.wantErr) {
+				t.Errorf("got %v, want %v", err, test.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"fmt"
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+	"stoo-kv/config"
+	"stoo-kv/internal/auth"
+	"strings"
+	"sync/atomic"
+)
+
+// Authenticate resolves the caller identity from a bearer access token, or else from the subject
+// of a verified client certificate. Requests without either proceed anonymously, while unknown
+// tokens are rejected.
+func Authenticate(authenticator *auth.Authenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.GetHeader("Authorization")
+		if header == "" {
+			if c.Request.TLS != nil && len(c.Request.TLS.VerifiedChains) > 0 {
+				if identity := authenticator.FromCertificate(c.Request.TLS.VerifiedChains[0][0]); identity != nil {
+					c.Request = c.Request.WithContext(auth.WithIdentity(c.Request.Context(), identity))
+				}
+			}
+			c.Next()
+			return
+		}
+		token, ok := auth.BearerToken(header)
+		if !ok {
+			HandleUnauthorized(c, "Authorization header must be a bearer token")
+			return
+		}
+		identity := authenticator.FromToken(token)
+		if identity == nil {
+			HandleUnauthorized(c, "Invalid access token")
+			return
+		}
+		c.Request = c.Request.WithContext(auth.WithIdentity(c.Request.Context(), identity))
+		c.Next()
+	}
+}
+
+// RequirePermission rejects callers granted none of the permissions once access tokens or
+// certificate identities are configured.
+func RequirePermission(cfg *config.Config, permissions ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !AuthConfigured(cfg) {
+			c.Next()
+			return
+		}
+		identity := auth.FromContext(c.Request.Context())
+		for _, permission := range permissions {
+			if identity.Can(permission) {
+				c.Next()
+				return
+			}
+		}
+		HandleForbidden(c, fmt.Sprintf("Permission %s is required", strings.Join(permissions, " or ")))
+	}
+}
+
+// Cors applies the CORS policy of cors_allowed_origins, all origins when it is empty, and follows
+// its changes on configuration reloads.
+func Cors(cfg *config.Config) gin.HandlerFunc {
+	var handler atomic.Value
+	build := func(app *config.ApplicationConfig) {
+		corsConfig := cors.DefaultConfig()
+		if len(app.CorsAllowedOrigins) == 0 {
+			corsConfig.AllowAllOrigins = true
+		} else {
+			corsConfig.AllowOrigins = app.CorsAllowedOrigins
+		}
+		corsConfig.AddAllowHeaders("Authorization")
+		handler.Store(cors.New(corsConfig))
+	}
+	build(cfg.Current())
+	cfg.OnReload(build)
+	return func(c *gin.Context) {
+		handler.Load().(gin.HandlerFunc)(c)
+	}
+}
@@ -1,32 +1,72 @@
 package api
 
 import (
-	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/pkg/errors"
 	"net"
+	"net/http"
 	"stoo-kv/config"
+	"stoo-kv/internal/auth"
+	"stoo-kv/internal/certs"
+	"stoo-kv/internal/secrets"
 	"stoo-kv/internal/store"
+	"stoo-kv/internal/webhook"
 )
 
-func InitializeRoutes(storage store.Store, cfg *config.Config) error {
+func InitializeRoutes(storage store.Store, cfg *config.Config, engine *secrets.Engine, dispatcher *webhook.Dispatcher) error {
 	gin.SetMode(cfg.Application.ServerLogLevel)
 	r := gin.Default()
-	r.Use(cors.Default())
+	cfg.OnReload(func(app *config.ApplicationConfig) {
+		gin.SetMode(app.ServerLogLevel)
+	})
+	r.Use(Cors(cfg))
+	r.Use(Authenticate(auth.NewAuthenticator(cfg)))
 
 	if err := r.SetTrustedProxies(nil); err != nil {
 		return errors.Wrapf(err, "failed to set trusted proxies")
 	}
-	handler := NewHandler(storage, cfg)
+	handler := NewHandler(storage, cfg, engine, dispatcher)
 	r.GET("/stoo-kv/:namespace/:profile/:key", handler.GetHandler)
 	r.GET("/stoo-kv/:namespace/:profile", handler.GetByNamespaceAndProfileHandler)
 	//r.GET("/stoo-kv", handler.GetAllHandler)
 	r.POST("/stoo-kv/:namespace/:profile", handler.SetHandler)
 	r.POST("/stoo-kv/secrets/:namespace/:profile", handler.SetSecretHandler)
+	r.POST("/stoo-kv/_/secrets/generate/:namespace/:profile", handler.GenerateSecretHandler)
+	manageSecrets := RequirePermission(cfg, auth.PermissionAdmin, auth.PermissionRevealSecrets)
+	r.GET("/stoo-kv/_/secrets/leases/:namespace/:profile", manageSecrets, handler.GetLeasesHandler)
+	r.PUT("/stoo-kv/_/secrets/leases/:namespace/:profile", manageSecrets, handler.RenewLeaseHandler)
+	r.POST("/stoo-kv/_/secrets/leases/:namespace/:profile", manageSecrets, handler.RotateLeaseHandler)
+	r.DELETE("/stoo-kv/_/secrets/leases/:namespace/:profile", manageSecrets, handler.RevokeLeaseHandler)
+	r.GET("/stoo-kv/_/secrets/rotations/:namespace/:profile", manageSecrets, handler.GetRotationsHandler)
+	r.POST("/stoo-kv/_/secrets/rotations/:namespace/:profile", manageSecrets, handler.SetRotationHandler)
+	r.PUT("/stoo-kv/_/secrets/rotations/:namespace/:profile", manageSecrets, handler.RotateNowHandler)
+	r.DELETE("/stoo-kv/_/secrets/rotations/:namespace/:profile", manageSecrets, handler.DeleteRotationHandler)
 	r.DELETE("/stoo-kv/:namespace/:profile", handler.DeleteHandler)
+	r.GET("/stoo-kv/_/snapshots/:namespace/:profile", handler.GetSnapshotHandler)
+	r.GET("/stoo-kv/_/schemas/:namespace", handler.GetSchemaHandler)
+	r.POST("/stoo-kv/_/schemas/:namespace", handler.SetSchemaHandler)
+	r.DELETE("/stoo-kv/_/schemas/:namespace", handler.DeleteSchemaHandler)
+	manageWebhooks := RequirePermission(cfg, auth.PermissionManageWebhooks)
+	r.GET("/stoo-kv/_/webhooks", manageWebhooks, handler.GetSubscriptionsHandler)
+	r.POST("/stoo-kv/_/webhooks", manageWebhooks, handler.SubscribeHandler)
+	r.GET("/stoo-kv/_/webhooks/:id", manageWebhooks, handler.GetSubscriptionHandler)
+	r.DELETE("/stoo-kv/_/webhooks/:id", manageWebhooks, handler.UnsubscribeHandler)
+	r.GET("/stoo-kv/_/webhooks/:id/deliveries", manageWebhooks, handler.GetDeliveriesHandler)
+	r.GET("/stoo-kv/_/webhooks/:id/dead-letters", manageWebhooks, handler.GetDeadLettersHandler)
+	r.POST("/stoo-kv/_/webhooks/:id/dead-letters/:delivery", manageWebhooks, handler.RedeliverHandler)
 	r.POST("/stoo-kv/encrypt", handler.EncryptHandler)
-	if cfg.Application.EnableDecryptEndpoint {
-		r.POST("/stoo-kv/decrypt", handler.DecryptHandler)
+	r.POST("/stoo-kv/decrypt", handler.DecryptHandler)
+	r.POST("/stoo-kv/_/admin/reload", handler.ReloadHandler)
+	address := net.JoinHostPort(cfg.Application.ServerBindingHost, cfg.Application.ServerPort)
+	if !cfg.Application.ServerUseTls {
+		return r.Run(address)
 	}
-	return r.Run(net.JoinHostPort(cfg.Application.ServerBindingHost, cfg.Application.ServerPort))
+	reloader, err := certs.NewServerReloader(cfg, func(app *config.ApplicationConfig) (string, string) {
+		return app.ServerCert, app.ServerKey
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to create server credentials")
+	}
+	server := &http.Server{Addr: address, Handler: r, TLSConfig: reloader.TLSConfig()}
+	return server.ListenAndServeTLS("", "")
 }
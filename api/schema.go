@@ -0,0 +1,64 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"log"
+	"stoo-kv/internal/schema"
+)
+
+func (h Handler) GetSchemaHandler(c *gin.Context) {
+	namespace := c.Param("namespace")
+	if !CheckNamespace(c, namespace) {
+		return
+	}
+	s, err := h.schemas.Get(namespace)
+	if err != nil {
+		log.Printf("Failed to read schema from storage: %v", err)
+		HandleStorageError(c, err)
+		return
+	}
+	if s == nil {
+		HandleError(c, StatusNotFound, "Schema not found from storage")
+		return
+	}
+	HandleSuccess(c, s)
+}
+
+func (h Handler) SetSchemaHandler(c *gin.Context) {
+	namespace := c.Param("namespace")
+	if !CheckNamespace(c, namespace) {
+		return
+	}
+	data, err := c.GetRawData()
+	if err != nil {
+		log.Printf("Failed to parse data: %v", err)
+		HandleInvalid(c, err.Error())
+		return
+	}
+
+	s, err := schema.Parse(data)
+	if err != nil {
+		log.Printf("Failed to parse schema: %v", err)
+		HandleValidationError(c, err)
+		return
+	}
+	if err := h.schemas.Set(namespace, s); err != nil {
+		log.Printf("Failed to store schema into storage: %v", err)
+		HandleStorageError(c, err)
+		return
+	}
+	HandleSuccess(c, "Schema set successfully")
+}
+
+func (h Handler) DeleteSchemaHandler(c *gin.Context) {
+	namespace := c.Param("namespace")
+	if !CheckNamespace(c, namespace) {
+		return
+	}
+	if err := h.schemas.Delete(namespace); err != nil {
+		log.Printf("Failed to remove schema from storage: %v", err)
+		HandleStorageError(c, err)
+		return
+	}
+	HandleSuccess(c, "Schema removed successfully")
+}
@@ -0,0 +1,181 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"log"
+	"stoo-kv/internal/auth"
+	"stoo-kv/internal/secrets"
+)
+
+func (h Handler) GenerateSecretHandler(c *gin.Context) {
+	namespace := c.Param("namespace")
+	profile := c.Param("profile")
+	if !CheckNamespace(c, namespace) {
+		return
+	}
+	spec := &secrets.Spec{}
+	if err := c.ShouldBindJSON(spec); err != nil {
+		log.Printf("Failed to decode data: %v", err)
+		HandleInvalid(c, err.Error())
+		return
+	}
+
+	lease, values, err := h.secrets.Generate(namespace, profile, spec)
+	if err != nil {
+		log.Printf("Failed to generate secret: %v", err)
+		HandleStorageError(c, err)
+		return
+	}
+	HandleSuccess(c, h.leaseResponse(c, lease, values))
+}
+
+func (h Handler) GetLeasesHandler(c *gin.Context) {
+	namespace := c.Param("namespace")
+	profile := c.Param("profile")
+	if !CheckNamespace(c, namespace) {
+		return
+	}
+	leases, err := h.secrets.Leases(namespace, profile)
+	if err != nil {
+		log.Printf("Failed to read secret leases: %v", err)
+		HandleStorageError(c, err)
+		return
+	}
+	if len(leases) == 0 {
+		HandleError(c, StatusNotFound, "Leases not found from storage")
+		return
+	}
+	HandleSuccess(c, leases)
+}
+
+func (h Handler) RenewLeaseHandler(c *gin.Context) {
+	if !CheckNamespace(c, c.Param("namespace")) {
+		return
+	}
+	lease, err := h.secrets.Renew(c.Param("namespace"), c.Param("profile"), c.Query("key"))
+	h.leaseProcessor(c, lease, nil, err)
+}
+
+func (h Handler) RotateLeaseHandler(c *gin.Context) {
+	if !CheckNamespace(c, c.Param("namespace")) {
+		return
+	}
+	lease, values, err := h.secrets.Rotate(c.Param("namespace"), c.Param("profile"), c.Query("key"))
+	h.leaseProcessor(c, lease, values, err)
+}
+
+func (h Handler) RevokeLeaseHandler(c *gin.Context) {
+	if !CheckNamespace(c, c.Param("namespace")) {
+		return
+	}
+	revoked, err := h.secrets.Revoke(c.Param("namespace"), c.Param("profile"), c.Query("key"))
+	if err != nil {
+		log.Printf("Failed to revoke secret lease: %v", err)
+		HandleStorageError(c, err)
+		return
+	}
+	if !revoked {
+		HandleError(c, StatusNotFound, "Lease not found from storage")
+		return
+	}
+	HandleSuccess(c, "Lease revoked successfully")
+}
+
+func (h Handler) leaseProcessor(c *gin.Context, lease *secrets.Lease, values map[string]string, err error) {
+	if err != nil {
+		log.Printf("Failed to update secret lease: %v", err)
+		HandleStorageError(c, err)
+		return
+	}
+	if lease == nil {
+		HandleError(c, StatusNotFound, "Lease not found from storage")
+		return
+	}
+	HandleSuccess(c, h.leaseResponse(c, lease, values))
+}
+
+// leaseResponse returns generated values along with their lease only to callers that may reveal
+// secrets, as reading them would require.
+func (h Handler) leaseResponse(c *gin.Context, lease *secrets.Lease, values map[string]string) gin.H {
+	if values == nil {
+		return gin.H{"lease": lease}
+	}
+	if err := CheckReveal(h.config, auth.FromContext(c.Request.Context()), true); err != nil {
+		log.Printf("Generated values withheld: %v", err)
+		return gin.H{"lease": lease}
+	}
+	return gin.H{"lease": lease, "values": values}
+}
+
+func (h Handler) SetRotationHandler(c *gin.Context) {
+	namespace := c.Param("namespace")
+	profile := c.Param("profile")
+	if !CheckNamespace(c, namespace) {
+		return
+	}
+	policy := &secrets.RotationPolicy{}
+	if err := c.ShouldBindJSON(policy); err != nil {
+		log.Printf("Failed to decode data: %v", err)
+		HandleInvalid(c, err.Error())
+		return
+	}
+
+	policy, err := h.secrets.SetRotation(namespace, profile, policy)
+	if err != nil {
+		log.Printf("Failed to set rotation policy: %v", err)
+		HandleStorageError(c, err)
+		return
+	}
+	HandleSuccess(c, policy)
+}
+
+func (h Handler) GetRotationsHandler(c *gin.Context) {
+	if !CheckNamespace(c, c.Param("namespace")) {
+		return
+	}
+	policies, err := h.secrets.Rotations(c.Param("namespace"), c.Param("profile"))
+	if err != nil {
+		log.Printf("Failed to read rotation policies: %v", err)
+		HandleStorageError(c, err)
+		return
+	}
+	if len(policies) == 0 {
+		HandleError(c, StatusNotFound, "Rotation policies not found from storage")
+		return
+	}
+	HandleSuccess(c, policies)
+}
+
+func (h Handler) RotateNowHandler(c *gin.Context) {
+	if !CheckNamespace(c, c.Param("namespace")) {
+		return
+	}
+	policy, err := h.secrets.RotateNow(c.Param("namespace"), c.Param("profile"), c.Query("key"))
+	if err != nil {
+		log.Printf("Failed to rotate secret: %v", err)
+		HandleStorageError(c, err)
+		return
+	}
+	if policy == nil {
+		HandleError(c, StatusNotFound, "Rotation policy not found from storage")
+		return
+	}
+	HandleSuccess(c, policy)
+}
+
+func (h Handler) DeleteRotationHandler(c *gin.Context) {
+	if !CheckNamespace(c, c.Param("namespace")) {
+		return
+	}
+	deleted, err := h.secrets.DeleteRotation(c.Param("namespace"), c.Param("profile"), c.Query("key"))
+	if err != nil {
+		log.Printf("Failed to remove rotation policy: %v", err)
+		HandleStorageError(c, err)
+		return
+	}
+	if !deleted {
+		HandleError(c, StatusNotFound, "Rotation policy not found from storage")
+		return
+	}
+	HandleSuccess(c, "Rotation policy removed successfully")
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"stoo-kv/internal/auth"
+	"stoo-kv/internal/bundle"
+	"stoo-kv/internal/content"
+	"strings"
+)
+
+// GetSnapshotHandler returns the signed bundle of a namespace and profile for the caller to keep,
+// with secrets, and values embedding them, encrypted with the caller's snapshot key. A request
+// whose If-None-Match holds the current ETag gets 304 Not Modified.
+func (h Handler) GetSnapshotHandler(c *gin.Context) {
+	namespace := c.Param("namespace")
+	profile := c.Param("profile")
+	if !CheckNamespace(c, namespace) {
+		return
+	}
+	identity := auth.FromContext(c.Request.Context())
+	if identity == nil || identity.SnapshotKey == "" {
+		HandleForbidden(c, "A snapshot_key is required to fetch snapshots")
+		return
+	}
+	if err := CheckReveal(h.config, identity, true); err != nil {
+		log.Printf("Snapshot denied: %v", err)
+		HandleForbidden(c, err.Error())
+		return
+	}
+	values, err := h.storage.GetByNameSpaceAndProfile(namespace, profile)
+	if err != nil {
+		log.Printf("Failed to read keys from storage: %v", err)
+		HandleStorageError(c, err)
+		return
+	}
+	if len(values) == 0 {
+		HandleError(c, StatusNotFound, "Keys not found from storage")
+		return
+	}
+
+	plain, secretKeys := ReadValues(h.storage, h.config, identity, namespace, profile, values, false, true)
+	secretKeys = append(secretKeys, h.embeddingSecrets(identity, namespace, profile, values, plain)...)
+	payloads := make(map[string]string, len(plain))
+	contentTypes := make(map[string]string)
+	for k, v := range plain {
+		contentType, payload := content.Decode(v)
+		if contentType != content.TypeText {
+			contentTypes[k] = contentType
+		}
+		payloads[k] = payload
+	}
+	b, err := bundle.New(namespace, profile, payloads, contentTypes, secretKeys, identity.SnapshotKey)
+	if err != nil {
+		log.Printf("Failed to create the snapshot: %v", err)
+		HandleGeneralError(c, err.Error())
+		return
+	}
+	c.Header("ETag", `"`+b.ETag+`"`)
+	if matchesETag(c.GetHeader("If-None-Match"), b.ETag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	HandleSuccess(c, b)
+}
+
+// embeddingSecrets returns the keys of text values that resolve to something else once the
+// secrets they reference are masked.
+func (h Handler) embeddingSecrets(identity *auth.Identity, namespace, profile string, values, plain map[string]string) []string {
+	masked := make(map[string]string, len(values))
+	for k, v := range values {
+		masked[k] = v
+		if IsSecret(v, h.config) {
+			masked[k] = MaskedSecret
+		}
+	}
+	masked = InterpolateValues(h.storage, h.config, identity, namespace, profile, ParseValues(masked, h.config), true)
+	var keys []string
+	for k, v := range plain {
+		if !IsSecret(values[k], h.config) && masked[k] != v {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+func matchesETag(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || strings.Trim(candidate, `"`) == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -1,11 +1,23 @@
 package api
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"log"
 	"net/http"
+	"regexp"
+	"sort"
 	"stoo-kv/config"
+	"stoo-kv/internal/auth"
+	"stoo-kv/internal/content"
 	"stoo-kv/internal/crypto"
+	"stoo-kv/internal/interpolate"
+	"stoo-kv/internal/schema"
+	"stoo-kv/internal/store"
+	"stoo-kv/internal/webhook"
+	"strconv"
 	"strings"
 )
 
@@ -13,13 +25,88 @@ func HandleGeneralError(c *gin.Context, message string) {
 	HandleError(c, StatusGeneralError, message)
 }
 
+// HandleError responds with the HTTP status matching statusCode: 404 for StatusNotFound, 400 for
+// StatusValidationError, 409 for StatusConflict, 503 for StatusUnavailable and 500 otherwise.
 func HandleError(c *gin.Context, statusCode int, message string) {
-	c.JSON(http.StatusInternalServerError, gin.H{
+	httpStatus := http.StatusInternalServerError
+	switch statusCode {
+	case StatusNotFound:
+		httpStatus = http.StatusNotFound
+	case StatusValidationError:
+		httpStatus = http.StatusBadRequest
+	case StatusConflict:
+		httpStatus = http.StatusConflict
+	case StatusUnavailable:
+		httpStatus = http.StatusServiceUnavailable
+	}
+	c.JSON(httpStatus, gin.H{
 		"status":  statusCode,
 		"message": message,
 	})
 }
 
+// HandleInvalid rejects a malformed request.
+func HandleInvalid(c *gin.Context, message string) {
+	HandleError(c, StatusValidationError, message)
+}
+
+// HandleStorageError responds to an error of the storage, or of a feature kept in it, with the
+// status of its kind: not found, invalid, conflicting or unavailable.
+func HandleStorageError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		HandleError(c, StatusNotFound, err.Error())
+	case errors.Is(err, store.ErrInvalid):
+		HandleValidationError(c, err)
+	case errors.Is(err, store.ErrConflict):
+		HandleError(c, StatusConflict, err.Error())
+	case errors.Is(err, store.ErrUnavailable):
+		HandleError(c, StatusUnavailable, err.Error())
+	default:
+		HandleGeneralError(c, err.Error())
+	}
+}
+
+func HandleForbidden(c *gin.Context, message string) {
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+		"status":  StatusForbidden,
+		"message": message,
+	})
+}
+
+func HandleUnauthorized(c *gin.Context, message string) {
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+		"status":  StatusUnauthorized,
+		"message": message,
+	})
+}
+
+func HandleValidationError(c *gin.Context, err error) {
+	var validationErr *schema.ValidationError
+	if !errors.As(err, &validationErr) {
+		if errors.Is(err, store.ErrInvalid) {
+			HandleInvalid(c, err.Error())
+		} else {
+			HandleStorageError(c, err)
+		}
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{
+		"status":  StatusValidationError,
+		"message": "Validation failed",
+		"errors":  validationErr.Errors,
+	})
+}
+
+// CheckNamespace rejects requests that target the reserved system namespace.
+func CheckNamespace(c *gin.Context, namespace string) bool {
+	if store.IsReserved(namespace) {
+		HandleForbidden(c, fmt.Sprintf("Namespace %s is reserved", namespace))
+		return false
+	}
+	return true
+}
+
 func HandleSuccess(c *gin.Context, data any) {
 	c.JSON(http.StatusOK, gin.H{
 		"status":  StatusSuccess,
@@ -27,18 +114,28 @@ func HandleSuccess(c *gin.Context, data any) {
 		"data":    data,
 	})
 }
-func CheckEncryption(value string, config *config.Config) (string, error) {
-	encPrefix := config.Application.EncryptPrefix
-	if encPrefix == "" {
-		encPrefix = "{ENC} "
+func HandleSuccessWithMeta(c *gin.Context, data any, meta gin.H) {
+	response := gin.H{
+		"status":  StatusSuccess,
+		"message": "Success",
+		"data":    data,
+	}
+	for k, v := range meta {
+		response[k] = v
 	}
-	encValue, status := strings.CutPrefix(value, encPrefix)
+	c.JSON(http.StatusOK, response)
+}
+
+// CheckEncryption decrypts the payload of a stored value, keeping its content type.
+func CheckEncryption(value string, config *config.Config) (string, error) {
+	contentType, payload := content.Decode(value)
+	encValue, status := strings.CutPrefix(payload, crypto.Prefix(config.Application.EncryptPrefix))
 	if status {
 		valueByte, err := crypto.Decrypt([]byte(encValue), config.Application.EncryptKey)
 		if err != nil {
 			return "", err
 		}
-		value = string(valueByte)
+		value = content.Encode(contentType, string(valueByte))
 	}
 	return value, nil
 }
@@ -49,15 +146,309 @@ func ParseValues(values map[string]string, config *config.Config) map[string]str
 		v, err := CheckEncryption(v, config)
 		if err != nil {
 			log.Printf("Failed to decrypt the value: %v", err)
-			v = "****NOT VALID****"
+			v = InvalidValue
 		}
 		parsedValues[k] = v
 	}
 	return parsedValues
 }
 
+func IsSecret(value string, config *config.Config) bool {
+	_, payload := content.Decode(value)
+	return strings.HasPrefix(payload, crypto.Prefix(config.Application.EncryptPrefix))
+}
+
+// SecretKeys returns the sorted keys whose stored values are encrypted.
+func SecretKeys(values map[string]string, config *config.Config) []string {
+	secrets := make([]string, 0)
+	for k, v := range values {
+		if IsSecret(v, config) {
+			secrets = append(secrets, k)
+		}
+	}
+	sort.Strings(secrets)
+	return secrets
+}
+
+func MaskSecrets(config *config.Config) bool {
+	return config.Current().SecretMasking != "" && config.Current().SecretMasking != SecretMaskingNone
+}
+
+// MaskedValue is returned in place of a secret that is not revealed.
+func MaskedValue(config *config.Config, namespace, profile, key string) string {
+	if config.Current().SecretMasking == SecretMaskingReference {
+		return fmt.Sprintf("secret://%s/%s/%s", namespace, profile, key)
+	}
+	return MaskedSecret
+}
+
+// ReadValues decrypts the values read in bulk and, unless raw is requested, resolves their references.
+// When secret masking is configured and reveal is not requested, secrets and the values embedding them
+// are masked. It returns the keys holding secrets along with the values.
+func ReadValues(storage store.Store, config *config.Config, identity *auth.Identity, namespace, profile string, values map[string]string, raw, reveal bool) (map[string]string, []string) {
+	secrets := SecretKeys(values, config)
+	masked := MaskSecrets(config) && !reveal
+	if masked {
+		maskedValues := make(map[string]string)
+		for k, v := range values {
+			maskedValues[k] = v
+		}
+		for _, k := range secrets {
+			maskedValues[k] = MaskedValue(config, namespace, profile, k)
+		}
+		values = maskedValues
+	}
+	values = ParseValues(values, config)
+	if !raw {
+		values = InterpolateValues(storage, config, identity, namespace, profile, values, masked)
+	}
+	return values, secrets
+}
+
+// NewResolver creates a resolver that reads referenced keys from storage, preferring
+// the already decrypted values of the namespace and profile being read when given.
+// Referenced secrets are masked when masked is set, and secrets of other namespaces are only
+// resolved for callers allowed to reveal them.
+func NewResolver(storage store.Store, config *config.Config, identity *auth.Identity, namespace, profile string, values map[string]string, masked bool) *interpolate.Resolver {
+	return interpolate.NewResolver(func(ns, p, key string) (string, bool, error) {
+		if store.IsReserved(ns) {
+			return "", false, fmt.Errorf("namespace %s is reserved and cannot be referenced", ns)
+		}
+		if ns == namespace && p == profile {
+			if value, ok := values[key]; ok {
+				_, payload := content.Decode(value)
+				return payload, true, nil
+			}
+		}
+		value, err := storage.Get(fmt.Sprintf("%s::%s::%s", ns, p, key))
+		if errors.Is(err, store.ErrNotFound) {
+			return "", false, nil
+		}
+		if err != nil {
+			return "", false, err
+		}
+		if masked && IsSecret(value, config) {
+			return MaskedValue(config, ns, p, key), true, nil
+		}
+		if ns != namespace && IsSecret(value, config) {
+			if err := CheckSecretReference(config, identity); err != nil {
+				return "", false, fmt.Errorf("%w, referenced by %s:%s:%s", err, ns, p, key)
+			}
+		}
+		value, err = CheckEncryption(value, config)
+		_, payload := content.Decode(value)
+		return payload, err == nil, err
+	}, config.Current().InterpolateEnv)
+}
+
+// ReferenceResolver resolves a value written to a key as it would be read, for schemas to validate
+// the value a reference stands for.
+func ReferenceResolver(storage store.Store, config *config.Config, identity *auth.Identity, namespace, profile, key string) func(string) (string, error) {
+	return func(value string) (string, error) {
+		return NewResolver(storage, config, identity, namespace, profile, nil, false).Resolve(namespace, profile, key, value)
+	}
+}
+
+func InterpolateValues(storage store.Store, config *config.Config, identity *auth.Identity, namespace, profile string, values map[string]string, masked bool) map[string]string {
+	resolver := NewResolver(storage, config, identity, namespace, profile, values, masked)
+	resolvedValues := make(map[string]string)
+	for k, v := range values {
+		contentType, payload := content.Decode(v)
+		if contentType != content.TypeText {
+			resolvedValues[k] = v
+			continue
+		}
+		resolved, err := resolver.Resolve(namespace, profile, k, payload)
+		if err != nil {
+			log.Printf("Failed to resolve the value of %s: %v", k, err)
+			resolved = InvalidValue
+		}
+		resolvedValues[k] = content.Encode(contentType, resolved)
+	}
+	return resolvedValues
+}
+
+// ReadValue decrypts a stored value and, unless raw is requested, resolves the references
+// of text values. It returns the content type and the plain payload. Like bulk reads, it masks
+// secrets and the values embedding them unless reveal is requested.
+func ReadValue(storage store.Store, config *config.Config, identity *auth.Identity, namespace, profile, key, value string, raw, reveal bool) (string, string, error) {
+	masked := MaskSecrets(config) && !reveal
+	if masked && IsSecret(value, config) {
+		return content.TypeText, MaskedValue(config, namespace, profile, key), nil
+	}
+	value, err := CheckEncryption(value, config)
+	if err != nil {
+		return "", "", err
+	}
+	contentType, payload := content.Decode(value)
+	if contentType != content.TypeText || raw {
+		return contentType, payload, nil
+	}
+	payload, err = NewResolver(storage, config, identity, namespace, profile, nil, masked).Resolve(namespace, profile, key, payload)
+	return contentType, payload, err
+}
+
+// RenderValues converts decrypted values into their REST representation, returning the
+// content types of the values that are not plain text.
+func RenderValues(values map[string]string) (map[string]any, map[string]string) {
+	renderedValues := make(map[string]any)
+	contentTypes := make(map[string]string)
+	for k, v := range values {
+		contentType, payload := content.Decode(v)
+		rendered, err := content.Render(contentType, payload)
+		if err != nil {
+			log.Printf("Failed to render the value of %s: %v", k, err)
+			rendered = InvalidValue
+		}
+		if contentType != content.TypeText {
+			contentTypes[k] = contentType
+		}
+		renderedValues[k] = rendered
+	}
+	return renderedValues, contentTypes
+}
+
+// NewListOptions validates the listing filters, applying the default and maximum page size.
+func NewListOptions(prefix, glob, regex string, limit int, cursor string, descending bool) (store.ListOptions, error) {
+	if regex != "" {
+		if _, err := regexp.Compile(regex); err != nil {
+			return store.ListOptions{}, fmt.Errorf("invalid regex: %v", err)
+		}
+	}
+	switch {
+	case limit < 0:
+		return store.ListOptions{}, fmt.Errorf("limit cannot be negative")
+	case limit == 0:
+		limit = DefaultPageSize
+	case limit > MaxPageSize:
+		limit = MaxPageSize
+	}
+	return store.ListOptions{
+		Prefix:     prefix,
+		Glob:       glob,
+		Regex:      regex,
+		Limit:      limit,
+		Cursor:     cursor,
+		Descending: descending,
+	}, nil
+}
+
+// ParseListOptions reads the listing query parameters; it reports false when none are given,
+// in which case the whole namespace and profile is returned.
+func ParseListOptions(c *gin.Context) (store.ListOptions, bool, error) {
+	query := c.Request.URL.Query()
+	paged := false
+	for _, param := range []string{"prefix", "glob", "regex", "limit", "cursor", "sort"} {
+		paged = paged || query.Has(param)
+	}
+	if !paged {
+		return store.ListOptions{}, false, nil
+	}
+
+	limit := 0
+	if query.Get("limit") != "" {
+		var err error
+		if limit, err = strconv.Atoi(query.Get("limit")); err != nil {
+			return store.ListOptions{}, true, fmt.Errorf("invalid limit: %v", err)
+		}
+	}
+	var descending bool
+	switch query.Get("sort") {
+	case "", "asc":
+	case "desc":
+		descending = true
+	default:
+		return store.ListOptions{}, true, fmt.Errorf("sort must be asc or desc")
+	}
+	options, err := NewListOptions(query.Get("prefix"), query.Get("glob"), query.Get("regex"), limit, query.Get("cursor"), descending)
+	return options, true, err
+}
+
+// PageValues returns the values of a page along with its keys in page order.
+func PageValues(page *store.Page) (map[string]string, []string) {
+	values := make(map[string]string)
+	keys := make([]string, 0, len(page.Items))
+	for _, item := range page.Items {
+		values[item.Key] = item.Value
+		keys = append(keys, item.Key)
+	}
+	return values, keys
+}
+
+func IsRaw(c *gin.Context) bool {
+	raw, _ := strconv.ParseBool(c.Query("raw"))
+	return raw
+}
+
+func IsReveal(c *gin.Context) bool {
+	reveal, _ := strconv.ParseBool(c.Query("reveal"))
+	return reveal
+}
+
+// ErrRevealDenied is returned when the caller lacks the permission to reveal secrets.
+var ErrRevealDenied = fmt.Errorf("permission %s is required to reveal secrets", auth.PermissionRevealSecrets)
+
+// CheckReveal verifies that the caller may reveal secrets when masking is enabled.
+func CheckReveal(config *config.Config, identity *auth.Identity, reveal bool) error {
+	if reveal && MaskSecrets(config) && !identity.Can(auth.PermissionRevealSecrets) {
+		return ErrRevealDenied
+	}
+	return nil
+}
+
+// CheckSecretReference verifies that the caller may resolve a reference to a secret of another
+// namespace, which needs the permission to reveal secrets once authentication or masking is configured.
+func CheckSecretReference(config *config.Config, identity *auth.Identity) error {
+	if (AuthConfigured(config) || MaskSecrets(config)) && !identity.Can(auth.PermissionRevealSecrets) {
+		return ErrRevealDenied
+	}
+	return nil
+}
+
+// AuthConfigured reports whether callers can authenticate, with access tokens or client certificates.
+func AuthConfigured(config *config.Config) bool {
+	current := config.Current()
+	return len(current.AccessTokens) > 0 || len(current.CertificateIdentities) > 0
+}
+
+// DeletesSecret tells the subscribers of the deletion event of a key whether it holds a secret. The
+// key is only read when the event has subscribers, and the deletion itself reports a missing key.
+func DeletesSecret(storage store.Store, config *config.Config, webhooks *webhook.Dispatcher, event *webhook.Event, key string) bool {
+	if !webhooks.Subscribed(event) {
+		return false
+	}
+	value, err := storage.Get(key)
+	return err == nil && IsSecret(value, config)
+}
+
+// NewEvent describes a key change made by the caller of a request.
+func NewEvent(ctx context.Context, name, namespace, profile, key string, isSecret bool) *webhook.Event {
+	event := &webhook.Event{Event: name, Namespace: namespace, Profile: profile, Key: key, Secret: isSecret}
+	if identity := auth.FromContext(ctx); identity != nil {
+		event.Actor = identity.Name
+	}
+	return event
+}
+
+const (
+	InvalidValue      = "****NOT VALID****"
+	MaskedSecret      = "********"
+	ContentTypeHeader = "X-Stoo-Content-Type"
+	DefaultPageSize   = 100
+	MaxPageSize       = 1000
+
+	SecretMaskingNone      = config.SecretMaskingNone
+	SecretMaskingReference = config.SecretMaskingReference
+)
+
 const (
 	StatusSuccess      = 0
 	StatusGeneralError = -1
 	StatusNotFound     = -2
+
+	StatusValidationError = -3
+	StatusForbidden       = -4
+	StatusUnauthorized    = -5
+	StatusConflict        = -6
+	StatusUnavailable     = -7
 )
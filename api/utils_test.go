@@ -0,0 +1,55 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"stoo-kv/internal/provider"
+	"stoo-kv/internal/schema"
+	"stoo-kv/internal/store"
+	"syscall"
+	"testing"
+)
+
+func TestHandleStorageError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name       string
+		err        error
+		wantHTTP   int
+		wantStatus int
+	}{
+		{name: "not found", err: store.ErrNotFound, wantHTTP: http.StatusNotFound, wantStatus: StatusNotFound},
+		{name: "wrapped not found", err: fmt.Errorf("schema of app: %w", store.ErrNotFound), wantHTTP: http.StatusNotFound, wantStatus: StatusNotFound},
+		{name: "invalid", err: provider.Invalidf("invalid cursor"), wantHTTP: http.StatusBadRequest, wantStatus: StatusValidationError},
+		{name: "schema violation", err: &schema.ValidationError{Errors: []schema.FieldError{{Field: "port", Message: "must be at most 65535"}}}, wantHTTP: http.StatusBadRequest, wantStatus: StatusValidationError},
+		{name: "conflict", err: provider.Mark(errors.New("duplicate key"), store.ErrConflict), wantHTTP: http.StatusConflict, wantStatus: StatusConflict},
+		{name: "unavailable", err: provider.Mark(syscall.ECONNREFUSED, store.ErrUnavailable), wantHTTP: http.StatusServiceUnavailable, wantStatus: StatusUnavailable},
+		{name: "circuit open", err: store.ErrCircuitOpen, wantHTTP: http.StatusServiceUnavailable, wantStatus: StatusUnavailable},
+		{name: "timeout", err: store.ErrTimeout, wantHTTP: http.StatusServiceUnavailable, wantStatus: StatusUnavailable},
+		{name: "unexpected", err: errors.New("disk full"), wantHTTP: http.StatusInternalServerError, wantStatus: StatusGeneralError},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(recorder)
+			HandleStorageError(c, test.err)
+			if recorder.Code != test.wantHTTP {
+				t.Errorf("got HTTP status %d, want %d", recorder.Code, test.wantHTTP)
+			}
+			var body struct {
+				Status  int    `json:"status"`
+				Message string `json:"message"`
+			}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+				t.Fatalf("decoding %s: %v", recorder.Body, err)
+			}
+			if body.Status != test.wantStatus {
+				t.Errorf("got status %d, want %d", body.Status, test.wantStatus)
+			}
+		})
+	}
+}
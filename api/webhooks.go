@@ -0,0 +1,105 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"log"
+	"stoo-kv/internal/webhook"
+)
+
+func (h Handler) SubscribeHandler(c *gin.Context) {
+	subscription := &webhook.Subscription{}
+	if err := c.ShouldBindJSON(subscription); err != nil {
+		log.Printf("Failed to decode data: %v", err)
+		HandleInvalid(c, err.Error())
+		return
+	}
+	if !CheckNamespace(c, subscription.Namespace) {
+		return
+	}
+	subscription, err := h.webhooks.Subscribe(subscription)
+	if err != nil {
+		log.Printf("Failed to create webhook subscription: %v", err)
+		HandleStorageError(c, err)
+		return
+	}
+	HandleSuccess(c, subscription)
+}
+
+func (h Handler) GetSubscriptionsHandler(c *gin.Context) {
+	subscriptions, err := h.webhooks.Subscriptions(c.Query("namespace"))
+	if err != nil {
+		log.Printf("Failed to read webhook subscriptions: %v", err)
+		HandleStorageError(c, err)
+		return
+	}
+	if len(subscriptions) == 0 {
+		HandleError(c, StatusNotFound, "Webhook subscriptions not found from storage")
+		return
+	}
+	HandleSuccess(c, subscriptions)
+}
+
+func (h Handler) GetSubscriptionHandler(c *gin.Context) {
+	subscription, err := h.webhooks.Subscription(c.Param("id"))
+	if err != nil {
+		log.Printf("Failed to read webhook subscription: %v", err)
+		HandleStorageError(c, err)
+		return
+	}
+	if subscription == nil {
+		HandleError(c, StatusNotFound, "Webhook subscription not found from storage")
+		return
+	}
+	HandleSuccess(c, subscription)
+}
+
+func (h Handler) UnsubscribeHandler(c *gin.Context) {
+	deleted, err := h.webhooks.Unsubscribe(c.Param("id"))
+	if err != nil {
+		log.Printf("Failed to remove webhook subscription: %v", err)
+		HandleStorageError(c, err)
+		return
+	}
+	if !deleted {
+		HandleError(c, StatusNotFound, "Webhook subscription not found from storage")
+		return
+	}
+	HandleSuccess(c, "Webhook subscription removed successfully")
+}
+
+func (h Handler) GetDeliveriesHandler(c *gin.Context) {
+	deliveries, err := h.webhooks.History(c.Param("id"))
+	h.deliveriesProcessor(c, deliveries, err)
+}
+
+func (h Handler) GetDeadLettersHandler(c *gin.Context) {
+	deliveries, err := h.webhooks.DeadLetters(c.Param("id"))
+	h.deliveriesProcessor(c, deliveries, err)
+}
+
+func (h Handler) RedeliverHandler(c *gin.Context) {
+	delivery, err := h.webhooks.Redeliver(c.Param("id"), c.Param("delivery"))
+	if err != nil {
+		log.Printf("Failed to redeliver webhook: %v", err)
+		HandleStorageError(c, err)
+		return
+	}
+	if delivery == nil {
+		HandleError(c, StatusNotFound, "Dead letter not found from storage")
+		return
+	}
+	HandleSuccess(c, "Webhook queued for redelivery")
+}
+
+func (h Handler) deliveriesProcessor(c *gin.Context, deliveries []*webhook.Delivery, err error) {
+	if err != nil {
+		log.Printf("Failed to read webhook deliveries: %v", err)
+		HandleStorageError(c, err)
+		return
+	}
+	if len(deliveries) == 0 {
+		HandleError(c, StatusNotFound, "Webhook deliveries not found from storage")
+		return
+	}
+	HandleSuccess(c, deliveries)
+}
@@ -0,0 +1,453 @@
+// Package client is the Go SDK of stookv. It reads and writes the keys of a namespace and profile
+// over the REST API or gRPC, failing over between servers, caching values in process and notifying
+// callbacks when values change on the server.
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"stoo-kv/internal/bundle"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	TransportREST = "rest"
+	TransportGRPC = "grpc"
+)
+
+var ErrNotFound = errors.New("stookv: key not found")
+
+// Error is a failure reported by the server, with the REST status or gRPC code.
+type Error struct {
+	Status  int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("stookv: %s (status %d)", e.Message, e.Status)
+}
+
+type Options struct {
+	// Addresses are tried in order, moving to the next one when a server is unavailable:
+	// base URLs such as http://localhost:9098 for REST, host:port for gRPC.
+	Addresses []string
+	Transport string
+	Namespace string
+	Profile   string
+	// Token is sent as bearer access token.
+	Token string
+	// Reveal asks for the decrypted values of secrets when the server masks them.
+	Reveal    bool
+	TLSConfig *tls.Config
+	Timeout   time.Duration
+	// Retries is the number of extra rounds over all addresses, waiting RetryBackoff doubled
+	// after every round.
+	Retries      int
+	RetryBackoff time.Duration
+	// CacheTTL keeps values read from the server in process; zero disables the cache.
+	CacheTTL time.Duration
+	// RefreshInterval reloads all values in the background and reports changes to the OnChange
+	// callbacks; zero disables reloading.
+	RefreshInterval time.Duration
+	// SnapshotFile makes the client load all values as a signed snapshot, kept in this file, and
+	// start from the file when no server is reachable. SnapshotKey is the snapshot_key of the
+	// Token; it verifies the snapshot and decrypts its secrets, so secrets are always revealed.
+	// Snapshots are only served over REST.
+	SnapshotFile string
+	SnapshotKey  string
+}
+
+// Change describes a value that differs between two reloads.
+type Change struct {
+	Key      string
+	OldValue string
+	NewValue string
+	Deleted  bool
+}
+
+type Client struct {
+	options   Options
+	endpoints []endpoint
+
+	mu        sync.RWMutex
+	current   int
+	values    map[string]string
+	loadedAt  time.Time
+	snapshot  *bundle.Bundle
+	callbacks []func(Change)
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func New(options Options) (*Client, error) {
+	if len(options.Addresses) == 0 {
+		return nil, errors.New("stookv: at least one address is required")
+	}
+	if options.Namespace == "" || options.Profile == "" {
+		return nil, errors.New("stookv: namespace and profile are required")
+	}
+	if options.SnapshotFile != "" && options.SnapshotKey == "" {
+		return nil, errors.New("stookv: a snapshot file needs a snapshot key")
+	}
+	if options.SnapshotFile != "" && options.Transport == TransportGRPC {
+		return nil, errors.New("stookv: snapshots are only available over REST")
+	}
+	if options.Timeout == 0 {
+		options.Timeout = 10 * time.Second
+	}
+	if options.RetryBackoff == 0 {
+		options.RetryBackoff = 200 * time.Millisecond
+	}
+
+	c := &Client{options: options}
+	httpClient := &http.Client{Timeout: options.Timeout, Transport: &http.Transport{TLSClientConfig: options.TLSConfig}}
+	for _, address := range options.Addresses {
+		switch options.Transport {
+		case "", TransportREST:
+			c.endpoints = append(c.endpoints, newRestEndpoint(address, options.Token, httpClient))
+		case TransportGRPC:
+			e, err := newGrpcEndpoint(address, options.Token, options.TLSConfig)
+			if err != nil {
+				c.Close()
+				return nil, err
+			}
+			c.endpoints = append(c.endpoints, e)
+		default:
+			return nil, fmt.Errorf("stookv: unsupported transport %q", options.Transport)
+		}
+	}
+	if options.RefreshInterval > 0 {
+		c.stop, c.done = make(chan struct{}), make(chan struct{})
+		go c.watch()
+	}
+	return c, nil
+}
+
+// Get returns the text of a value; JSON documents and lists are returned as JSON.
+func (c *Client) Get(ctx context.Context, key string) (string, error) {
+	if c.options.CacheTTL > 0 || c.options.RefreshInterval > 0 {
+		values, err := c.cached(ctx)
+		if err != nil {
+			return "", err
+		}
+		value, ok := values[key]
+		if !ok {
+			return "", ErrNotFound
+		}
+		return value, nil
+	}
+
+	var value string
+	err := c.do(ctx, func(ctx context.Context, e endpoint) (err error) {
+		value, err = e.get(ctx, c.options.Namespace, c.options.Profile, key, c.options.Reveal)
+		return err
+	})
+	return value, err
+}
+
+// GetString returns the value of a key, or def when the key does not exist.
+func (c *Client) GetString(ctx context.Context, key, def string) (string, error) {
+	value, err := c.Get(ctx, key)
+	if errors.Is(err, ErrNotFound) {
+		return def, nil
+	}
+	return value, err
+}
+
+func (c *Client) GetInt(ctx context.Context, key string) (int, error) {
+	value, err := c.Get(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("stookv: %s is not an int: %v", key, err)
+	}
+	return number, nil
+}
+
+func (c *Client) GetFloat(ctx context.Context, key string) (float64, error) {
+	value, err := c.Get(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("stookv: %s is not a float: %v", key, err)
+	}
+	return number, nil
+}
+
+func (c *Client) GetBool(ctx context.Context, key string) (bool, error) {
+	value, err := c.Get(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("stookv: %s is not a bool: %v", key, err)
+	}
+	return b, nil
+}
+
+func (c *Client) GetDuration(ctx context.Context, key string) (time.Duration, error) {
+	value, err := c.Get(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("stookv: %s is not a duration: %v", key, err)
+	}
+	return duration, nil
+}
+
+// GetJSON decodes a JSON document or list value into v.
+func (c *Client) GetJSON(ctx context.Context, key string, v any) error {
+	value, err := c.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(value), v)
+}
+
+// All returns every value of the namespace and profile.
+func (c *Client) All(ctx context.Context) (map[string]string, error) {
+	values, err := c.cached(ctx)
+	if err != nil {
+		return nil, err
+	}
+	copied := make(map[string]string, len(values))
+	for key, value := range values {
+		copied[key] = value
+	}
+	return copied, nil
+}
+
+func (c *Client) Set(ctx context.Context, key, value string) error {
+	return c.write(ctx, func(ctx context.Context, e endpoint) error {
+		return e.set(ctx, c.options.Namespace, c.options.Profile, key, value, false)
+	})
+}
+
+// SetSecret stores a value encrypted.
+func (c *Client) SetSecret(ctx context.Context, key, value string) error {
+	return c.write(ctx, func(ctx context.Context, e endpoint) error {
+		return e.set(ctx, c.options.Namespace, c.options.Profile, key, value, true)
+	})
+}
+
+// Delete removes a key, returning ErrNotFound when it does not exist.
+func (c *Client) Delete(ctx context.Context, key string) error {
+	return c.write(ctx, func(ctx context.Context, e endpoint) error {
+		return e.delete(ctx, c.options.Namespace, c.options.Profile, key)
+	})
+}
+
+// Encrypt returns the hex ciphertext of a value, as stored for secrets without the prefix.
+func (c *Client) Encrypt(ctx context.Context, plaintext string) (string, error) {
+	return c.crypt(ctx, "encrypt", plaintext)
+}
+
+// Decrypt needs the decrypt endpoint to be enabled on the server.
+func (c *Client) Decrypt(ctx context.Context, ciphertext string) (string, error) {
+	return c.crypt(ctx, "decrypt", ciphertext)
+}
+
+func (c *Client) crypt(ctx context.Context, operation, data string) (string, error) {
+	var result string
+	err := c.do(ctx, func(ctx context.Context, e endpoint) (err error) {
+		result, err = e.crypt(ctx, operation, data)
+		return err
+	})
+	return result, err
+}
+
+// OnChange registers a callback for values changed on the server, as seen by the background
+// reload or by the next load of the cache.
+func (c *Client) OnChange(callback func(Change)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.callbacks = append(c.callbacks, callback)
+}
+
+// Refresh reloads all values right away, reporting changes to the callbacks.
+func (c *Client) Refresh(ctx context.Context) error {
+	var values map[string]string
+	var err error
+	if c.options.SnapshotFile != "" {
+		values, err = c.loadSnapshot(ctx)
+	} else {
+		err = c.do(ctx, func(ctx context.Context, e endpoint) (err error) {
+			values, err = e.list(ctx, c.options.Namespace, c.options.Profile, c.options.Reveal)
+			return err
+		})
+	}
+	if errors.Is(err, ErrNotFound) {
+		values, err = map[string]string{}, nil
+	}
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	previous, loaded := c.values, c.values != nil
+	c.values, c.loadedAt = values, time.Now()
+	callbacks := c.callbacks
+	c.mu.Unlock()
+	if loaded {
+		for _, change := range diff(previous, values) {
+			for _, callback := range callbacks {
+				callback(change)
+			}
+		}
+	}
+	return nil
+}
+
+// Close stops the background reload and closes the server connections.
+func (c *Client) Close() error {
+	if c.stop != nil {
+		close(c.stop)
+		<-c.done
+		c.stop = nil
+	}
+	var err error
+	for _, e := range c.endpoints {
+		if closeErr := e.close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+func (c *Client) cached(ctx context.Context) (map[string]string, error) {
+	c.mu.RLock()
+	values, loadedAt := c.values, c.loadedAt
+	c.mu.RUnlock()
+	fresh := c.options.RefreshInterval > 0 || time.Since(loadedAt) < c.options.CacheTTL
+	if !loadedAt.IsZero() && fresh {
+		return values, nil
+	}
+	if err := c.Refresh(ctx); err != nil {
+		return nil, err
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.values, nil
+}
+
+// write runs a change on the server and drops the cache, so the next read sees it.
+func (c *Client) write(ctx context.Context, fn func(context.Context, endpoint) error) error {
+	if err := c.do(ctx, fn); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.loadedAt = time.Time{}
+	c.mu.Unlock()
+	return nil
+}
+
+// do runs fn against the current server, failing over to the next address when it is unavailable.
+func (c *Client) do(ctx context.Context, fn func(context.Context, endpoint) error) error {
+	backoff := c.options.RetryBackoff
+	var err error
+	for round := 0; round <= c.options.Retries; round++ {
+		if round > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+		for attempt := 0; attempt < len(c.endpoints); attempt++ {
+			c.mu.RLock()
+			current := c.current
+			c.mu.RUnlock()
+
+			requestCtx, cancel := context.WithTimeout(ctx, c.options.Timeout)
+			err = fn(requestCtx, c.endpoints[current])
+			cancel()
+			if !isUnavailable(err) {
+				return err
+			}
+			c.mu.Lock()
+			if c.current == current {
+				c.current = (current + 1) % len(c.endpoints)
+			}
+			c.mu.Unlock()
+		}
+	}
+	return err
+}
+
+func (c *Client) watch() {
+	defer close(c.done)
+	ticker := time.NewTicker(c.options.RefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), c.options.RefreshInterval)
+			_ = c.Refresh(ctx)
+			cancel()
+		}
+	}
+}
+
+func diff(previous, current map[string]string) []Change {
+	var changes []Change
+	for key, value := range current {
+		if old, ok := previous[key]; !ok || old != value {
+			changes = append(changes, Change{Key: key, OldValue: old, NewValue: value})
+		}
+	}
+	for key, old := range previous {
+		if _, ok := current[key]; !ok {
+			changes = append(changes, Change{Key: key, OldValue: old, Deleted: true})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+	return changes
+}
+
+// NewTLSConfig returns the TLS configuration trusting the CA certificates of a PEM file and, for
+// mutual TLS, presenting a client certificate. It returns nil to use the system roots when none
+// of them is given and verification is not skipped.
+func NewTLSConfig(caFile, certFile, keyFile string, insecureSkipVerify bool) (*tls.Config, error) {
+	if caFile == "" && certFile == "" && !insecureSkipVerify {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: insecureSkipVerify}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("stookv: no certificates found in %s", caFile)
+		}
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
@@ -0,0 +1,324 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"stoo-kv/internal/bundle"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeEndpoint serves the values of one server and its snapshot when set, failing its calls with
+// err when set.
+type fakeEndpoint struct {
+	mu     sync.Mutex
+	values map[string]string
+	bundle *bundle.Bundle
+	err    error
+	calls  int
+	lists  int
+}
+
+func (f *fakeEndpoint) call() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	return f.err
+}
+
+func (f *fakeEndpoint) get(ctx context.Context, namespace, profile, key string, reveal bool) (string, error) {
+	if err := f.call(); err != nil {
+		return "", err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	value, ok := f.values[key]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+func (f *fakeEndpoint) list(ctx context.Context, namespace, profile string, reveal bool) (map[string]string, error) {
+	if err := f.call(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lists++
+	values := make(map[string]string, len(f.values))
+	for key, value := range f.values {
+		values[key] = value
+	}
+	return values, nil
+}
+
+func (f *fakeEndpoint) set(ctx context.Context, namespace, profile, key, value string, secret bool) error {
+	if err := f.call(); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.values[key] = value
+	return nil
+}
+
+func (f *fakeEndpoint) delete(ctx context.Context, namespace, profile, key string) error {
+	if err := f.call(); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.values[key]; !ok {
+		return ErrNotFound
+	}
+	delete(f.values, key)
+	return nil
+}
+
+func (f *fakeEndpoint) crypt(ctx context.Context, operation, data string) (string, error) {
+	return data, f.call()
+}
+
+func (f *fakeEndpoint) snapshot(ctx context.Context, namespace, profile, etag string) (*bundle.Bundle, error) {
+	if err := f.call(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case f.bundle == nil:
+		return nil, errors.New("snapshots are not served")
+	case f.bundle.ETag == etag:
+		return nil, errNotModified
+	}
+	return f.bundle, nil
+}
+
+func (f *fakeEndpoint) close() error {
+	return nil
+}
+
+func (f *fakeEndpoint) setErr(err error) {
+	f.mu.Lock()
+	f.err = err
+	f.mu.Unlock()
+}
+
+var errDown = &unavailableError{errors.New("connection refused")}
+
+func newTestClient(options Options, endpoints ...*fakeEndpoint) *Client {
+	options.Namespace, options.Profile = "app", "prod"
+	if options.Timeout == 0 {
+		options.Timeout = time.Second
+	}
+	if options.RetryBackoff == 0 {
+		options.RetryBackoff = time.Millisecond
+	}
+	c := &Client{options: options}
+	for _, e := range endpoints {
+		c.endpoints = append(c.endpoints, e)
+	}
+	return c
+}
+
+func TestFailover(t *testing.T) {
+	tests := []struct {
+		name    string
+		errs    []error
+		retries int
+		// fixAfter clears the errors of every server after the first round.
+		fixAfter    bool
+		want        string
+		wantErr     error
+		wantCurrent int
+	}{
+		{name: "first server up", errs: []error{nil, nil}, want: "1"},
+		{name: "first server down", errs: []error{errDown, nil}, want: "1", wantCurrent: 1},
+		{name: "last server up", errs: []error{errDown, errDown, nil}, want: "1", wantCurrent: 2},
+		{name: "every server down", errs: []error{errDown, errDown}, wantErr: errDown},
+		{name: "every server down, back on retry", errs: []error{errDown, errDown}, retries: 1, fixAfter: true, want: "1"},
+		{name: "errors other than unavailability are returned", errs: []error{&Error{Status: -5, Message: "forbidden"}, nil}, wantErr: &Error{Status: -5, Message: "forbidden"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var endpoints []*fakeEndpoint
+			for _, err := range test.errs {
+				endpoints = append(endpoints, &fakeEndpoint{values: map[string]string{"a": "1"}, err: err})
+			}
+			c := newTestClient(Options{Retries: test.retries, RetryBackoff: 20 * time.Millisecond}, endpoints...)
+			if test.fixAfter {
+				time.AfterFunc(5*time.Millisecond, func() {
+					for _, e := range endpoints {
+						e.setErr(nil)
+					}
+				})
+			}
+			got, err := c.Get(context.Background(), "a")
+			if test.wantErr != nil {
+				if err == nil || err.Error() != test.wantErr.Error() {
+					t.Fatalf("got %q, %v, want error %v", got, err, test.wantErr)
+				}
+			} else if err != nil || got != test.want {
+				t.Fatalf("got %q, %v, want %q", got, err, test.want)
+			}
+			if c.current != test.wantCurrent {
+				t.Errorf("got current server %d, want %d", c.current, test.wantCurrent)
+			}
+		})
+	}
+}
+
+func TestFailoverStaysOnWorkingServer(t *testing.T) {
+	first := &fakeEndpoint{values: map[string]string{"a": "first"}, err: errDown}
+	second := &fakeEndpoint{values: map[string]string{"a": "second"}}
+	c := newTestClient(Options{}, first, second)
+	for i := 0; i < 3; i++ {
+		if got, err := c.Get(context.Background(), "a"); err != nil || got != "second" {
+			t.Fatalf("Get: got %q, %v, want second", got, err)
+		}
+	}
+	if first.calls != 1 || second.calls != 3 {
+		t.Errorf("got %d and %d calls, want the failed server tried once", first.calls, second.calls)
+	}
+	// The next failure moves back to the first server.
+	first.setErr(nil)
+	second.setErr(errDown)
+	if got, err := c.Get(context.Background(), "a"); err != nil || got != "first" {
+		t.Errorf("Get: got %q, %v, want first", got, err)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	server := &fakeEndpoint{values: map[string]string{}, err: errDown}
+	c := newTestClient(Options{Retries: 2, RetryBackoff: 20 * time.Millisecond}, server)
+	start := time.Now()
+	if _, err := c.Get(context.Background(), "a"); !isUnavailable(err) {
+		t.Fatalf("got %v, want the server unavailable", err)
+	}
+	// Rounds wait 20ms, then 40ms.
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+		t.Errorf("retries took %v, want at least 60ms", elapsed)
+	}
+	if server.calls != 3 {
+		t.Errorf("got %d calls, want 3", server.calls)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.Get(ctx, "a"); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want the retries cancelled with the context", err)
+	}
+}
+
+func TestCache(t *testing.T) {
+	server := &fakeEndpoint{values: map[string]string{"a": "1", "b": "2"}}
+	c := newTestClient(Options{CacheTTL: 50 * time.Millisecond}, server)
+	var changes []Change
+	c.OnChange(func(change Change) { changes = append(changes, change) })
+	ctx := context.Background()
+
+	for _, key := range []string{"a", "b", "a"} {
+		if _, err := c.Get(ctx, key); err != nil {
+			t.Fatalf("Get %s: %v", key, err)
+		}
+	}
+	if _, err := c.Get(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get of a missing key: got %v, want ErrNotFound", err)
+	}
+	if server.lists != 1 {
+		t.Fatalf("got %d loads, want reads served from the cache", server.lists)
+	}
+
+	// Writes drop the cache, and the next load reports the changes.
+	if err := c.Set(ctx, "a", "changed"); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if err := c.Delete(ctx, "b"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if got, err := c.Get(ctx, "a"); err != nil || got != "changed" {
+		t.Fatalf("Get after Set: got %q, %v", got, err)
+	}
+	wantChanges := []Change{{Key: "a", OldValue: "1", NewValue: "changed"}, {Key: "b", OldValue: "2", Deleted: true}}
+	if !reflect.DeepEqual(changes, wantChanges) {
+		t.Errorf("got changes %v, want %v", changes, wantChanges)
+	}
+
+	// Values changed by others are read once the cache expires.
+	server.mu.Lock()
+	server.values["c"] = "3"
+	server.mu.Unlock()
+	if _, err := c.Get(ctx, "c"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get before the cache expires: got %v, want ErrNotFound", err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if got, err := c.Get(ctx, "c"); err != nil || got != "3" {
+		t.Errorf("Get after the cache expires: got %q, %v", got, err)
+	}
+	if server.lists != 3 {
+		t.Errorf("got %d loads, want 3", server.lists)
+	}
+}
+
+func TestRefreshInBackground(t *testing.T) {
+	server := &fakeEndpoint{values: map[string]string{"a": "1"}}
+	c := newTestClient(Options{RefreshInterval: 10 * time.Millisecond}, server)
+	c.stop, c.done = make(chan struct{}), make(chan struct{})
+	go c.watch()
+	defer c.Close()
+
+	changed := make(chan Change, 1)
+	c.OnChange(func(change Change) { changed <- change })
+	if got, err := c.Get(context.Background(), "a"); err != nil || got != "1" {
+		t.Fatalf("Get: got %q, %v", got, err)
+	}
+	server.mu.Lock()
+	server.values["a"] = "2"
+	server.mu.Unlock()
+	select {
+	case change := <-changed:
+		if change != (Change{Key: "a", OldValue: "1", NewValue: "2"}) {
+			t.Errorf("got change %+v", change)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no change reported")
+	}
+	if got, _ := c.Get(context.Background(), "a"); got != "2" {
+		t.Errorf("Get after the refresh: got %q, want 2", got)
+	}
+}
+
+func TestRestFailover(t *testing.T) {
+	unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_ = json.NewEncoder(w).Encode(restResponse{Status: restStatusUnavailable, Message: "storage is unavailable"})
+	}))
+	defer unavailable.Close()
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/stoo-kv/app/prod/db" {
+			w.WriteHeader(http.StatusNotFound)
+			_ = json.NewEncoder(w).Encode(restResponse{Status: restStatusNotFound, Message: "key not found"})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(restResponse{Data: json.RawMessage(`{"host": "db", "port": 5432}`)})
+	}))
+	defer up.Close()
+
+	c, err := New(Options{Addresses: []string{unavailable.URL, up.URL}, Namespace: "app", Profile: "prod", RetryBackoff: time.Millisecond})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer c.Close()
+	if got, err := c.Get(context.Background(), "db"); err != nil || got != `{"host":"db","port":5432}` {
+		t.Errorf("Get: got %q, %v", got, err)
+	}
+	if _, err := c.Get(context.Background(), "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get of a missing key: got %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,57 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"stoo-kv/internal/bundle"
+)
+
+// loadSnapshot fetches the signed snapshot of the namespace and profile and saves it to the
+// snapshot file. While no server is reachable, it falls back to the last snapshot fetched or, on
+// the first load, to the one saved in the file.
+func (c *Client) loadSnapshot(ctx context.Context) (map[string]string, error) {
+	c.mu.RLock()
+	current := c.snapshot
+	c.mu.RUnlock()
+	etag := ""
+	if current != nil {
+		etag = current.ETag
+	}
+
+	var snapshot *bundle.Bundle
+	err := c.do(ctx, func(ctx context.Context, e endpoint) (err error) {
+		snapshot, err = e.snapshot(ctx, c.options.Namespace, c.options.Profile, etag)
+		return err
+	})
+	switch {
+	case errors.Is(err, errNotModified):
+		snapshot = current
+	case isUnavailable(err) && current != nil:
+		snapshot = current
+	case isUnavailable(err):
+		saved, loadErr := bundle.Load(c.options.SnapshotFile, c.options.SnapshotKey)
+		if loadErr != nil {
+			return nil, fmt.Errorf("%w, and the snapshot file cannot be read: %v", err, loadErr)
+		}
+		snapshot = saved
+	case err != nil:
+		return nil, err
+	default:
+		if err := snapshot.Verify(c.options.SnapshotKey); err != nil {
+			return nil, fmt.Errorf("stookv: %v", err)
+		}
+		if err := snapshot.Save(c.options.SnapshotFile); err != nil {
+			return nil, fmt.Errorf("stookv: cannot save the snapshot: %v", err)
+		}
+	}
+
+	values, err := snapshot.Open(c.options.SnapshotKey)
+	if err != nil {
+		return nil, fmt.Errorf("stookv: %v", err)
+	}
+	c.mu.Lock()
+	c.snapshot = snapshot
+	c.mu.Unlock()
+	return values, nil
+}
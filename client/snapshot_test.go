@@ -0,0 +1,147 @@
+package client
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"stoo-kv/internal/bundle"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSnapshotKey = "0123456789abcdef0123456789abcdef"
+
+var snapshotValues = map[string]string{"host": "db.internal", "password": "hunter2"}
+
+func newTestSnapshot(t *testing.T, payloads map[string]string) *bundle.Bundle {
+	t.Helper()
+	b, err := bundle.New("app", "prod", payloads, nil, []string{"password"}, testSnapshotKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func newSnapshotClient(t *testing.T, file string, e *fakeEndpoint) *Client {
+	t.Helper()
+	return newTestClient(Options{SnapshotFile: file, SnapshotKey: testSnapshotKey, CacheTTL: time.Hour}, e)
+}
+
+func TestSnapshotSaved(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "snapshot.json")
+	e := &fakeEndpoint{bundle: newTestSnapshot(t, snapshotValues)}
+	c := newSnapshotClient(t, file, e)
+	values, err := c.loadSnapshot(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(values, snapshotValues) {
+		t.Errorf("loadSnapshot() = %v, want %v", values, snapshotValues)
+	}
+	saved, err := bundle.Load(file, testSnapshotKey)
+	if err != nil {
+		t.Fatalf("snapshot file: %v", err)
+	}
+	if saved.ETag != e.bundle.ETag {
+		t.Errorf("saved ETag = %s, want %s", saved.ETag, e.bundle.ETag)
+	}
+
+	// An unchanged snapshot is answered with not modified and the last one is kept.
+	if values, err = c.loadSnapshot(context.Background()); err != nil || !reflect.DeepEqual(values, snapshotValues) {
+		t.Errorf("loadSnapshot() when not modified = %v, %v", values, err)
+	}
+}
+
+func TestSnapshotFallback(t *testing.T) {
+	tests := []struct {
+		name string
+		// file writes the snapshot file before the first load.
+		file    func(t *testing.T, file string)
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "saved snapshot", file: func(t *testing.T, file string) {
+			if err := newTestSnapshot(t, snapshotValues).Save(file); err != nil {
+				t.Fatal(err)
+			}
+		}, want: snapshotValues},
+		{name: "no snapshot file", file: func(t *testing.T, file string) {}, wantErr: true},
+		{name: "edited snapshot file", file: func(t *testing.T, file string) {
+			b := newTestSnapshot(t, snapshotValues)
+			b.Values["host"] = "evil.example.com"
+			if err := b.Save(file); err != nil {
+				t.Fatal(err)
+			}
+		}, wantErr: true},
+		{name: "snapshot signed with another key", file: func(t *testing.T, file string) {
+			b, err := bundle.New("app", "prod", snapshotValues, nil, nil, "fedcba9876543210fedcba9876543210")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := b.Save(file); err != nil {
+				t.Fatal(err)
+			}
+		}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "snapshot.json")
+			tt.file(t, file)
+			c := newSnapshotClient(t, file, &fakeEndpoint{err: errDown})
+			values, err := c.loadSnapshot(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadSnapshot() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(values, tt.want) {
+				t.Errorf("loadSnapshot() = %v, want %v", values, tt.want)
+			}
+		})
+	}
+}
+
+func TestSnapshotKeptWhileUnavailable(t *testing.T) {
+	e := &fakeEndpoint{bundle: newTestSnapshot(t, snapshotValues)}
+	c := newSnapshotClient(t, filepath.Join(t.TempDir(), "snapshot.json"), e)
+	if _, err := c.loadSnapshot(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	e.setErr(errDown)
+	values, err := c.loadSnapshot(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(values, snapshotValues) {
+		t.Errorf("loadSnapshot() = %v, want %v", values, snapshotValues)
+	}
+}
+
+func TestTamperedSnapshotRejected(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "snapshot.json")
+	e := &fakeEndpoint{bundle: newTestSnapshot(t, snapshotValues)}
+	c := newSnapshotClient(t, file, e)
+	if err := c.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := newTestSnapshot(t, map[string]string{"host": "db.example.com", "password": "hunter2"})
+	tampered.Values["host"] = "evil.example.com"
+	e.mu.Lock()
+	e.bundle = tampered
+	e.mu.Unlock()
+	err = c.Refresh(context.Background())
+	if err == nil || !strings.Contains(err.Error(), bundle.ErrInvalidSignature.Error()) {
+		t.Errorf("Refresh() = %v, want the invalid signature", err)
+	}
+	if value, err := c.Get(context.Background(), "host"); err != nil || value != "db.internal" {
+		t.Errorf("Get() after a tampered snapshot = %q, %v, want the last verified value", value, err)
+	}
+	if data, err := os.ReadFile(file); err != nil || string(data) != string(saved) {
+		t.Errorf("snapshot file replaced by a tampered snapshot: %v", err)
+	}
+}
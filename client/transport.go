@@ -0,0 +1,318 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"io"
+	"net/http"
+	"net/url"
+	"stoo-kv/api/grpc/proto"
+	"stoo-kv/internal/bundle"
+	"strings"
+)
+
+// endpoint is one stookv server reached over REST or gRPC.
+type endpoint interface {
+	get(ctx context.Context, namespace, profile, key string, reveal bool) (string, error)
+	list(ctx context.Context, namespace, profile string, reveal bool) (map[string]string, error)
+	set(ctx context.Context, namespace, profile, key, value string, secret bool) error
+	delete(ctx context.Context, namespace, profile, key string) error
+	crypt(ctx context.Context, operation, data string) (string, error)
+	snapshot(ctx context.Context, namespace, profile, etag string) (*bundle.Bundle, error)
+	close() error
+}
+
+// unavailableError marks failures worth retrying on another server.
+type unavailableError struct {
+	err error
+}
+
+func (e *unavailableError) Error() string { return e.err.Error() }
+
+func (e *unavailableError) Unwrap() error { return e.err }
+
+// errNotModified reports that the snapshot did not change since it was last fetched.
+var errNotModified = errors.New("stookv: snapshot not modified")
+
+func isUnavailable(err error) bool {
+	var unavailable *unavailableError
+	return errors.As(err, &unavailable)
+}
+
+type restEndpoint struct {
+	baseURL string
+	token   string
+	client  *http.Client
+}
+
+type restResponse struct {
+	Status  int             `json:"status"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// REST responses use these status codes next to the HTTP status.
+const (
+	restStatusSuccess     = 0
+	restStatusNotFound    = -2
+	restStatusUnavailable = -7
+)
+
+func newRestEndpoint(address, token string, client *http.Client) *restEndpoint {
+	return &restEndpoint{baseURL: strings.TrimSuffix(address, "/") + "/stoo-kv", token: token, client: client}
+}
+
+func (r *restEndpoint) get(ctx context.Context, namespace, profile, key string, reveal bool) (string, error) {
+	path := fmt.Sprintf("/%s/%s/%s", url.PathEscape(namespace), url.PathEscape(profile), url.PathEscape(key))
+	if reveal {
+		path += "?reveal=true"
+	}
+	data, err := r.do(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return "", err
+	}
+	return rawString(data)
+}
+
+func (r *restEndpoint) list(ctx context.Context, namespace, profile string, reveal bool) (map[string]string, error) {
+	path := fmt.Sprintf("/%s/%s", url.PathEscape(namespace), url.PathEscape(profile))
+	if reveal {
+		path += "?reveal=true"
+	}
+	data, err := r.do(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	values := make(map[string]string, len(raw))
+	for key, value := range raw {
+		if values[key], err = rawString(value); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+func (r *restEndpoint) set(ctx context.Context, namespace, profile, key, value string, secret bool) error {
+	path := fmt.Sprintf("/%s/%s", url.PathEscape(namespace), url.PathEscape(profile))
+	if secret {
+		path = "/secrets" + path
+	}
+	body, err := json.Marshal(map[string]string{"key": key, "value": value})
+	if err != nil {
+		return err
+	}
+	_, err = r.do(ctx, http.MethodPost, path, body)
+	return err
+}
+
+func (r *restEndpoint) delete(ctx context.Context, namespace, profile, key string) error {
+	_, err := r.do(ctx, http.MethodDelete, fmt.Sprintf("/%s/%s?key=%s", url.PathEscape(namespace), url.PathEscape(profile), url.QueryEscape(key)), nil)
+	return err
+}
+
+// snapshot fetches the signed snapshot, returning errNotModified when its ETag is still etag.
+func (r *restEndpoint) snapshot(ctx context.Context, namespace, profile, etag string) (*bundle.Bundle, error) {
+	header := http.Header{}
+	if etag != "" {
+		header.Set("If-None-Match", `"`+etag+`"`)
+	}
+	data, err := r.request(ctx, http.MethodGet, fmt.Sprintf("/_/snapshots/%s/%s", url.PathEscape(namespace), url.PathEscape(profile)), nil, header)
+	if err != nil {
+		return nil, err
+	}
+	b := &bundle.Bundle{}
+	return b, json.Unmarshal(data, b)
+}
+
+// crypt calls the encrypt or decrypt endpoint, which exchange plain text bodies.
+func (r *restEndpoint) crypt(ctx context.Context, operation, data string) (string, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, r.baseURL+"/"+operation, strings.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	if r.token != "" {
+		request.Header.Set("Authorization", "Bearer "+r.token)
+	}
+	response, err := r.client.Do(request)
+	if err != nil {
+		return "", &unavailableError{err}
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return "", &unavailableError{err}
+	}
+	if response.StatusCode != http.StatusOK {
+		result := &restResponse{Status: response.StatusCode, Message: response.Status}
+		_ = json.Unmarshal(body, result)
+		return "", &Error{Status: result.Status, Message: result.Message}
+	}
+	return string(body), nil
+}
+
+func (r *restEndpoint) do(ctx context.Context, method, path string, body []byte) (json.RawMessage, error) {
+	return r.request(ctx, method, path, body, nil)
+}
+
+func (r *restEndpoint) request(ctx context.Context, method, path string, body []byte, header http.Header) (json.RawMessage, error) {
+	request, err := http.NewRequestWithContext(ctx, method, r.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		request.Header[name] = values
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if r.token != "" {
+		request.Header.Set("Authorization", "Bearer "+r.token)
+	}
+	response, err := r.client.Do(request)
+	if err != nil {
+		return nil, &unavailableError{err}
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNotModified {
+		return nil, errNotModified
+	}
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, &unavailableError{err}
+	}
+
+	result := &restResponse{}
+	if err := json.Unmarshal(data, result); err != nil {
+		err = fmt.Errorf("unexpected response from %s: %s", r.baseURL, response.Status)
+		if response.StatusCode >= http.StatusBadGateway {
+			return nil, &unavailableError{err}
+		}
+		return nil, err
+	}
+	switch result.Status {
+	case restStatusSuccess:
+		return result.Data, nil
+	case restStatusNotFound:
+		return nil, ErrNotFound
+	case restStatusUnavailable:
+		return nil, &unavailableError{&Error{Status: result.Status, Message: result.Message}}
+	default:
+		return nil, &Error{Status: result.Status, Message: result.Message}
+	}
+}
+
+func (r *restEndpoint) close() error {
+	return nil
+}
+
+// rawString converts a REST value into its text form: strings as they are, JSON documents and lists
+// as compact JSON.
+func rawString(data json.RawMessage) (string, error) {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		return text, nil
+	}
+	var b bytes.Buffer
+	if err := json.Compact(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+type grpcEndpoint struct {
+	conn   *grpc.ClientConn
+	client proto.KVServiceClient
+	token  string
+}
+
+func newGrpcEndpoint(address, token string, tlsConfig *tls.Config) (*grpcEndpoint, error) {
+	creds := insecure.NewCredentials()
+	if tlsConfig != nil {
+		creds = credentials.NewTLS(tlsConfig)
+	}
+	conn, err := grpc.Dial(address, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, err
+	}
+	return &grpcEndpoint{conn: conn, client: proto.NewKVServiceClient(conn), token: token}, nil
+}
+
+func (g *grpcEndpoint) get(ctx context.Context, namespace, profile, key string, reveal bool) (string, error) {
+	response, err := g.client.GetService(g.context(ctx), &proto.GetRequest{Namespace: namespace, Profile: profile, Key: key, Reveal: reveal})
+	if err != nil {
+		return "", grpcError(err)
+	}
+	if len(response.BinaryData) > 0 {
+		return string(response.BinaryData), nil
+	}
+	return response.Data, nil
+}
+
+func (g *grpcEndpoint) list(ctx context.Context, namespace, profile string, reveal bool) (map[string]string, error) {
+	response, err := g.client.GetServiceByNamespaceAndProfile(g.context(ctx), &proto.GetByNamespaceAndProfileRequest{Namespace: namespace, Profile: profile, Reveal: reveal})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return response.Data, nil
+}
+
+func (g *grpcEndpoint) set(ctx context.Context, namespace, profile, key, value string, secret bool) error {
+	request := &proto.SetKeyRequest{Namespace: namespace, Profile: profile, Key: key, Value: value}
+	var err error
+	if secret {
+		_, err = g.client.SetSecretKeyService(g.context(ctx), request)
+	} else {
+		_, err = g.client.SetKeyService(g.context(ctx), request)
+	}
+	return grpcError(err)
+}
+
+func (g *grpcEndpoint) delete(ctx context.Context, namespace, profile, key string) error {
+	_, err := g.client.DeleteKeyService(g.context(ctx), &proto.DeleteKeyRequest{Namespace: namespace, Profile: profile, Key: key})
+	return grpcError(err)
+}
+
+func (g *grpcEndpoint) crypt(ctx context.Context, operation, data string) (string, error) {
+	return "", fmt.Errorf("stookv: %s is only available over REST", operation)
+}
+
+func (g *grpcEndpoint) snapshot(ctx context.Context, namespace, profile, etag string) (*bundle.Bundle, error) {
+	return nil, errors.New("stookv: snapshots are only available over REST")
+}
+
+func (g *grpcEndpoint) context(ctx context.Context) context.Context {
+	if g.token == "" {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+g.token)
+}
+
+func (g *grpcEndpoint) close() error {
+	return g.conn.Close()
+}
+
+func grpcError(err error) error {
+	if err == nil {
+		return nil
+	}
+	switch status.Code(err) {
+	case codes.NotFound:
+		return ErrNotFound
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted:
+		return &unavailableError{err}
+	default:
+		return &Error{Status: int(status.Code(err)), Message: status.Convert(err).Message()}
+	}
+}
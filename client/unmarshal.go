@@ -0,0 +1,129 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Unmarshal fills the fields of the struct pointed to by v from the values of the namespace and
+// profile. Fields are matched by their `stoo` tag, e.g. `stoo:"database.port"`; untagged fields are
+// left alone, except nested structs which are filled in turn. Keys that do not exist keep the
+// field's current value, unless the tag has the required option: `stoo:"database.url,required"`.
+//
+// Strings, booleans, numbers and durations are parsed from text, string slices from lists or comma
+// separated text, and any other type is decoded from a JSON document.
+func (c *Client) Unmarshal(ctx context.Context, v any) error {
+	values, err := c.All(ctx)
+	if err != nil {
+		return err
+	}
+	return Decode(values, v)
+}
+
+// Decode fills a struct from values the way Unmarshal does.
+func Decode(values map[string]string, v any) error {
+	target := reflect.ValueOf(v)
+	if target.Kind() != reflect.Pointer || target.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("stookv: unmarshal target must be a pointer to a struct, got %T", v)
+	}
+	return decodeStruct(values, target.Elem())
+}
+
+func decodeStruct(values map[string]string, target reflect.Value) error {
+	t := target.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field, value := t.Field(i), target.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		tag, ok := field.Tag.Lookup("stoo")
+		if !ok {
+			if field.Type.Kind() == reflect.Struct && field.Type != reflect.TypeOf(time.Time{}) {
+				if err := decodeStruct(values, value); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		key, option, _ := strings.Cut(tag, ",")
+		if key == "-" {
+			continue
+		}
+		raw, ok := values[key]
+		if !ok {
+			if option == "required" {
+				return fmt.Errorf("stookv: required key %s not found", key)
+			}
+			continue
+		}
+		if err := decodeValue(raw, value); err != nil {
+			return fmt.Errorf("stookv: cannot decode %s into %s: %v", key, field.Name, err)
+		}
+	}
+	return nil
+}
+
+func decodeValue(raw string, value reflect.Value) error {
+	if value.Type() == durationType {
+		duration, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		value.SetInt(int64(duration))
+		return nil
+	}
+
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		value.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		number, err := strconv.ParseInt(raw, 10, value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		value.SetInt(number)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		number, err := strconv.ParseUint(raw, 10, value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		value.SetUint(number)
+	case reflect.Float32, reflect.Float64:
+		number, err := strconv.ParseFloat(raw, value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		value.SetFloat(number)
+	case reflect.Pointer:
+		if value.IsNil() {
+			value.Set(reflect.New(value.Type().Elem()))
+		}
+		return decodeValue(raw, value.Elem())
+	case reflect.Slice:
+		if value.Type().Elem().Kind() == reflect.String && !strings.HasPrefix(strings.TrimSpace(raw), "[") {
+			items := strings.Split(raw, ",")
+			for i := range items {
+				items[i] = strings.TrimSpace(items[i])
+			}
+			value.Set(reflect.ValueOf(items).Convert(value.Type()))
+			return nil
+		}
+		return json.Unmarshal([]byte(raw), value.Addr().Interface())
+	default:
+		return json.Unmarshal([]byte(raw), value.Addr().Interface())
+	}
+	return nil
+}
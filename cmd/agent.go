@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"stoo-kv/internal/agent"
+	"syscall"
+)
+
+// Agent renders template files from stookv values and keeps them up to date, e.g.
+// stoo-kv agent -config ./conf/agent.json.
+func Agent(args []string) error {
+	fs := flag.NewFlagSet("agent", flag.ContinueOnError)
+	configFile := fs.String("config", "./conf/agent.json", "Agent configuration file")
+	once := fs.Bool("once", false, "render the templates once and exit")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	cfg, err := agent.ParseConfig(*configFile)
+	if err != nil {
+		return err
+	}
+	a, err := agent.New(cfg)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *once {
+		return a.RenderAll(ctx)
+	}
+	log.Printf("Rendering %d templates...", len(cfg.Templates))
+	return a.Run(ctx)
+}
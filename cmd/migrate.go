@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"stoo-kv/config"
+	"stoo-kv/internal/provider"
+	"text/tabwriter"
+	"time"
+)
+
+// Migrate applies, reverts or lists the schema migrations of the key table of a SQL storage type,
+// e.g. stoo-kv migrate up, stoo-kv migrate down -steps 1 or stoo-kv migrate status.
+func Migrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: stoo-kv migrate [flags] up|down|status")
+		fs.PrintDefaults()
+	}
+	configFile := fs.String("config.file", "", "Configuration file, $STOOKV_CONFIG_FILE or ./conf/stoo_kv.json by default")
+	target := fs.Int("to", 0, "version to migrate up to, the latest by default")
+	steps := fs.Int("steps", 1, "how many migrations to revert with down")
+	force := fs.Bool("force", false, "allow down to revert migration 1, which drops the key table")
+	storageType := fs.String("storage", "", "SQL storage type to migrate, storage_type by default")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("one of up, down or status is required")
+	}
+	// Flags may also follow the command, e.g. stoo-kv migrate up -to 2.
+	command := fs.Arg(0)
+	if err := fs.Parse(fs.Args()[1:]); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return fmt.Errorf("unexpected arguments %v", fs.Args())
+	}
+
+	cfg, err := config.LoadConfig(*configFile)
+	if err != nil {
+		return err
+	}
+	if *storageType == "" {
+		*storageType = cfg.Application.StorageType
+	}
+	rdbms, err := provider.OpenRdbms(cfg, *storageType)
+	if err != nil {
+		return err
+	}
+	switch command {
+	case "up":
+		count, err := rdbms.MigrateUp(*target)
+		fmt.Printf("Applied %d migrations\n", count)
+		return err
+	case "down":
+		count, err := rdbms.MigrateDown(*steps, *force)
+		if errors.Is(err, provider.ErrDestructiveMigration) {
+			err = fmt.Errorf("%v, pass -force to revert it", err)
+		}
+		fmt.Printf("Reverted %d migrations\n", count)
+		return err
+	case "status":
+		statuses, err := rdbms.Migrations()
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED")
+		for _, status := range statuses {
+			applied := "pending"
+			if status.AppliedAt != nil {
+				applied = status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\n", status.Version, status.Name, applied)
+		}
+		return tw.Flush()
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown migrate command %q", command)
+	}
+}
@@ -0,0 +1,307 @@
+package cmd
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sort"
+	"stoo-kv/api"
+	"stoo-kv/config"
+	"stoo-kv/internal/auth"
+	"stoo-kv/internal/content"
+	"stoo-kv/internal/environ"
+	"stoo-kv/internal/render"
+	"stoo-kv/internal/store"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	OnChangeRestart = "restart"
+	OnChangeSignal  = "signal"
+	OnChangeNone    = "none"
+)
+
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+type runOptions struct {
+	configFile  string
+	namespace   string
+	profile     string
+	prefix      string
+	noOverride  bool
+	templates   stringList
+	watch       time.Duration
+	onChange    string
+	signal      string
+	killTimeout time.Duration
+	command     []string
+}
+
+// templateFile is a -template flag: "source:destination" with an optional ":mode", e.g. ":0640".
+type templateFile struct {
+	source      string
+	destination string
+	perm        os.FileMode
+}
+
+// Run reads the keys of a namespace and profile from storage and starts a command with them as
+// environment variables and rendered template files, e.g. stoo-kv run -n my-app -p prod -- ./my-app.
+// With -watch the command is restarted or signalled when the values change. It returns the exit
+// code of the command.
+func Run(args []string) (int, error) {
+	o := &runOptions{}
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: stoo-kv run [flags] -- COMMAND [ARGS...]")
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&o.configFile, "config.file", "", "Configuration file, $STOOKV_CONFIG_FILE or ./conf/stoo_kv.json by default")
+	fs.StringVar(&o.namespace, "n", "", "namespace of the keys")
+	fs.StringVar(&o.profile, "p", "", "profile of the keys")
+	fs.StringVar(&o.prefix, "prefix", "", "prefix of the environment variable names")
+	fs.BoolVar(&o.noOverride, "no-override", false, "keep environment variables that are already set")
+	fs.Var(&o.templates, "template", "template to render before starting, as source:destination[:mode] (repeatable)")
+	fs.DurationVar(&o.watch, "watch", 0, "how often to check for changes, 0 disables watching")
+	fs.StringVar(&o.onChange, "on-change", OnChangeRestart, "what to do with the command on change: restart, signal or none")
+	fs.StringVar(&o.signal, "signal", "SIGHUP", "signal sent with -on-change signal")
+	fs.DurationVar(&o.killTimeout, "kill-timeout", 10*time.Second, "how long to wait for the command to stop before killing it")
+	if err := fs.Parse(args); err != nil {
+		return 2, err
+	}
+	o.command = fs.Args()
+	if o.namespace == "" || o.profile == "" || len(o.command) == 0 {
+		fs.Usage()
+		return 2, errors.New("namespace, profile and command are required")
+	}
+	switch o.onChange {
+	case OnChangeRestart, OnChangeSignal, OnChangeNone:
+	default:
+		return 2, fmt.Errorf("invalid -on-change %q", o.onChange)
+	}
+	reloadSignal, err := parseSignal(o.signal)
+	if err != nil {
+		return 2, err
+	}
+	templates, err := parseTemplates(o.templates)
+	if err != nil {
+		return 2, err
+	}
+
+	cfg, err := config.LoadConfig(o.configFile)
+	if err != nil {
+		return 1, err
+	}
+	storage, err := store.NewStorage(cfg)
+	if err != nil {
+		return 1, err
+	}
+	values, err := readValues(storage, cfg, o.namespace, o.profile)
+	if err != nil {
+		return 1, err
+	}
+	if err := renderTemplates(templates, values); err != nil {
+		return 1, err
+	}
+
+	child, err := startCommand(o, values)
+	if err != nil {
+		return 1, err
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGUSR1, syscall.SIGUSR2)
+	defer signal.Stop(signals)
+
+	var ticks <-chan time.Time
+	if o.watch > 0 {
+		ticker := time.NewTicker(o.watch)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+	for {
+		select {
+		case sig := <-signals:
+			_ = child.cmd.Process.Signal(sig)
+		case <-child.done:
+			return child.cmd.ProcessState.ExitCode(), nil
+		case <-ticks:
+			latest, err := readValues(storage, cfg, o.namespace, o.profile)
+			if err != nil {
+				log.Printf("Failed to read keys from storage: %v", err)
+				continue
+			}
+			if equal(values, latest) {
+				continue
+			}
+			values = latest
+			log.Printf("Keys of %s/%s changed", o.namespace, o.profile)
+			if err := renderTemplates(templates, values); err != nil {
+				log.Printf("Failed to render templates: %v", err)
+				continue
+			}
+			switch o.onChange {
+			case OnChangeSignal:
+				_ = child.cmd.Process.Signal(reloadSignal)
+			case OnChangeRestart:
+				log.Printf("Restarting %s", o.command[0])
+				child.stop(o.killTimeout)
+				if child, err = startCommand(o, values); err != nil {
+					return 1, err
+				}
+			}
+		}
+	}
+}
+
+type process struct {
+	cmd  *exec.Cmd
+	done chan struct{}
+}
+
+func startCommand(o *runOptions, values map[string]string) (*process, error) {
+	cmd := exec.Command(o.command[0], o.command[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = environment(os.Environ(), values, o.prefix, o.noOverride)
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	p := &process{cmd: cmd, done: make(chan struct{})}
+	go func() {
+		_ = cmd.Wait()
+		close(p.done)
+	}()
+	return p, nil
+}
+
+// stop terminates the process, killing it after the timeout.
+func (p *process) stop(timeout time.Duration) {
+	_ = p.cmd.Process.Signal(syscall.SIGTERM)
+	select {
+	case <-p.done:
+	case <-time.After(timeout):
+		_ = p.cmd.Process.Kill()
+		<-p.done
+	}
+}
+
+// operator is the identity of the command, which reads the storage with the rights of whoever runs it.
+var operator = &auth.Identity{Name: "stoo-kv run", Permissions: []string{auth.PermissionAll}}
+
+// readValues returns the decrypted and interpolated values of a namespace and profile, the way
+// the API reads them when secrets are revealed.
+func readValues(storage store.Store, cfg *config.Config, namespace, profile string) (map[string]string, error) {
+	values, err := storage.GetByNameSpaceAndProfile(namespace, profile)
+	if err != nil {
+		return nil, err
+	}
+	values, _ = api.ReadValues(storage, cfg, operator, namespace, profile, values, false, true)
+	for k, v := range values {
+		_, values[k] = content.Decode(v)
+	}
+	return values, nil
+}
+
+func environment(base []string, values map[string]string, prefix string, noOverride bool) []string {
+	env := make([]string, 0, len(base)+len(values))
+	existing := make(map[string]bool, len(base))
+	injected := make(map[string]string, len(values))
+	for key, value := range values {
+		injected[environ.Name(prefix, key)] = value
+	}
+	for _, entry := range base {
+		name, _, _ := strings.Cut(entry, "=")
+		existing[name] = true
+		if _, ok := injected[name]; ok && !noOverride {
+			continue
+		}
+		env = append(env, entry)
+	}
+	names := make([]string, 0, len(injected))
+	for name := range injected {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if noOverride && existing[name] {
+			continue
+		}
+		env = append(env, name+"="+injected[name])
+	}
+	return env
+}
+
+func parseTemplates(flags []string) ([]templateFile, error) {
+	templates := make([]templateFile, 0, len(flags))
+	for _, flag := range flags {
+		parts := strings.Split(flag, ":")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid -template %q, expected source:destination[:mode]", flag)
+		}
+		t := templateFile{source: parts[0], destination: parts[1], perm: 0644}
+		if len(parts) == 3 {
+			perm, err := strconv.ParseUint(parts[2], 8, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid mode in -template %q: %v", flag, err)
+			}
+			t.perm = os.FileMode(perm)
+		}
+		templates = append(templates, t)
+	}
+	return templates, nil
+}
+
+func renderTemplates(templates []templateFile, values map[string]string) error {
+	for _, t := range templates {
+		data, err := render.ExecuteFile(t.source, values)
+		if err != nil {
+			return fmt.Errorf("failed to render %s: %v", t.source, err)
+		}
+		if _, err := render.WriteFile(t.destination, data, t.perm); err != nil {
+			return fmt.Errorf("failed to write %s: %v", t.destination, err)
+		}
+	}
+	return nil
+}
+
+func parseSignal(name string) (syscall.Signal, error) {
+	switch strings.TrimPrefix(strings.ToUpper(name), "SIG") {
+	case "HUP":
+		return syscall.SIGHUP, nil
+	case "INT":
+		return syscall.SIGINT, nil
+	case "TERM":
+		return syscall.SIGTERM, nil
+	case "QUIT":
+		return syscall.SIGQUIT, nil
+	case "USR1":
+		return syscall.SIGUSR1, nil
+	case "USR2":
+		return syscall.SIGUSR2, nil
+	}
+	return 0, fmt.Errorf("unsupported signal %q", name)
+}
+
+func equal(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || w != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"stoo-kv/config"
+	"stoo-kv/internal/crypto"
+	"stoo-kv/internal/provider"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestEnvironment(t *testing.T) {
+	base := []string{"PATH=/bin", "APP_DATABASE_URL=old", "HOME=/root"}
+	values := map[string]string{"database.url": "postgres://db", "log-level": "debug", "9lives": "yes"}
+	tests := []struct {
+		name       string
+		prefix     string
+		noOverride bool
+		want       []string
+	}{
+		{
+			name: "values added as names",
+			want: []string{"PATH=/bin", "APP_DATABASE_URL=old", "HOME=/root", "DATABASE_URL=postgres://db", "LOG_LEVEL=debug", "_9LIVES=yes"},
+		},
+		{
+			name:   "values override the environment",
+			prefix: "APP_",
+			want:   []string{"PATH=/bin", "HOME=/root", "APP_9LIVES=yes", "APP_DATABASE_URL=postgres://db", "APP_LOG_LEVEL=debug"},
+		},
+		{
+			name:       "environment kept with no-override",
+			prefix:     "APP_",
+			noOverride: true,
+			want:       []string{"PATH=/bin", "APP_DATABASE_URL=old", "HOME=/root", "APP_9LIVES=yes", "APP_LOG_LEVEL=debug"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := environment(base, values, test.prefix, test.noOverride)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestReadValues(t *testing.T) {
+	key := "0123456789abcdef0123456789abcdef"
+	cfg := &config.Config{Application: &config.ApplicationConfig{EncryptKey: key, SecretMasking: config.SecretMaskingMask}}
+	sealed, err := crypto.Seal("s3cret", key, "")
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	storage := provider.NewMemory()
+	for k, v := range map[string]string{
+		"app::prod::password": sealed,
+		"app::prod::url":      "postgres://app:${password}@${shared:dev:host}",
+		"app::prod::replicas": `{TYPE application/x-stoo-list} ["r0","r1"]`,
+		"shared::dev::host":   "db",
+	} {
+		if err := storage.Set(k, v); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+	}
+	got, err := readValues(storage, cfg, "app", "prod")
+	if err != nil {
+		t.Fatalf("readValues: %v", err)
+	}
+	// Secrets are revealed to the command, references resolved and content types dropped.
+	want := map[string]string{"password": "s3cret", "url": "postgres://app:s3cret@db", "replicas": `["r0","r1"]`}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestStartCommand(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	t.Setenv("STOOKV_TEST_KEEP", "kept")
+	o := &runOptions{prefix: "APP_", command: []string{"/bin/sh", "-c", `printf '%s|%s' "$APP_DATABASE_URL" "$STOOKV_TEST_KEEP" > "$0"`, out}}
+	child, err := startCommand(o, map[string]string{"database.url": "postgres://db"})
+	if err != nil {
+		t.Fatalf("startCommand: %v", err)
+	}
+	select {
+	case <-child.done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("command did not exit")
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if string(data) != "postgres://db|kept" {
+		t.Errorf("got %q, want the injected value and the inherited environment", data)
+	}
+}
+
+func TestProcessStop(t *testing.T) {
+	// The command ignores SIGTERM, so it is killed after the timeout.
+	child, err := startCommand(&runOptions{command: []string{"/bin/sh", "-c", `trap "" TERM; while :; do sleep 0.01; done`}}, nil)
+	if err != nil {
+		t.Fatalf("startCommand: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	start := time.Now()
+	child.stop(50 * time.Millisecond)
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("stopped after %v, want the timeout waited for", elapsed)
+	}
+	if status, ok := child.cmd.ProcessState.Sys().(syscall.WaitStatus); !ok || status.Signal() != syscall.SIGKILL {
+		t.Errorf("got state %v, want the command killed", child.cmd.ProcessState)
+	}
+}
+
+func TestParseTemplates(t *testing.T) {
+	tests := []struct {
+		flag    string
+		want    templateFile
+		wantErr bool
+	}{
+		{flag: "app.tmpl:app.conf", want: templateFile{source: "app.tmpl", destination: "app.conf", perm: 0644}},
+		{flag: "app.tmpl:/etc/app.conf:0600", want: templateFile{source: "app.tmpl", destination: "/etc/app.conf", perm: 0600}},
+		{flag: "app.tmpl", wantErr: true},
+		{flag: ":app.conf", wantErr: true},
+		{flag: "app.tmpl:app.conf:rw", wantErr: true},
+		{flag: "a:b:0600:x", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := parseTemplates([]string{test.flag})
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("parseTemplates(%q): got %v, want an error", test.flag, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, []templateFile{test.want}) {
+			t.Errorf("parseTemplates(%q): got %v, %v, want %v", test.flag, got, err, test.want)
+		}
+	}
+}
+
+func TestParseSignal(t *testing.T) {
+	for name, want := range map[string]syscall.Signal{"SIGHUP": syscall.SIGHUP, "hup": syscall.SIGHUP, "SIGUSR2": syscall.SIGUSR2, "term": syscall.SIGTERM} {
+		if got, err := parseSignal(name); err != nil || got != want {
+			t.Errorf("parseSignal(%q): got %v, %v, want %v", name, got, err, want)
+		}
+	}
+	if _, err := parseSignal("SIGKILL"); err == nil {
+		t.Errorf("parseSignal(SIGKILL): want an error")
+	}
+}
@@ -1,11 +1,19 @@
 package cmd
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"os"
+	"os/signal"
 	"stoo-kv/api"
 	"stoo-kv/api/grpc"
 	"stoo-kv/config"
+	"stoo-kv/internal/secrets"
 	"stoo-kv/internal/store"
+	"stoo-kv/internal/webhook"
+	"syscall"
+	"time"
 )
 
 func Start() error {
@@ -20,10 +28,57 @@ func Start() error {
 		return err
 	}
 
+	if replicated, ok := storage.(*store.ReplicatedStore); ok {
+		reconcileInterval, err := time.ParseDuration(cfg.Application.ReconcileInterval)
+		if err != nil {
+			return fmt.Errorf("invalid reconcile_interval %q", cfg.Application.ReconcileInterval)
+		}
+		if reconcileInterval > 0 {
+			log.Println("Start storage reconciliation asynchronously...")
+			go replicated.Run(context.Background(), reconcileInterval)
+		}
+	}
+
+	log.Println("Start secrets engine lease checks asynchronously...")
+	leaseCheckInterval, err := secrets.ParseDuration(cfg.Application.SecretLeaseCheckInterval)
+	if err != nil || leaseCheckInterval <= 0 {
+		return fmt.Errorf("invalid secret_lease_check_interval %q", cfg.Application.SecretLeaseCheckInterval)
+	}
+	webhookTimeout, err := time.ParseDuration(cfg.Application.WebhookTimeout)
+	if err != nil {
+		return fmt.Errorf("invalid webhook_timeout %q", cfg.Application.WebhookTimeout)
+	}
+	webhookRetryBackoff, err := time.ParseDuration(cfg.Application.WebhookRetryBackoff)
+	if err != nil || webhookRetryBackoff <= 0 {
+		return fmt.Errorf("invalid webhook_retry_backoff %q", cfg.Application.WebhookRetryBackoff)
+	}
+	sender := webhook.NewSender(webhookTimeout, func() []string {
+		return cfg.Current().WebhookAllowedHosts
+	})
+	engine := secrets.NewEngine(storage, cfg, sender)
+	go engine.Run(context.Background(), leaseCheckInterval)
+	dispatcher := webhook.NewDispatcher(storage, cfg, sender, cfg.Application.WebhookMaxAttempts, webhookRetryBackoff, cfg.Application.WebhookQueueSize)
+	go dispatcher.Run(context.Background(), cfg.Application.WebhookWorkers)
+
 	log.Println("Start GRPC server asynchronously...")
-	if err := grpc.RunGrpcServer(cfg, storage); err != nil {
+	if err := grpc.RunGrpcServer(cfg, storage, dispatcher); err != nil {
 		return err
 	}
+	go reloadOnHangup(cfg)
 	log.Println("Initialize REST API routes...")
-	return api.InitializeRoutes(storage, cfg)
+	return api.InitializeRoutes(storage, cfg, engine, dispatcher)
+}
+
+// reloadOnHangup reloads the configurations on SIGHUP.
+func reloadOnHangup(cfg *config.Config) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		report, err := cfg.Reload()
+		if err != nil {
+			log.Printf("Failed to reload configurations: %v", err)
+			continue
+		}
+		log.Printf("Reloaded configurations: %v, restart required for %v", report.Reloaded, report.RestartRequired)
+	}
 }
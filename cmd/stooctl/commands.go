@@ -0,0 +1,510 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"stoo-kv/client"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// globalFlags are accepted by every command and override the current context.
+type globalFlags struct {
+	context   string
+	namespace string
+	profile   string
+	output    string
+	address   string
+	transport string
+	token     string
+	reveal    bool
+}
+
+func newFlagSet(name string, g *globalFlags) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: stooctl %s\n", commands[name].usage)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&g.context, "context", "", "context of the config file to use instead of the current one")
+	fs.StringVar(&g.namespace, "n", "", "namespace, defaults to the context namespace")
+	fs.StringVar(&g.profile, "p", "", "profile, defaults to the context profile")
+	fs.StringVar(&g.output, "o", outputTable, "output format: table, json, yaml or env")
+	fs.StringVar(&g.address, "address", "", "comma separated server addresses overriding the context")
+	fs.StringVar(&g.transport, "transport", "", "rest or grpc, overriding the context")
+	fs.StringVar(&g.token, "token", "", "access token overriding the context")
+	fs.BoolVar(&g.reveal, "reveal", false, "return the values of secrets when the server masks them")
+	return fs
+}
+
+func (g *globalFlags) parse(fs *flag.FlagSet, args []string, minArgs, maxArgs int) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < minArgs || (maxArgs >= 0 && fs.NArg() > maxArgs) {
+		fs.Usage()
+		return errors.New("wrong number of arguments")
+	}
+	return checkOutput(g.output)
+}
+
+// client connects to the server of the context, for a namespace and profile that default to the
+// flags and then the context.
+func (g *globalFlags) client(namespace, profile string) (*client.Client, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	ctx, err := cfg.context(g.context)
+	if err != nil {
+		return nil, err
+	}
+	options, err := ctx.options(first(namespace, g.namespace, ctx.Namespace), first(profile, g.profile, ctx.Profile))
+	if err != nil {
+		return nil, err
+	}
+	if g.address != "" {
+		options.Addresses = strings.Split(g.address, ",")
+	}
+	if g.transport != "" {
+		options.Transport = g.transport
+	}
+	if g.token != "" {
+		options.Token = g.token
+	}
+	if options.Namespace == "" || options.Profile == "" {
+		return nil, errors.New("namespace and profile are required, set them with -n and -p or in the context")
+	}
+	options.Reveal = g.reveal
+	return client.New(options)
+}
+
+func runGet(args []string) error {
+	g := &globalFlags{}
+	fs := newFlagSet("get", g)
+	if err := g.parse(fs, args, 1, 1); err != nil {
+		return err
+	}
+	c, err := g.client("", "")
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	key := fs.Arg(0)
+	value, err := c.Get(context.Background(), key)
+	if err != nil {
+		return err
+	}
+	if g.output == outputTable {
+		fmt.Println(value)
+		return nil
+	}
+	return writeValues(os.Stdout, g.output, map[string]string{key: value})
+}
+
+func runSet(secret bool) func(args []string) error {
+	return func(args []string) error {
+		g := &globalFlags{}
+		name := "set"
+		if secret {
+			name = "set-secret"
+		}
+		fs := newFlagSet(name, g)
+		if err := g.parse(fs, args, 2, 2); err != nil {
+			return err
+		}
+		c, err := g.client("", "")
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		if secret {
+			return c.SetSecret(context.Background(), fs.Arg(0), fs.Arg(1))
+		}
+		return c.Set(context.Background(), fs.Arg(0), fs.Arg(1))
+	}
+}
+
+func runDelete(args []string) error {
+	g := &globalFlags{}
+	fs := newFlagSet("delete", g)
+	if err := g.parse(fs, args, 1, 1); err != nil {
+		return err
+	}
+	c, err := g.client("", "")
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return c.Delete(context.Background(), fs.Arg(0))
+}
+
+func runList(args []string) error {
+	g := &globalFlags{}
+	fs := newFlagSet("list", g)
+	if err := g.parse(fs, args, 0, 0); err != nil {
+		return err
+	}
+	values, err := g.values("", "")
+	if err != nil {
+		return err
+	}
+	return writeValues(os.Stdout, g.output, values)
+}
+
+func runExport(args []string) error {
+	g := &globalFlags{}
+	fs := newFlagSet("export", g)
+	fs.Lookup("o").DefValue, g.output = outputJSON, outputJSON
+	if err := g.parse(fs, args, 0, 1); err != nil {
+		return err
+	}
+	values, err := g.values("", "")
+	if err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return writeValues(os.Stdout, g.output, values)
+	}
+	f, err := os.OpenFile(fs.Arg(0), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if err := writeValues(f, g.output, values); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func runImport(args []string) error {
+	g := &globalFlags{}
+	fs := newFlagSet("import", g)
+	format := fs.String("format", "", "file format: json, yaml or env, guessed from the extension by default")
+	secrets := fs.String("secrets", "", "comma separated keys to store as secrets")
+	dryRun := fs.Bool("dry-run", false, "print the keys that would be set without writing them")
+	if err := g.parse(fs, args, 1, 1); err != nil {
+		return err
+	}
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *format == "" {
+		*format = formatOf(fs.Arg(0))
+	}
+	values, err := readValues(data, *format)
+	if err != nil {
+		return err
+	}
+	secretKeys := make(map[string]bool)
+	for _, key := range strings.Split(*secrets, ",") {
+		secretKeys[strings.TrimSpace(key)] = true
+	}
+
+	c, err := g.client("", "")
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	for _, key := range sortedKeys(values) {
+		if *dryRun {
+			fmt.Printf("would set %s\n", key)
+			continue
+		}
+		if secretKeys[key] {
+			err = c.SetSecret(context.Background(), key, values[key])
+		} else {
+			err = c.Set(context.Background(), key, values[key])
+		}
+		if err != nil {
+			return fmt.Errorf("failed to set %s: %v", key, err)
+		}
+	}
+	if !*dryRun {
+		fmt.Printf("Imported %d keys\n", len(values))
+	}
+	return nil
+}
+
+type difference struct {
+	Key    string  `json:"key" yaml:"key"`
+	Status string  `json:"status" yaml:"status"`
+	Left   *string `json:"left,omitempty" yaml:"left,omitempty"`
+	Right  *string `json:"right,omitempty" yaml:"right,omitempty"`
+}
+
+func runDiff(args []string) error {
+	g := &globalFlags{}
+	fs := newFlagSet("diff", g)
+	if err := g.parse(fs, args, 2, 2); err != nil {
+		return err
+	}
+	if g.output == outputEnv {
+		return errors.New("diff does not support env output")
+	}
+	leftNamespace, leftProfile := splitTarget(fs.Arg(0))
+	rightNamespace, rightProfile := splitTarget(fs.Arg(1))
+	left, err := g.values(leftNamespace, leftProfile)
+	if err != nil {
+		return err
+	}
+	right, err := g.values(rightNamespace, rightProfile)
+	if err != nil {
+		return err
+	}
+
+	union := make(map[string]string, len(left)+len(right))
+	for key := range left {
+		union[key] = ""
+	}
+	for key := range right {
+		union[key] = ""
+	}
+	var differences []difference
+	for _, key := range sortedKeys(union) {
+		l, inLeft := left[key]
+		r, inRight := right[key]
+		switch {
+		case !inRight:
+			differences = append(differences, difference{Key: key, Status: "removed", Left: &l})
+		case !inLeft:
+			differences = append(differences, difference{Key: key, Status: "added", Right: &r})
+		case l != r:
+			differences = append(differences, difference{Key: key, Status: "changed", Left: &l, Right: &r})
+		}
+	}
+
+	switch g.output {
+	case outputJSON:
+		return writeJSON(os.Stdout, differences)
+	case outputYAML:
+		return writeYAML(differences)
+	}
+	rows := make([][]string, 0, len(differences))
+	for _, d := range differences {
+		rows = append(rows, []string{d.Status, d.Key, deref(d.Left), deref(d.Right)})
+	}
+	return writeTable(os.Stdout, []string{"STATUS", "KEY", fs.Arg(0), fs.Arg(1)}, rows)
+}
+
+func runCrypt(operation string) func(args []string) error {
+	return func(args []string) error {
+		g := &globalFlags{}
+		fs := newFlagSet(operation, g)
+		if err := g.parse(fs, args, 1, 1); err != nil {
+			return err
+		}
+		// encrypt and decrypt do not depend on a namespace, so placeholders are enough.
+		c, err := g.client(first(g.namespace, "-"), first(g.profile, "-"))
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		var result string
+		if operation == "encrypt" {
+			result, err = c.Encrypt(context.Background(), fs.Arg(0))
+		} else {
+			result, err = c.Decrypt(context.Background(), fs.Arg(0))
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Println(result)
+		return nil
+	}
+}
+
+func runWatch(args []string) error {
+	g := &globalFlags{}
+	fs := newFlagSet("watch", g)
+	interval := fs.Duration("interval", 5*time.Second, "how often the values are reloaded")
+	if err := g.parse(fs, args, 0, -1); err != nil {
+		return err
+	}
+	if g.output == outputEnv {
+		return errors.New("watch does not support env output")
+	}
+	watched := toSet(fs.Args())
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	c, err := g.client("", "")
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if err := c.Refresh(ctx); err != nil {
+		return err
+	}
+	c.OnChange(func(change client.Change) {
+		if len(watched) > 0 && !watched[change.Key] {
+			return
+		}
+		printChange(g.output, change)
+	})
+	fmt.Fprintf(os.Stderr, "Watching for changes every %s, press Ctrl+C to stop\n", *interval)
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := c.Refresh(ctx); err != nil && ctx.Err() == nil {
+				fmt.Fprintf(os.Stderr, "stooctl: %v\n", err)
+			}
+		}
+	}
+}
+
+func printChange(output string, change client.Change) {
+	status := "changed"
+	switch {
+	case change.Deleted:
+		status = "deleted"
+	case change.OldValue == "":
+		status = "added"
+	}
+	event := map[string]string{"time": time.Now().Format(time.RFC3339), "status": status, "key": change.Key, "value": change.NewValue}
+	switch output {
+	case outputJSON:
+		_ = writeJSON(os.Stdout, event)
+	case outputYAML:
+		_ = writeYAML([]map[string]string{event})
+	default:
+		fmt.Printf("%s\t%s\t%s\t%s\n", event["time"], status, change.Key, change.NewValue)
+	}
+}
+
+func runContext(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: stooctl " + commands["context"].usage)
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	action, args := args[0], args[1:]
+	switch action {
+	case "list":
+		rows := make([][]string, 0, len(cfg.Contexts))
+		for _, name := range cfg.names() {
+			current := ""
+			if name == cfg.CurrentContext {
+				current = "*"
+			}
+			ctx := cfg.Contexts[name]
+			rows = append(rows, []string{current, name, strings.Join(ctx.Addresses, ","), first(ctx.Transport, client.TransportREST), ctx.Namespace, ctx.Profile})
+		}
+		return writeTable(os.Stdout, []string{"CURRENT", "NAME", "ADDRESSES", "TRANSPORT", "NAMESPACE", "PROFILE"}, rows)
+	case "use":
+		if len(args) != 1 {
+			return errors.New("usage: stooctl context use NAME")
+		}
+		if _, err := cfg.context(args[0]); err != nil {
+			return err
+		}
+		cfg.CurrentContext = args[0]
+		return cfg.save()
+	case "delete":
+		if len(args) != 1 {
+			return errors.New("usage: stooctl context delete NAME")
+		}
+		delete(cfg.Contexts, args[0])
+		if cfg.CurrentContext == args[0] {
+			cfg.CurrentContext = ""
+		}
+		return cfg.save()
+	case "set":
+		if len(args) == 0 {
+			return errors.New("usage: stooctl context set NAME [flags]")
+		}
+		name := args[0]
+		ctx, ok := cfg.Contexts[name]
+		if !ok {
+			ctx = &Context{}
+		}
+		fs := flag.NewFlagSet("context set", flag.ContinueOnError)
+		address := fs.String("address", strings.Join(ctx.Addresses, ","), "comma separated server addresses")
+		fs.StringVar(&ctx.Transport, "transport", ctx.Transport, "rest or grpc")
+		fs.StringVar(&ctx.Token, "token", ctx.Token, "access token")
+		fs.StringVar(&ctx.Namespace, "n", ctx.Namespace, "default namespace")
+		fs.StringVar(&ctx.Profile, "p", ctx.Profile, "default profile")
+		fs.StringVar(&ctx.CaFile, "ca-file", ctx.CaFile, "CA certificate used to verify the server")
+		fs.StringVar(&ctx.CertFile, "cert-file", ctx.CertFile, "client certificate for mutual TLS")
+		fs.StringVar(&ctx.KeyFile, "key-file", ctx.KeyFile, "key of the client certificate")
+		fs.BoolVar(&ctx.InsecureSkipVerify, "insecure-skip-verify", ctx.InsecureSkipVerify, "skip verification of the server certificate")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if *address == "" {
+			return errors.New("at least one address is required")
+		}
+		ctx.Addresses = strings.Split(*address, ",")
+		cfg.Contexts[name] = ctx
+		if cfg.CurrentContext == "" {
+			cfg.CurrentContext = name
+		}
+		return cfg.save()
+	}
+	return fmt.Errorf("unknown context action %q", action)
+}
+
+func (g *globalFlags) values(namespace, profile string) (map[string]string, error) {
+	c, err := g.client(namespace, profile)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	return c.All(context.Background())
+}
+
+// splitTarget reads "namespace/profile", or a profile of the default namespace.
+func splitTarget(target string) (string, string) {
+	if namespace, profile, ok := strings.Cut(target, "/"); ok {
+		return namespace, profile
+	}
+	return "", target
+}
+
+func formatOf(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return outputYAML
+	case ".env":
+		return outputEnv
+	}
+	return outputJSON
+}
+
+func first(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func toSet(keys []string) map[string]bool {
+	set := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		set[key] = true
+	}
+	return set
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"stoo-kv/client"
+)
+
+const defaultContext = "local"
+
+// Context is a named stookv server with the namespace and profile used by default.
+type Context struct {
+	Addresses          []string `json:"addresses"`
+	Transport          string   `json:"transport,omitempty"`
+	Token              string   `json:"token,omitempty"`
+	Namespace          string   `json:"namespace,omitempty"`
+	Profile            string   `json:"profile,omitempty"`
+	CaFile             string   `json:"ca_file,omitempty"`
+	CertFile           string   `json:"cert_file,omitempty"`
+	KeyFile            string   `json:"key_file,omitempty"`
+	InsecureSkipVerify bool     `json:"insecure_skip_verify,omitempty"`
+}
+
+type Config struct {
+	CurrentContext string              `json:"current_context"`
+	Contexts       map[string]*Context `json:"contexts"`
+
+	path string
+}
+
+// configPath is $STOOCTL_CONFIG or ~/.stooctl.json.
+func configPath() string {
+	if path := os.Getenv("STOOCTL_CONFIG"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".stooctl.json"
+	}
+	return filepath.Join(home, ".stooctl.json")
+}
+
+func loadConfig() (*Config, error) {
+	cfg := &Config{path: configPath()}
+	data, err := os.ReadFile(cfg.path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		cfg.CurrentContext = defaultContext
+		cfg.Contexts = map[string]*Context{defaultContext: {Addresses: []string{"http://localhost:9098"}}}
+		return cfg, nil
+	case err != nil:
+		return nil, err
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %v", cfg.path, err)
+	}
+	if cfg.Contexts == nil {
+		cfg.Contexts = map[string]*Context{}
+	}
+	return cfg, nil
+}
+
+func (c *Config) save() error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(c.path, append(data, '\n'), 0600)
+}
+
+func (c *Config) context(name string) (*Context, error) {
+	if name == "" {
+		name = c.CurrentContext
+	}
+	ctx, ok := c.Contexts[name]
+	if !ok {
+		return nil, fmt.Errorf("context %q not found in %s", name, c.path)
+	}
+	return ctx, nil
+}
+
+func (c *Config) names() []string {
+	names := make([]string, 0, len(c.Contexts))
+	for name := range c.Contexts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (c *Context) options(namespace, profile string) (client.Options, error) {
+	tlsConfig, err := client.NewTLSConfig(c.CaFile, c.CertFile, c.KeyFile, c.InsecureSkipVerify)
+	if err != nil {
+		return client.Options{}, err
+	}
+	return client.Options{
+		Addresses: c.Addresses,
+		Transport: c.Transport,
+		Token:     c.Token,
+		Namespace: namespace,
+		Profile:   profile,
+		TLSConfig: tlsConfig,
+		Retries:   1,
+	}, nil
+}
@@ -0,0 +1,60 @@
+// Command stooctl reads and writes stookv keys from the command line.
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+var commands map[string]command
+
+// init fills commands, as the commands read their own usage from it.
+func init() {
+	commands = map[string]command{
+		"get":        {"get [flags] KEY", runGet},
+		"set":        {"set [flags] KEY VALUE", runSet(false)},
+		"set-secret": {"set-secret [flags] KEY VALUE", runSet(true)},
+		"delete":     {"delete [flags] KEY", runDelete},
+		"list":       {"list [flags]", runList},
+		"export":     {"export [flags] [FILE]", runExport},
+		"import":     {"import [flags] FILE", runImport},
+		"diff":       {"diff [flags] PROFILE|NAMESPACE/PROFILE PROFILE|NAMESPACE/PROFILE", runDiff},
+		"encrypt":    {"encrypt [flags] VALUE", runCrypt("encrypt")},
+		"decrypt":    {"decrypt [flags] CIPHERTEXT", runCrypt("decrypt")},
+		"watch":      {"watch [flags] [KEY...]", runWatch},
+		"context":    {"context list|use NAME|set NAME|delete NAME [flags]", runContext},
+	}
+}
+
+var order = []string{"get", "set", "set-secret", "delete", "list", "export", "import", "diff", "encrypt", "decrypt", "watch", "context"}
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] == "help" || os.Args[1] == "-h" || os.Args[1] == "--help" {
+		usage()
+		return
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "stooctl: unknown command %q\n\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+	if err := cmd.run(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "stooctl: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: stooctl COMMAND [flags] [args]")
+	fmt.Fprintln(os.Stderr, "\nCommands:")
+	for _, name := range order {
+		fmt.Fprintf(os.Stderr, "  %s\n", commands[name].usage)
+	}
+	fmt.Fprintln(os.Stderr, "\nRun stooctl COMMAND -h for the flags of a command.")
+}
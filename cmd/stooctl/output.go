@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"sort"
+	"stoo-kv/internal/environ"
+	"strings"
+	"text/tabwriter"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+	outputEnv   = "env"
+)
+
+func checkOutput(output string) error {
+	switch output {
+	case outputTable, outputJSON, outputYAML, outputEnv:
+		return nil
+	}
+	return fmt.Errorf("unsupported output %q, use table, json, yaml or env", output)
+}
+
+// writeValues prints key values sorted by key.
+func writeValues(w io.Writer, output string, values map[string]string) error {
+	keys := sortedKeys(values)
+	switch output {
+	case outputJSON:
+		return writeJSON(w, values)
+	case outputYAML:
+		return yaml.NewEncoder(w).Encode(values)
+	case outputEnv:
+		for _, key := range keys {
+			if _, err := fmt.Fprintf(w, "%s=%s\n", environ.Name("", key), environ.Quote(values[key])); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		rows := make([][]string, 0, len(keys))
+		for _, key := range keys {
+			rows = append(rows, []string{key, values[key]})
+		}
+		return writeTable(w, []string{"KEY", "VALUE"}, rows)
+	}
+}
+
+func writeJSON(w io.Writer, v any) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func writeYAML(v any) error {
+	return yaml.NewEncoder(os.Stdout).Encode(v)
+}
+
+func writeTable(w io.Writer, header []string, rows [][]string) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, row := range rows {
+		for i := range row {
+			row[i] = strings.ReplaceAll(row[i], "\n", `\n`)
+		}
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+// readValues parses an import file: a flat JSON or YAML object, or NAME=value lines.
+func readValues(data []byte, format string) (map[string]string, error) {
+	switch format {
+	case outputEnv:
+		return environ.Parse(string(data)), nil
+	case outputJSON, outputYAML:
+		var raw map[string]any
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			return nil, err
+		}
+		values := make(map[string]string, len(raw))
+		for key, value := range raw {
+			switch v := value.(type) {
+			case string:
+				values[key] = v
+			case nil:
+				values[key] = ""
+			case map[string]any, []any:
+				encoded, err := json.Marshal(v)
+				if err != nil {
+					return nil, fmt.Errorf("cannot encode %s: %v", key, err)
+				}
+				values[key] = string(encoded)
+			default:
+				values[key] = fmt.Sprint(v)
+			}
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("unsupported import format %q, use json, yaml or env", format)
+}
+
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -1,26 +1,45 @@
 package config
 
 import (
-	"encoding/json"
 	"flag"
 	"github.com/gin-gonic/gin"
 	"io"
 	"os"
+	"sync"
+	"sync/atomic"
 )
 
 type Config struct {
+	// Application and Providers are the settings the server started with. Settings that can be
+	// reloaded are read with Current.
 	Application *ApplicationConfig
 	Providers   *ProviderConfig
+
+	configFile string
+	overrides  Overrides
+	current    atomic.Pointer[ApplicationConfig]
+	mu         sync.Mutex
+	listeners  []func(*ApplicationConfig)
 }
 
 type ProviderConfig struct {
 	Redis struct {
-		Host               string `json:"host"`
-		Port               string `json:"port"`
-		Password           string `json:"password"`
-		Database           int    `json:"database"`
-		ConnectionPoolSize int    `json:"connection_pool_size"`
-		StoreName          string `json:"store_name"`
+		// Mode is standalone (default), sentinel or cluster.
+		Mode string `json:"mode"`
+		Host string `json:"host"`
+		Port string `json:"port"`
+		// Addresses are the sentinels or the cluster nodes, host:port by default.
+		Addresses          []string  `json:"addresses"`
+		MasterName         string    `json:"master_name"`
+		SentinelUsername   string    `json:"sentinel_username"`
+		SentinelPassword   string    `json:"sentinel_password"`
+		Username           string    `json:"username"`
+		Password           string    `json:"password"`
+		Database           int       `json:"database"`
+		ConnectionPoolSize int       `json:"connection_pool_size"`
+		MinIdleConnections int       `json:"min_idle_connections"`
+		StoreName          string    `json:"store_name"`
+		Tls                TLSConfig `json:"tls"`
 	} `json:"redis"`
 	Mysql struct {
 		Host         string `json:"host"`
@@ -28,6 +47,10 @@ type ProviderConfig struct {
 		Username     string `json:"username"`
 		Password     string `json:"password"`
 		DatabaseName string `json:"database_name"`
+		// Params are added to the DSN, e.g. {"timeout": "5s"}, replacing the defaults of the same name.
+		Params map[string]string `json:"params"`
+		Tls    TLSConfig         `json:"tls"`
+		Pool   PoolConfig        `json:"pool"`
 	} `json:"mysql"`
 	Postgres struct {
 		Host         string `json:"host"`
@@ -37,59 +60,214 @@ type ProviderConfig struct {
 		DatabaseName string `json:"database_name"`
 		SslMode      string `json:"ssl_mode"`
 		TimeZone     string `json:"timezone"`
+		// Params are added to the DSN, e.g. {"application_name": "stookv"}.
+		Params map[string]string `json:"params"`
+		// Tls files are passed as sslrootcert, sslcert and sslkey; ssl_mode decides how they are used.
+		Tls  TLSConfig  `json:"tls"`
+		Pool PoolConfig `json:"pool"`
 	} `json:"postgres"`
+	Sqlite struct {
+		// Path is the database file, created when missing, or ":memory:".
+		Path string `json:"path"`
+		// Params are added to the DSN, e.g. {"_journal_mode": "WAL"}, replacing the defaults of the same name.
+		Params map[string]string `json:"params"`
+	} `json:"sqlite"`
 	Mongo struct {
 		MongoUri       string `json:"mongo_uri"`
 		DatabaseName   string `json:"database_name"`
 		CollectionName string `json:"collection_name"`
+		Username       string `json:"username"`
+		Password       string `json:"password"`
+		AuthSource     string `json:"auth_source"`
+		// Params are added to the query of mongo_uri, e.g. {"replicaSet": "rs0"}.
+		Params      map[string]string `json:"params"`
+		Tls         TLSConfig         `json:"tls"`
+		MaxPoolSize uint64            `json:"max_pool_size"`
+		MinPoolSize uint64            `json:"min_pool_size"`
 	} `json:"mongo"`
 	Etcd struct {
-		Endpoints   []string `json:"endpoints"`
-		Username    string   `json:"username"`
-		Password    string   `json:"password"`
-		DialTimeout int      `json:"dial_timeout"`
+		Endpoints   []string  `json:"endpoints"`
+		Username    string    `json:"username"`
+		Password    string    `json:"password"`
+		DialTimeout int       `json:"dial_timeout"`
+		Tls         TLSConfig `json:"tls"`
 	} `json:"etcd"`
 }
 
+// PoolConfig sizes the connection pool of a SQL database; zero values keep the driver defaults.
+type PoolConfig struct {
+	MaxOpenConnections    int    `json:"max_open_connections"`
+	MaxIdleConnections    int    `json:"max_idle_connections"`
+	ConnectionMaxLifetime string `json:"connection_max_lifetime"`
+	ConnectionMaxIdleTime string `json:"connection_max_idle_time"`
+}
+
+// TLSConfig is how stookv connects to a storage backend over TLS: the CA bundle verifying the
+// backend and, when it requires client certificates, the certificate and key presented to it.
+type TLSConfig struct {
+	Enabled            bool   `json:"enabled"`
+	CaFile             string `json:"ca_file"`
+	CertFile           string `json:"cert_file"`
+	KeyFile            string `json:"key_file"`
+	ServerName         string `json:"server_name"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+}
+
 type ApplicationConfig struct {
-	ServerLogLevel        string `json:"server_log_level"`
-	ServerPort            string `json:"server_port"`
-	ServerBindingHost     string `json:"server_binding_host"`
-	GrpcPort              string `json:"grpc_port"`
-	GrpcUseTls            bool   `json:"grpc_use_tls"`
-	GrpcServerKey         string `json:"grpc_server_key"`
-	GrpcServerCert        string `json:"grpc_server_cert"`
-	StorageType           string `json:"storage_type"`
-	EncryptKey            string `json:"encrypt_key"`
-	EnableDecryptEndpoint bool   `json:"enable_decrypt_endpoint"`
-	RdbmsDefaultTable     string `json:"rdbms_default_table"`
-	EncryptPrefix         string `json:"encrypt_prefix"`
-	ProviderPath          string `json:"provider_path"`
+	ServerLogLevel           string        `json:"server_log_level"`
+	ServerPort               string        `json:"server_port"`
+	ServerBindingHost        string        `json:"server_binding_host"`
+	GrpcPort                 string        `json:"grpc_port"`
+	GrpcUseTls               bool          `json:"grpc_use_tls"`
+	GrpcServerKey            string        `json:"grpc_server_key"`
+	GrpcServerCert           string        `json:"grpc_server_cert"`
+	StorageType              string        `json:"storage_type"`
+	EncryptKey               string        `json:"encrypt_key"`
+	EnableDecryptEndpoint    bool          `json:"enable_decrypt_endpoint"`
+	RdbmsDefaultTable        string        `json:"rdbms_default_table"`
+	RdbmsManualMigrations    bool          `json:"rdbms_manual_migrations"`
+	StorageTimeout           string        `json:"storage_timeout"`
+	StorageMaxAttempts       int           `json:"storage_max_attempts"`
+	StorageRetryBackoff      string        `json:"storage_retry_backoff"`
+	StorageRetryMaxBackoff   string        `json:"storage_retry_max_backoff"`
+	StorageBreakerThreshold  int           `json:"storage_breaker_threshold"`
+	StorageBreakerCooldown   string        `json:"storage_breaker_cooldown"`
+	SecondaryStorageTypes    []string      `json:"secondary_storage_types"`
+	MirrorWrites             string        `json:"mirror_writes"`
+	SnapshotFile             string        `json:"snapshot_file"`
+	ReconcileInterval        string        `json:"reconcile_interval"`
+	EncryptPrefix            string        `json:"encrypt_prefix"`
+	ProviderPath             string        `json:"provider_path"`
+	SecretMasking            string        `json:"secret_masking"`
+	InterpolateEnv           []string      `json:"interpolate_env"`
+	AccessTokens             []AccessToken `json:"access_tokens"`
+	SecretLeaseCheckInterval string        `json:"secret_lease_check_interval"`
+	WebhookSigningSecret     string        `json:"webhook_signing_secret"`
+	WebhookTimeout           string        `json:"webhook_timeout"`
+	WebhookMaxAttempts       int           `json:"webhook_max_attempts"`
+	WebhookRetryBackoff      string        `json:"webhook_retry_backoff"`
+	WebhookWorkers           int           `json:"webhook_workers"`
+	WebhookQueueSize         int           `json:"webhook_queue_size"`
+	WebhookAllowedHosts      []string      `json:"webhook_allowed_hosts"`
+	CorsAllowedOrigins       []string      `json:"cors_allowed_origins"`
+	ServerUseTls             bool          `json:"server_use_tls"`
+	ServerCert               string        `json:"server_cert"`
+	ServerKey                string        `json:"server_key"`
+	// TlsClientAuth verifies client certificates against TlsClientCa on both transports.
+	TlsClientAuth         string                `json:"tls_client_auth"`
+	TlsClientCa           string                `json:"tls_client_ca"`
+	CertificateIdentities []CertificateIdentity `json:"certificate_identities"`
+}
+
+type AccessToken struct {
+	Name        string   `json:"name"`
+	Token       string   `json:"token"`
+	Permissions []string `json:"permissions"`
+	// SnapshotKey encrypts the secrets of the snapshots served to the token's owner.
+	SnapshotKey string `json:"snapshot_key"`
+}
+
+// CertificateIdentity grants permissions to the clients whose certificate subject matches, either
+// its common name or its full distinguished name such as "CN=deployer,O=Acme".
+type CertificateIdentity struct {
+	Name        string   `json:"name"`
+	Subject     string   `json:"subject"`
+	Permissions []string `json:"permissions"`
+	SnapshotKey string   `json:"snapshot_key"`
 }
 
+const defaultConfigFile = "./conf/stoo_kv.json"
+
+const (
+	SecretMaskingNone      = "none"
+	SecretMaskingMask      = "mask"
+	SecretMaskingReference = "reference"
+)
+
+const (
+	RedisModeStandalone = "standalone"
+	RedisModeSentinel   = "sentinel"
+	RedisModeCluster    = "cluster"
+)
+
+const (
+	MirrorWritesSync  = "sync"
+	MirrorWritesAsync = "async"
+	MirrorWritesNone  = "none"
+)
+
+const (
+	ClientAuthNone     = "none"
+	ClientAuthOptional = "optional"
+	ClientAuthRequire  = "require"
+)
+
 func NewApplicationConfig(configFile string) (*ApplicationConfig, error) {
 	config := &ApplicationConfig{}
-	configs, err := readFile(configFile)
-	if err != nil {
-		return nil, err
-	}
-	if err := json.Unmarshal(configs, config); err != nil {
+	if err := decodeFile(configFile, config); err != nil {
 		return nil, err
 	}
+	config.setDefaults()
+	return config, nil
+}
+
+func (config *ApplicationConfig) setDefaults() {
 	if config.ServerLogLevel == "" {
 		config.ServerLogLevel = gin.ReleaseMode
 	}
-
-	return config, nil
+	if config.SecretMasking == "" {
+		config.SecretMasking = SecretMaskingNone
+	}
+	if config.SecretLeaseCheckInterval == "" {
+		config.SecretLeaseCheckInterval = "1m"
+	}
+	if config.WebhookTimeout == "" {
+		config.WebhookTimeout = "10s"
+	}
+	if config.WebhookMaxAttempts <= 0 {
+		config.WebhookMaxAttempts = 5
+	}
+	if config.WebhookRetryBackoff == "" {
+		config.WebhookRetryBackoff = "1s"
+	}
+	if config.WebhookWorkers <= 0 {
+		config.WebhookWorkers = 4
+	}
+	if config.WebhookQueueSize <= 0 {
+		config.WebhookQueueSize = 1000
+	}
+	if config.TlsClientAuth == "" {
+		config.TlsClientAuth = ClientAuthNone
+	}
+	if config.StorageTimeout == "" {
+		config.StorageTimeout = "10s"
+	}
+	if config.StorageMaxAttempts <= 0 {
+		config.StorageMaxAttempts = 3
+	}
+	if config.StorageRetryBackoff == "" {
+		config.StorageRetryBackoff = "100ms"
+	}
+	if config.StorageRetryMaxBackoff == "" {
+		config.StorageRetryMaxBackoff = "2s"
+	}
+	if config.StorageBreakerThreshold == 0 {
+		config.StorageBreakerThreshold = 5
+	}
+	if config.StorageBreakerCooldown == "" {
+		config.StorageBreakerCooldown = "30s"
+	}
+	if config.MirrorWrites == "" {
+		config.MirrorWrites = MirrorWritesSync
+	}
+	if config.ReconcileInterval == "" {
+		config.ReconcileInterval = "5m"
+	}
 }
 
 func NewProviderConfig(providerConfigFile string) (*ProviderConfig, error) {
 	config := &ProviderConfig{}
-	configs, err := readFile(providerConfigFile)
-	if err != nil {
-		return nil, err
-	}
-	if err := json.Unmarshal(configs, config); err != nil {
+	if err := decodeFile(providerConfigFile, config); err != nil {
 		return nil, err
 	}
 	return config, nil
@@ -100,6 +278,7 @@ func readFile(configFile string) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	defer f.Close()
 	data, err := io.ReadAll(f)
 	if err != nil {
 		return nil, err
@@ -107,22 +286,76 @@ func readFile(configFile string) ([]byte, error) {
 	return data, nil
 }
 
+// ParseConfig reads the configuration file given by -config.file, or $STOOKV_CONFIG_FILE, and
+// overrides its settings with STOOKV_* environment variables and command line flags.
 func ParseConfig() (*Config, error) {
 	var configFile string
-	flag.StringVar(&configFile, "config.file", "./conf/stoo_kv.json", "Configuration file")
+	overrides := Overrides{}
+	flag.StringVar(&configFile, "config.file", "", "Configuration file (JSON, YAML or TOML), ./conf/stoo_kv.json by default")
+	overrides.RegisterFlags(flag.CommandLine)
 	flag.Parse()
-	applicationCfg, err := NewApplicationConfig(configFile)
-	if err != nil {
+	return Load(configFile, overrides)
+}
+
+// LoadConfig reads the application configuration file and the provider file it points to.
+func LoadConfig(configFile string) (*Config, error) {
+	return Load(configFile, nil)
+}
+
+// Load layers the configurations: defaults, the configuration file with the provider file it
+// points to or its "providers" section, STOOKV_* environment variables and then the overrides.
+// Without a configuration file, $STOOKV_CONFIG_FILE or ./conf/stoo_kv.json are read when they
+// exist, so that a server can be configured from the environment alone.
+func Load(configFile string, overrides Overrides) (*Config, error) {
+	cfg := &Config{configFile: configFile, overrides: overrides}
+	optional := false
+	if configFile == "" {
+		configFile = os.Getenv(EnvPrefix + "CONFIG_FILE")
+	}
+	if configFile == "" {
+		configFile, optional = defaultConfigFile, true
+	}
+	if _, err := os.Stat(configFile); err != nil && optional {
+		configFile = ""
+	}
+	applicationCfg := &ApplicationConfig{}
+	if configFile != "" {
+		if err := decodeFile(configFile, applicationCfg); err != nil {
+			return nil, err
+		}
+	}
+	if err := applyEnv(applicationCfg, os.LookupEnv); err != nil {
+		return nil, err
+	}
+	if err := overrides.apply(applicationCfg); err != nil {
 		return nil, err
 	}
-	providerFile := applicationCfg.ProviderPath
+	applicationCfg.setDefaults()
 
-	providerConfig, err := NewProviderConfig(providerFile)
-	if err != nil {
+	providerConfig := &ProviderConfig{}
+	if applicationCfg.ProviderPath != "" {
+		if err := decodeFile(applicationCfg.ProviderPath, providerConfig); err != nil {
+			return nil, err
+		}
+	}
+	if configFile != "" {
+		inline := &struct {
+			Providers *ProviderConfig `json:"providers"`
+		}{providerConfig}
+		if err := decodeFile(configFile, inline); err != nil {
+			return nil, err
+		}
+	}
+	if err := applyEnv(providerConfig, os.LookupEnv); err != nil {
+		return nil, err
+	}
+	if err := overrides.apply(providerConfig); err != nil {
+		return nil, err
+	}
+
+	cfg.Application, cfg.Providers = applicationCfg, providerConfig
+	if err := cfg.Validate(); err != nil {
 		return nil, err
 	}
-	return &Config{
-		Application: applicationCfg,
-		Providers:   providerConfig,
-	}, nil
+	return cfg, nil
 }
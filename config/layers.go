@@ -0,0 +1,331 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EnvPrefix prefixes the environment variables overriding configurations, e.g. STOOKV_SERVER_PORT
+// or STOOKV_REDIS_HOST.
+const EnvPrefix = "STOOKV_"
+
+// decodeFile reads a JSON, YAML (.yaml, .yml) or TOML (.toml) file into v. YAML and TOML are
+// converted to JSON first so that every format uses the json tags of the configuration structs.
+func decodeFile(path string, v any) error {
+	data, err := readFile(path)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		var values map[string]any
+		if err := yaml.Unmarshal(data, &values); err != nil {
+			return fmt.Errorf("invalid YAML in %s: %v", path, err)
+		}
+		if data, err = json.Marshal(values); err != nil {
+			return fmt.Errorf("invalid YAML in %s: %v", path, err)
+		}
+	case ".toml":
+		var values map[string]any
+		if err := toml.Unmarshal(data, &values); err != nil {
+			return fmt.Errorf("invalid TOML in %s: %v", path, err)
+		}
+		if data, err = json.Marshal(values); err != nil {
+			return fmt.Errorf("invalid TOML in %s: %v", path, err)
+		}
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("invalid configuration in %s: %v", path, err)
+	}
+	return nil
+}
+
+// field is a configuration setting, named by the json tags leading to it, e.g. ["redis", "host"].
+type field struct {
+	path  []string
+	value reflect.Value
+}
+
+func (f field) envName() string {
+	return EnvPrefix + strings.ToUpper(strings.Join(f.path, "_"))
+}
+
+func (f field) flagName() string {
+	return strings.Join(f.path, ".")
+}
+
+// fields lists the settings of a configuration struct, descending into nested structs.
+func fields(v any) []field {
+	var result []field
+	var walk func(path []string, value reflect.Value)
+	walk = func(path []string, value reflect.Value) {
+		for i := 0; i < value.NumField(); i++ {
+			name, _, _ := strings.Cut(value.Type().Field(i).Tag.Get("json"), ",")
+			if name == "" || name == "-" {
+				continue
+			}
+			fieldPath := append(append([]string{}, path...), name)
+			if value.Field(i).Kind() == reflect.Struct {
+				walk(fieldPath, value.Field(i))
+				continue
+			}
+			result = append(result, field{path: fieldPath, value: value.Field(i)})
+		}
+	}
+	walk(nil, reflect.ValueOf(v).Elem())
+	return result
+}
+
+// set parses a setting from text: lists are comma separated and other structured values are JSON.
+func (f field) set(text string) error {
+	v := f.value
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(text)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", text)
+		}
+		v.SetBool(b)
+	case reflect.Int:
+		n, err := strconv.Atoi(text)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", text)
+		}
+		v.SetInt(int64(n))
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.String && !strings.HasPrefix(strings.TrimSpace(text), "[") {
+			items := strings.Split(text, ",")
+			list := reflect.MakeSlice(v.Type(), 0, len(items))
+			for _, item := range items {
+				if item = strings.TrimSpace(item); item != "" {
+					list = reflect.Append(list, reflect.ValueOf(item))
+				}
+			}
+			v.Set(list)
+			return nil
+		}
+		fallthrough
+	default:
+		if err := json.Unmarshal([]byte(text), v.Addr().Interface()); err != nil {
+			return fmt.Errorf("%q is not valid JSON: %v", text, err)
+		}
+	}
+	return nil
+}
+
+// applyEnv overrides the settings of a configuration struct with the STOOKV_* environment variables.
+func applyEnv(v any, lookup func(string) (string, bool)) error {
+	for _, f := range fields(v) {
+		text, ok := lookup(f.envName())
+		if !ok {
+			continue
+		}
+		if err := f.set(text); err != nil {
+			return fmt.Errorf("invalid %s: %v", f.envName(), err)
+		}
+	}
+	return nil
+}
+
+// Overrides holds the settings given as command line flags, applied after the environment.
+type Overrides map[string]string
+
+// RegisterFlags adds a flag for every configuration setting, e.g. -server_port or -redis.host.
+func (o Overrides) RegisterFlags(fs *flag.FlagSet) {
+	for _, v := range []any{&ApplicationConfig{}, &ProviderConfig{}} {
+		for _, f := range fields(v) {
+			name := f.flagName()
+			fs.Func(name, "overrides "+name, func(text string) error {
+				o[name] = text
+				return nil
+			})
+		}
+	}
+}
+
+func (o Overrides) apply(v any) error {
+	for _, f := range fields(v) {
+		text, ok := o[f.flagName()]
+		if !ok {
+			continue
+		}
+		if err := f.set(text); err != nil {
+			return fmt.Errorf("invalid -%s: %v", f.flagName(), err)
+		}
+	}
+	return nil
+}
+
+var (
+	storageTypes  = []string{"", "memory", "redis", "mysql", "postgres", "sqlite", "mongo", "etcd"}
+	logLevels     = []string{"debug", "release", "test"}
+	maskingValues = []string{SecretMaskingNone, SecretMaskingMask, SecretMaskingReference}
+	clientAuths   = []string{ClientAuthNone, ClientAuthOptional, ClientAuthRequire}
+	redisModes    = []string{"", RedisModeStandalone, RedisModeSentinel, RedisModeCluster}
+	mirrorModes   = []string{MirrorWritesSync, MirrorWritesAsync, MirrorWritesNone}
+)
+
+// Validate reports every missing or contradictory setting at once.
+func (c *Config) Validate() error {
+	var errs []string
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Sprintf(format, args...))
+		}
+	}
+	app, providers := c.Application, c.Providers
+
+	check(contains(storageTypes, app.StorageType), "unknown storage_type %q, expected one of %s", app.StorageType, strings.Join(storageTypes[1:], ", "))
+	check(contains(logLevels, app.ServerLogLevel), "unknown server_log_level %q, expected one of %s", app.ServerLogLevel, strings.Join(logLevels, ", "))
+	check(contains(maskingValues, app.SecretMasking), "unknown secret_masking %q, expected one of %s", app.SecretMasking, strings.Join(maskingValues, ", "))
+	check(validPort(app.ServerPort), "invalid server_port %q", app.ServerPort)
+	check(validPort(app.GrpcPort), "invalid grpc_port %q", app.GrpcPort)
+	if app.GrpcUseTls {
+		check(app.GrpcServerCert != "" && app.GrpcServerKey != "", "grpc_use_tls needs grpc_server_cert and grpc_server_key")
+	}
+	if app.ServerUseTls {
+		check(app.ServerCert != "" && app.ServerKey != "", "server_use_tls needs server_cert and server_key")
+	}
+	check(contains(clientAuths, app.TlsClientAuth), "unknown tls_client_auth %q, expected one of %s", app.TlsClientAuth, strings.Join(clientAuths, ", "))
+	if app.TlsClientAuth != ClientAuthNone {
+		check(app.TlsClientCa != "", "tls_client_auth %s needs tls_client_ca", app.TlsClientAuth)
+		check(app.ServerUseTls || app.GrpcUseTls, "tls_client_auth %s needs server_use_tls or grpc_use_tls", app.TlsClientAuth)
+	}
+	for i, identity := range app.CertificateIdentities {
+		check(identity.Name != "" && identity.Subject != "", "certificate_identities[%d] needs a name and a subject", i)
+		check(validKey(identity.SnapshotKey), "certificate_identities[%d].snapshot_key must be 16, 24 or 32 bytes long", i)
+	}
+	for i, name := range app.InterpolateEnv {
+		check(!strings.HasPrefix(name, EnvPrefix), "interpolate_env[%d] %q cannot be a %s* variable", i, name, EnvPrefix)
+	}
+	switch len(app.EncryptKey) {
+	case 0:
+		check(!app.EnableDecryptEndpoint, "enable_decrypt_endpoint needs an encrypt_key")
+	case 16, 24, 32:
+	default:
+		check(false, "encrypt_key must be 16, 24 or 32 bytes long for AES-128, AES-192 or AES-256, got %d", len(app.EncryptKey))
+	}
+	timeout, err := time.ParseDuration(app.WebhookTimeout)
+	check(err == nil && timeout >= 0, "invalid webhook_timeout %q", app.WebhookTimeout)
+	backoff, err := time.ParseDuration(app.WebhookRetryBackoff)
+	check(err == nil && backoff > 0, "invalid webhook_retry_backoff %q", app.WebhookRetryBackoff)
+	durations := []struct{ name, value string }{
+		{"storage_timeout", app.StorageTimeout},
+		{"storage_retry_backoff", app.StorageRetryBackoff},
+		{"storage_retry_max_backoff", app.StorageRetryMaxBackoff},
+		{"storage_breaker_cooldown", app.StorageBreakerCooldown},
+		{"mysql.pool.connection_max_lifetime", providers.Mysql.Pool.ConnectionMaxLifetime},
+		{"mysql.pool.connection_max_idle_time", providers.Mysql.Pool.ConnectionMaxIdleTime},
+		{"postgres.pool.connection_max_lifetime", providers.Postgres.Pool.ConnectionMaxLifetime},
+		{"postgres.pool.connection_max_idle_time", providers.Postgres.Pool.ConnectionMaxIdleTime},
+	}
+	for _, d := range durations {
+		if d.value != "" {
+			duration, err := time.ParseDuration(d.value)
+			check(err == nil && duration >= 0, "invalid %s %q", d.name, d.value)
+		}
+	}
+	tokens := make(map[string]bool, len(app.AccessTokens))
+	for i, token := range app.AccessTokens {
+		check(token.Name != "" && token.Token != "", "access_tokens[%d] needs a name and a token", i)
+		check(!tokens[token.Token] || token.Token == "", "access_tokens[%d] repeats the token of another access token", i)
+		check(validKey(token.SnapshotKey), "access_tokens[%d].snapshot_key must be 16, 24 or 32 bytes long", i)
+		tokens[token.Token] = true
+	}
+
+	providerTls := []TLSConfig{providers.Redis.Tls, providers.Mysql.Tls, providers.Postgres.Tls, providers.Mongo.Tls, providers.Etcd.Tls}
+	for i, name := range []string{"redis", "mysql", "postgres", "mongo", "etcd"} {
+		check((providerTls[i].CertFile == "") == (providerTls[i].KeyFile == ""), "%s.tls needs both cert_file and key_file", name)
+	}
+
+	primary := app.StorageType
+	if primary == "" {
+		primary = "memory"
+	}
+	for i, storageType := range app.SecondaryStorageTypes {
+		check(contains(storageTypes[1:], storageType), "unknown secondary_storage_types[%d] %q, expected one of %s", i, storageType, strings.Join(storageTypes[1:], ", "))
+		check(storageType != primary && !contains(app.SecondaryStorageTypes[:i], storageType), "secondary_storage_types[%d] %q repeats a storage type", i, storageType)
+	}
+	check(contains(mirrorModes, app.MirrorWrites), "unknown mirror_writes %q, expected one of %s", app.MirrorWrites, strings.Join(mirrorModes, ", "))
+	interval, err := time.ParseDuration(app.ReconcileInterval)
+	check(err == nil && interval >= 0, "invalid reconcile_interval %q", app.ReconcileInterval)
+	check(app.SnapshotFile == "" || interval > 0, "snapshot_file needs a reconcile_interval above 0")
+
+	for i, storageType := range append([]string{app.StorageType}, app.SecondaryStorageTypes...) {
+		setting := "storage_type " + storageType
+		if i > 0 {
+			setting = "secondary storage " + storageType
+		}
+		switch storageType {
+		case "redis":
+			redis := providers.Redis
+			check(contains(redisModes, redis.Mode), "unknown redis.mode %q, expected one of %s", redis.Mode, strings.Join(redisModes[1:], ", "))
+			check(len(redis.Addresses) > 0 || (redis.Host != "" && redis.Port != ""), "%s needs redis.host and redis.port, or redis.addresses", setting)
+			check(redis.StoreName != "", "%s needs redis.store_name", setting)
+			if redis.Mode == RedisModeSentinel {
+				check(redis.MasterName != "", "redis.mode sentinel needs redis.master_name")
+			}
+			if redis.Mode == RedisModeCluster {
+				check(redis.Database == 0, "redis.mode cluster only supports redis.database 0")
+			}
+		case "mysql":
+			check(providers.Mysql.Host != "" && providers.Mysql.Port != "", "%s needs mysql.host and mysql.port", setting)
+			check(providers.Mysql.DatabaseName != "", "%s needs mysql.database_name", setting)
+			check(app.RdbmsDefaultTable != "", "%s needs rdbms_default_table", setting)
+		case "postgres":
+			check(providers.Postgres.Host != "" && providers.Postgres.Port != "", "%s needs postgres.host and postgres.port", setting)
+			check(providers.Postgres.DatabaseName != "", "%s needs postgres.database_name", setting)
+			check(app.RdbmsDefaultTable != "", "%s needs rdbms_default_table", setting)
+		case "sqlite":
+			check(providers.Sqlite.Path != "", "%s needs sqlite.path", setting)
+			check(app.RdbmsDefaultTable != "", "%s needs rdbms_default_table", setting)
+		case "mongo":
+			check(providers.Mongo.MongoUri != "", "%s needs mongo.mongo_uri", setting)
+			check(providers.Mongo.DatabaseName != "" && providers.Mongo.CollectionName != "", "%s needs mongo.database_name and mongo.collection_name", setting)
+		case "etcd":
+			check(len(providers.Etcd.Endpoints) > 0, "%s needs etcd.endpoints", setting)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.New("invalid configuration: " + strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func validPort(port string) bool {
+	if port == "" {
+		return true
+	}
+	n, err := strconv.Atoi(port)
+	return err == nil && n >= 0 && n <= 65535
+}
+
+// validKey accepts an unset key or an AES-128, AES-192 or AES-256 key.
+func validKey(key string) bool {
+	switch len(key) {
+	case 0, 16, 24, 32:
+		return true
+	}
+	return false
+}
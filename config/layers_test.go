@@ -0,0 +1,246 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, dir, name, text string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	return path
+}
+
+func TestDecodeFormats(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"c.json": `{"server_port": "9098", "cors_allowed_origins": ["https://a"], "webhook_workers": 2}`,
+		"c.yaml": "server_port: \"9098\"\ncors_allowed_origins: [https://a]\nwebhook_workers: 2\n",
+		"c.yml":  "server_port: \"9098\"\ncors_allowed_origins:\n  - https://a\nwebhook_workers: 2\n",
+		"c.toml": "server_port = \"9098\"\ncors_allowed_origins = [\"https://a\"]\nwebhook_workers = 2\n",
+	}
+	for name, text := range files {
+		t.Run(name, func(t *testing.T) {
+			got := &ApplicationConfig{}
+			if err := decodeFile(writeFile(t, dir, name, text), got); err != nil {
+				t.Fatalf("decodeFile: %v", err)
+			}
+			if got.ServerPort != "9098" || !reflect.DeepEqual(got.CorsAllowedOrigins, []string{"https://a"}) || got.WebhookWorkers != 2 {
+				t.Errorf("got %+v", got)
+			}
+		})
+	}
+	if err := decodeFile(writeFile(t, dir, "bad.yaml", "server_port: [\n"), &ApplicationConfig{}); err == nil || !strings.Contains(err.Error(), "invalid YAML") {
+		t.Errorf("got %v, want invalid YAML", err)
+	}
+}
+
+func TestLoadLayers(t *testing.T) {
+	dir := t.TempDir()
+	providers := writeFile(t, dir, "providers.json", `{"redis": {"host": "file-host", "port": "6379", "store_name": "kv"}}`)
+	file := writeFile(t, dir, "stoo_kv.yaml", `
+server_port: "9098"
+grpc_port: "9099"
+storage_type: redis
+provider_path: `+providers+`
+webhook_workers: 8
+providers:
+  redis:
+    port: "6380"
+`)
+	tests := []struct {
+		name      string
+		env       map[string]string
+		overrides Overrides
+		check     func(t *testing.T, cfg *Config)
+	}{
+		{
+			name: "file over defaults",
+			check: func(t *testing.T, cfg *Config) {
+				app := cfg.Application
+				if app.ServerPort != "9098" || app.WebhookWorkers != 8 || app.WebhookQueueSize != 1000 || app.SecretMasking != SecretMaskingNone {
+					t.Errorf("got %+v", app)
+				}
+				// The inline providers section is read over the provider file.
+				if cfg.Providers.Redis.Host != "file-host" || cfg.Providers.Redis.Port != "6380" {
+					t.Errorf("got redis %+v", cfg.Providers.Redis)
+				}
+			},
+		},
+		{
+			name: "environment over the file",
+			env: map[string]string{
+				"STOOKV_SERVER_PORT":           "7000",
+				"STOOKV_REDIS_HOST":            "env-host",
+				"STOOKV_CORS_ALLOWED_ORIGINS":  "https://a, https://b",
+				"STOOKV_WEBHOOK_ALLOWED_HOSTS": `["hooks.example.com"]`,
+				"STOOKV_MYSQL_PARAMS":          `{"timeout": "5s"}`,
+			},
+			check: func(t *testing.T, cfg *Config) {
+				app := cfg.Application
+				if app.ServerPort != "7000" || app.GrpcPort != "9099" || cfg.Providers.Redis.Host != "env-host" {
+					t.Errorf("got port %s, grpc port %s, redis host %s", app.ServerPort, app.GrpcPort, cfg.Providers.Redis.Host)
+				}
+				if !reflect.DeepEqual(app.CorsAllowedOrigins, []string{"https://a", "https://b"}) || !reflect.DeepEqual(app.WebhookAllowedHosts, []string{"hooks.example.com"}) {
+					t.Errorf("got lists %v and %v", app.CorsAllowedOrigins, app.WebhookAllowedHosts)
+				}
+				if cfg.Providers.Mysql.Params["timeout"] != "5s" {
+					t.Errorf("got mysql params %v", cfg.Providers.Mysql.Params)
+				}
+			},
+		},
+		{
+			name:      "flags over the environment",
+			env:       map[string]string{"STOOKV_SERVER_PORT": "7000", "STOOKV_REDIS_HOST": "env-host"},
+			overrides: Overrides{"server_port": "7001", "redis.host": "flag-host", "webhook_workers": "3"},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.Application.ServerPort != "7001" || cfg.Providers.Redis.Host != "flag-host" || cfg.Application.WebhookWorkers != 3 {
+					t.Errorf("got port %s, redis host %s, workers %d", cfg.Application.ServerPort, cfg.Providers.Redis.Host, cfg.Application.WebhookWorkers)
+				}
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for name, value := range test.env {
+				t.Setenv(name, value)
+			}
+			cfg, err := Load(file, test.overrides)
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			test.check(t, cfg)
+		})
+	}
+}
+
+func TestLoadFromEnvironmentAlone(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Getwd: %v", err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatalf("Chdir: %v", err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+	t.Setenv("STOOKV_CONFIG_FILE", "")
+	t.Setenv("STOOKV_STORAGE_TYPE", "memory")
+	t.Setenv("STOOKV_SERVER_PORT", "9100")
+	cfg, err := Load("", nil)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Application.StorageType != "memory" || cfg.Application.ServerPort != "9100" {
+		t.Errorf("got %+v", cfg.Application)
+	}
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.json"), nil); err == nil {
+		t.Errorf("Load of a missing file given explicitly: want an error")
+	}
+}
+
+func TestLoadRejectsInvalidOverrides(t *testing.T) {
+	file := writeFile(t, t.TempDir(), "c.json", `{}`)
+	tests := []struct {
+		name      string
+		env       map[string]string
+		overrides Overrides
+		wantErr   string
+	}{
+		{name: "integer", env: map[string]string{"STOOKV_WEBHOOK_WORKERS": "many"}, wantErr: `invalid STOOKV_WEBHOOK_WORKERS: "many" is not an integer`},
+		{name: "boolean", env: map[string]string{"STOOKV_SERVER_USE_TLS": "maybe"}, wantErr: `invalid STOOKV_SERVER_USE_TLS: "maybe" is not a boolean`},
+		{name: "json", env: map[string]string{"STOOKV_ACCESS_TOKENS": "[{"}, wantErr: "invalid STOOKV_ACCESS_TOKENS"},
+		{name: "flag", overrides: Overrides{"redis.database": "x"}, wantErr: `invalid -redis.database: "x" is not an integer`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for name, value := range test.env {
+				t.Setenv(name, value)
+			}
+			if _, err := Load(file, test.overrides); err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("got %v, want %s", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		app     func(app *ApplicationConfig)
+		wantErr []string
+	}{
+		{name: "defaults", app: func(app *ApplicationConfig) {}},
+		{
+			name: "every problem at once",
+			app: func(app *ApplicationConfig) {
+				app.StorageType = "cassandra"
+				app.ServerPort = "70000"
+				app.SecretMasking = "hide"
+				app.EncryptKey = "short"
+			},
+			wantErr: []string{`unknown storage_type "cassandra"`, `invalid server_port "70000"`, `unknown secret_masking "hide"`, "encrypt_key must be 16, 24 or 32 bytes long"},
+		},
+		{
+			name:    "tls without certificates",
+			app:     func(app *ApplicationConfig) { app.ServerUseTls = true },
+			wantErr: []string{"server_use_tls needs server_cert and server_key"},
+		},
+		{
+			name:    "client certificates without tls",
+			app:     func(app *ApplicationConfig) { app.TlsClientAuth = ClientAuthRequire },
+			wantErr: []string{"tls_client_auth require needs tls_client_ca", "tls_client_auth require needs server_use_tls or grpc_use_tls"},
+		},
+		{
+			name: "repeated access tokens",
+			app: func(app *ApplicationConfig) {
+				app.AccessTokens = []AccessToken{{Name: "a", Token: "t"}, {Name: "b", Token: "t"}}
+			},
+			wantErr: []string{"access_tokens[1] repeats the token of another access token"},
+		},
+		{
+			name: "storage settings missing",
+			app: func(app *ApplicationConfig) {
+				app.StorageType, app.SecondaryStorageTypes = "sqlite", []string{"sqlite", "etcd"}
+			},
+			wantErr: []string{"storage_type sqlite needs sqlite.path", "needs rdbms_default_table", `secondary_storage_types[0] "sqlite" repeats a storage type`, "secondary storage etcd needs etcd.endpoints"},
+		},
+		{
+			name:    "invalid durations",
+			app:     func(app *ApplicationConfig) { app.StorageTimeout, app.WebhookRetryBackoff = "-1s", "0s" },
+			wantErr: []string{`invalid storage_timeout "-1s"`, `invalid webhook_retry_backoff "0s"`},
+		},
+		{
+			name:    "environment references to stookv settings",
+			app:     func(app *ApplicationConfig) { app.InterpolateEnv = []string{"HOME", "STOOKV_ENCRYPT_KEY"} },
+			wantErr: []string{`interpolate_env[1] "STOOKV_ENCRYPT_KEY" cannot be a STOOKV_* variable`},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			app := &ApplicationConfig{}
+			test.app(app)
+			app.setDefaults()
+			err := (&Config{Application: app, Providers: &ProviderConfig{}}).Validate()
+			if len(test.wantErr) == 0 {
+				if err != nil {
+					t.Fatalf("Validate: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Validate: want errors %v", test.wantErr)
+			}
+			for _, want := range test.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("got %v, want it to report %s", err, want)
+				}
+			}
+		})
+	}
+}
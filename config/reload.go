@@ -0,0 +1,92 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// reloadable lists the settings applied by Reload without a restart. Certificates and the client
+// CA bundle are re-read from their files on every reload.
+var reloadable = map[string]bool{
+	"server_log_level":        true,
+	"cors_allowed_origins":    true,
+	"enable_decrypt_endpoint": true,
+	"access_tokens":           true,
+	"secret_masking":          true,
+	"interpolate_env":         true,
+	"webhook_signing_secret":  true,
+	"webhook_allowed_hosts":   true,
+	"grpc_server_cert":        true,
+	"grpc_server_key":         true,
+	"server_cert":             true,
+	"server_key":              true,
+	"tls_client_auth":         true,
+	"tls_client_ca":           true,
+	"certificate_identities":  true,
+}
+
+// ReloadReport lists the settings changed by a reload, split into the ones applied right away and
+// the ones that keep their value until the server restarts.
+type ReloadReport struct {
+	Reloaded        []string `json:"reloaded"`
+	RestartRequired []string `json:"restart_required"`
+}
+
+// Current returns the application settings in effect, including the reloaded ones.
+func (c *Config) Current() *ApplicationConfig {
+	if current := c.current.Load(); current != nil {
+		return current
+	}
+	return c.Application
+}
+
+// OnReload registers a function called with the settings in effect after every reload.
+func (c *Config) OnReload(listener func(*ApplicationConfig)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.listeners = append(c.listeners, listener)
+}
+
+// Reload reads the configurations again from the same file, environment and flags. Reloadable
+// settings are swapped atomically; the others are reported and keep their value. An invalid
+// configuration is rejected as a whole.
+func (c *Config) Reload() (*ReloadReport, error) {
+	if c.Application == nil {
+		return nil, errors.New("configurations have not been loaded yet")
+	}
+	loaded, err := Load(c.configFile, c.overrides)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the configurations again: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	report := &ReloadReport{Reloaded: []string{}, RestartRequired: []string{}}
+	next := *c.Current()
+	nextFields := fields(&next)
+	for i, f := range fields(loaded.Application) {
+		name := f.flagName()
+		if reflect.DeepEqual(f.value.Interface(), nextFields[i].value.Interface()) {
+			continue
+		}
+		if !reloadable[name] {
+			report.RestartRequired = append(report.RestartRequired, name)
+			continue
+		}
+		nextFields[i].value.Set(f.value)
+		report.Reloaded = append(report.Reloaded, name)
+	}
+	startup := fields(c.Providers)
+	for i, f := range fields(loaded.Providers) {
+		if !reflect.DeepEqual(f.value.Interface(), startup[i].value.Interface()) {
+			report.RestartRequired = append(report.RestartRequired, f.flagName())
+		}
+	}
+
+	c.current.Store(&next)
+	for _, listener := range c.listeners {
+		listener(&next)
+	}
+	return report, nil
+}
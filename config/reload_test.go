@@ -0,0 +1,133 @@
+package config
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	initial := `{
+		"server_port": "9098",
+		"storage_type": "redis",
+		"secret_masking": "none",
+		"access_tokens": [{"name": "ops", "token": "t1"}],
+		"providers": {"redis": {"host": "localhost", "port": "6379", "store_name": "kv"}}
+	}`
+	file := writeFile(t, dir, "c.json", initial)
+	tests := []struct {
+		name                string
+		file                string
+		env                 map[string]string
+		wantReloaded        []string
+		wantRestartRequired []string
+		wantErr             string
+	}{
+		{name: "nothing changed", file: initial, wantReloaded: []string{}, wantRestartRequired: []string{}},
+		{
+			name: "reloadable settings",
+			file: `{
+				"server_port": "9098",
+				"storage_type": "redis",
+				"secret_masking": "mask",
+				"access_tokens": [{"name": "ops", "token": "t2"}],
+				"providers": {"redis": {"host": "localhost", "port": "6379", "store_name": "kv"}}
+			}`,
+			env:                 map[string]string{"STOOKV_WEBHOOK_ALLOWED_HOSTS": "hooks.example.com"},
+			wantReloaded:        []string{"secret_masking", "access_tokens", "webhook_allowed_hosts"},
+			wantRestartRequired: []string{},
+		},
+		{
+			name: "settings needing a restart",
+			file: `{
+				"server_port": "9100",
+				"storage_type": "redis",
+				"secret_masking": "mask",
+				"access_tokens": [{"name": "ops", "token": "t1"}],
+				"providers": {"redis": {"host": "redis.internal", "port": "6379", "store_name": "kv"}}
+			}`,
+			wantReloaded:        []string{"secret_masking"},
+			wantRestartRequired: []string{"server_port", "redis.host"},
+		},
+		{
+			name:    "invalid configuration",
+			file:    `{"server_port": "9098", "storage_type": "cassandra"}`,
+			wantErr: `unknown storage_type "cassandra"`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := os.WriteFile(file, []byte(initial), 0644); err != nil {
+				t.Fatalf("WriteFile: %v", err)
+			}
+			cfg, err := Load(file, nil)
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			var notified []*ApplicationConfig
+			cfg.OnReload(func(app *ApplicationConfig) { notified = append(notified, app) })
+			startup := *cfg.Application
+
+			for name, value := range test.env {
+				t.Setenv(name, value)
+			}
+			if err := os.WriteFile(file, []byte(test.file), 0644); err != nil {
+				t.Fatalf("WriteFile: %v", err)
+			}
+			report, err := cfg.Reload()
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("got %v, want %s", err, test.wantErr)
+				}
+				// The settings in effect are kept.
+				if cfg.Current() != cfg.Application || len(notified) != 0 {
+					t.Errorf("settings changed by a rejected reload")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Reload: %v", err)
+			}
+			if !reflect.DeepEqual(report.Reloaded, test.wantReloaded) || !reflect.DeepEqual(report.RestartRequired, test.wantRestartRequired) {
+				t.Errorf("got reloaded %v and restart required %v, want %v and %v", report.Reloaded, report.RestartRequired, test.wantReloaded, test.wantRestartRequired)
+			}
+			if len(notified) != 1 || notified[0] != cfg.Current() {
+				t.Fatalf("got %d notifications, want the settings in effect notified once", len(notified))
+			}
+
+			// The settings the server started with stay as they were; the current ones only take
+			// the reloadable changes.
+			if !reflect.DeepEqual(*cfg.Application, startup) {
+				t.Errorf("startup settings changed by a reload")
+			}
+			loaded, err := Load(file, nil)
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			current := cfg.Current()
+			if current.ServerPort != startup.ServerPort {
+				t.Errorf("got server_port %s, want %s until a restart", current.ServerPort, startup.ServerPort)
+			}
+			if current.SecretMasking != loaded.Application.SecretMasking || !reflect.DeepEqual(current.AccessTokens, loaded.Application.AccessTokens) {
+				t.Errorf("got masking %s and tokens %v, want the reloaded ones", current.SecretMasking, current.AccessTokens)
+			}
+		})
+	}
+}
+
+func TestReloadableSettingsExist(t *testing.T) {
+	names := make(map[string]bool)
+	for _, f := range fields(&ApplicationConfig{}) {
+		names[f.flagName()] = true
+	}
+	for name := range reloadable {
+		if !names[name] {
+			t.Errorf("reloadable setting %s does not exist", name)
+		}
+	}
+	if _, err := (&Config{}).Reload(); err == nil {
+		t.Errorf("Reload before Load: want an error")
+	}
+}
@@ -0,0 +1,287 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"stoo-kv/client"
+	"stoo-kv/internal/render"
+	"stoo-kv/internal/webhook"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config describes the templates rendered by the agent and the stookv server they read from.
+type Config struct {
+	Server    ServerConfig `json:"server"`
+	Namespace string       `json:"namespace"`
+	Profile   string       `json:"profile"`
+	// PollInterval is how often values are reloaded; zero disables polling.
+	PollInterval string `json:"poll_interval"`
+	// WebhookListen is the address receiving webhook notifications of key changes, which are
+	// re-rendered right away; WebhookSecret verifies their signatures.
+	WebhookListen string            `json:"webhook_listen"`
+	WebhookSecret string            `json:"webhook_secret"`
+	Templates     []*TemplateConfig `json:"templates"`
+}
+
+type ServerConfig struct {
+	Addresses          []string `json:"addresses"`
+	Transport          string   `json:"transport"`
+	Token              string   `json:"token"`
+	CaFile             string   `json:"ca_file"`
+	CertFile           string   `json:"cert_file"`
+	KeyFile            string   `json:"key_file"`
+	InsecureSkipVerify bool     `json:"insecure_skip_verify"`
+	// SnapshotDir keeps a signed snapshot of every namespace and profile rendered, named
+	// namespace.profile.json, to render from when no server is reachable. SnapshotKey is the
+	// snapshot_key of the token.
+	SnapshotDir string `json:"snapshot_dir"`
+	SnapshotKey string `json:"snapshot_key"`
+}
+
+type TemplateConfig struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	// Perms is the octal mode of the rendered file, 0644 by default.
+	Perms     string `json:"perms"`
+	Namespace string `json:"namespace"`
+	Profile   string `json:"profile"`
+	// Command runs with sh -c after the file changed, e.g. "nginx -s reload".
+	Command        string `json:"command"`
+	CommandTimeout string `json:"command_timeout"`
+
+	perm           os.FileMode
+	commandTimeout time.Duration
+}
+
+func ParseConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("invalid agent config %s: %v", path, err)
+	}
+	if len(cfg.Templates) == 0 {
+		return nil, errors.New("agent config has no templates")
+	}
+	for i, t := range cfg.Templates {
+		if t.Source == "" || t.Destination == "" {
+			return nil, fmt.Errorf("template %d needs a source and a destination", i)
+		}
+		if t.Namespace == "" {
+			t.Namespace = cfg.Namespace
+		}
+		if t.Profile == "" {
+			t.Profile = cfg.Profile
+		}
+		if t.Namespace == "" || t.Profile == "" {
+			return nil, fmt.Errorf("template %s needs a namespace and a profile", t.Source)
+		}
+		t.perm = 0644
+		if t.Perms != "" {
+			perm, err := strconv.ParseUint(t.Perms, 8, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid perms %q of template %s", t.Perms, t.Source)
+			}
+			t.perm = os.FileMode(perm)
+		}
+		t.commandTimeout = 30 * time.Second
+		if t.CommandTimeout != "" {
+			if t.commandTimeout, err = time.ParseDuration(t.CommandTimeout); err != nil {
+				return nil, fmt.Errorf("invalid command_timeout of template %s: %v", t.Source, err)
+			}
+		}
+	}
+	if cfg.PollInterval == "" && cfg.WebhookListen == "" {
+		cfg.PollInterval = "1m"
+	}
+	return cfg, nil
+}
+
+// Agent renders templates with values and secrets read from stookv, writing the files atomically
+// and running their command when they change.
+type Agent struct {
+	config  *Config
+	clients map[string]*client.Client
+	trigger chan struct{}
+	mu      sync.Mutex
+}
+
+func New(cfg *Config) (*Agent, error) {
+	tlsConfig, err := client.NewTLSConfig(cfg.Server.CaFile, cfg.Server.CertFile, cfg.Server.KeyFile, cfg.Server.InsecureSkipVerify)
+	if err != nil {
+		return nil, err
+	}
+	a := &Agent{config: cfg, clients: make(map[string]*client.Client), trigger: make(chan struct{}, 1)}
+	for _, t := range cfg.Templates {
+		id := t.Namespace + "/" + t.Profile
+		if _, ok := a.clients[id]; ok {
+			continue
+		}
+		var snapshotFile string
+		if cfg.Server.SnapshotDir != "" {
+			snapshotFile = filepath.Join(cfg.Server.SnapshotDir, t.Namespace+"."+t.Profile+".json")
+		}
+		c, err := client.New(client.Options{
+			Addresses:    cfg.Server.Addresses,
+			Transport:    cfg.Server.Transport,
+			Token:        cfg.Server.Token,
+			Namespace:    t.Namespace,
+			Profile:      t.Profile,
+			Reveal:       true,
+			TLSConfig:    tlsConfig,
+			Retries:      2,
+			SnapshotFile: snapshotFile,
+			SnapshotKey:  cfg.Server.SnapshotKey,
+		})
+		if err != nil {
+			a.Close()
+			return nil, err
+		}
+		a.clients[id] = c
+	}
+	return a, nil
+}
+
+// RenderAll renders every template once.
+func (a *Agent) RenderAll(ctx context.Context) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	values := make(map[string]map[string]string)
+	var errs []string
+	for _, t := range a.config.Templates {
+		id := t.Namespace + "/" + t.Profile
+		if _, ok := values[id]; !ok {
+			v, err := a.clients[id].All(ctx)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("failed to read %s: %v", id, err))
+				continue
+			}
+			values[id] = v
+		}
+		if err := a.render(ctx, t, values[id]); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (a *Agent) render(ctx context.Context, t *TemplateConfig, values map[string]string) error {
+	data, err := render.ExecuteFile(t.Source, values)
+	if err != nil {
+		return fmt.Errorf("failed to render %s: %v", t.Source, err)
+	}
+	changed, err := render.WriteFile(t.Destination, data, t.perm)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %v", t.Destination, err)
+	}
+	if !changed {
+		return nil
+	}
+	log.Printf("Rendered %s", t.Destination)
+	if t.Command == "" {
+		return nil
+	}
+	commandCtx, cancel := context.WithTimeout(ctx, t.commandTimeout)
+	defer cancel()
+	output, err := exec.CommandContext(commandCtx, "sh", "-c", t.Command).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("command %q of %s failed: %v: %s", t.Command, t.Destination, err, output)
+	}
+	return nil
+}
+
+// Run renders the templates and keeps them up to date until the context is cancelled.
+func (a *Agent) Run(ctx context.Context) error {
+	if err := a.RenderAll(ctx); err != nil {
+		log.Printf("Failed to render templates: %v", err)
+	}
+
+	var ticks <-chan time.Time
+	if a.config.PollInterval != "" {
+		interval, err := time.ParseDuration(a.config.PollInterval)
+		if err != nil || interval <= 0 {
+			return fmt.Errorf("invalid poll_interval %q", a.config.PollInterval)
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+	if a.config.WebhookListen != "" {
+		server := &http.Server{Addr: a.config.WebhookListen, Handler: http.HandlerFunc(a.handleWebhook)}
+		go func() {
+			if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Printf("Agent webhook listener failed: %v", err)
+			}
+		}()
+		defer server.Close()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticks:
+		case <-a.trigger:
+		}
+		if err := a.RenderAll(ctx); err != nil {
+			log.Printf("Failed to render templates: %v", err)
+		}
+	}
+}
+
+// handleWebhook accepts the key change notifications of a stookv webhook subscription.
+func (a *Agent) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if a.config.WebhookSecret != "" {
+		timestamp, _ := strconv.ParseInt(r.Header.Get(webhook.TimestampHeader), 10, 64)
+		if !webhook.Verify(a.config.WebhookSecret, timestamp, body, r.Header.Get(webhook.SignatureHeader)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}
+	event := &webhook.Event{}
+	if err := json.Unmarshal(body, event); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	for _, t := range a.config.Templates {
+		if t.Namespace == event.Namespace && t.Profile == event.Profile {
+			select {
+			case a.trigger <- struct{}{}:
+			default:
+			}
+			break
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *Agent) Close() {
+	for _, c := range a.clients {
+		_ = c.Close()
+	}
+}
@@ -0,0 +1,248 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"stoo-kv/internal/webhook"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeServer answers the REST list endpoint of stookv with the values of each namespace/profile.
+type fakeServer struct {
+	*httptest.Server
+	mu     sync.Mutex
+	values map[string]map[string]string
+}
+
+func newFakeServer(t *testing.T, values map[string]map[string]string) *fakeServer {
+	t.Helper()
+	server := &fakeServer{values: values}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		id := strings.TrimPrefix(r.URL.Path, "/stoo-kv/")
+		data, err := json.Marshal(server.values[id])
+		if err != nil {
+			t.Errorf("Marshal: %v", err)
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"status": 0, "data": json.RawMessage(data)})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func (s *fakeServer) set(id, key, value string) {
+	s.mu.Lock()
+	s.values[id][key] = value
+	s.mu.Unlock()
+}
+
+func writeConfig(t *testing.T, dir string, cfg map[string]any) string {
+	t.Helper()
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	path := filepath.Join(dir, "agent.json")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	return path
+}
+
+func TestParseConfig(t *testing.T) {
+	template := map[string]any{"source": "a.tmpl", "destination": "a.conf"}
+	tests := []struct {
+		name    string
+		cfg     map[string]any
+		wantErr string
+	}{
+		{name: "valid", cfg: map[string]any{"namespace": "app", "profile": "prod", "templates": []any{template}}},
+		{name: "no templates", cfg: map[string]any{"namespace": "app", "profile": "prod"}, wantErr: "no templates"},
+		{name: "no destination", cfg: map[string]any{"namespace": "app", "profile": "prod", "templates": []any{map[string]any{"source": "a.tmpl"}}}, wantErr: "needs a source and a destination"},
+		{name: "no profile", cfg: map[string]any{"namespace": "app", "templates": []any{template}}, wantErr: "needs a namespace and a profile"},
+		{name: "invalid perms", cfg: map[string]any{"namespace": "app", "profile": "prod", "templates": []any{map[string]any{"source": "a", "destination": "b", "perms": "rw"}}}, wantErr: "invalid perms"},
+		{name: "invalid command timeout", cfg: map[string]any{"namespace": "app", "profile": "prod", "templates": []any{map[string]any{"source": "a", "destination": "b", "command_timeout": "soon"}}}, wantErr: "invalid command_timeout"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg, err := ParseConfig(writeConfig(t, t.TempDir(), test.cfg))
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("got %v, want error %s", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseConfig: %v", err)
+			}
+			// Templates inherit the namespace and profile, and polling is on by default.
+			got := cfg.Templates[0]
+			if got.Namespace != "app" || got.Profile != "prod" || got.perm != 0644 || got.commandTimeout != 30*time.Second || cfg.PollInterval != "1m" {
+				t.Errorf("got %+v, poll interval %q", got, cfg.PollInterval)
+			}
+		})
+	}
+}
+
+func TestRenderAll(t *testing.T) {
+	dir := t.TempDir()
+	server := newFakeServer(t, map[string]map[string]string{
+		"app/prod":    {"database.url": "postgres://db", "password": "s3cret"},
+		"shared/prod": {"region": "eu"},
+	})
+	source := filepath.Join(dir, "app.tmpl")
+	if err := os.WriteFile(source, []byte(`url={{ value "database.url" }}:{{ value "password" }}`), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	sharedSource := filepath.Join(dir, "shared.tmpl")
+	if err := os.WriteFile(sharedSource, []byte(`region={{ value "region" }}`), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	destination, sharedDestination := filepath.Join(dir, "out", "app.conf"), filepath.Join(dir, "out", "shared.conf")
+	runs := filepath.Join(dir, "runs")
+	cfg, err := ParseConfig(writeConfig(t, dir, map[string]any{
+		"server":    map[string]any{"addresses": []string{server.URL}},
+		"namespace": "app",
+		"profile":   "prod",
+		"templates": []any{
+			map[string]any{"source": source, "destination": destination, "perms": "0600", "command": "echo run >> " + runs},
+			map[string]any{"source": sharedSource, "destination": sharedDestination, "namespace": "shared"},
+		},
+	}))
+	if err != nil {
+		t.Fatalf("ParseConfig: %v", err)
+	}
+	a, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer a.Close()
+	ctx := context.Background()
+
+	steps := []struct {
+		name     string
+		change   func()
+		want     string
+		wantRuns int
+	}{
+		{name: "first render runs the command", want: "url=postgres://db:s3cret", wantRuns: 1},
+		{name: "unchanged values do not run it again", want: "url=postgres://db:s3cret", wantRuns: 1},
+		{name: "changed values run it again", change: func() { server.set("app/prod", "password", "rotated") }, want: "url=postgres://db:rotated", wantRuns: 2},
+	}
+	for _, step := range steps {
+		if step.change != nil {
+			step.change()
+		}
+		if err := a.RenderAll(ctx); err != nil {
+			t.Fatalf("%s: RenderAll: %v", step.name, err)
+		}
+		data, err := os.ReadFile(destination)
+		if err != nil || string(data) != step.want {
+			t.Errorf("%s: got %q, %v, want %q", step.name, data, err, step.want)
+		}
+		if info, err := os.Stat(destination); err != nil || info.Mode().Perm() != 0600 {
+			t.Errorf("%s: got mode %v, want 0600", step.name, info.Mode().Perm())
+		}
+		output, _ := os.ReadFile(runs)
+		if got := strings.Count(string(output), "run"); got != step.wantRuns {
+			t.Errorf("%s: got %d command runs, want %d", step.name, got, step.wantRuns)
+		}
+	}
+	if data, _ := os.ReadFile(sharedDestination); string(data) != "region=eu" {
+		t.Errorf("got %q, want the template of the other namespace rendered", data)
+	}
+}
+
+func TestRenderAllKeepsFilesOnErrors(t *testing.T) {
+	dir := t.TempDir()
+	server := newFakeServer(t, map[string]map[string]string{"app/prod": {"a": "1"}})
+	source := filepath.Join(dir, "app.tmpl")
+	if err := os.WriteFile(source, []byte(`a={{ value "a" }}`), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	destination := filepath.Join(dir, "app.conf")
+	cfg, err := ParseConfig(writeConfig(t, dir, map[string]any{
+		"server":    map[string]any{"addresses": []string{server.URL}},
+		"namespace": "app",
+		"profile":   "prod",
+		"templates": []any{map[string]any{"source": source, "destination": destination, "command": "exit 3"}},
+	}))
+	if err != nil {
+		t.Fatalf("ParseConfig: %v", err)
+	}
+	a, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer a.Close()
+
+	if err := a.RenderAll(context.Background()); err == nil || !strings.Contains(err.Error(), `command "exit 3"`) {
+		t.Errorf("got %v, want the failed command reported", err)
+	}
+	// A template that no longer renders leaves the last rendered file in place.
+	if err := os.WriteFile(source, []byte(`a={{ value "missing" }}`), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if err := a.RenderAll(context.Background()); err == nil || !strings.Contains(err.Error(), "key missing not found") {
+		t.Errorf("got %v, want the missing key reported", err)
+	}
+	if data, _ := os.ReadFile(destination); string(data) != "a=1" {
+		t.Errorf("got %q, want the last rendered file kept", data)
+	}
+}
+
+func TestHandleWebhook(t *testing.T) {
+	a := &Agent{
+		config:  &Config{WebhookSecret: "secret", Templates: []*TemplateConfig{{Namespace: "app", Profile: "prod"}}},
+		trigger: make(chan struct{}, 1),
+	}
+	body, _ := json.Marshal(&webhook.Event{Event: webhook.EventKeySet, Namespace: "app", Profile: "prod", Key: "a"})
+	other, _ := json.Marshal(&webhook.Event{Event: webhook.EventKeySet, Namespace: "other", Profile: "prod", Key: "a"})
+	timestamp := time.Now().Unix()
+	tests := []struct {
+		name        string
+		method      string
+		body        []byte
+		signature   string
+		wantStatus  int
+		wantTrigger bool
+	}{
+		{name: "signed event", method: http.MethodPost, body: body, signature: webhook.Sign("secret", timestamp, body), wantStatus: http.StatusNoContent, wantTrigger: true},
+		{name: "event of another namespace", method: http.MethodPost, body: other, signature: webhook.Sign("secret", timestamp, other), wantStatus: http.StatusNoContent},
+		{name: "wrong signature", method: http.MethodPost, body: body, signature: webhook.Sign("other", timestamp, body), wantStatus: http.StatusUnauthorized},
+		{name: "unsigned event", method: http.MethodPost, body: body, wantStatus: http.StatusUnauthorized},
+		{name: "wrong method", method: http.MethodGet, wantStatus: http.StatusMethodNotAllowed},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(test.method, "/", bytes.NewReader(test.body))
+			request.Header.Set(webhook.TimestampHeader, strconv.FormatInt(timestamp, 10))
+			request.Header.Set(webhook.SignatureHeader, test.signature)
+			recorder := httptest.NewRecorder()
+			a.handleWebhook(recorder, request)
+			if recorder.Code != test.wantStatus {
+				t.Errorf("got status %d, want %d", recorder.Code, test.wantStatus)
+			}
+			select {
+			case <-a.trigger:
+				if !test.wantTrigger {
+					t.Errorf("templates rendered again, want them left alone")
+				}
+			default:
+				if test.wantTrigger {
+					t.Errorf("templates not rendered again")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/x509"
+	"stoo-kv/config"
+	"strings"
+)
+
+const (
+	PermissionRevealSecrets  = "secrets:reveal"
+	PermissionAdmin          = "admin"
+	PermissionManageWebhooks = "webhooks:manage"
+	PermissionAll            = "*"
+)
+
+// Identity is the caller of an API request along with the permissions granted to it.
+type Identity struct {
+	Name        string
+	Permissions []string
+	// SnapshotKey encrypts the secrets of the snapshots served to the caller, if set.
+	SnapshotKey string
+}
+
+func (i *Identity) Can(permission string) bool {
+	if i == nil {
+		return false
+	}
+	for _, granted := range i.Permissions {
+		if granted == permission || granted == PermissionAll {
+			return true
+		}
+	}
+	return false
+}
+
+type Authenticator struct {
+	config *config.Config
+}
+
+func NewAuthenticator(cfg *config.Config) *Authenticator {
+	return &Authenticator{config: cfg}
+}
+
+// FromToken returns the identity owning an access token, or nil when the token is unknown.
+func (a *Authenticator) FromToken(token string) *Identity {
+	var identity *Identity
+	for _, accessToken := range a.config.Current().AccessTokens {
+		if subtle.ConstantTimeCompare([]byte(accessToken.Token), []byte(token)) == 1 {
+			identity = &Identity{Name: accessToken.Name, Permissions: accessToken.Permissions, SnapshotKey: accessToken.SnapshotKey}
+		}
+	}
+	return identity
+}
+
+// FromCertificate returns the identity mapped to the subject of a verified client certificate,
+// matching either its common name or its full distinguished name, or nil when none matches.
+func (a *Authenticator) FromCertificate(cert *x509.Certificate) *Identity {
+	if cert == nil {
+		return nil
+	}
+	for _, mapping := range a.config.Current().CertificateIdentities {
+		if mapping.Subject == cert.Subject.CommonName || mapping.Subject == cert.Subject.String() {
+			return &Identity{Name: mapping.Name, Permissions: mapping.Permissions, SnapshotKey: mapping.SnapshotKey}
+		}
+	}
+	return nil
+}
+
+// BearerToken extracts the token of an "Authorization: Bearer <token>" header value.
+func BearerToken(header string) (string, bool) {
+	scheme, token, ok := strings.Cut(strings.TrimSpace(header), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+type identityKey struct{}
+
+func WithIdentity(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+func FromContext(ctx context.Context) *Identity {
+	identity, _ := ctx.Value(identityKey{}).(*Identity)
+	return identity
+}
@@ -0,0 +1,165 @@
+package bundle
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"os"
+	"path/filepath"
+	"sort"
+	"stoo-kv/internal/crypto"
+	"strconv"
+	"time"
+)
+
+var ErrInvalidSignature = errors.New("snapshot signature does not match, it was changed or signed with another key")
+
+// Bundle is a signed copy of the values of a namespace and profile, kept by clients to start from
+// when the server is unreachable. Values holds plain payloads; Secrets holds the payloads of
+// secrets, and of values embedding them, encrypted with the client's snapshot key. ContentTypes
+// lists the keys of both that are not plain text.
+type Bundle struct {
+	Namespace    string            `json:"namespace"`
+	Profile      string            `json:"profile"`
+	CreatedAt    time.Time         `json:"created_at"`
+	ETag         string            `json:"etag"`
+	Values       map[string]string `json:"values"`
+	Secrets      map[string]string `json:"secrets"`
+	ContentTypes map[string]string `json:"content_types,omitempty"`
+	Signature    string            `json:"signature"`
+}
+
+// New builds the bundle of payloads, encrypting those of the secret keys with key. The ETag is
+// derived from the plain payloads, so it only changes with them.
+func New(namespace, profile string, payloads, contentTypes map[string]string, secretKeys []string, key string) (*Bundle, error) {
+	b := &Bundle{
+		Namespace:    namespace,
+		Profile:      profile,
+		CreatedAt:    time.Now().UTC().Truncate(time.Second),
+		Values:       make(map[string]string),
+		Secrets:      make(map[string]string),
+		ContentTypes: contentTypes,
+	}
+	secret := make(map[string]bool, len(secretKeys))
+	for _, k := range secretKeys {
+		secret[k] = true
+	}
+	for k, payload := range payloads {
+		if !secret[k] {
+			b.Values[k] = payload
+			continue
+		}
+		ciphertext, err := crypto.Encrypt([]byte(payload), key)
+		if err != nil {
+			return nil, err
+		}
+		b.Secrets[k] = hex.EncodeToString(ciphertext)
+	}
+	etag := digest(key, namespace, profile)
+	writeMap(etag, payloads)
+	writeMap(etag, contentTypes)
+	b.ETag = hex.EncodeToString(etag.Sum(nil))[:32]
+	b.Signature = b.sign(key)
+	return b, nil
+}
+
+// Verify checks that the bundle was signed with key and not changed since.
+func (b *Bundle) Verify(key string) error {
+	if !hmac.Equal([]byte(b.sign(key)), []byte(b.Signature)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// Open returns the plain payloads of the values and secrets.
+func (b *Bundle) Open(key string) (map[string]string, error) {
+	payloads := make(map[string]string, len(b.Values)+len(b.Secrets))
+	for k, payload := range b.Values {
+		payloads[k] = payload
+	}
+	for k, ciphertext := range b.Secrets {
+		plaintext, err := crypto.Decrypt([]byte(ciphertext), key)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decrypt secret %s: %v", k, err)
+		}
+		payloads[k] = string(plaintext)
+	}
+	return payloads, nil
+}
+
+// Load reads a bundle saved to file and verifies its signature.
+func Load(file, key string) (*Bundle, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	b := &Bundle{}
+	if err := json.Unmarshal(data, b); err != nil {
+		return nil, err
+	}
+	if err := b.Verify(key); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Save writes the bundle to file, replacing it at once so a crash never leaves half of it.
+func (b *Bundle) Save(file string) error {
+	data, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	temp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), file)
+}
+
+// sign computes the "sha256=" prefixed hex HMAC-SHA256 of every field but the signature, keyed
+// with the snapshot key. Fields are length prefixed and maps sorted by key, so the signature does
+// not depend on how the bundle is encoded.
+func (b *Bundle) sign(key string) string {
+	mac := digest(key, b.Namespace, b.Profile, b.CreatedAt.UTC().Format(time.RFC3339), b.ETag)
+	writeMap(mac, b.Values)
+	writeMap(mac, b.Secrets)
+	writeMap(mac, b.ContentTypes)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func digest(key string, fields ...string) hash.Hash {
+	mac := hmac.New(sha256.New, []byte(key))
+	for _, field := range fields {
+		writeField(mac, field)
+	}
+	return mac
+}
+
+func writeMap(mac hash.Hash, m map[string]string) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	writeField(mac, strconv.Itoa(len(keys)))
+	for _, k := range keys {
+		writeField(mac, k)
+		writeField(mac, m[k])
+	}
+}
+
+func writeField(mac hash.Hash, field string) {
+	mac.Write([]byte(strconv.Itoa(len(field)) + ":" + field))
+}
@@ -0,0 +1,142 @@
+package bundle
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const (
+	testKey  = "0123456789abcdef0123456789abcdef"
+	otherKey = "fedcba9876543210fedcba9876543210"
+)
+
+func newTestBundle(t *testing.T) *Bundle {
+	t.Helper()
+	payloads := map[string]string{"host": "db.internal", "password": "hunter2", "config": `{"a":1}`}
+	b, err := New("app", "prod", payloads, map[string]string{"config": "application/json"}, []string{"password"}, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestNew(t *testing.T) {
+	b := newTestBundle(t)
+	if _, ok := b.Values["password"]; ok {
+		t.Errorf("secret kept in plain values: %v", b.Values)
+	}
+	if b.Secrets["password"] == "" || b.Secrets["password"] == "hunter2" {
+		t.Errorf("secret not encrypted: %v", b.Secrets)
+	}
+	if err := b.Verify(testKey); err != nil {
+		t.Errorf("Verify() = %v", err)
+	}
+	payloads, err := b.Open(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"host": "db.internal", "password": "hunter2", "config": `{"a":1}`}
+	if !reflect.DeepEqual(payloads, want) {
+		t.Errorf("Open() = %v, want %v", payloads, want)
+	}
+}
+
+func TestETag(t *testing.T) {
+	b := newTestBundle(t)
+	if again := newTestBundle(t); again.ETag != b.ETag {
+		t.Errorf("ETag changed without the payloads: %s, then %s", b.ETag, again.ETag)
+	}
+	changed, err := New("app", "prod", map[string]string{"host": "db.example.com"}, nil, nil, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed.ETag == b.ETag {
+		t.Errorf("ETag %s did not change with the payloads", b.ETag)
+	}
+}
+
+func TestVerifyRejectsTampering(t *testing.T) {
+	tests := []struct {
+		name   string
+		key    string
+		tamper func(b *Bundle)
+	}{
+		{name: "other key", key: otherKey, tamper: func(b *Bundle) {}},
+		{name: "value changed", key: testKey, tamper: func(b *Bundle) { b.Values["host"] = "evil.example.com" }},
+		{name: "value added", key: testKey, tamper: func(b *Bundle) { b.Values["debug"] = "true" }},
+		{name: "value removed", key: testKey, tamper: func(b *Bundle) { delete(b.Values, "host") }},
+		{name: "secret made plain", key: testKey, tamper: func(b *Bundle) {
+			delete(b.Secrets, "password")
+			b.Values["password"] = "hunter2"
+		}},
+		{name: "secret replaced", key: testKey, tamper: func(b *Bundle) { b.Secrets["password"] = "00" }},
+		{name: "content type changed", key: testKey, tamper: func(b *Bundle) { b.ContentTypes["config"] = "text/plain" }},
+		{name: "namespace changed", key: testKey, tamper: func(b *Bundle) { b.Namespace = "other" }},
+		{name: "profile changed", key: testKey, tamper: func(b *Bundle) { b.Profile = "dev" }},
+		{name: "created at changed", key: testKey, tamper: func(b *Bundle) { b.CreatedAt = b.CreatedAt.Add(time.Hour) }},
+		{name: "etag changed", key: testKey, tamper: func(b *Bundle) { b.ETag = "0123456789abcdef0123456789abcdef" }},
+		{name: "signature removed", key: testKey, tamper: func(b *Bundle) { b.Signature = "" }},
+		{name: "field boundary moved", key: testKey, tamper: func(b *Bundle) {
+			b.Values["hos"] = "t" + b.Values["host"]
+			delete(b.Values, "host")
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTestBundle(t)
+			tt.tamper(b)
+			if err := b.Verify(tt.key); !errors.Is(err, ErrInvalidSignature) {
+				t.Errorf("Verify() = %v, want %v", err, ErrInvalidSignature)
+			}
+		})
+	}
+}
+
+func TestOpenWithOtherKey(t *testing.T) {
+	if _, err := newTestBundle(t).Open(otherKey); err == nil {
+		t.Error("Open() decrypted the secrets with another key")
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "snapshot.json")
+	b := newTestBundle(t)
+	if err := b.Save(file); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(file, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, b) {
+		t.Errorf("Load() = %+v, want %+v", loaded, b)
+	}
+	if _, err := Load(file, otherKey); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Load() with another key = %v, want %v", err, ErrInvalidSignature)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := bytes.Replace(data, []byte("db.internal"), []byte("db.external"), 1)
+	if err := os.WriteFile(file, tampered, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(file, testKey); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Load() of an edited file = %v, want %v", err, ErrInvalidSignature)
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Save() left %d files, want only the snapshot", len(entries))
+	}
+}
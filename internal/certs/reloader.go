@@ -0,0 +1,127 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"stoo-kv/config"
+	"sync"
+)
+
+// Reloader serves a certificate and key pair that can be re-read from disk, so that renewed
+// certificates are picked up by new connections without restarting the servers. It also holds
+// how client certificates are verified.
+type Reloader struct {
+	mu         sync.RWMutex
+	certFile   string
+	keyFile    string
+	cert       *tls.Certificate
+	clientAuth tls.ClientAuthType
+	clientCAs  *x509.CertPool
+}
+
+func NewReloader(certFile, keyFile string) (*Reloader, error) {
+	r := &Reloader{}
+	if err := r.Reload(certFile, keyFile); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// NewServerReloader loads the server certificate returned by files, verifies client certificates
+// as configured by tls_client_auth and tls_client_ca, and follows configuration reloads.
+func NewServerReloader(cfg *config.Config, files func(*config.ApplicationConfig) (string, string)) (*Reloader, error) {
+	app := cfg.Current()
+	r, err := NewReloader(files(app))
+	if err != nil {
+		return nil, err
+	}
+	if err := r.SetClientAuth(app.TlsClientAuth, app.TlsClientCa); err != nil {
+		return nil, err
+	}
+	cfg.OnReload(func(app *config.ApplicationConfig) {
+		if err := r.Reload(files(app)); err != nil {
+			log.Printf("Failed to reload certificate: %v", err)
+		}
+		if err := r.SetClientAuth(app.TlsClientAuth, app.TlsClientCa); err != nil {
+			log.Printf("Failed to reload client CA: %v", err)
+		}
+	})
+	return r, nil
+}
+
+// Reload reads the certificate and key again, from new files when they are given. The current
+// certificate is kept when they cannot be loaded.
+func (r *Reloader) Reload(certFile, keyFile string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if certFile == "" {
+		certFile, keyFile = r.certFile, r.keyFile
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate %s: %v", certFile, err)
+	}
+	r.certFile, r.keyFile, r.cert = certFile, keyFile, &cert
+	return nil
+}
+
+// SetClientAuth sets whether client certificates are required or verified when given, one of the
+// config.ClientAuth* values, and the CA bundle they are verified against.
+func (r *Reloader) SetClientAuth(mode, caFile string) error {
+	var clientAuth tls.ClientAuthType
+	switch mode {
+	case "", config.ClientAuthNone:
+		clientAuth = tls.NoClientCert
+	case config.ClientAuthOptional:
+		clientAuth = tls.VerifyClientCertIfGiven
+	case config.ClientAuthRequire:
+		clientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return fmt.Errorf("unknown client auth %q", mode)
+	}
+	var pool *x509.CertPool
+	if clientAuth != tls.NoClientCert {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in %s", caFile)
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.clientAuth, r.clientCAs = clientAuth, pool
+	return nil
+}
+
+// GetCertificate is used as tls.Config.GetCertificate.
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// TLSConfig returns a server TLS configuration serving the current certificate and client
+// verification settings.
+func (r *Reloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.GetCertificate,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*r.cert},
+				ClientAuth:   r.clientAuth,
+				ClientCAs:    r.clientCAs,
+				NextProtos:   []string{"h2", "http/1.1"},
+			}, nil
+		},
+	}
+}
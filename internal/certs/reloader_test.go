@@ -0,0 +1,242 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"stoo-kv/config"
+	"strings"
+	"testing"
+	"time"
+)
+
+// issuer signs certificates for the tests; a nil issuer makes them self-signed.
+type issuer struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newCert(t *testing.T, serial int64, name string, parent *issuer, isCA bool) *issuer {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	signer := &issuer{cert: template, key: key}
+	if parent != nil {
+		signer = parent
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer.cert, &key.PublicKey, signer.key)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("ParseCertificate: %v", err)
+	}
+	return &issuer{cert: cert, key: key}
+}
+
+// write saves the certificate and key as PEM files named after name.
+func (i *issuer) write(t *testing.T, dir, name string) (string, string) {
+	t.Helper()
+	keyDER, err := x509.MarshalECPrivateKey(i.key)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey: %v", err)
+	}
+	certFile, keyFile := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: i.cert.Raw}), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	return certFile, keyFile
+}
+
+func serial(t *testing.T, r *Reloader) int64 {
+	t.Helper()
+	cert, err := r.GetCertificate(nil)
+	if err != nil {
+		t.Fatalf("GetCertificate: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("ParseCertificate: %v", err)
+	}
+	return leaf.SerialNumber.Int64()
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := newCert(t, 1, "server", nil, false).write(t, dir, "server")
+	r, err := NewReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("NewReloader: %v", err)
+	}
+	if got := serial(t, r); got != 1 {
+		t.Fatalf("got serial %d, want 1", got)
+	}
+
+	// A renewed certificate written over the files is read again.
+	newCert(t, 2, "server", nil, false).write(t, dir, "server")
+	if err := r.Reload("", ""); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if got := serial(t, r); got != 2 {
+		t.Errorf("got serial %d, want 2 after the reload", got)
+	}
+
+	// Other files replace the current ones.
+	otherCert, otherKey := newCert(t, 3, "server", nil, false).write(t, dir, "other")
+	if err := r.Reload(otherCert, otherKey); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if got := serial(t, r); got != 3 {
+		t.Errorf("got serial %d, want 3 from the other files", got)
+	}
+
+	// Certificates that cannot be loaded keep the current one.
+	if err := os.WriteFile(otherCert, []byte("truncated"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if err := r.Reload("", ""); err == nil {
+		t.Errorf("Reload of an invalid certificate: want an error")
+	}
+	if err := r.Reload(certFile, filepath.Join(dir, "missing.key")); err == nil {
+		t.Errorf("Reload of a missing key: want an error")
+	}
+	if got := serial(t, r); got != 3 {
+		t.Errorf("got serial %d, want 3 kept after failed reloads", got)
+	}
+}
+
+func TestSetClientAuth(t *testing.T) {
+	dir := t.TempDir()
+	caFile, _ := newCert(t, 1, "ca", nil, true).write(t, dir, "ca")
+	empty := filepath.Join(dir, "empty.pem")
+	if err := os.WriteFile(empty, []byte("no certificates"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	tests := []struct {
+		mode    string
+		caFile  string
+		want    tls.ClientAuthType
+		wantErr string
+	}{
+		{mode: "", want: tls.NoClientCert},
+		{mode: config.ClientAuthNone, caFile: "ignored", want: tls.NoClientCert},
+		{mode: config.ClientAuthOptional, caFile: caFile, want: tls.VerifyClientCertIfGiven},
+		{mode: config.ClientAuthRequire, caFile: caFile, want: tls.RequireAndVerifyClientCert},
+		{mode: config.ClientAuthRequire, caFile: filepath.Join(dir, "missing.pem"), wantErr: "no such file"},
+		{mode: config.ClientAuthRequire, caFile: empty, wantErr: "no certificates found"},
+		{mode: "always", wantErr: `unknown client auth "always"`},
+	}
+	for _, test := range tests {
+		t.Run(test.mode+" "+filepath.Base(test.caFile), func(t *testing.T) {
+			r := &Reloader{clientAuth: tls.RequestClientCert}
+			err := r.SetClientAuth(test.mode, test.caFile)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("got %v, want %s", err, test.wantErr)
+				}
+				if r.clientAuth != tls.RequestClientCert {
+					t.Errorf("client auth changed by a failed call")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SetClientAuth: %v", err)
+			}
+			if r.clientAuth != test.want || (r.clientCAs == nil) != (test.want == tls.NoClientCert) {
+				t.Errorf("got %v with CAs %v, want %v", r.clientAuth, r.clientCAs != nil, test.want)
+			}
+		})
+	}
+}
+
+func TestServerReloader(t *testing.T) {
+	dir := t.TempDir()
+	ca := newCert(t, 1, "ca", nil, true)
+	caFile, _ := ca.write(t, dir, "ca")
+	certFile, keyFile := newCert(t, 10, "server", ca, false).write(t, dir, "server")
+	clientCert, clientKey := newCert(t, 20, "deployer", ca, false).write(t, dir, "client")
+	configFile := filepath.Join(dir, "c.json")
+	writeConfig := func(clientAuth string) {
+		t.Helper()
+		data := `{"server_use_tls": true, "server_cert": "` + certFile + `", "server_key": "` + keyFile + `", "tls_client_auth": "` + clientAuth + `", "tls_client_ca": "` + caFile + `"}`
+		if err := os.WriteFile(configFile, []byte(data), 0644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+	writeConfig(config.ClientAuthNone)
+	cfg, err := config.Load(configFile, nil)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	r, err := NewServerReloader(cfg, func(app *config.ApplicationConfig) (string, string) { return app.ServerCert, app.ServerKey })
+	if err != nil {
+		t.Fatalf("NewServerReloader: %v", err)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = r.TLSConfig()
+	server.StartTLS()
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	withCert, err := tls.LoadX509KeyPair(clientCert, clientKey)
+	if err != nil {
+		t.Fatalf("LoadX509KeyPair: %v", err)
+	}
+	// get connects anew, returning the serial of the server certificate.
+	get := func(certificates []tls.Certificate) (int64, error) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certificates}}}
+		defer client.CloseIdleConnections()
+		response, err := client.Get(server.URL)
+		if err != nil {
+			return 0, err
+		}
+		response.Body.Close()
+		return response.TLS.PeerCertificates[0].SerialNumber.Int64(), nil
+	}
+
+	if got, err := get(nil); err != nil || got != 10 {
+		t.Fatalf("got serial %d, %v, want 10", got, err)
+	}
+
+	// A renewed certificate and required client certificates apply to new connections after a reload.
+	newCert(t, 11, "server", ca, false).write(t, dir, "server")
+	writeConfig(config.ClientAuthRequire)
+	if _, err := cfg.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if _, err := get(nil); err == nil {
+		t.Errorf("connection without a client certificate: want it refused")
+	}
+	if got, err := get([]tls.Certificate{withCert}); err != nil || got != 11 {
+		t.Errorf("got serial %d, %v, want 11", got, err)
+	}
+}
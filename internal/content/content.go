@@ -0,0 +1,178 @@
+package content
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	TypeText   = "text/plain"
+	TypeJSON   = "application/json"
+	TypeList   = "application/x-stoo-list"
+	TypeBinary = "application/octet-stream"
+)
+
+const typePrefix = "{TYPE "
+
+// Encode prefixes the stored payload with its content type, e.g. "{TYPE application/json} {...}".
+// Text values are stored as they are, so values written before content types existed stay valid,
+// unless they start with the prefix themselves and are then stored as "{TYPE text/plain} ...".
+// JSON documents and lists are stored as compact JSON and binary values as standard base64.
+func Encode(contentType, payload string) string {
+	if (contentType == "" || contentType == TypeText) && !strings.HasPrefix(payload, typePrefix) {
+		return payload
+	}
+	if contentType == "" {
+		contentType = TypeText
+	}
+	return typePrefix + contentType + "} " + payload
+}
+
+func Decode(stored string) (string, string) {
+	if !strings.HasPrefix(stored, typePrefix) {
+		return TypeText, stored
+	}
+	end := strings.Index(stored, "} ")
+	if end < 0 {
+		return TypeText, stored
+	}
+	return stored[len(typePrefix):end], stored[end+2:]
+}
+
+func IsSupported(contentType string) bool {
+	switch contentType {
+	case TypeText, TypeJSON, TypeList, TypeBinary:
+		return true
+	}
+	return false
+}
+
+// FromJSON converts a value received over REST into its content type and stored payload.
+// Without an explicit content type, JSON strings, numbers and booleans are text, objects are
+// JSON documents and arrays of strings are lists. Binary values are sent as base64 strings.
+func FromJSON(raw json.RawMessage, contentType string) (string, string, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		raw = []byte(`""`)
+	}
+	if contentType == "" {
+		contentType = infer(raw)
+	}
+
+	switch contentType {
+	case TypeText:
+		var text string
+		if err := json.Unmarshal(raw, &text); err != nil {
+			if raw[0] == '{' || raw[0] == '[' || raw[0] == '"' {
+				return "", "", fmt.Errorf("text value must be a string")
+			}
+			text = string(raw)
+		}
+		return TypeText, text, nil
+	case TypeJSON:
+		var text string
+		if err := json.Unmarshal(raw, &text); err == nil {
+			raw = []byte(text)
+		}
+		payload, err := compact(raw)
+		return TypeJSON, payload, err
+	case TypeList:
+		var list []string
+		if err := json.Unmarshal(raw, &list); err != nil {
+			return "", "", fmt.Errorf("list value must be an array of strings")
+		}
+		return FromList(list)
+	case TypeBinary:
+		var text string
+		if err := json.Unmarshal(raw, &text); err != nil {
+			return "", "", fmt.Errorf("binary value must be a base64 string")
+		}
+		data, err := base64.StdEncoding.DecodeString(text)
+		if err != nil {
+			return "", "", fmt.Errorf("binary value must be a base64 string: %v", err)
+		}
+		return FromBytes(data)
+	default:
+		return "", "", fmt.Errorf("unsupported content type %q", contentType)
+	}
+}
+
+// FromString converts a value received as text, e.g. over gRPC, into its stored payload.
+func FromString(value, contentType string) (string, string, error) {
+	switch contentType {
+	case "", TypeText:
+		return TypeText, value, nil
+	case TypeBinary:
+		data, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return "", "", fmt.Errorf("binary value must be a base64 string: %v", err)
+		}
+		return FromBytes(data)
+	default:
+		return FromJSON(json.RawMessage(value), contentType)
+	}
+}
+
+func FromList(list []string) (string, string, error) {
+	if list == nil {
+		list = []string{}
+	}
+	data, err := json.Marshal(list)
+	return TypeList, string(data), err
+}
+
+func FromBytes(data []byte) (string, string, error) {
+	return TypeBinary, base64.StdEncoding.EncodeToString(data), nil
+}
+
+// Render converts a decrypted payload into the value returned over REST.
+func Render(contentType, payload string) (any, error) {
+	switch contentType {
+	case TypeJSON:
+		if !json.Valid([]byte(payload)) {
+			return nil, fmt.Errorf("stored value is not valid json")
+		}
+		return json.RawMessage(payload), nil
+	case TypeList:
+		var list []string
+		if err := json.Unmarshal([]byte(payload), &list); err != nil {
+			return nil, err
+		}
+		return list, nil
+	default:
+		return payload, nil
+	}
+}
+
+func Bytes(contentType, payload string) ([]byte, error) {
+	if contentType == TypeBinary {
+		return base64.StdEncoding.DecodeString(payload)
+	}
+	return []byte(payload), nil
+}
+
+func infer(raw []byte) string {
+	switch raw[0] {
+	case '{':
+		return TypeJSON
+	case '[':
+		var list []string
+		if json.Unmarshal(raw, &list) == nil {
+			return TypeList
+		}
+		return TypeJSON
+	default:
+		return TypeText
+	}
+}
+
+func compact(raw []byte) (string, error) {
+	var b bytes.Buffer
+	if err := json.Compact(&b, raw); err != nil {
+		return "", fmt.Errorf("invalid json value: %v", err)
+	}
+	return b.String(), nil
+}
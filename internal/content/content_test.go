@@ -0,0 +1,139 @@
+package content
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		payload     string
+		wantStored  string
+		wantType    string
+	}{
+		{name: "text is stored as is", contentType: TypeText, payload: "plain", wantStored: "plain", wantType: TypeText},
+		{name: "no content type is text", payload: "plain", wantStored: "plain", wantType: TypeText},
+		{name: "text starting with the prefix", contentType: TypeText, payload: "{TYPE x} y", wantStored: "{TYPE text/plain} {TYPE x} y", wantType: TypeText},
+		{name: "empty text", contentType: TypeText, wantStored: "", wantType: TypeText},
+		{name: "json", contentType: TypeJSON, payload: `{"a":1}`, wantStored: `{TYPE application/json} {"a":1}`, wantType: TypeJSON},
+		{name: "list", contentType: TypeList, payload: `["a","b"]`, wantStored: `{TYPE application/x-stoo-list} ["a","b"]`, wantType: TypeList},
+		{name: "binary", contentType: TypeBinary, payload: "AAE=", wantStored: "{TYPE application/octet-stream} AAE=", wantType: TypeBinary},
+		{name: "empty json document", contentType: TypeJSON, payload: "", wantStored: "{TYPE application/json} ", wantType: TypeJSON},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stored := Encode(test.contentType, test.payload)
+			if stored != test.wantStored {
+				t.Fatalf("Encode: got %q, want %q", stored, test.wantStored)
+			}
+			contentType, payload := Decode(stored)
+			if contentType != test.wantType || payload != test.payload {
+				t.Errorf("Decode: got %s %q, want %s %q", contentType, payload, test.wantType, test.payload)
+			}
+		})
+	}
+}
+
+func TestDecodeValuesWithoutType(t *testing.T) {
+	for _, stored := range []string{"", "plain", "{TYPE", "{TYPE application/json}", `{"a":1}`} {
+		if contentType, payload := Decode(stored); contentType != TypeText || payload != stored {
+			t.Errorf("Decode(%q): got %s %q, want the text as is", stored, contentType, payload)
+		}
+	}
+}
+
+func TestFromJSON(t *testing.T) {
+	tests := []struct {
+		name        string
+		raw         string
+		contentType string
+		wantType    string
+		wantPayload string
+		wantErr     bool
+	}{
+		{name: "string", raw: `"value"`, wantType: TypeText, wantPayload: "value"},
+		{name: "number", raw: `8080`, wantType: TypeText, wantPayload: "8080"},
+		{name: "boolean", raw: `true`, wantType: TypeText, wantPayload: "true"},
+		{name: "null", raw: `null`, wantType: TypeText, wantPayload: ""},
+		{name: "object", raw: `{ "a": [1, 2] }`, wantType: TypeJSON, wantPayload: `{"a":[1,2]}`},
+		{name: "array of strings", raw: `["a", "b"]`, wantType: TypeList, wantPayload: `["a","b"]`},
+		{name: "mixed array", raw: `["a", 1]`, wantType: TypeJSON, wantPayload: `["a",1]`},
+		{name: "json given as a string", raw: `"{\"a\": 1}"`, contentType: TypeJSON, wantType: TypeJSON, wantPayload: `{"a":1}`},
+		{name: "invalid json string", raw: `"{a"`, contentType: TypeJSON, wantErr: true},
+		{name: "text given an object", raw: `{"a":1}`, contentType: TypeText, wantErr: true},
+		{name: "list of numbers", raw: `[1, 2]`, contentType: TypeList, wantErr: true},
+		{name: "empty list", raw: `[]`, contentType: TypeList, wantType: TypeList, wantPayload: `[]`},
+		{name: "binary", raw: `"AAH/"`, contentType: TypeBinary, wantType: TypeBinary, wantPayload: "AAH/"},
+		{name: "binary that is not base64", raw: `"not base64!"`, contentType: TypeBinary, wantErr: true},
+		{name: "unsupported type", raw: `"x"`, contentType: "text/html", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			contentType, payload, err := FromJSON(json.RawMessage(test.raw), test.contentType)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("got %s %q, want an error", contentType, payload)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("FromJSON: %v", err)
+			}
+			if contentType != test.wantType || payload != test.wantPayload {
+				t.Errorf("got %s %q, want %s %q", contentType, payload, test.wantType, test.wantPayload)
+			}
+		})
+	}
+}
+
+func TestFromString(t *testing.T) {
+	tests := []struct {
+		value       string
+		contentType string
+		wantType    string
+		wantPayload string
+		wantErr     bool
+	}{
+		{value: `{"a": 1}`, wantType: TypeText, wantPayload: `{"a": 1}`},
+		{value: `{"a": 1}`, contentType: TypeJSON, wantType: TypeJSON, wantPayload: `{"a":1}`},
+		{value: `["a"]`, contentType: TypeList, wantType: TypeList, wantPayload: `["a"]`},
+		{value: "AAE=", contentType: TypeBinary, wantType: TypeBinary, wantPayload: "AAE="},
+		{value: "{", contentType: TypeJSON, wantErr: true},
+		{value: "%%", contentType: TypeBinary, wantErr: true},
+	}
+	for _, test := range tests {
+		contentType, payload, err := FromString(test.value, test.contentType)
+		if (err != nil) != test.wantErr || (!test.wantErr && (contentType != test.wantType || payload != test.wantPayload)) {
+			t.Errorf("FromString(%q, %q): got %s %q, %v", test.value, test.contentType, contentType, payload, err)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		contentType string
+		payload     string
+		want        any
+		wantErr     bool
+	}{
+		{contentType: TypeText, payload: "plain", want: "plain"},
+		{contentType: TypeJSON, payload: `{"a":1}`, want: json.RawMessage(`{"a":1}`)},
+		{contentType: TypeJSON, payload: `{"a":`, wantErr: true},
+		{contentType: TypeList, payload: `["a","b"]`, want: []string{"a", "b"}},
+		{contentType: TypeList, payload: `"a"`, wantErr: true},
+		{contentType: TypeBinary, payload: "AAE=", want: "AAE="},
+	}
+	for _, test := range tests {
+		got, err := Render(test.contentType, test.payload)
+		if (err != nil) != test.wantErr || (!test.wantErr && !reflect.DeepEqual(got, test.want)) {
+			t.Errorf("Render(%s, %q): got %v, %v, want %v", test.contentType, test.payload, got, err, test.want)
+		}
+	}
+	data, err := Bytes(TypeBinary, "AAH/")
+	if err != nil || !reflect.DeepEqual(data, []byte{0, 1, 255}) {
+		t.Errorf("Bytes: got %v, %v", data, err)
+	}
+}
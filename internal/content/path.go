@@ -0,0 +1,60 @@
+package content
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Query returns the part of a JSON document addressed by a path such as
+// "$.database.replicas[0].host" or "database.replicas.0.host".
+func Query(document, path string) (json.RawMessage, error) {
+	var node any
+	if err := json.Unmarshal([]byte(document), &node); err != nil {
+		return nil, fmt.Errorf("stored value is not valid json: %v", err)
+	}
+
+	segments, err := splitPath(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, segment := range segments {
+		switch current := node.(type) {
+		case map[string]any:
+			value, ok := current[segment]
+			if !ok {
+				return nil, fmt.Errorf("path %s not found", path)
+			}
+			node = value
+		case []any:
+			index, err := strconv.Atoi(segment)
+			if err != nil || index < 0 || index >= len(current) {
+				return nil, fmt.Errorf("path %s not found", path)
+			}
+			node = current[index]
+		default:
+			return nil, fmt.Errorf("path %s not found", path)
+		}
+	}
+	return json.Marshal(node)
+}
+
+func splitPath(path string) ([]string, error) {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	path = strings.ReplaceAll(path, "[", ".")
+	var segments []string
+	for _, segment := range strings.Split(path, ".") {
+		if segment == "" {
+			continue
+		}
+		if strings.HasSuffix(segment, "]") {
+			segment = strings.TrimSuffix(segment, "]")
+			if _, err := strconv.Atoi(segment); err != nil {
+				return nil, fmt.Errorf("invalid index %q in path %s", segment, path)
+			}
+		}
+		segments = append(segments, segment)
+	}
+	return segments, nil
+}
@@ -0,0 +1,50 @@
+package content
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestQuery(t *testing.T) {
+	document := `{"a.b":1,"database":{"host":"db","port":5432,"replicas":[{"host":"r0"},{"host":"r1"}],"tls":null}}`
+	tests := []struct {
+		path    string
+		want    string
+		wantErr string
+	}{
+		{path: "$.database.host", want: `"db"`},
+		{path: "database.port", want: `5432`},
+		{path: "$.database.replicas[1].host", want: `"r1"`},
+		{path: "database.replicas.0.host", want: `"r0"`},
+		{path: "$.database.replicas", want: `[{"host":"r0"},{"host":"r1"}]`},
+		{path: "$.database.tls", want: `null`},
+		{path: "$", want: document},
+		{path: "", want: document},
+		{path: "$.database.user", wantErr: "path $.database.user not found"},
+		{path: "$.database.replicas[2]", wantErr: "path $.database.replicas[2] not found"},
+		{path: "$.database.replicas[-1]", wantErr: "path $.database.replicas[-1] not found"},
+		{path: "$.database.host.name", wantErr: "path $.database.host.name not found"},
+		{path: "$.database.replicas.host", wantErr: "path $.database.replicas.host not found"},
+		{path: "$.database.replicas[x]", wantErr: `invalid index "x"`},
+	}
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			got, err := Query(document, test.path)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("got %s, %v, want error %s", got, err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Query: %v", err)
+			}
+			if string(got) != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+	if _, err := Query("{", "$.a"); err == nil {
+		t.Errorf("Query of invalid json: want an error")
+	}
+}
@@ -51,3 +51,23 @@ func Decrypt(cipherData []byte, key string) ([]byte, error) {
 	ciphertext = ciphertext[nonceSize:]
 	return gcm.Open(nil, nonce, ciphertext, nil)
 }
+
+// DefaultPrefix marks stored values that are encrypted when no prefix is configured.
+const DefaultPrefix = "{ENC} "
+
+// Prefix returns the configured prefix of encrypted values, or DefaultPrefix when none is set.
+func Prefix(configured string) string {
+	if configured == "" {
+		return DefaultPrefix
+	}
+	return configured
+}
+
+// Seal encrypts a value into the form kept in storage: the prefix followed by the hex ciphertext.
+func Seal(plaintext, key, prefix string) (string, error) {
+	ciphertext, err := Encrypt([]byte(plaintext), key)
+	if err != nil {
+		return "", err
+	}
+	return Prefix(prefix) + hex.EncodeToString(ciphertext), nil
+}
@@ -0,0 +1,57 @@
+package environ
+
+import (
+	"strings"
+)
+
+// Name converts a key into an environment variable name, e.g. "database.url" with prefix "APP_"
+// becomes APP_DATABASE_URL.
+func Name(prefix, key string) string {
+	var b strings.Builder
+	b.WriteString(prefix)
+	for _, r := range strings.ToUpper(key) {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('_')
+		}
+	}
+	name := b.String()
+	if name != "" && name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+// Quote returns a value quoted for POSIX shells and .env files.
+func Quote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// Parse reads NAME=value lines as written by Quote, skipping blank lines, comments and an
+// optional "export " prefix.
+func Parse(data string) map[string]string {
+	values := make(map[string]string)
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		if !ok {
+			continue
+		}
+		values[strings.TrimSpace(name)] = unquote(strings.TrimSpace(value))
+	}
+	return values
+}
+
+func unquote(value string) string {
+	switch {
+	case len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'':
+		return strings.ReplaceAll(value[1:len(value)-1], `'\''`, "'")
+	case len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"':
+		return strings.NewReplacer(`\"`, `"`, `\\`, `\`, `\n`, "\n").Replace(value[1 : len(value)-1])
+	}
+	return value
+}
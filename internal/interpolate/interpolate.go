@@ -0,0 +1,138 @@
+package interpolate
+
+import (
+	"fmt"
+	"os"
+	"stoo-kv/config"
+	"strings"
+)
+
+const (
+	maxDepth = 32
+	// maxLookups bounds the keys read to resolve a value, as a value can reference many keys.
+	maxLookups = 256
+	// maxLength bounds the length of a resolved value, which doubles with every key referencing
+	// another one twice.
+	maxLength = 1 << 20
+)
+
+// Lookup returns the plain value of a key and whether the key exists.
+type Lookup func(namespace, profile, key string) (string, bool, error)
+
+// Resolver expands ${key}, ${namespace:profile:key} and ${env:VAR} references.
+// A reference can be escaped by doubling the dollar sign, e.g. $${key}.
+type Resolver struct {
+	lookup Lookup
+	env    map[string]bool
+}
+
+// NewResolver creates a resolver reading keys with lookup. Only the environment variables listed in
+// env can be referenced, and never the STOOKV_* variables configuring the server.
+func NewResolver(lookup Lookup, env []string) *Resolver {
+	allowed := make(map[string]bool, len(env))
+	for _, name := range env {
+		allowed[name] = !strings.HasPrefix(name, config.EnvPrefix)
+	}
+	return &Resolver{lookup: lookup, env: allowed}
+}
+
+// resolution is the state of a Resolve call: the keys resolved so far, each read once however often
+// it is referenced, and how many keys were read.
+type resolution struct {
+	resolved map[string]string
+	lookups  int
+}
+
+func (r *Resolver) Resolve(namespace, profile, key, value string) (string, error) {
+	state := &resolution{resolved: make(map[string]string)}
+	return r.resolve(state, namespace, profile, value, []string{fullKey(namespace, profile, key)})
+}
+
+func (r *Resolver) resolve(state *resolution, namespace, profile, value string, chain []string) (string, error) {
+	if !strings.Contains(value, "${") {
+		return value, nil
+	}
+	if len(chain) > maxDepth {
+		return "", fmt.Errorf("reference depth exceeds %d: %s", maxDepth, strings.Join(chain, " -> "))
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(value); {
+		if strings.HasPrefix(value[i:], "$${") {
+			b.WriteString("${")
+			i += 3
+			continue
+		}
+		if strings.HasPrefix(value[i:], "${") {
+			end := strings.IndexByte(value[i+2:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated reference at offset %d", i)
+			}
+			resolved, err := r.reference(state, namespace, profile, value[i+2:i+2+end], chain)
+			if err != nil {
+				return "", err
+			}
+			if b.Len()+len(resolved) > maxLength {
+				return "", fmt.Errorf("resolved value exceeds %d bytes", maxLength)
+			}
+			b.WriteString(resolved)
+			i += end + 3
+			continue
+		}
+		b.WriteByte(value[i])
+		i++
+	}
+	return b.String(), nil
+}
+
+func (r *Resolver) reference(state *resolution, namespace, profile, ref string, chain []string) (string, error) {
+	parts := strings.Split(ref, ":")
+	switch {
+	case len(parts) == 2 && parts[0] == "env":
+		if !r.env[parts[1]] {
+			return "", fmt.Errorf("environment variable %s is not allowed by interpolate_env", parts[1])
+		}
+		value, ok := os.LookupEnv(parts[1])
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", parts[1])
+		}
+		return value, nil
+	case len(parts) == 1 && parts[0] != "":
+	case len(parts) == 3 && parts[0] != "" && parts[1] != "" && parts[2] != "":
+		namespace, profile = parts[0], parts[1]
+	default:
+		return "", fmt.Errorf("invalid reference ${%s}", ref)
+	}
+
+	key := parts[len(parts)-1]
+	id := fullKey(namespace, profile, key)
+	for _, visited := range chain {
+		if visited == id {
+			return "", fmt.Errorf("cyclic reference: %s -> %s", strings.Join(chain, " -> "), id)
+		}
+	}
+
+	if resolved, ok := state.resolved[id]; ok {
+		return resolved, nil
+	}
+	if state.lookups++; state.lookups > maxLookups {
+		return "", fmt.Errorf("value references more than %d keys", maxLookups)
+	}
+	value, found, err := r.lookup(namespace, profile, key)
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		return "", fmt.Errorf("unresolved reference ${%s}", ref)
+	}
+	resolved, err := r.resolve(state, namespace, profile, value, append(chain, id))
+	if err != nil {
+		return "", err
+	}
+	state.resolved[id] = resolved
+	return resolved, nil
+}
+
+func fullKey(namespace, profile, key string) string {
+	return fmt.Sprintf("%s::%s::%s", namespace, profile, key)
+}
@@ -0,0 +1,124 @@
+package interpolate
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// mapLookup reads keys from a map of namespace::profile::key and counts the keys read.
+type mapLookup struct {
+	values  map[string]string
+	lookups int
+}
+
+func (m *mapLookup) lookup(namespace, profile, key string) (string, bool, error) {
+	m.lookups++
+	if namespace == "down" {
+		return "", false, errors.New("storage is unavailable")
+	}
+	value, ok := m.values[fullKey(namespace, profile, key)]
+	return value, ok, nil
+}
+
+// chain returns keys k0 to k{n-1} of app::prod, each referencing the next one the given times.
+func chain(n, times int) map[string]string {
+	values := map[string]string{}
+	for i := 0; i < n-1; i++ {
+		values[fmt.Sprintf("app::prod::k%d", i)] = strings.Repeat(fmt.Sprintf("${k%d}", i+1), times)
+	}
+	values[fmt.Sprintf("app::prod::k%d", n-1)] = "x"
+	return values
+}
+
+func TestResolve(t *testing.T) {
+	t.Setenv("APP_HOME", "/home/app")
+	t.Setenv("STOOKV_TEST_HOME", "/home/stookv")
+	t.Setenv("STOOKV_SERVER_PORT", "9098")
+	values := map[string]string{
+		"app::prod::host":      "db.local",
+		"app::prod::port":      "5432",
+		"app::prod::url":       "postgres://${host}:${port}",
+		"app::prod::escaped":   "$${host}",
+		"app::prod::a":         "${b}",
+		"app::prod::b":         "${a}",
+		"app::prod::self":      "${self}",
+		"shared::prod::region": "eu-west-1",
+		"shared::prod::zone":   "${region}a",
+	}
+	tests := []struct {
+		name    string
+		values  map[string]string
+		value   string
+		want    string
+		wantErr string
+		// wantLookups is the number of keys read, when checked.
+		wantLookups int
+	}{
+		{name: "plain value", value: "plain", want: "plain"},
+		{name: "same profile", value: "${host}", want: "db.local"},
+		{name: "nested references", value: "url=${url}", want: "url=postgres://db.local:5432"},
+		{name: "other namespace", value: "${shared:prod:zone}", want: "eu-west-1a"},
+		{name: "escaped", value: "$${host} is ${host}", want: "${host} is db.local"},
+		{name: "escaped in a referenced value", value: "${escaped}", want: "${host}"},
+		{name: "environment variable", value: "home=${env:APP_HOME}", want: "home=/home/app"},
+		{name: "unset environment variable", value: "${env:HOME_DIR}", wantErr: "environment variable HOME_DIR is not set"},
+		{name: "environment variable not allowed", value: "${env:PATH}", wantErr: "environment variable PATH is not allowed by interpolate_env"},
+		{name: "server environment variable", value: "${env:STOOKV_SERVER_PORT}", wantErr: "environment variable STOOKV_SERVER_PORT is not allowed by interpolate_env"},
+		{name: "server prefix", value: "${env:STOOKV_TEST_HOME}", wantErr: "environment variable STOOKV_TEST_HOME is not allowed by interpolate_env"},
+		{name: "cycle", value: "${a}", wantErr: "cyclic reference: app::prod::key -> app::prod::a -> app::prod::b -> app::prod::a"},
+		{name: "self reference", value: "${self}", wantErr: "cyclic reference: app::prod::key -> app::prod::self -> app::prod::self"},
+		{name: "unresolved", value: "${missing}", wantErr: "unresolved reference ${missing}"},
+		{name: "invalid", value: "${app:prod}", wantErr: "invalid reference ${app:prod}"},
+		{name: "unterminated", value: "x${host", wantErr: "unterminated reference at offset 1"},
+		{name: "lookup error", value: "${down:prod:key}", wantErr: "storage is unavailable"},
+		{name: "deep chain", values: chain(maxDepth, 1), value: "${k0}", want: "x", wantLookups: maxDepth},
+		{name: "too deep", values: chain(maxDepth+1, 1), value: "${k0}", wantErr: "reference depth exceeds 32"},
+		{name: "keys read once", values: map[string]string{"app::prod::a": "${b}${b}${b}", "app::prod::b": "1"}, value: "${a}${a}", want: "111111", wantLookups: 2},
+		{name: "doubling chain", values: chain(16, 2), value: "${k0}", want: strings.Repeat("x", 1<<15), wantLookups: 16},
+		{name: "doubling chain too long", values: chain(maxDepth, 2), value: "${k0}", wantErr: "resolved value exceeds 1048576 bytes"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lookup := &mapLookup{values: values}
+			if test.values != nil {
+				lookup.values = test.values
+			}
+			resolver := NewResolver(lookup.lookup, []string{"APP_HOME", "HOME_DIR", "STOOKV_TEST_HOME", "STOOKV_SERVER_PORT"})
+			got, err := resolver.Resolve("app", "prod", "key", test.value)
+			if test.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), test.wantErr) {
+					t.Fatalf("got %q, %v; want error %s", got, err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Resolve: %v", err)
+			}
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+			if test.wantLookups > 0 && lookup.lookups != test.wantLookups {
+				t.Errorf("got %d lookups, want %d", lookup.lookups, test.wantLookups)
+			}
+		})
+	}
+}
+
+func TestResolveLimitsLookups(t *testing.T) {
+	values := map[string]string{}
+	var refs strings.Builder
+	for i := 0; i <= maxLookups; i++ {
+		values[fmt.Sprintf("app::prod::k%d", i)] = "x"
+		fmt.Fprintf(&refs, "${k%d}", i)
+	}
+	lookup := &mapLookup{values: values}
+	_, err := NewResolver(lookup.lookup, nil).Resolve("app", "prod", "key", refs.String())
+	if err == nil || err.Error() != fmt.Sprintf("value references more than %d keys", maxLookups) {
+		t.Fatalf("got error %v, want the lookup limit", err)
+	}
+	if lookup.lookups != maxLookups {
+		t.Errorf("got %d lookups, want %d", lookup.lookups, maxLookups)
+	}
+}
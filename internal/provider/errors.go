@@ -0,0 +1,58 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrNotFound is returned by Get and Delete for a key that does not exist. A key holding an
+	// empty value exists.
+	ErrNotFound = errors.New("key not found")
+	// ErrConflict matches writes colliding with another write, such as a unique index violation.
+	ErrConflict = errors.New("conflicting write")
+	// ErrUnavailable matches errors of a storage that cannot be reached or did not answer in time.
+	ErrUnavailable = errors.New("storage is unavailable")
+	// ErrInvalid matches requests rejected for their content, such as malformed keys or cursors.
+	ErrInvalid = errors.New("invalid request")
+)
+
+// kindError makes errors.Is match one of the errors above while keeping the message and the
+// chain of the error it marks.
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e *kindError) Error() string { return e.err.Error() }
+
+func (e *kindError) Is(target error) bool { return target == e.kind }
+
+func (e *kindError) Unwrap() error { return e.err }
+
+// Mark returns err matching kind with errors.Is, e.g. Mark(err, ErrUnavailable).
+func Mark(err, kind error) error {
+	if err == nil || errors.Is(err, kind) {
+		return err
+	}
+	return &kindError{kind: kind, err: err}
+}
+
+// Invalidf formats an error matching ErrInvalid.
+func Invalidf(format string, args ...any) error {
+	return Mark(fmt.Errorf(format, args...), ErrInvalid)
+}
+
+// Classify marks the errors of a backend that failed for good: transient errors as ErrUnavailable
+// and unique index violations as ErrConflict.
+func Classify(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case IsTransient(err):
+		return Mark(err, ErrUnavailable)
+	case IsConflict(err):
+		return Mark(err, ErrConflict)
+	}
+	return err
+}
@@ -2,7 +2,6 @@ package provider
 
 import (
 	"context"
-	"fmt"
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"stoo-kv/config"
 	"strings"
@@ -15,11 +14,16 @@ type EtcdClient struct {
 }
 
 func NewEtcdClient(ctx context.Context, config *config.Config) (*EtcdClient, error) {
+	tlsConfig, err := newTLSConfig(config.Providers.Etcd.Tls)
+	if err != nil {
+		return nil, err
+	}
 	client, err := clientv3.New(clientv3.Config{
 		Endpoints:   config.Providers.Etcd.Endpoints,
 		DialTimeout: time.Duration(config.Providers.Etcd.DialTimeout) * time.Second,
 		Username:    config.Providers.Etcd.Username,
 		Password:    config.Providers.Etcd.Password,
+		TLS:         tlsConfig,
 	})
 	if err != nil {
 		return nil, err
@@ -27,8 +31,14 @@ func NewEtcdClient(ctx context.Context, config *config.Config) (*EtcdClient, err
 	return &EtcdClient{client: client, ctx: ctx}, nil
 
 }
+func (e *EtcdClient) WithContext(ctx context.Context) Store {
+	bound := *e
+	bound.ctx = ctx
+	return &bound
+}
+
 func (e *EtcdClient) Set(key string, value any) error {
-	_, err := e.client.Put(e.ctx, key, value.(string))
+	_, err := e.client.Put(e.ctx, key, toString(value))
 	return err
 }
 
@@ -40,12 +50,18 @@ func (e *EtcdClient) Get(key string) (string, error) {
 	for _, v := range resp.Kvs {
 		return string(v.Value), nil
 	}
-	return "", nil
+	return "", ErrNotFound
 }
 
 func (e *EtcdClient) Delete(key string) error {
-	_, err := e.client.Delete(e.ctx, key)
-	return err
+	response, err := e.client.Delete(e.ctx, key)
+	if err != nil {
+		return err
+	}
+	if response.Deleted == 0 {
+		return ErrNotFound
+	}
+	return nil
 }
 
 //func (e *EtcdClient) GetAll() (map[string]string, error) {
@@ -53,7 +69,74 @@ func (e *EtcdClient) Delete(key string) error {
 //}
 
 func (e *EtcdClient) GetByNameSpaceAndProfile(namespace, profile string) (map[string]string, error) {
-	return e.findAll(fmt.Sprintf("%s::%s", namespace, profile))
+	return e.findAll(keyPrefix(namespace, profile))
+}
+
+// List ranges over the keys of the namespace and profile in key order. Etcd has no server-side
+// pattern matching, so glob and regex filters are applied while paging through the range.
+func (e *EtcdClient) List(namespace, profile string, options ListOptions) (*Page, error) {
+	matcher, err := newMatcher(options)
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := decodeCursor(options.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := keyPrefix(namespace, profile)
+	from := prefix + options.Prefix
+	end := clientv3.GetPrefixRangeEnd(from)
+	order := clientv3.SortAscend
+	if options.Descending {
+		order = clientv3.SortDescend
+		if cursor != "" {
+			end = prefix + cursor
+		}
+	} else if cursor != "" && prefix+cursor >= from {
+		from = prefix + cursor + "\x00"
+	}
+
+	var items []KeyValue
+	for {
+		opts := []clientv3.OpOption{clientv3.WithRange(end), clientv3.WithSort(clientv3.SortByKey, order)}
+		if options.Limit > 0 {
+			opts = append(opts, clientv3.WithLimit(int64(options.Limit+1)))
+		}
+		result, err := e.client.Get(e.ctx, from, opts...)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range result.Kvs {
+			keyName := strings.TrimPrefix(string(v.Key), prefix)
+			if matcher.match(keyName) {
+				items = append(items, KeyValue{Key: keyName, Value: string(v.Value)})
+			}
+		}
+		if !result.More || len(result.Kvs) == 0 || (options.Limit > 0 && len(items) > options.Limit) {
+			break
+		}
+		last := string(result.Kvs[len(result.Kvs)-1].Key)
+		if options.Descending {
+			end = last
+		} else {
+			from = last + "\x00"
+		}
+	}
+	return paginate(items, options.Limit), nil
+}
+
+// Namespaces ranges over every key, reading the key names only.
+func (e *EtcdClient) Namespaces() (map[string][]string, error) {
+	result, err := e.client.Get(e.ctx, "", clientv3.WithFromKey(), clientv3.WithKeysOnly())
+	if err != nil {
+		return nil, err
+	}
+	index := namespaceIndex{}
+	for _, v := range result.Kvs {
+		index.addKey(string(v.Key))
+	}
+	return index.profiles(), nil
 }
 
 func (e *EtcdClient) findAll(prefix string) (map[string]string, error) {
@@ -63,12 +146,7 @@ func (e *EtcdClient) findAll(prefix string) (map[string]string, error) {
 		return nil, err
 	}
 	for _, v := range result.Kvs {
-		key := string(v.Key)
-		if strings.Contains(key, "::") {
-			keyValues[strings.Split(key, "::")[2]] = string(v.Value)
-			continue
-		}
-		keyValues[key] = string(v.Value)
+		keyValues[strings.TrimPrefix(string(v.Key), prefix)] = string(v.Value)
 	}
 	return keyValues, nil
 }
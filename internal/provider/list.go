@@ -0,0 +1,198 @@
+package provider
+
+import (
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ListOptions filters and pages the keys of a namespace and profile. Prefix, Glob and Regex
+// apply to the key name without its namespace and profile, and Cursor is the opaque
+// NextCursor of the previous page.
+type ListOptions struct {
+	Prefix     string
+	Glob       string
+	Regex      string
+	Limit      int
+	Cursor     string
+	Descending bool
+}
+
+type KeyValue struct {
+	Key   string
+	Value string
+}
+
+type Page struct {
+	Items      []KeyValue
+	NextCursor string
+}
+
+func keyPrefix(namespace, profile string) string {
+	return fmt.Sprintf("%s::%s::", namespace, profile)
+}
+
+// namespaceIndex collects the namespace and profile pairs holding keys.
+type namespaceIndex map[string]map[string]bool
+
+func (n namespaceIndex) add(namespace, profile string) {
+	if n[namespace] == nil {
+		n[namespace] = make(map[string]bool)
+	}
+	n[namespace][profile] = true
+}
+
+// addKey adds the namespace and profile of a namespace::profile::key.
+func (n namespaceIndex) addKey(key string) {
+	if parts := strings.SplitN(key, "::", 3); len(parts) == 3 {
+		n.add(parts[0], parts[1])
+	}
+}
+
+// profiles returns the sorted profiles of each namespace.
+func (n namespaceIndex) profiles() map[string][]string {
+	namespaces := make(map[string][]string, len(n))
+	for namespace, profiles := range n {
+		for profile := range profiles {
+			namespaces[namespace] = append(namespaces[namespace], profile)
+		}
+		sort.Strings(namespaces[namespace])
+	}
+	return namespaces
+}
+
+type matcher struct {
+	prefix string
+	glob   *regexp.Regexp
+	regex  *regexp.Regexp
+}
+
+func newMatcher(options ListOptions) (*matcher, error) {
+	m := &matcher{prefix: options.Prefix}
+	if options.Glob != "" {
+		m.glob = regexp.MustCompile("^" + globToRegex(options.Glob) + "$")
+	}
+	if options.Regex != "" {
+		regex, err := regexp.Compile(options.Regex)
+		if err != nil {
+			return nil, Invalidf("invalid regex: %v", err)
+		}
+		m.regex = regex
+	}
+	return m, nil
+}
+
+func (m *matcher) match(key string) bool {
+	return strings.HasPrefix(key, m.prefix) &&
+		(m.glob == nil || m.glob.MatchString(key)) &&
+		(m.regex == nil || m.regex.MatchString(key))
+}
+
+// after returns whether a key comes after the cursor in the requested order, failing for a cursor
+// that was not returned as NextCursor.
+func (o ListOptions) after() (func(key string) bool, error) {
+	cursor, err := decodeCursor(o.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	return func(key string) bool {
+		switch {
+		case cursor == "":
+			return true
+		case o.Descending:
+			return key < cursor
+		}
+		return key > cursor
+	}, nil
+}
+
+func sortItems(items []KeyValue, descending bool) {
+	sort.Slice(items, func(i, j int) bool {
+		if descending {
+			return items[i].Key > items[j].Key
+		}
+		return items[i].Key < items[j].Key
+	})
+}
+
+// paginate cuts sorted items to the page limit. Items may hold one extra entry, fetched only
+// to learn whether another page exists.
+func paginate(items []KeyValue, limit int) *Page {
+	if limit <= 0 || len(items) <= limit {
+		return &Page{Items: items}
+	}
+	items = items[:limit]
+	return &Page{Items: items, NextCursor: encodeCursor(items[limit-1].Key)}
+}
+
+func encodeCursor(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+func decodeCursor(cursor string) (string, error) {
+	key, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", Invalidf("invalid cursor: %v", err)
+	}
+	return string(key), nil
+}
+
+func globToRegex(glob string) string {
+	var b strings.Builder
+	for _, r := range glob {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	return b.String()
+}
+
+func globToLike(glob string) string {
+	var b strings.Builder
+	for _, r := range glob {
+		switch r {
+		case '*':
+			b.WriteString("%")
+		case '?':
+			b.WriteString("_")
+		case '%', '_', '\\':
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func escapeLike(value string) string {
+	var b strings.Builder
+	for _, r := range value {
+		switch r {
+		case '%', '_', '\\':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// escapeGlob quotes the characters that Redis MATCH patterns treat as special.
+func escapeGlob(value string) string {
+	var b strings.Builder
+	for _, r := range value {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,97 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"stoo-kv/config"
+	"testing"
+)
+
+// unreachableRedis returns a client of a server that refuses connections. Invalid list options
+// are rejected before the server is called.
+func unreachableRedis(t *testing.T, migrating bool) *RedisClient {
+	t.Helper()
+	cfg := &config.Config{Providers: &config.ProviderConfig{}}
+	cfg.Providers.Redis.Host = "127.0.0.1"
+	cfg.Providers.Redis.Port = "1"
+	cfg.Providers.Redis.StoreName = "stoo-kv"
+	r, err := NewRedisClient(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("NewRedisClient: %v", err)
+	}
+	t.Cleanup(func() { r.client.Close() })
+	r.migrating.Store(migrating)
+	return r
+}
+
+func TestListRejectsInvalidCursors(t *testing.T) {
+	memory := NewMemory()
+	memory.Set("app::prod::a", "1")
+	tests := []struct {
+		name   string
+		store  Store
+		cursor string
+	}{
+		{"memory, not base64", memory, "!"},
+		{"memory, padded base64", memory, "YQ=="},
+		{"redis, not base64", unreachableRedis(t, false), "!"},
+		{"redis, padded base64", unreachableRedis(t, false), "YQ=="},
+		{"redis migrating, not base64", unreachableRedis(t, true), "!"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := test.store.List("app", "prod", ListOptions{Limit: 1, Cursor: test.cursor})
+			if !errors.Is(err, ErrInvalid) {
+				t.Fatalf("List with cursor %q: got %v, want ErrInvalid", test.cursor, err)
+			}
+		})
+	}
+}
+
+func TestMemoryListPages(t *testing.T) {
+	memory := NewMemory()
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		memory.Set("app::prod::"+key, key)
+	}
+	memory.Set("app::prod-eu::z", "z")
+	tests := []struct {
+		name    string
+		options ListOptions
+		want    [][]string
+	}{
+		{"ascending", ListOptions{Limit: 2}, [][]string{{"a", "b"}, {"c", "d"}, {"e"}}},
+		{"descending", ListOptions{Limit: 2, Descending: true}, [][]string{{"e", "d"}, {"c", "b"}, {"a"}}},
+		{"exact pages", ListOptions{Limit: 5}, [][]string{{"a", "b", "c", "d", "e"}}},
+		{"unlimited", ListOptions{}, [][]string{{"a", "b", "c", "d", "e"}}},
+		{"glob", ListOptions{Limit: 3, Glob: "*"}, [][]string{{"a", "b", "c"}, {"d", "e"}}},
+		{"prefix", ListOptions{Limit: 1, Prefix: "c"}, [][]string{{"c"}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var pages [][]string
+			options := test.options
+			for {
+				page, err := memory.List("app", "prod", options)
+				if err != nil {
+					t.Fatalf("List: %v", err)
+				}
+				keys := []string{}
+				for _, item := range page.Items {
+					keys = append(keys, item.Key)
+				}
+				pages = append(pages, keys)
+				if page.NextCursor == "" {
+					break
+				}
+				if len(pages) > len(test.want) {
+					t.Fatalf("got more pages than %v: %v", test.want, pages)
+				}
+				options.Cursor = page.NextCursor
+			}
+			if !reflect.DeepEqual(pages, test.want) {
+				t.Errorf("got pages %v, want %v", pages, test.want)
+			}
+		})
+	}
+}
@@ -1,7 +1,6 @@
 package provider
 
 import (
-	"fmt"
 	"strings"
 	"sync"
 )
@@ -15,20 +14,22 @@ func NewMemory() *Memory {
 }
 
 func (m *Memory) Set(key string, value any) error {
-	m.kv.Store(key, value)
+	m.kv.Store(key, toString(value))
 	return nil
 }
 
 func (m *Memory) Get(key string) (string, error) {
 	value, ok := m.kv.Load(key)
 	if !ok {
-		return "", nil
+		return "", ErrNotFound
 	}
 	return value.(string), nil
 }
 
 func (m *Memory) Delete(key string) error {
-	m.kv.Delete(key)
+	if _, ok := m.kv.LoadAndDelete(key); !ok {
+		return ErrNotFound
+	}
 	return nil
 }
 
@@ -44,12 +45,42 @@ func (m *Memory) Delete(key string) error {
 
 func (m *Memory) GetByNameSpaceAndProfile(namespace, profile string) (map[string]string, error) {
 	keyValues := make(map[string]string)
+	prefix := keyPrefix(namespace, profile)
 	m.kv.Range(func(key, value any) bool {
-		if strings.HasPrefix(key.(string), fmt.Sprintf("%s::%s", namespace, profile)) {
-			//keyValues[strings.Split(key.(string), "::")[2]] = value.(string)
-			keyValues[key.(string)] = value.(string)
+		if keyName, ok := strings.CutPrefix(key.(string), prefix); ok {
+			keyValues[keyName] = value.(string)
 		}
 		return true
 	})
 	return keyValues, nil
 }
+
+func (m *Memory) Namespaces() (map[string][]string, error) {
+	index := namespaceIndex{}
+	m.kv.Range(func(key, _ any) bool {
+		index.addKey(key.(string))
+		return true
+	})
+	return index.profiles(), nil
+}
+
+func (m *Memory) List(namespace, profile string, options ListOptions) (*Page, error) {
+	matcher, err := newMatcher(options)
+	if err != nil {
+		return nil, err
+	}
+	after, err := options.after()
+	if err != nil {
+		return nil, err
+	}
+	var items []KeyValue
+	prefix := keyPrefix(namespace, profile)
+	m.kv.Range(func(key, value any) bool {
+		if keyName, ok := strings.CutPrefix(key.(string), prefix); ok && matcher.match(keyName) && after(keyName) {
+			items = append(items, KeyValue{Key: keyName, Value: value.(string)})
+		}
+		return true
+	})
+	sortItems(items, options.Descending)
+	return paginate(items, options.Limit), nil
+}
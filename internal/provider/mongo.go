@@ -7,14 +7,24 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"net/url"
+	"regexp"
+	"sort"
 	"stoo-kv/config"
 	"strings"
+	"time"
 )
 
+// mongoKv is a key of a namespace and profile, unique by the three of them.
 type mongoKv struct {
-	Key   string
-	Value string
+	Namespace string    `bson:"namespace"`
+	Profile   string    `bson:"profile"`
+	Key       string    `bson:"key"`
+	Value     string    `bson:"value"`
+	UpdatedAt time.Time `bson:"updatedAt"`
 }
+
 type MongoClient struct {
 	client     *mongo.Client
 	cfg        *config.Config
@@ -23,81 +33,282 @@ type MongoClient struct {
 }
 
 func NewMongoClient(ctx context.Context, config *config.Config) (*MongoClient, error) {
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(config.Providers.Mongo.MongoUri))
-	return &MongoClient{client: client,
+	mongoConfig := config.Providers.Mongo
+	uri, err := url.Parse(mongoConfig.MongoUri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mongo_uri: %v", err)
+	}
+	query := uri.Query()
+	for name, value := range mongoConfig.Params {
+		query.Set(name, value)
+	}
+	uri.RawQuery = query.Encode()
+	clientOptions := options.Client().ApplyURI(uri.String())
+	if mongoConfig.Username != "" {
+		clientOptions.SetAuth(options.Credential{
+			Username:   mongoConfig.Username,
+			Password:   mongoConfig.Password,
+			AuthSource: mongoConfig.AuthSource,
+		})
+	}
+	if mongoConfig.MaxPoolSize > 0 {
+		clientOptions.SetMaxPoolSize(mongoConfig.MaxPoolSize)
+	}
+	if mongoConfig.MinPoolSize > 0 {
+		clientOptions.SetMinPoolSize(mongoConfig.MinPoolSize)
+	}
+	tlsConfig, err := newTLSConfig(mongoConfig.Tls)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		clientOptions.SetTLSConfig(tlsConfig)
+	}
+	client, err := mongo.Connect(ctx, clientOptions)
+	if err != nil {
+		return nil, err
+	}
+	m := &MongoClient{client: client,
 		cfg:        config,
-		collection: client.Database(config.Providers.Mongo.DatabaseName).Collection(config.Providers.Mongo.CollectionName)}, err
+		ctx:        ctx,
+		collection: client.Database(config.Providers.Mongo.DatabaseName).Collection(config.Providers.Mongo.CollectionName)}
+	if err := m.migrateLegacyDocuments(); err != nil {
+		return nil, fmt.Errorf("failed to migrate mongo documents: %v", err)
+	}
+	if _, err := m.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "namespace", Value: 1}, {Key: "profile", Value: 1}, {Key: "key", Value: 1}},
+		Options: options.Index().SetName("namespace_profile_key").SetUnique(true),
+	}); err != nil {
+		return nil, fmt.Errorf("failed to create mongo index: %v", err)
+	}
+	return m, nil
+}
+
+// filter selects the document of a namespace::profile::key.
+func (m *MongoClient) filter(key string) (bson.D, error) {
+	parts := strings.SplitN(key, "::", 3)
+	if len(parts) != 3 {
+		return nil, Invalidf("invalid key %q, expected namespace::profile::key", key)
+	}
+	return bson.D{{Key: "namespace", Value: parts[0]}, {Key: "profile", Value: parts[1]}, {Key: "key", Value: parts[2]}}, nil
+}
+
+func (m *MongoClient) WithContext(ctx context.Context) Store {
+	bound := *m
+	bound.ctx = ctx
+	return &bound
 }
 
 func (m *MongoClient) Set(key string, value any) error {
-	filter := bson.D{{key, value}}
-	document := bson.D{{"$set", bson.D{{key, value}}}}
-	opts := options.Update().SetUpsert(true)
-	_, err := m.collection.UpdateOne(m.ctx, filter, document, opts)
+	filter, err := m.filter(key)
+	if err != nil {
+		return err
+	}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "value", Value: toString(value)}, {Key: "updatedAt", Value: time.Now().UTC()}}}}
+	_, err = m.collection.UpdateOne(m.ctx, filter, update, options.Update().SetUpsert(true))
 	return err
 }
 
 func (m *MongoClient) Get(key string) (string, error) {
+	filter, err := m.filter(key)
+	if err != nil {
+		return "", err
+	}
 	kv := &mongoKv{}
-	err := m.collection.FindOne(m.ctx, bson.D{{"key", key}}).Decode(kv)
+	err = m.collection.FindOne(m.ctx, filter, options.FindOne().SetProjection(bson.D{{Key: "value", Value: 1}})).Decode(kv)
 	if errors.Is(err, mongo.ErrNoDocuments) {
-		return "", nil
+		return "", ErrNotFound
 	}
 	return kv.Value, err
 }
 
 func (m *MongoClient) Delete(key string) error {
-	_, err := m.collection.DeleteMany(m.ctx, bson.D{{"key", key}})
-	return err
+	filter, err := m.filter(key)
+	if err != nil {
+		return err
+	}
+	result, err := m.collection.DeleteOne(m.ctx, filter)
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
 }
 
-//func (m *MongoClient) GetAll() (map[string]string, error) {
-//	keyValues := make(map[string]string)
-//	results, err := m.findAll()
-//	if err != nil {
-//		return validateError(err)
-//	}
-//
-//	for k, v := range results {
-//		keyValues[strings.Split(k, "::")[2]] = v
-//	}
-//	return keyValues, nil
-//}
-
 func (m *MongoClient) GetByNameSpaceAndProfile(namespace, profile string) (map[string]string, error) {
-	keyValues := make(map[string]string)
-	results, err := m.findAll()
+	filter := bson.D{{Key: "namespace", Value: namespace}, {Key: "profile", Value: profile}}
+	projection := bson.D{{Key: "key", Value: 1}, {Key: "value", Value: 1}}
+	cursor, err := m.collection.Find(m.ctx, filter, options.Find().SetProjection(projection))
 	if err != nil {
-		return validateError(err)
+		return nil, err
 	}
-
-	for k, v := range results {
-		if strings.HasPrefix(k, fmt.Sprintf("%s::%s", namespace, profile)) {
-			keyValues[strings.Split(k, "::")[2]] = v
-		}
+	var results []mongoKv
+	if err := cursor.All(m.ctx, &results); err != nil {
+		return nil, err
+	}
+	keyValues := make(map[string]string, len(results))
+	for _, result := range results {
+		keyValues[result.Key] = result.Value
 	}
 	return keyValues, nil
 }
 
-func (m *MongoClient) findAll() (map[string]string, error) {
-	keyValues := make(map[string]string)
-	cursor, err := m.collection.Find(m.ctx, bson.D{})
+func (m *MongoClient) Namespaces() (map[string][]string, error) {
+	cursor, err := m.collection.Aggregate(m.ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.D{{Key: "_id", Value: bson.D{{Key: "namespace", Value: "$namespace"}, {Key: "profile", Value: "$profile"}}}}}},
+	})
 	if err != nil {
 		return nil, err
 	}
-	var results []bson.M
+	var groups []struct {
+		ID mongoKv `bson:"_id"`
+	}
+	if err := cursor.All(m.ctx, &groups); err != nil {
+		return nil, err
+	}
+	index := namespaceIndex{}
+	for _, group := range groups {
+		index.add(group.ID.Namespace, group.ID.Profile)
+	}
+	return index.profiles(), nil
+}
+
+func (m *MongoClient) List(namespace, profile string, listOptions ListOptions) (*Page, error) {
+	filters := bson.A{
+		bson.D{{Key: "namespace", Value: namespace}},
+		bson.D{{Key: "profile", Value: profile}},
+	}
+	if listOptions.Prefix != "" {
+		filters = append(filters, bson.D{{Key: "key", Value: bson.D{{Key: "$regex", Value: "^" + regexp.QuoteMeta(listOptions.Prefix)}}}})
+	}
+	if listOptions.Glob != "" {
+		filters = append(filters, bson.D{{Key: "key", Value: bson.D{{Key: "$regex", Value: "^" + globToRegex(listOptions.Glob) + "$"}}}})
+	}
+	if listOptions.Regex != "" {
+		if _, err := regexp.Compile(listOptions.Regex); err != nil {
+			return nil, Invalidf("invalid regex: %v", err)
+		}
+		filters = append(filters, bson.D{{Key: "key", Value: bson.D{{Key: "$regex", Value: listOptions.Regex}}}})
+	}
+
+	order := 1
+	operator := "$gt"
+	if listOptions.Descending {
+		order, operator = -1, "$lt"
+	}
+	if listOptions.Cursor != "" {
+		cursor, err := decodeCursor(listOptions.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, bson.D{{Key: "key", Value: bson.D{{Key: operator, Value: cursor}}}})
+	}
+
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "key", Value: order}}).
+		SetProjection(bson.D{{Key: "key", Value: 1}, {Key: "value", Value: 1}})
+	if listOptions.Limit > 0 {
+		findOptions.SetLimit(int64(listOptions.Limit + 1))
+	}
+	cursor, err := m.collection.Find(m.ctx, bson.D{{Key: "$and", Value: filters}}, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	var results []mongoKv
 	if err := cursor.All(m.ctx, &results); err != nil {
 		return nil, err
 	}
-	for _, v := range results {
-		keyValues[v["key"].(string)] = v["value"].(string)
+	items := make([]KeyValue, 0, len(results))
+	for _, result := range results {
+		items = append(items, KeyValue{Key: result.Key, Value: result.Value})
 	}
-	return keyValues, nil
+	return paginate(items, listOptions.Limit), nil
 }
 
-func validateError(err error) (map[string]string, error) {
-	if errors.Is(err, mongo.ErrNoDocuments) {
-		return map[string]string{}, nil
+// migrateLegacyDocuments converts the documents of earlier releases, which lack a namespace. Keys
+// written in the new shape are kept over their old value. A document is only removed once every
+// key of it was converted; the others are logged and kept.
+func (m *MongoClient) migrateLegacyDocuments() error {
+	cursor, err := m.collection.Find(m.ctx, bson.D{{Key: "namespace", Value: bson.D{{Key: "$exists", Value: false}}}})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(m.ctx)
+	keys, documents, kept := 0, 0, 0
+	for cursor.Next(m.ctx) {
+		var document bson.M
+		if err := cursor.Decode(&document); err != nil {
+			return err
+		}
+		converted, complete := convertLegacyDocument(document)
+		for _, kv := range converted {
+			filter := bson.D{{Key: "namespace", Value: kv.Namespace}, {Key: "profile", Value: kv.Profile}, {Key: "key", Value: kv.Key}}
+			update := bson.D{{Key: "$setOnInsert", Value: bson.D{{Key: "value", Value: kv.Value}, {Key: "updatedAt", Value: time.Now().UTC()}}}}
+			if _, err := m.collection.UpdateOne(m.ctx, filter, update, options.Update().SetUpsert(true)); err != nil {
+				return err
+			}
+			keys++
+		}
+		if !complete {
+			log.Printf("Keeping mongo document %v, it holds fields that are not namespace::profile::key text values", document["_id"])
+			kept++
+			continue
+		}
+		if _, err := m.collection.DeleteOne(m.ctx, bson.D{{Key: "_id", Value: document["_id"]}}); err != nil {
+			return err
+		}
+		documents++
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	if keys > 0 {
+		log.Printf("Migrated %d keys of legacy mongo documents to the namespace, profile and key model, removing %d documents", keys, documents)
+	}
+	if kept > 0 {
+		log.Printf("Kept %d legacy mongo documents that could not be migrated whole", kept)
+	}
+	return nil
+}
+
+// convertLegacyDocument returns the keys of a legacy document, either {key: "namespace::profile::key",
+// value} or {"namespace::profile::key": value}, sorted by key. complete is false when the document
+// holds no key or fields that are not namespace::profile::key text values.
+func convertLegacyDocument(document bson.M) (converted []mongoKv, complete bool) {
+	complete = true
+	values := make(map[string]string)
+	if key, ok := document["key"].(string); ok {
+		if value, ok := document["value"].(string); ok {
+			values[key] = value
+		} else {
+			complete = false
+		}
+	} else {
+		for key, value := range document {
+			if key == "_id" {
+				continue
+			}
+			if text, ok := value.(string); ok {
+				values[key] = text
+			} else {
+				complete = false
+			}
+		}
+	}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		namespace, profile, keyName, err := splitKey(key)
+		if err != nil {
+			complete = false
+			continue
+		}
+		converted = append(converted, mongoKv{Namespace: namespace, Profile: profile, Key: keyName, Value: values[key]})
 	}
-	return nil, err
+	return converted, complete && len(converted) > 0
 }
@@ -0,0 +1,63 @@
+package provider
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"reflect"
+	"testing"
+)
+
+func TestConvertLegacyDocument(t *testing.T) {
+	tests := []struct {
+		name         string
+		document     bson.M
+		want         []mongoKv
+		wantComplete bool
+	}{
+		{
+			name:         "key and value fields",
+			document:     bson.M{"_id": 1, "key": "app::prod::db::url", "value": "postgres://db"},
+			want:         []mongoKv{{Namespace: "app", Profile: "prod", Key: "db::url", Value: "postgres://db"}},
+			wantComplete: true,
+		},
+		{
+			name:     "keys as field names",
+			document: bson.M{"_id": 1, "app::prod::b": "2", "app::prod::a": "1", "app::dev::a": ""},
+			want: []mongoKv{
+				{Namespace: "app", Profile: "dev", Key: "a", Value: ""},
+				{Namespace: "app", Profile: "prod", Key: "a", Value: "1"},
+				{Namespace: "app", Profile: "prod", Key: "b", Value: "2"},
+			},
+			wantComplete: true,
+		},
+		{
+			name:     "value that is not text",
+			document: bson.M{"_id": 1, "key": "app::prod::a", "value": 1},
+		},
+		{
+			name:     "field that is not a key",
+			document: bson.M{"_id": 1, "app::prod::a": "1", "owner": "ops"},
+			want:     []mongoKv{{Namespace: "app", Profile: "prod", Key: "a", Value: "1"}},
+		},
+		{
+			name:     "field that is not text",
+			document: bson.M{"_id": 1, "app::prod::a": "1", "app::prod::b": bson.M{"nested": "2"}},
+			want:     []mongoKv{{Namespace: "app", Profile: "prod", Key: "a", Value: "1"}},
+		},
+		{
+			name:     "key without a profile",
+			document: bson.M{"_id": 1, "key": "app::a", "value": "1"},
+		},
+		{
+			name:     "document without keys",
+			document: bson.M{"_id": 1},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, complete := convertLegacyDocument(test.document)
+			if !reflect.DeepEqual(got, test.want) || complete != test.wantComplete {
+				t.Errorf("got %v, complete %v, want %v, complete %v", got, complete, test.want, test.wantComplete)
+			}
+		})
+	}
+}
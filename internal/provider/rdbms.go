@@ -1,11 +1,22 @@
 package provider
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/mattn/go-sqlite3"
 	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"net/url"
+	"regexp"
 	"stoo-kv/config"
 	"strings"
+	"time"
+	"unicode/utf8"
 )
 
 type Rdbms struct {
@@ -13,107 +24,243 @@ type Rdbms struct {
 	cfg *config.Config
 }
 type kv struct {
-	Namespace string `gorm:"column:namespace"`
-	Profile   string `gorm:"column:profile"`
-	Key       string `gorm:"column:key"`
-	Value     string `gorm:"column:value"`
+	Namespace string    `gorm:"column:namespace"`
+	Profile   string    `gorm:"column:profile"`
+	Key       string    `gorm:"column:key"`
+	Value     string    `gorm:"column:value"`
+	CreatedAt time.Time `gorm:"column:created_at"`
+	UpdatedAt time.Time `gorm:"column:updated_at"`
+	Version   int64     `gorm:"column:version"`
 }
 
-func NewMySql(config *config.Config) (*Rdbms, error) {
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=Local",
-		config.Providers.Mysql.Username,
-		config.Providers.Mysql.Password,
-		config.Providers.Mysql.Host,
-		config.Providers.Mysql.Port,
-		config.Providers.Mysql.DatabaseName,
-	)
-	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{})
+// sqliteDriver is go-sqlite3 with a REGEXP function and case-sensitive LIKE, matching keys like
+// Postgres and MySQL do.
+const sqliteDriver = "sqlite3_stookv"
+
+func init() {
+	sql.Register(sqliteDriver, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			if _, err := conn.Exec("PRAGMA case_sensitive_like = ON", nil); err != nil {
+				return err
+			}
+			return conn.RegisterFunc("regexp", regexp.MatchString, true)
+		},
+	})
+}
+
+// mysqlTLSConfig is the name the TLS configuration of MySQL is registered with.
+const mysqlTLSConfig = "stookv"
+
+// NewRdbms opens the database of the mysql, postgres or sqlite storage type and applies the pending
+// migrations of its key table, or with rdbms_manual_migrations fails when any are pending.
+func NewRdbms(config *config.Config, storageType string) (*Rdbms, error) {
+	r, err := OpenRdbms(config, storageType)
 	if err != nil {
 		return nil, err
 	}
-
-	if err := db.Table(config.Application.RdbmsDefaultTable).AutoMigrate(&kv{}); err != nil {
+	if !config.Application.RdbmsManualMigrations {
+		if _, err := r.MigrateUp(0); err != nil {
+			return nil, err
+		}
+		return r, nil
+	}
+	pending, err := r.pendingMigrations()
+	if err != nil {
 		return nil, err
 	}
-	return &Rdbms{
-		db:  db,
-		cfg: config}, nil
+	if pending > 0 {
+		return nil, fmt.Errorf("table %s of %s has %d pending migrations, run stoo-kv migrate up", config.Application.RdbmsDefaultTable, storageType, pending)
+	}
+	return r, nil
 }
 
-func NewPostgres(config *config.Config) (*Rdbms, error) {
-	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=%s TimeZone=%s",
-		config.Providers.Postgres.Host,
-		config.Providers.Postgres.Username,
-		config.Providers.Postgres.Password,
-		config.Providers.Postgres.DatabaseName,
-		config.Providers.Postgres.Port,
-		config.Providers.Postgres.SslMode,
-		config.Providers.Postgres.TimeZone,
-	)
-	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{})
+// OpenRdbms opens the database of the storage type without migrating it.
+func OpenRdbms(config *config.Config, storageType string) (*Rdbms, error) {
+	var dialector gorm.Dialector
+	var err error
+	switch storageType {
+	case "mysql":
+		dialector, err = mysqlDialector(config)
+	case "postgres":
+		dialector = postgresDialector(config)
+	case "sqlite":
+		dialector = sqliteDialector(config)
+	default:
+		return nil, fmt.Errorf("storage type %q is not a SQL database", storageType)
+	}
 	if err != nil {
 		return nil, err
 	}
-
-	if err := db.Table(config.Application.RdbmsDefaultTable).AutoMigrate(&kv{}); err != nil {
+	db, err := gorm.Open(dialector, &gorm.Config{})
+	if err != nil {
 		return nil, err
 	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	switch storageType {
+	case "mysql":
+		setPool(sqlDB, config.Providers.Mysql.Pool)
+	case "postgres":
+		setPool(sqlDB, config.Providers.Postgres.Pool)
+	case "sqlite":
+		// SQLite has a single writer, and every connection to ":memory:" opens a database of its own.
+		sqlDB.SetMaxOpenConns(1)
+	}
 	return &Rdbms{db: db,
 		cfg: config}, nil
 }
 
+// setPool applies the pool settings that are given; durations are checked by the configuration.
+func setPool(db *sql.DB, pool config.PoolConfig) {
+	if pool.MaxOpenConnections > 0 {
+		db.SetMaxOpenConns(pool.MaxOpenConnections)
+	}
+	if pool.MaxIdleConnections > 0 {
+		db.SetMaxIdleConns(pool.MaxIdleConnections)
+	}
+	if lifetime, err := time.ParseDuration(pool.ConnectionMaxLifetime); err == nil {
+		db.SetConnMaxLifetime(lifetime)
+	}
+	if idleTime, err := time.ParseDuration(pool.ConnectionMaxIdleTime); err == nil {
+		db.SetConnMaxIdleTime(idleTime)
+	}
+}
+
+func mysqlDialector(config *config.Config) (gorm.Dialector, error) {
+	defaults := map[string]string{"charset": "utf8mb4", "parseTime": "True", "loc": "Local"}
+	tlsConfig, err := newTLSConfig(config.Providers.Mysql.Tls)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		if err := mysqldriver.RegisterTLSConfig(mysqlTLSConfig, tlsConfig); err != nil {
+			return nil, err
+		}
+		defaults["tls"] = mysqlTLSConfig
+	}
+	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?%s",
+		config.Providers.Mysql.Username,
+		config.Providers.Mysql.Password,
+		config.Providers.Mysql.Host,
+		config.Providers.Mysql.Port,
+		config.Providers.Mysql.DatabaseName,
+		joinParams(defaults, config.Providers.Mysql.Params, func(name, value string) string {
+			return name + "=" + url.QueryEscape(value)
+		}, "&"),
+	)
+	return mysql.Open(dsn), nil
+}
+
+func postgresDialector(config *config.Config) gorm.Dialector {
+	postgresConfig := config.Providers.Postgres
+	settings := make(map[string]string)
+	for name, value := range map[string]string{
+		"host":        postgresConfig.Host,
+		"user":        postgresConfig.Username,
+		"password":    postgresConfig.Password,
+		"dbname":      postgresConfig.DatabaseName,
+		"port":        postgresConfig.Port,
+		"sslmode":     postgresConfig.SslMode,
+		"TimeZone":    postgresConfig.TimeZone,
+		"sslrootcert": postgresConfig.Tls.CaFile,
+		"sslcert":     postgresConfig.Tls.CertFile,
+		"sslkey":      postgresConfig.Tls.KeyFile,
+	} {
+		if value != "" {
+			settings[name] = value
+		}
+	}
+	dsn := joinParams(settings, postgresConfig.Params, func(name, value string) string {
+		return name + "=" + quoteDSNValue(value)
+	}, " ")
+	return postgres.Open(dsn)
+}
+
+// sqliteDialector opens the SQLite database file at sqlite.path, ":memory:" keeping the keys in memory.
+func sqliteDialector(config *config.Config) gorm.Dialector {
+	dsn := config.Providers.Sqlite.Path + "?" + joinParams(map[string]string{"_busy_timeout": "5000"}, config.Providers.Sqlite.Params,
+		func(name, value string) string {
+			return name + "=" + url.QueryEscape(value)
+		}, "&")
+	return sqlite.Dialector{DriverName: sqliteDriver, DSN: dsn}
+}
+
+// quoteDSNValue quotes a value of a key=value connection string when it is empty or holds spaces,
+// quotes or backslashes.
+func quoteDSNValue(value string) string {
+	if value != "" && !strings.ContainsAny(value, ` '\`) {
+		return value
+	}
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(value) + "'"
+}
+
+func (r *Rdbms) WithContext(ctx context.Context) Store {
+	return &Rdbms{db: r.db.WithContext(ctx), cfg: r.cfg}
+}
+
 func (r *Rdbms) Set(key string, value any) error {
-	namespace, profile, keyName := splitKey(key)
-	data := map[string]any{
-		"namespace": namespace,
-		"profile":   profile,
-		"key":       keyName,
-		"value":     value,
+	namespace, profile, keyName, err := splitKey(key)
+	if err != nil {
+		return err
 	}
-	return r.db.Table(r.cfg.Application.RdbmsDefaultTable).
-		Assign(data).
-		Where("`namespace` = ? and `profile` = ? and `key` = ?", namespace, profile, keyName).
-		FirstOrCreate(&kv{}).Error //Try to ensure no duplicate keys under same namespace and profile
+	if err := checkColumnSizes(namespace, profile, keyName); err != nil {
+		return err
+	}
+	table := r.cfg.Application.RdbmsDefaultTable
+	now := time.Now()
+	return r.db.Table(table).
+		Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "namespace"}, {Name: "profile"}, {Name: "key"}},
+			DoUpdates: clause.Assignments(map[string]any{
+				"value":      toString(value),
+				"updated_at": now,
+				"version":    gorm.Expr("? + 1", clause.Column{Table: table, Name: "version"}),
+			}),
+		}).
+		Create(&kv{Namespace: namespace, Profile: profile, Key: keyName, Value: toString(value), CreatedAt: now, UpdatedAt: now, Version: 1}).Error
 }
 
 func (r *Rdbms) Get(key string) (string, error) {
-	namespace, profile, keyName := splitKey(key)
+	condition, err := r.keyCondition(key)
+	if err != nil {
+		return "", err
+	}
 	keyValue := &kv{}
-	err := r.db.
+	result := r.db.
 		Limit(1).
 		Table(r.cfg.Application.RdbmsDefaultTable).
-		Where("`namespace` = ? AND `profile` = ? AND `key` = ?", namespace, profile, keyName).
-		Find(keyValue).Error
-	return keyValue.Value, err
+		Where(condition).
+		Find(keyValue)
+	if result.Error == nil && result.RowsAffected == 0 {
+		return "", ErrNotFound
+	}
+	return keyValue.Value, result.Error
 }
 
 func (r *Rdbms) Delete(key string) error {
-	namespace, profile, keyName := splitKey(key)
-	return r.db.
+	condition, err := r.keyCondition(key)
+	if err != nil {
+		return err
+	}
+	result := r.db.
 		Table(r.cfg.Application.RdbmsDefaultTable).
-		Where("`namespace` = ? AND `profile` = ? AND `key` = ?", namespace, profile, keyName).
-		Delete(&kv{}).Error
-}
-
-//func (r *Rdbms) GetAll() (map[string]string, error) {
-//	kvMap := make(map[string]string)
-//	var keyValues []kv
-//	if err := r.db.Table(r.cfg.Application.RdbmsDefaultTable).Select("`key`", "value").Find(&keyValues).Error; err != nil {
-//		return nil, err
-//	}
-//	for _, entry := range keyValues {
-//		kvMap[entry.Key] = entry.Value
-//	}
-//	return kvMap, nil
-//}
+		Where(condition).
+		Delete(&kv{})
+	if result.Error == nil && result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return result.Error
+}
 
 func (r *Rdbms) GetByNameSpaceAndProfile(namespace, profile string) (map[string]string, error) {
 	kvMap := make(map[string]string)
 	var keyValues []kv
 	if err := r.db.
 		Table(r.cfg.Application.RdbmsDefaultTable).
-		Where("namespace = ? AND profile = ?", namespace, profile).
-		Select("`key`", "value").
+		Where(column("namespace", namespace), column("profile", profile)).
+		Select("key", "value").
 		Find(&keyValues).Error; err != nil {
 		return nil, err
 	}
@@ -123,7 +270,117 @@ func (r *Rdbms) GetByNameSpaceAndProfile(namespace, profile string) (map[string]
 	return kvMap, nil
 }
 
-func splitKey(key string) (string, string, string) {
-	keys := strings.Split(key, "::")
-	return keys[0], keys[1], keys[2]
+func (r *Rdbms) Namespaces() (map[string][]string, error) {
+	var pairs []kv
+	if err := r.db.
+		Table(r.cfg.Application.RdbmsDefaultTable).
+		Distinct("namespace", "profile").
+		Find(&pairs).Error; err != nil {
+		return nil, err
+	}
+	index := namespaceIndex{}
+	for _, pair := range pairs {
+		index.add(pair.Namespace, pair.Profile)
+	}
+	return index.profiles(), nil
+}
+
+func (r *Rdbms) List(namespace, profile string, options ListOptions) (*Page, error) {
+	query := r.db.
+		Table(r.cfg.Application.RdbmsDefaultTable).
+		Where(column("namespace", namespace), column("profile", profile))
+	if options.Prefix != "" {
+		query = query.Where(r.like(escapeLike(options.Prefix) + "%"))
+	}
+	if options.Glob != "" {
+		query = query.Where(r.like(globToLike(options.Glob)))
+	}
+	if options.Regex != "" {
+		if _, err := regexp.Compile(options.Regex); err != nil {
+			return nil, Invalidf("invalid regex: %v", err)
+		}
+		query = query.Where(clause.Expr{SQL: "? " + r.regexOperator() + " ?", Vars: []any{clause.Column{Name: "key"}, options.Regex}})
+	}
+
+	if options.Cursor != "" {
+		cursor, err := decodeCursor(options.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		if options.Descending {
+			query = query.Where(clause.Lt{Column: clause.Column{Name: "key"}, Value: cursor})
+		} else {
+			query = query.Where(clause.Gt{Column: clause.Column{Name: "key"}, Value: cursor})
+		}
+	}
+	if options.Limit > 0 {
+		query = query.Limit(options.Limit + 1)
+	}
+
+	var keyValues []kv
+	order := clause.OrderByColumn{Column: clause.Column{Name: "key"}, Desc: options.Descending}
+	if err := query.Select("key", "value").Order(order).Find(&keyValues).Error; err != nil {
+		return nil, err
+	}
+	items := make([]KeyValue, 0, len(keyValues))
+	for _, entry := range keyValues {
+		items = append(items, KeyValue{Key: entry.Key, Value: entry.Value})
+	}
+	return paginate(items, options.Limit), nil
+}
+
+// keyCondition matches the row of a namespace::profile::key. Columns are quoted by the dialect,
+// since MySQL quotes with backticks and Postgres and SQLite with double quotes.
+func (r *Rdbms) keyCondition(key string) (clause.Expression, error) {
+	namespace, profile, keyName, err := splitKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return clause.And(column("namespace", namespace), column("profile", profile), column("key", keyName)), nil
+}
+
+func column(name string, value any) clause.Eq {
+	return clause.Eq{Column: clause.Column{Name: name}, Value: value}
+}
+
+// like matches the key against a LIKE pattern escaped with backslashes, which SQLite only
+// supports with an ESCAPE clause. MySQL compares binary strings to match case like the others.
+func (r *Rdbms) like(pattern string) clause.Expression {
+	switch r.db.Dialector.Name() {
+	case "sqlite":
+		return clause.Expr{SQL: `? LIKE ? ESCAPE '\'`, Vars: []any{clause.Column{Name: "key"}, pattern}}
+	case "mysql":
+		return clause.Expr{SQL: "? LIKE BINARY ?", Vars: []any{clause.Column{Name: "key"}, pattern}}
+	}
+	return clause.Like{Column: clause.Column{Name: "key"}, Value: pattern}
+}
+
+// regexOperator matches case-sensitively on every database; MySQL ignores case unless the
+// pattern is a binary string.
+func (r *Rdbms) regexOperator() string {
+	switch r.db.Dialector.Name() {
+	case "postgres":
+		return "~"
+	case "mysql":
+		return "REGEXP BINARY"
+	}
+	return "REGEXP"
+}
+
+// checkColumnSizes rejects a namespace, profile or key longer than the columns of a created table.
+func checkColumnSizes(namespace, profile, key string) error {
+	for _, part := range []struct{ name, value string }{{"namespace", namespace}, {"profile", profile}, {"key", key}} {
+		if utf8.RuneCountInString(part.value) > keyColumnSize {
+			return Invalidf("%s is longer than %d characters", part.name, keyColumnSize)
+		}
+	}
+	return nil
+}
+
+func splitKey(key string) (string, string, string, error) {
+	keys := strings.SplitN(key, "::", 3)
+	if len(keys) != 3 {
+		return "", "", "", Invalidf("invalid key %q, expected namespace::profile::key", key)
+	}
+	return keys[0], keys[1], keys[2], nil
 }
@@ -0,0 +1,360 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"log"
+	"time"
+)
+
+// migration is a numbered change of the schema of the key table. Each runs in a transaction, though
+// MySQL commits its DDL statements right away.
+type migration struct {
+	version int
+	name    string
+	up      func(tx *gorm.DB, table string) error
+	down    func(tx *gorm.DB, table string) error
+	// destructive migrations lose the stored keys when reverted, so they are only reverted by force.
+	destructive bool
+}
+
+// ErrDestructiveMigration is returned when reverting a migration would drop the stored keys and
+// was not forced.
+var ErrDestructiveMigration = errors.New("reverting it drops the stored keys")
+
+// MigrationStatus is a migration of the key table and when it was applied, nil while pending.
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+// schemaMigration is a row of the migrations table of a key table, "{table}_schema_migrations".
+type schemaMigration struct {
+	Version   int       `gorm:"column:version;primaryKey;autoIncrement:false"`
+	Name      string    `gorm:"column:name;size:255"`
+	AppliedAt time.Time `gorm:"column:applied_at"`
+}
+
+// The models below are the schema as of a migration, so later changes of kv do not alter them.
+type kvV1 struct {
+	Namespace string `gorm:"column:namespace;size:255"`
+	Profile   string `gorm:"column:profile;size:255"`
+	Key       string `gorm:"column:key;size:255"`
+	Value     string `gorm:"column:value"`
+}
+
+type kvV2 struct {
+	CreatedAt time.Time `gorm:"column:created_at"`
+	UpdatedAt time.Time `gorm:"column:updated_at"`
+	Version   int64     `gorm:"column:version;not null;default:1"`
+}
+
+// legacyIndex is the unique index kv created before the migrations, named the same for every table.
+const legacyIndex = "idx_namespace_profile_key"
+
+// keyColumnSize is the length of the namespace, profile and key columns of a created table.
+const keyColumnSize = 255
+
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "create key table",
+		up: func(tx *gorm.DB, table string) error {
+			// Tables created before the migrations keep their columns, apart from the key columns
+			// of MySQL; the unique index is brought to the same name.
+			if !tx.Migrator().HasTable(table) {
+				if err := tx.Table(table).Migrator().CreateTable(&kvV1{}); err != nil {
+					return err
+				}
+			}
+			if err := indexableKeyColumns(tx, table); err != nil {
+				return err
+			}
+			if tx.Migrator().HasIndex(table, legacyIndex) {
+				if err := tx.Migrator().DropIndex(table, legacyIndex); err != nil {
+					return err
+				}
+			}
+			if err := removeDuplicates(tx, table); err != nil {
+				return err
+			}
+			return tx.Exec("CREATE UNIQUE INDEX ? ON ? (?, ?, ?)", clause.Column{Name: uniqueIndex(table)}, clause.Table{Name: table},
+				clause.Column{Name: "namespace"}, clause.Column{Name: "profile"}, clause.Column{Name: "key"}).Error
+		},
+		down: func(tx *gorm.DB, table string) error {
+			return tx.Migrator().DropTable(table)
+		},
+		destructive: true,
+	},
+	{
+		version: 2,
+		name:    "timestamps and versions",
+		up: func(tx *gorm.DB, table string) error {
+			for _, field := range []string{"CreatedAt", "UpdatedAt", "Version"} {
+				if err := tx.Table(table).Migrator().AddColumn(&kvV2{}, field); err != nil {
+					return err
+				}
+			}
+			now := time.Now()
+			return tx.Table(table).
+				Where(clause.Eq{Column: clause.Column{Name: "created_at"}, Value: nil}).
+				Updates(map[string]any{"created_at": now, "updated_at": now}).Error
+		},
+		down: func(tx *gorm.DB, table string) error {
+			// gorm drops SQLite columns by copying the table, which loses its index; SQLite 3.35
+			// drops them in place, as MySQL and Postgres do.
+			for _, name := range []string{"version", "updated_at", "created_at"} {
+				if err := tx.Exec("ALTER TABLE ? DROP COLUMN ?", clause.Table{Name: table}, clause.Column{Name: name}).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+}
+
+// indexableKeyColumns makes the namespace, profile and key columns of a MySQL table varchar(255)
+// with a binary collation, so that they can be indexed and keys differing in case, such as Foo and
+// foo, stay apart as they do on other databases. Tables created before the migrations have longtext
+// columns; it refuses to convert them when a stored value is longer, rather than truncating it.
+// Other databases index text columns as they are and compare them case-sensitively.
+func indexableKeyColumns(tx *gorm.DB, table string) error {
+	if tx.Dialector.Name() != "mysql" {
+		return nil
+	}
+	for _, name := range []string{"namespace", "profile", "key"} {
+		var longest int64
+		if err := tx.Table(table).Select("COALESCE(MAX(CHAR_LENGTH(?)), 0)", clause.Column{Name: name}).Scan(&longest).Error; err != nil {
+			return err
+		}
+		if longest > keyColumnSize {
+			return fmt.Errorf("column %s holds values of %d characters, longer than the %d of the unique index", name, longest, keyColumnSize)
+		}
+		if err := tx.Exec(fmt.Sprintf("ALTER TABLE ? MODIFY ? VARCHAR(%d) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin", keyColumnSize),
+			clause.Table{Name: table}, clause.Column{Name: name}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func uniqueIndex(table string) string {
+	return "idx_" + table + "_namespace_profile_key"
+}
+
+func migrationsTable(table string) string {
+	return table + "_schema_migrations"
+}
+
+// removeDuplicates keeps a single row of each namespace, profile and key, which earlier releases
+// could write twice. The most recently written row is kept; when the table cannot tell which one
+// that is, the rows must hold the same value.
+func removeDuplicates(tx *gorm.DB, table string) error {
+	newest := newestFirst(tx, table)
+	var duplicates []kvV1
+	if err := tx.Table(table).
+		Select("namespace", "profile", "key").
+		Group("namespace").Group("profile").Group("key").
+		Having("COUNT(*) > 1").
+		Find(&duplicates).Error; err != nil {
+		return err
+	}
+	for _, duplicate := range duplicates {
+		where := clause.And(column("namespace", duplicate.Namespace), column("profile", duplicate.Profile), column("key", duplicate.Key))
+		var rows []kvV1
+		query := tx.Table(table).Where(where)
+		if newest != nil {
+			query = query.Order(*newest).Limit(1)
+		}
+		if err := query.Find(&rows).Error; err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			continue
+		}
+		kept := &rows[0]
+		for _, row := range rows[1:] {
+			if row.Value != kept.Value {
+				return fmt.Errorf("key %s::%s::%s is stored %d times with different values; keep one of the rows and migrate again",
+					duplicate.Namespace, duplicate.Profile, duplicate.Key, len(rows))
+			}
+		}
+		if err := tx.Table(table).Where(where).Delete(&kvV1{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Table(table).Create(kept).Error; err != nil {
+			return err
+		}
+		log.Printf("Removed duplicates of key %s::%s::%s from table %s", duplicate.Namespace, duplicate.Profile, duplicate.Key, table)
+	}
+	return nil
+}
+
+// newestFirst orders the rows of a table from the most recently written one, by updated_at or id
+// when the table has either and by rowid on SQLite. It returns nil when the rows have no such order.
+func newestFirst(tx *gorm.DB, table string) *clause.OrderByColumn {
+	for _, name := range []string{"updated_at", "id"} {
+		if tx.Migrator().HasColumn(table, name) {
+			return &clause.OrderByColumn{Column: clause.Column{Name: name}, Desc: true}
+		}
+	}
+	if tx.Dialector.Name() == "sqlite" {
+		return &clause.OrderByColumn{Column: clause.Column{Name: "rowid", Raw: true}, Desc: true}
+	}
+	return nil
+}
+
+// Migrations returns every migration of the key table, applied or pending.
+func (r *Rdbms) Migrations() ([]MigrationStatus, error) {
+	applied, err := appliedMigrations(r.db, r.cfg.Application.RdbmsDefaultTable)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		status := MigrationStatus{Version: m.version, Name: m.name}
+		if row, ok := applied[m.version]; ok {
+			appliedAt := row.AppliedAt
+			status.AppliedAt = &appliedAt
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// MigrateUp applies the pending migrations up to a version, every one when it is 0, and returns
+// how many were applied.
+func (r *Rdbms) MigrateUp(target int) (int, error) {
+	table := r.cfg.Application.RdbmsDefaultTable
+	count := 0
+	err := r.withMigrationLock(func(db *gorm.DB) error {
+		applied, err := appliedMigrations(db, table)
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			if target > 0 && m.version > target {
+				break
+			}
+			if _, ok := applied[m.version]; ok {
+				continue
+			}
+			m := m
+			if err := db.Transaction(func(tx *gorm.DB) error {
+				if err := m.up(tx, table); err != nil {
+					return err
+				}
+				return tx.Table(migrationsTable(table)).Create(&schemaMigration{Version: m.version, Name: m.name, AppliedAt: time.Now()}).Error
+			}); err != nil {
+				return fmt.Errorf("migration %d (%s) of table %s failed: %v", m.version, m.name, table, err)
+			}
+			log.Printf("Applied migration %d (%s) to table %s", m.version, m.name, table)
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// MigrateDown reverts the last applied migrations and returns how many were reverted. Destructive
+// migrations are only reverted with force.
+func (r *Rdbms) MigrateDown(steps int, force bool) (int, error) {
+	table := r.cfg.Application.RdbmsDefaultTable
+	count := 0
+	err := r.withMigrationLock(func(db *gorm.DB) error {
+		applied, err := appliedMigrations(db, table)
+		if err != nil {
+			return err
+		}
+		for i := len(migrations) - 1; i >= 0 && count < steps; i-- {
+			m := migrations[i]
+			if _, ok := applied[m.version]; !ok {
+				continue
+			}
+			if m.destructive && !force {
+				return fmt.Errorf("migration %d (%s) of table %s: %w", m.version, m.name, table, ErrDestructiveMigration)
+			}
+			if err := db.Transaction(func(tx *gorm.DB) error {
+				if err := m.down(tx, table); err != nil {
+					return err
+				}
+				return tx.Table(migrationsTable(table)).Where(column("version", m.version)).Delete(&schemaMigration{}).Error
+			}); err != nil {
+				return fmt.Errorf("reverting migration %d (%s) of table %s failed: %v", m.version, m.name, table, err)
+			}
+			log.Printf("Reverted migration %d (%s) of table %s", m.version, m.name, table)
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// migrationLockTimeout is how long MySQL waits for another server to finish migrating.
+const migrationLockTimeout = 5 * time.Minute
+
+// withMigrationLock runs fn on a connection holding the lock of the migrations of the key table, so
+// that servers starting together apply them one after the other. MySQL and Postgres take an advisory
+// lock of the connection; SQLite locks the database file for each migration transaction.
+func (r *Rdbms) withMigrationLock(fn func(db *gorm.DB) error) error {
+	name := migrationsTable(r.cfg.Application.RdbmsDefaultTable)
+	return r.db.Connection(func(db *gorm.DB) error {
+		// A new session, so that the statements run on the connection do not share their clauses.
+		db = db.Session(&gorm.Session{NewDB: true})
+		switch db.Dialector.Name() {
+		case "mysql":
+			// MySQL lock names are at most 64 characters; tables sharing a prefix only share the lock.
+			if len(name) > 64 {
+				name = name[:64]
+			}
+			var locked int
+			if err := db.Raw("SELECT GET_LOCK(?, ?)", name, int(migrationLockTimeout.Seconds())).Scan(&locked).Error; err != nil {
+				return err
+			}
+			if locked != 1 {
+				return fmt.Errorf("timed out waiting for another server to migrate table %s", r.cfg.Application.RdbmsDefaultTable)
+			}
+			defer db.Exec("SELECT RELEASE_LOCK(?)", name)
+		case "postgres":
+			if err := db.Exec("SELECT pg_advisory_lock(hashtext(?))", name).Error; err != nil {
+				return err
+			}
+			defer db.Exec("SELECT pg_advisory_unlock(hashtext(?))", name)
+		}
+		return fn(db)
+	})
+}
+
+// pendingMigrations returns how many migrations are not applied yet.
+func (r *Rdbms) pendingMigrations() (int, error) {
+	applied, err := appliedMigrations(r.db, r.cfg.Application.RdbmsDefaultTable)
+	if err != nil {
+		return 0, err
+	}
+	pending := 0
+	for _, m := range migrations {
+		if _, ok := applied[m.version]; !ok {
+			pending++
+		}
+	}
+	return pending, nil
+}
+
+func appliedMigrations(db *gorm.DB, table string) (map[int]schemaMigration, error) {
+	table = migrationsTable(table)
+	if err := db.Table(table).AutoMigrate(&schemaMigration{}); err != nil {
+		return nil, err
+	}
+	var rows []schemaMigration
+	if err := db.Table(table).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	applied := make(map[int]schemaMigration, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
@@ -0,0 +1,162 @@
+package provider
+
+import (
+	"errors"
+	"path/filepath"
+	"stoo-kv/config"
+	"testing"
+)
+
+func openSqlite(t *testing.T) *Rdbms {
+	t.Helper()
+	cfg := &config.Config{Application: &config.ApplicationConfig{RdbmsDefaultTable: "kv"}, Providers: &config.ProviderConfig{}}
+	cfg.Providers.Sqlite.Path = filepath.Join(t.TempDir(), "kv.db")
+	r, err := OpenRdbms(cfg, "sqlite")
+	if err != nil {
+		t.Fatalf("OpenRdbms: %v", err)
+	}
+	t.Cleanup(func() {
+		if db, err := r.db.DB(); err == nil {
+			db.Close()
+		}
+	})
+	return r
+}
+
+func TestMigrateUp(t *testing.T) {
+	tests := []struct {
+		name string
+		// setup creates the table of an earlier release, if any.
+		setup    []string
+		wantKeys map[string]string
+	}{
+		{
+			name:     "new table",
+			wantKeys: map[string]string{},
+		},
+		{
+			name: "table without index, keys stored twice",
+			setup: []string{
+				"CREATE TABLE kv (namespace text, profile text, key text, value text)",
+				"INSERT INTO kv VALUES ('app', 'prod', 'a', '1'), ('app', 'prod', 'a', '1'), ('app', 'prod', 'b', '2')",
+			},
+			wantKeys: map[string]string{"a": "1", "b": "2"},
+		},
+		{
+			name: "keys stored twice with different values",
+			setup: []string{
+				"CREATE TABLE kv (namespace text, profile text, key text, value text)",
+				"INSERT INTO kv VALUES ('app', 'prod', 'a', 'old'), ('app', 'prod', 'a', 'new')",
+			},
+			wantKeys: map[string]string{"a": "new"},
+		},
+		{
+			name: "keys differing in case",
+			setup: []string{
+				"CREATE TABLE kv (namespace text, profile text, key text, value text)",
+				"INSERT INTO kv VALUES ('app', 'prod', 'a', '1'), ('app', 'prod', 'A', '2')",
+			},
+			wantKeys: map[string]string{"a": "1", "A": "2"},
+		},
+		{
+			name: "table with the shared index",
+			setup: []string{
+				"CREATE TABLE kv (namespace varchar(255), profile varchar(255), key varchar(255), value text)",
+				"CREATE UNIQUE INDEX idx_namespace_profile_key ON kv (namespace, profile, key)",
+				"INSERT INTO kv VALUES ('app', 'prod', 'a', '1')",
+			},
+			wantKeys: map[string]string{"a": "1"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := openSqlite(t)
+			for _, statement := range test.setup {
+				if err := r.db.Exec(statement).Error; err != nil {
+					t.Fatalf("setup %q: %v", statement, err)
+				}
+			}
+			applied, err := r.MigrateUp(0)
+			if err != nil || applied != len(migrations) {
+				t.Fatalf("MigrateUp applied %d migrations, %v; want %d", applied, err, len(migrations))
+			}
+			if !r.db.Migrator().HasIndex("kv", uniqueIndex("kv")) {
+				t.Errorf("index %s is missing", uniqueIndex("kv"))
+			}
+			if r.db.Migrator().HasIndex("kv", legacyIndex) {
+				t.Errorf("index %s was kept", legacyIndex)
+			}
+			if !r.db.Table("kv").Migrator().HasColumn(&kv{}, "version") {
+				t.Errorf("column version is missing")
+			}
+			keys, err := r.GetByNameSpaceAndProfile("app", "prod")
+			if err != nil {
+				t.Fatalf("GetByNameSpaceAndProfile: %v", err)
+			}
+			if len(keys) != len(test.wantKeys) {
+				t.Errorf("got keys %v, want %v", keys, test.wantKeys)
+			}
+			for key, value := range test.wantKeys {
+				if keys[key] != value {
+					t.Errorf("key %s is %q, want %q", key, keys[key], value)
+				}
+			}
+			if err := r.Set("app::prod::a", "3"); err != nil {
+				t.Errorf("Set after migrating: %v", err)
+			}
+			if applied, err := r.MigrateUp(0); err != nil || applied != 0 {
+				t.Errorf("second MigrateUp applied %d migrations, %v; want 0", applied, err)
+			}
+		})
+	}
+}
+
+func TestMigrateDown(t *testing.T) {
+	tests := []struct {
+		name        string
+		steps       int
+		force       bool
+		wantErr     error
+		wantApplied int
+		wantTable   bool
+	}{
+		{name: "last migration", steps: 1, wantApplied: 1, wantTable: true},
+		{name: "create table without force", steps: 2, wantErr: ErrDestructiveMigration, wantApplied: 1, wantTable: true},
+		{name: "create table with force", steps: 2, force: true, wantApplied: 0},
+		{name: "more steps than applied", steps: 5, force: true, wantApplied: 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := openSqlite(t)
+			if _, err := r.MigrateUp(0); err != nil {
+				t.Fatalf("MigrateUp: %v", err)
+			}
+			if _, err := r.MigrateDown(test.steps, test.force); !errors.Is(err, test.wantErr) {
+				t.Fatalf("MigrateDown: got %v, want %v", err, test.wantErr)
+			}
+			statuses, err := r.Migrations()
+			if err != nil {
+				t.Fatalf("Migrations: %v", err)
+			}
+			applied := 0
+			for _, status := range statuses {
+				if status.AppliedAt != nil {
+					applied++
+				}
+			}
+			if applied != test.wantApplied {
+				t.Errorf("got %d applied migrations, want %d", applied, test.wantApplied)
+			}
+			if r.db.Migrator().HasTable("kv") != test.wantTable {
+				t.Errorf("table kv exists: %v, want %v", !test.wantTable, test.wantTable)
+			}
+			if r.db.Table("kv").Migrator().HasColumn(&kv{}, "version") {
+				t.Errorf("column version was kept")
+			}
+			// The reverted migrations apply again.
+			if _, err := r.MigrateUp(0); err != nil {
+				t.Errorf("MigrateUp after MigrateDown: %v", err)
+			}
+		})
+	}
+}
@@ -6,75 +6,167 @@ import (
 	"github.com/redis/go-redis/v9"
 	"stoo-kv/config"
 	"strings"
+	"sync/atomic"
 )
 
+// RedisClient stores the keys of each namespace and profile in their own hash, named
+// "{store_name}:{namespace::profile}", and the namespace::profile pairs in the index set
+// "{store_name}:index". The braces are a hash tag, so a cluster places each hash by its namespace
+// and profile and spreads the namespaces across slots.
 type RedisClient struct {
-	client *redis.Client
-	ctx    context.Context
-	cfg    config.Config
+	client    redis.UniversalClient
+	ctx       context.Context
+	cfg       *config.Config
+	migrating *atomic.Bool
 }
 
-func NewRedisClient(ctx context.Context, config *config.Config) *RedisClient {
-	return &RedisClient{
-		client: redis.NewClient(&redis.Options{
-			Addr:     config.Providers.Redis.Host + ":" + config.Providers.Redis.Port,
-			Password: config.Providers.Redis.Password,
-			DB:       config.Providers.Redis.Database,
-			PoolSize: config.Providers.Redis.ConnectionPoolSize,
-		}),
-		ctx: ctx,
+func NewRedisClient(ctx context.Context, cfg *config.Config) (*RedisClient, error) {
+	redisConfig := cfg.Providers.Redis
+	tlsConfig, err := newTLSConfig(redisConfig.Tls)
+	if err != nil {
+		return nil, err
+	}
+	addresses := redisConfig.Addresses
+	if len(addresses) == 0 {
+		addresses = []string{redisConfig.Host + ":" + redisConfig.Port}
+	}
+	options := &redis.UniversalOptions{
+		Addrs:            addresses,
+		MasterName:       redisConfig.MasterName,
+		SentinelUsername: redisConfig.SentinelUsername,
+		SentinelPassword: redisConfig.SentinelPassword,
+		Username:         redisConfig.Username,
+		Password:         redisConfig.Password,
+		DB:               redisConfig.Database,
+		PoolSize:         redisConfig.ConnectionPoolSize,
+		MinIdleConns:     redisConfig.MinIdleConnections,
+		TLSConfig:        tlsConfig,
+	}
+	var client redis.UniversalClient
+	switch redisConfig.Mode {
+	case config.RedisModeSentinel:
+		client = redis.NewFailoverClient(options.Failover())
+	case config.RedisModeCluster:
+		client = redis.NewClusterClient(options.Cluster())
+	default:
+		client = redis.NewClient(options.Simple())
+	}
+	r := &RedisClient{client: client, ctx: ctx, cfg: cfg, migrating: &atomic.Bool{}}
+	r.startMigration()
+	return r, nil
+}
+
+func (r *RedisClient) WithContext(ctx context.Context) Store {
+	bound := *r
+	bound.ctx = ctx
+	return &bound
+}
+
+// hash returns the hash holding the keys of a namespace and profile.
+func (r *RedisClient) hash(namespace, profile string) string {
+	return fmt.Sprintf("%s:{%s::%s}", r.cfg.Providers.Redis.StoreName, namespace, profile)
+}
+
+// field splits a namespace::profile::key into its hash, the key name within it and the member
+// of the index set.
+func (r *RedisClient) field(key string) (string, string, string, error) {
+	parts := strings.SplitN(key, "::", 3)
+	if len(parts) != 3 {
+		return "", "", "", Invalidf("invalid key %q, expected namespace::profile::key", key)
 	}
+	return r.hash(parts[0], parts[1]), parts[2], parts[0] + "::" + parts[1], nil
 }
+
 func (r *RedisClient) Set(key string, value any) error {
-	return r.client.HSet(r.ctx, r.cfg.Providers.Redis.StoreName, key, value).Err()
+	hash, field, member, err := r.field(key)
+	if err != nil {
+		return err
+	}
+	_, err = r.client.Pipelined(r.ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(r.ctx, hash, field, toString(value))
+		pipe.SAdd(r.ctx, r.indexKey(), member)
+		if r.migrating.Load() {
+			pipe.HDel(r.ctx, r.legacyHash(), key)
+		}
+		return nil
+	})
+	return err
 }
 
 func (r *RedisClient) Get(key string) (string, error) {
-	return r.client.HGet(r.ctx, r.cfg.Providers.Redis.StoreName, key).Result()
+	hash, field, _, err := r.field(key)
+	if err != nil {
+		return "", err
+	}
+	value, err := r.client.HGet(r.ctx, hash, field).Result()
+	if err == redis.Nil && r.migrating.Load() {
+		value, err = r.client.HGet(r.ctx, r.legacyHash(), key).Result()
+	}
+	if err == redis.Nil {
+		return "", ErrNotFound
+	}
+	return value, err
 }
 
 func (r *RedisClient) Delete(key string) error {
-	return r.client.HDel(r.ctx, r.cfg.Providers.Redis.StoreName, key).Err()
+	hash, field, _, err := r.field(key)
+	if err != nil {
+		return err
+	}
+	var deleted int64
+	if r.migrating.Load() {
+		if deleted, err = r.client.HDel(r.ctx, r.legacyHash(), key).Result(); err != nil {
+			return err
+		}
+	}
+	count, err := r.client.HDel(r.ctx, hash, field).Result()
+	if err != nil {
+		return err
+	}
+	if deleted+count == 0 {
+		return ErrNotFound
+	}
+	return nil
 }
 
-//func (r *RedisClient) GetAll() (map[string]string, error) {
-//	keyValues := make(map[string]string)
-//	result, err := r.client.HGetAll(r.ctx, r.cfg.Providers.Redis.StoreName).Result()
-//	if err != nil {
-//		return nil, err
-//	}
-//	for k, v := range result {
-//		if strings.Contains(k, "::") {
-//			keyValues[strings.Split(k, "::")[2]] = v
-//			continue
-//		}
-//		keyValues[k] = v
-//	}
-//	return keyValues, nil
-//}
-
-func (r *RedisClient) GetAllWithNamespaceAndProfile() (map[string]string, error) {
-	keyValues := make(map[string]string)
-	result, err := r.client.HGetAll(r.ctx, r.cfg.Providers.Redis.StoreName).Result()
+// List reads the hash of the namespace and profile and pages its sorted keys, as HSCAN cursors
+// follow the hash order and may return more keys than asked for.
+func (r *RedisClient) List(namespace, profile string, options ListOptions) (*Page, error) {
+	matcher, err := newMatcher(options)
 	if err != nil {
 		return nil, err
 	}
-	for k, v := range result {
-		keyValues[k] = v
+	after, err := options.after()
+	if err != nil {
+		return nil, err
 	}
-	return keyValues, nil
+	values, err := r.GetByNameSpaceAndProfile(namespace, profile)
+	if err != nil {
+		return nil, err
+	}
+	var items []KeyValue
+	for key, value := range values {
+		if matcher.match(key) && after(key) {
+			items = append(items, KeyValue{Key: key, Value: value})
+		}
+	}
+	sortItems(items, options.Descending)
+	return paginate(items, options.Limit), nil
 }
 
 func (r *RedisClient) GetByNameSpaceAndProfile(namespace, profile string) (map[string]string, error) {
-	keyValues := make(map[string]string)
-	result, err := r.client.HGetAll(r.ctx, r.cfg.Providers.Redis.StoreName).Result()
+	values, err := r.client.HGetAll(r.ctx, r.hash(namespace, profile)).Result()
+	if err != nil || !r.migrating.Load() {
+		return values, err
+	}
+	legacy, err := r.legacyValues(namespace, profile)
 	if err != nil {
 		return nil, err
 	}
-	for k, v := range result {
-		if strings.HasPrefix(k, fmt.Sprintf("%s::%s", namespace, profile)) {
-			keyValues[strings.Split(k, "::")[2]] = v
+	for key, value := range legacy {
+		if _, ok := values[key]; !ok {
+			values[key] = value
 		}
 	}
-	return keyValues, nil
+	return values, nil
 }
@@ -0,0 +1,169 @@
+package provider
+
+import (
+	"github.com/redis/go-redis/v9"
+	"log"
+	"stoo-kv/config"
+	"strings"
+)
+
+// migrationBatch is how many keys of the legacy hash are moved at once.
+const migrationBatch = 500
+
+// moveLegacyKey moves a key of the legacy hash (KEYS[1], field ARGV[1]) to its own hash (KEYS[2],
+// field ARGV[2]) and indexes it (KEYS[3], member ARGV[3]), unless it was deleted from the legacy
+// hash since it was scanned. A key written since keeps its new value.
+var moveLegacyKey = redis.NewScript(`
+local value = redis.call('HGET', KEYS[1], ARGV[1])
+if not value then
+	return 0
+end
+redis.call('HSETNX', KEYS[2], ARGV[2], value)
+redis.call('SADD', KEYS[3], ARGV[3])
+redis.call('HDEL', KEYS[1], ARGV[1])
+return 1
+`)
+
+// legacyHash is the single hash, named store_name, that held every key before each namespace and
+// profile got their own hash.
+func (r *RedisClient) legacyHash() string {
+	return r.cfg.Providers.Redis.StoreName
+}
+
+// indexKey is the set of the "namespace::profile" pairs that were given keys.
+func (r *RedisClient) indexKey() string {
+	return r.cfg.Providers.Redis.StoreName + ":index"
+}
+
+// Namespaces returns the profiles holding keys, by namespace, read from the index set and, while
+// migrating, the legacy hash.
+func (r *RedisClient) Namespaces() (map[string][]string, error) {
+	members, err := r.client.SMembers(r.ctx, r.indexKey()).Result()
+	if err != nil {
+		return nil, err
+	}
+	index := namespaceIndex{}
+	pipe := r.client.Pipeline()
+	exists := make(map[string]*redis.IntCmd, len(members))
+	for _, member := range members {
+		if namespace, profile, ok := strings.Cut(member, "::"); ok {
+			exists[member] = pipe.Exists(r.ctx, r.hash(namespace, profile))
+		}
+	}
+	if len(exists) > 0 {
+		if _, err := pipe.Exec(r.ctx); err != nil {
+			return nil, err
+		}
+	}
+	for member, cmd := range exists {
+		if cmd.Val() > 0 {
+			namespace, profile, _ := strings.Cut(member, "::")
+			index.add(namespace, profile)
+		}
+	}
+	if r.migrating.Load() {
+		var cursor uint64
+		for {
+			result, next, err := r.client.HScan(r.ctx, r.legacyHash(), cursor, "*", migrationBatch).Result()
+			if err != nil {
+				return nil, err
+			}
+			for i := 0; i+1 < len(result); i += 2 {
+				index.addKey(result[i])
+			}
+			if cursor = next; cursor == 0 {
+				break
+			}
+		}
+	}
+	return index.profiles(), nil
+}
+
+// startMigration moves the keys of the legacy hash to the hashes of their namespace and profile in
+// the background. Until it is done, reads fall back to the legacy hash and writes remove the
+// legacy copy of their key; when it fails, they keep doing so until the next start retries it.
+// The legacy layout predates cluster support and is not migrated in cluster mode, where the legacy
+// hash and the new ones live in different slots and cannot be updated atomically.
+func (r *RedisClient) startMigration() {
+	kind, err := r.client.Type(r.ctx, r.legacyHash()).Result()
+	if err != nil {
+		log.Printf("Failed to check the redis hash %s for migration: %v", r.legacyHash(), err)
+		return
+	}
+	if kind != "hash" {
+		return
+	}
+	if r.cfg.Providers.Redis.Mode == config.RedisModeCluster {
+		log.Printf("Not migrating redis hash %s in cluster mode, migrate it on a standalone server first", r.legacyHash())
+		return
+	}
+	r.migrating.Store(true)
+	go func() {
+		log.Printf("Migrating the keys of redis hash %s to a hash per namespace and profile...", r.legacyHash())
+		migrated, err := r.migrate()
+		if err != nil {
+			log.Printf("Failed to migrate redis hash %s after %d keys, retrying on next start: %v", r.legacyHash(), migrated, err)
+			return
+		}
+		r.migrating.Store(false)
+		log.Printf("Migrated %d keys of redis hash %s", migrated, r.legacyHash())
+	}()
+}
+
+// migrate moves each key of the legacy hash with moveLegacyKey, so that a key deleted while the
+// batch is moved is not copied back. Keys that are not namespace::profile::key are left in place.
+func (r *RedisClient) migrate() (int, error) {
+	var cursor uint64
+	migrated := 0
+	for {
+		result, next, err := r.client.HScan(r.ctx, r.legacyHash(), cursor, "*", migrationBatch).Result()
+		if err != nil {
+			return migrated, err
+		}
+		pipe := r.client.Pipeline()
+		var moves []*redis.Cmd
+		for i := 0; i+1 < len(result); i += 2 {
+			parts := strings.SplitN(result[i], "::", 3)
+			if len(parts) != 3 {
+				log.Printf("Skipping key %q of redis hash %s, expected namespace::profile::key", result[i], r.legacyHash())
+				continue
+			}
+			keys := []string{r.legacyHash(), r.hash(parts[0], parts[1]), r.indexKey()}
+			moves = append(moves, moveLegacyKey.Eval(r.ctx, pipe, keys, result[i], parts[2], parts[0]+"::"+parts[1]))
+		}
+		if len(moves) > 0 {
+			if _, err := pipe.Exec(r.ctx); err != nil {
+				return migrated, err
+			}
+			for _, move := range moves {
+				if moved, _ := move.Int(); moved == 1 {
+					migrated++
+				}
+			}
+		}
+		cursor = next
+		if cursor == 0 {
+			return migrated, nil
+		}
+	}
+}
+
+// legacyValues returns the keys of a namespace and profile not migrated yet.
+func (r *RedisClient) legacyValues(namespace, profile string) (map[string]string, error) {
+	values := make(map[string]string)
+	prefix := keyPrefix(namespace, profile)
+	var cursor uint64
+	for {
+		result, next, err := r.client.HScan(r.ctx, r.legacyHash(), cursor, escapeGlob(prefix)+"*", migrationBatch).Result()
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i+1 < len(result); i += 2 {
+			values[strings.TrimPrefix(result[i], prefix)] = result[i+1]
+		}
+		cursor = next
+		if cursor == 0 {
+			return values, nil
+		}
+	}
+}
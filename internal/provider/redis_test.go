@@ -0,0 +1,489 @@
+package provider
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"io"
+	"net"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"stoo-kv/config"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeRedis is an in-memory Redis server speaking RESP2 with the hash and set commands of the
+// provider. EVAL runs scripts made of the Lua statements moveLegacyKey uses: a local assigned from
+// redis.call, "if not <local> then ... end", redis.call and return of a number; other statements
+// fail the script, so that the script and this interpreter are kept in step.
+type fakeRedis struct {
+	mu       sync.Mutex
+	hashes   map[string]map[string]string
+	sets     map[string]map[string]bool
+	listener net.Listener
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	f := &fakeRedis{hashes: map[string]map[string]string{}, sets: map[string]map[string]bool{}, listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { listener.Close() })
+	return f
+}
+
+type simpleString string
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	reader, writer := bufio.NewReader(conn), bufio.NewWriter(conn)
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+		f.mu.Lock()
+		reply := f.exec(args)
+		f.mu.Unlock()
+		writeReply(writer, reply)
+		if writer.Flush() != nil {
+			return
+		}
+	}
+}
+
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("unexpected %q", line)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		header, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(header[1:]))
+		if err != nil {
+			return nil, err
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+		args[i] = string(data[:size])
+	}
+	return args, nil
+}
+
+func writeReply(w *bufio.Writer, reply any) {
+	switch reply := reply.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case simpleString:
+		fmt.Fprintf(w, "+%s\r\n", reply)
+	case error:
+		fmt.Fprintf(w, "-%s\r\n", reply)
+	case int64:
+		fmt.Fprintf(w, ":%d\r\n", reply)
+	case string:
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(reply), reply)
+	case []any:
+		fmt.Fprintf(w, "*%d\r\n", len(reply))
+		for _, item := range reply {
+			writeReply(w, item)
+		}
+	}
+}
+
+func (f *fakeRedis) hash(key string) map[string]string {
+	if f.hashes[key] == nil {
+		f.hashes[key] = map[string]string{}
+	}
+	return f.hashes[key]
+}
+
+func (f *fakeRedis) exec(args []string) any {
+	name := strings.ToUpper(args[0])
+	switch {
+	case name == "PING":
+		return simpleString("PONG")
+	case name == "TYPE" && len(args) == 2:
+		switch {
+		case len(f.hashes[args[1]]) > 0:
+			return simpleString("hash")
+		case len(f.sets[args[1]]) > 0:
+			return simpleString("set")
+		}
+		return simpleString("none")
+	case name == "EXISTS" && len(args) == 2:
+		if len(f.hashes[args[1]]) > 0 || len(f.sets[args[1]]) > 0 {
+			return int64(1)
+		}
+		return int64(0)
+	case name == "HGET" && len(args) == 3:
+		if value, ok := f.hashes[args[1]][args[2]]; ok {
+			return value
+		}
+		return nil
+	case name == "HSET" && len(args) == 4:
+		_, exists := f.hashes[args[1]][args[2]]
+		f.hash(args[1])[args[2]] = args[3]
+		if exists {
+			return int64(0)
+		}
+		return int64(1)
+	case name == "HSETNX" && len(args) == 4:
+		if _, exists := f.hashes[args[1]][args[2]]; exists {
+			return int64(0)
+		}
+		f.hash(args[1])[args[2]] = args[3]
+		return int64(1)
+	case name == "HDEL" && len(args) >= 3:
+		deleted := int64(0)
+		for _, field := range args[2:] {
+			if _, ok := f.hashes[args[1]][field]; ok {
+				delete(f.hashes[args[1]], field)
+				deleted++
+			}
+		}
+		return deleted
+	case name == "HGETALL" && len(args) == 2:
+		return f.fields(args[1], "*")
+	case name == "HSCAN" && len(args) >= 3:
+		// Every field is returned in one page, ending the scan.
+		match := "*"
+		for i := 3; i+1 < len(args); i += 2 {
+			if strings.ToUpper(args[i]) == "MATCH" {
+				match = args[i+1]
+			}
+		}
+		return []any{"0", f.fields(args[1], match)}
+	case name == "SADD" && len(args) >= 3:
+		if f.sets[args[1]] == nil {
+			f.sets[args[1]] = map[string]bool{}
+		}
+		added := int64(0)
+		for _, member := range args[2:] {
+			if !f.sets[args[1]][member] {
+				f.sets[args[1]][member] = true
+				added++
+			}
+		}
+		return added
+	case name == "SMEMBERS" && len(args) == 2:
+		members := []any{}
+		for member := range f.sets[args[1]] {
+			members = append(members, member)
+		}
+		return members
+	case name == "EVAL" && len(args) >= 3:
+		numKeys, err := strconv.Atoi(args[2])
+		if err != nil || 3+numKeys > len(args) {
+			return errors.New("ERR invalid number of keys")
+		}
+		return f.eval(args[1], args[3:3+numKeys], args[3+numKeys:])
+	}
+	return fmt.Errorf("ERR unknown command '%s'", args[0])
+}
+
+// fields returns the fields and values of a hash matching a glob pattern, sorted by field.
+func (f *fakeRedis) fields(key, pattern string) []any {
+	var names []string
+	for field := range f.hashes[key] {
+		if ok, _ := path.Match(pattern, field); ok {
+			names = append(names, field)
+		}
+	}
+	sort.Strings(names)
+	result := []any{}
+	for _, name := range names {
+		result = append(result, name, f.hashes[key][name])
+	}
+	return result
+}
+
+var (
+	localCall = regexp.MustCompile(`^local (\w+) = redis\.call\((.*)\)$`)
+	call      = regexp.MustCompile(`^redis\.call\((.*)\)$`)
+	ifNot     = regexp.MustCompile(`^if not (\w+) then$`)
+	returns   = regexp.MustCompile(`^return (-?\d+)$`)
+	keyArg    = regexp.MustCompile(`^(KEYS|ARGV)\[(\d+)\]$`)
+)
+
+func (f *fakeRedis) eval(script string, keys, argv []string) any {
+	locals := map[string]any{}
+	arg := func(text string) (string, error) {
+		text = strings.TrimSpace(text)
+		if m := keyArg.FindStringSubmatch(text); m != nil {
+			i, _ := strconv.Atoi(m[2])
+			list := keys
+			if m[1] == "ARGV" {
+				list = argv
+			}
+			if i < 1 || i > len(list) {
+				return "", fmt.Errorf("%s out of range", text)
+			}
+			return list[i-1], nil
+		}
+		if len(text) >= 2 && text[0] == '\'' && text[len(text)-1] == '\'' {
+			return text[1 : len(text)-1], nil
+		}
+		if value, ok := locals[text].(string); ok {
+			return value, nil
+		}
+		return "", fmt.Errorf("unsupported argument %s", text)
+	}
+	redisCall := func(argsText string) (any, error) {
+		var args []string
+		for _, text := range strings.Split(argsText, ",") {
+			value, err := arg(text)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, value)
+		}
+		reply := f.exec(args)
+		if err, ok := reply.(error); ok {
+			return nil, err
+		}
+		return reply, nil
+	}
+
+	lines := strings.Split(strings.TrimSpace(script), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		switch {
+		case line == "" || line == "end":
+		case localCall.MatchString(line):
+			m := localCall.FindStringSubmatch(line)
+			reply, err := redisCall(m[2])
+			if err != nil {
+				return fmt.Errorf("ERR %v", err)
+			}
+			locals[m[1]] = reply
+		case ifNot.MatchString(line):
+			// Lua treats the nil reply of redis.call as false; the block is skipped otherwise.
+			if locals[ifNot.FindStringSubmatch(line)[1]] != nil {
+				for i < len(lines) && strings.TrimSpace(lines[i]) != "end" {
+					i++
+				}
+			}
+		case call.MatchString(line):
+			if _, err := redisCall(call.FindStringSubmatch(line)[1]); err != nil {
+				return fmt.Errorf("ERR %v", err)
+			}
+		case returns.MatchString(line):
+			n, _ := strconv.ParseInt(returns.FindStringSubmatch(line)[1], 10, 64)
+			return n
+		default:
+			return fmt.Errorf("ERR unsupported statement %q", line)
+		}
+	}
+	return nil
+}
+
+// newTestRedis connects to the fake server without starting the migration, as a server that
+// found the legacy hash and is migrating it when migrating is set.
+func newTestRedis(t *testing.T, f *fakeRedis, migrating bool) *RedisClient {
+	t.Helper()
+	cfg := &config.Config{Application: &config.ApplicationConfig{}, Providers: &config.ProviderConfig{}}
+	cfg.Providers.Redis.StoreName = "kv"
+	client := redis.NewClient(&redis.Options{Addr: f.listener.Addr().String()})
+	t.Cleanup(func() { client.Close() })
+	r := &RedisClient{client: client, ctx: context.Background(), cfg: cfg, migrating: &atomic.Bool{}}
+	r.migrating.Store(migrating)
+	return r
+}
+
+// scriptSource returns the Lua source moveLegacyKey sends with EVAL.
+func scriptSource(t *testing.T) string {
+	t.Helper()
+	client := redis.NewClient(&redis.Options{Addr: "127.0.0.1:0"})
+	defer client.Close()
+	args := moveLegacyKey.Eval(context.Background(), client.Pipeline(), []string{"k"}, "a").Args()
+	if len(args) < 2 || args[0] != "eval" {
+		t.Fatalf("got %v, want an EVAL command", args)
+	}
+	return args[1].(string)
+}
+
+func TestMoveLegacyKey(t *testing.T) {
+	tests := []struct {
+		name       string
+		legacy     map[string]string
+		current    map[string]string
+		want       int64
+		wantHash   map[string]string
+		wantLegacy map[string]string
+		wantIndex  bool
+	}{
+		{
+			name:       "key moved",
+			legacy:     map[string]string{"app::prod::a": "1", "app::prod::b": "2"},
+			want:       1,
+			wantHash:   map[string]string{"a": "1"},
+			wantLegacy: map[string]string{"app::prod::b": "2"},
+			wantIndex:  true,
+		},
+		{
+			name:       "key deleted since the scan",
+			legacy:     map[string]string{"app::prod::b": "2"},
+			wantLegacy: map[string]string{"app::prod::b": "2"},
+		},
+		{
+			name:       "key written since the scan",
+			legacy:     map[string]string{"app::prod::a": "old"},
+			current:    map[string]string{"a": "new"},
+			want:       1,
+			wantHash:   map[string]string{"a": "new"},
+			wantLegacy: map[string]string{},
+			wantIndex:  true,
+		},
+	}
+	source := scriptSource(t)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := &fakeRedis{hashes: map[string]map[string]string{"kv": test.legacy}, sets: map[string]map[string]bool{}}
+			if test.current != nil {
+				f.hashes["kv:{app::prod}"] = test.current
+			}
+			got := f.eval(source, []string{"kv", "kv:{app::prod}", "kv:index"}, []string{"app::prod::a", "a", "app::prod"})
+			if got != test.want {
+				t.Fatalf("got %v, want %d", got, test.want)
+			}
+			if hash := f.hashes["kv:{app::prod}"]; len(hash)+len(test.wantHash) > 0 && !reflect.DeepEqual(hash, test.wantHash) {
+				t.Errorf("got hash %v, want %v", hash, test.wantHash)
+			}
+			if !reflect.DeepEqual(f.hashes["kv"], test.wantLegacy) {
+				t.Errorf("got legacy hash %v, want %v", f.hashes["kv"], test.wantLegacy)
+			}
+			if f.sets["kv:index"]["app::prod"] != test.wantIndex {
+				t.Errorf("got index %v, want app::prod indexed: %v", f.sets["kv:index"], test.wantIndex)
+			}
+		})
+	}
+}
+
+func TestRedisMigrate(t *testing.T) {
+	f := newFakeRedis(t)
+	f.hashes["kv"] = map[string]string{
+		"app::prod::a":       "old",
+		"app::prod::db::url": "postgres://db",
+		"app::dev::b":        "2",
+		"malformed":          "3",
+	}
+	// Written in the new layout after the migration started.
+	f.hashes["kv:{app::prod}"] = map[string]string{"a": "new"}
+	f.sets["kv:index"] = map[string]bool{"app::prod": true}
+	r := newTestRedis(t, f, true)
+
+	migrated, err := r.migrate()
+	if err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if migrated != 3 {
+		t.Errorf("got %d keys migrated, want 3", migrated)
+	}
+	want := map[string]map[string]string{
+		"kv":             {"malformed": "3"},
+		"kv:{app::prod}": {"a": "new", "db::url": "postgres://db"},
+		"kv:{app::dev}":  {"b": "2"},
+	}
+	if !reflect.DeepEqual(f.hashes, want) {
+		t.Errorf("got hashes %v, want %v", f.hashes, want)
+	}
+	if !reflect.DeepEqual(f.sets["kv:index"], map[string]bool{"app::prod": true, "app::dev": true}) {
+		t.Errorf("got index %v", f.sets["kv:index"])
+	}
+}
+
+func TestRedisStartMigration(t *testing.T) {
+	f := newFakeRedis(t)
+	f.hashes["kv"] = map[string]string{"app::prod::a": "1"}
+	r := newTestRedis(t, f, false)
+	r.startMigration()
+	deadline := time.Now().Add(5 * time.Second)
+	for r.migrating.Load() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if r.migrating.Load() {
+		t.Fatalf("migration did not finish")
+	}
+	if got, err := r.Get("app::prod::a"); err != nil || got != "1" {
+		t.Errorf("Get after the migration: got %q, %v", got, err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.hashes["kv"]) != 0 {
+		t.Errorf("got legacy hash %v, want it emptied", f.hashes["kv"])
+	}
+}
+
+func TestRedisWhileMigrating(t *testing.T) {
+	f := newFakeRedis(t)
+	f.hashes["kv"] = map[string]string{"app::prod::a": "legacy", "app::prod::b": "2", "app::dev::c": "3"}
+	f.hashes["kv:{app::prod}"] = map[string]string{"b": "new"}
+	f.sets["kv:index"] = map[string]bool{"app::prod": true}
+	r := newTestRedis(t, f, true)
+
+	// Reads fall back to the legacy hash, preferring keys written in the new layout.
+	if got, err := r.Get("app::prod::a"); err != nil || got != "legacy" {
+		t.Errorf("Get of a legacy key: got %q, %v", got, err)
+	}
+	values, err := r.GetByNameSpaceAndProfile("app", "prod")
+	if err != nil || !reflect.DeepEqual(values, map[string]string{"a": "legacy", "b": "new"}) {
+		t.Errorf("GetByNameSpaceAndProfile: got %v, %v", values, err)
+	}
+	namespaces, err := r.Namespaces()
+	if err != nil || !reflect.DeepEqual(namespaces, map[string][]string{"app": {"dev", "prod"}}) {
+		t.Errorf("Namespaces: got %v, %v", namespaces, err)
+	}
+
+	// Writes and deletes remove the legacy copy, so that the migration does not bring it back.
+	if err := r.Set("app::prod::a", "written"); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if err := r.Delete("app::dev::c"); err != nil {
+		t.Fatalf("Delete of a legacy key: %v", err)
+	}
+	if err := r.Delete("app::dev::c"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second Delete: got %v, want ErrNotFound", err)
+	}
+	f.mu.Lock()
+	legacy := f.hashes["kv"]
+	f.mu.Unlock()
+	if !reflect.DeepEqual(legacy, map[string]string{"app::prod::b": "2"}) {
+		t.Errorf("got legacy hash %v", legacy)
+	}
+	if got, err := r.Get("app::prod::a"); err != nil || got != "written" {
+		t.Errorf("Get after Set: got %q, %v", got, err)
+	}
+}
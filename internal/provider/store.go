@@ -0,0 +1,25 @@
+package provider
+
+import "context"
+
+// Store is implemented by every provider; see store.Store.
+type Store interface {
+	Set(key string, value any) error
+	// Get returns ErrNotFound for a key that does not exist.
+	Get(key string) (string, error)
+	// Delete returns ErrNotFound for a key that does not exist.
+	Delete(key string) error
+	//GetAll() (map[string]string, error)
+	GetByNameSpaceAndProfile(namespace, profile string) (map[string]string, error)
+	List(namespace, profile string, options ListOptions) (*Page, error)
+	// Namespaces returns the profiles holding keys, by namespace.
+	Namespaces() (map[string][]string, error)
+}
+
+// ContextStore is a Store whose operations can be bound to a context, which cancels them once it
+// is done.
+type ContextStore interface {
+	Store
+	// WithContext returns a copy of the store whose operations use ctx.
+	WithContext(ctx context.Context) Store
+}
@@ -0,0 +1,61 @@
+package provider
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestDeleteReportsMissingKeys(t *testing.T) {
+	tests := []struct {
+		name  string
+		store Store
+	}{
+		{"memory", NewMemory()},
+		{"sqlite", openSqlite(t)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if sqlite, ok := test.store.(*Rdbms); ok {
+				if _, err := sqlite.MigrateUp(0); err != nil {
+					t.Fatalf("MigrateUp: %v", err)
+				}
+			}
+			if err := test.store.Set("app::prod::a", ""); err != nil {
+				t.Fatalf("Set: %v", err)
+			}
+			if err := test.store.Delete("app::prod::a"); err != nil {
+				t.Fatalf("Delete: %v", err)
+			}
+			if err := test.store.Delete("app::prod::a"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("second Delete: got %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
+
+func TestRdbmsRejectsLongKeys(t *testing.T) {
+	long := strings.Repeat("é", keyColumnSize+1)
+	fits := strings.Repeat("é", keyColumnSize)
+	tests := []struct {
+		key     string
+		wantErr error
+	}{
+		{key: "app::prod::" + fits},
+		{key: "app::prod::" + long, wantErr: ErrInvalid},
+		{key: "app::" + long + "::a", wantErr: ErrInvalid},
+		{key: long + "::prod::a", wantErr: ErrInvalid},
+		// The key may hold separators; only the length of the key column counts.
+		{key: "app::prod::" + fits[:len(fits)-6] + "::b"},
+	}
+	sqlite := openSqlite(t)
+	if _, err := sqlite.MigrateUp(0); err != nil {
+		t.Fatalf("MigrateUp: %v", err)
+	}
+	for _, test := range tests {
+		if err := sqlite.Set(test.key, "v"); !errors.Is(err, test.wantErr) {
+			t.Errorf("Set of a key of %d characters: got %v, want %v", utf8.RuneCountInString(test.key), err, test.wantErr)
+		}
+	}
+}
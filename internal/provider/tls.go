@@ -0,0 +1,62 @@
+package provider
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sort"
+	"stoo-kv/config"
+	"strings"
+)
+
+// newTLSConfig returns the client TLS configuration of a backend, or nil when TLS is not enabled.
+func newTLSConfig(c config.TLSConfig) (*tls.Config, error) {
+	if !c.Enabled {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+	if c.CaFile != "" {
+		pem, err := os.ReadFile(c.CaFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", c.CaFile)
+		}
+	}
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// joinParams formats DSN parameters sorted by name, with params replacing the defaults.
+func joinParams(defaults, params map[string]string, format func(name, value string) string, separator string) string {
+	merged := make(map[string]string, len(defaults)+len(params))
+	for name, value := range defaults {
+		merged[name] = value
+	}
+	for name, value := range params {
+		merged[name] = value
+	}
+	names := make([]string, 0, len(merged))
+	for name := range merged {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, format(name, merged[name]))
+	}
+	return strings.Join(parts, separator)
+}
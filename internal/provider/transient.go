@@ -0,0 +1,101 @@
+package provider
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/mattn/go-sqlite3"
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/mongo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+)
+
+// redisTransientPrefixes are the Redis errors of a server that is loading, failing over or
+// resharding.
+var redisTransientPrefixes = []string{"LOADING ", "READONLY ", "MASTERDOWN ", "CLUSTERDOWN ", "TRYAGAIN "}
+
+// IsTransient reports whether an error of a backend may go away when the operation is retried:
+// lost or refused connections, timeouts, failovers, deadlocks and busy databases. Invalid keys and
+// queries are not transient.
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	for _, target := range []error{context.DeadlineExceeded, driver.ErrBadConn, io.EOF, io.ErrUnexpectedEOF,
+		syscall.ECONNREFUSED, syscall.ECONNRESET, syscall.EPIPE, mysqldriver.ErrInvalidConn} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	var redisErr redis.Error
+	if errors.As(err, &redisErr) {
+		for _, prefix := range redisTransientPrefixes {
+			if strings.HasPrefix(redisErr.Error(), prefix) {
+				return true
+			}
+		}
+	}
+	if mongo.IsNetworkError(err) || mongo.IsTimeout(err) {
+		return true
+	}
+	var mongoErr mongo.ServerError
+	if errors.As(err, &mongoErr) && mongoErr.HasErrorLabel("RetryableWriteError") {
+		return true
+	}
+	var mysqlErr *mysqldriver.MySQLError
+	if errors.As(err, &mysqlErr) {
+		// Lock wait timeout and deadlock.
+		return mysqlErr.Number == 1205 || mysqlErr.Number == 1213
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		// Serialization failure, deadlock and a server starting up or shutting down.
+		return pgErr.Code == "40001" || pgErr.Code == "40P01" || strings.HasPrefix(pgErr.Code, "57P")
+	}
+	if pgconn.SafeToRetry(err) || pgconn.Timeout(err) {
+		return true
+	}
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
+	}
+	// etcd reports failures over gRPC.
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
+
+// IsConflict reports whether an error of a backend is a unique index violation, e.g. of two
+// concurrent upserts creating the same key.
+func IsConflict(err error) bool {
+	if mongo.IsDuplicateKeyError(err) {
+		return true
+	}
+	var mysqlErr *mysqldriver.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == 1062
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == "23505"
+	}
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique || sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
+	}
+	return false
+}
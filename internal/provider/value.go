@@ -0,0 +1,17 @@
+package provider
+
+import "fmt"
+
+// toString converts the value handed to Store.Set into the string kept by the backend.
+func toString(value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case fmt.Stringer:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
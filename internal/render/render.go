@@ -0,0 +1,98 @@
+package render
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// Execute renders a Go text/template with the values of a namespace and profile. Templates read
+// values with {{ value "database.url" }}, {{ valueOr "pool_size" "10" }} or {{ index . "database.url" }},
+// iterate keys with {{ range $key, $value := . }}, decode JSON values with {{ (fromJson (value "limits")).cpu }}
+// and read the environment with {{ env "HOSTNAME" }}.
+func Execute(name, text string, values map[string]string) ([]byte, error) {
+	funcs := template.FuncMap{
+		"value": func(key string) (string, error) {
+			value, ok := values[key]
+			if !ok {
+				return "", fmt.Errorf("key %s not found", key)
+			}
+			return value, nil
+		},
+		"valueOr": func(key, def string) string {
+			if value, ok := values[key]; ok {
+				return value
+			}
+			return def
+		},
+		"env": os.Getenv,
+		"fromJson": func(value string) (any, error) {
+			var v any
+			err := json.Unmarshal([]byte(value), &v)
+			return v, err
+		},
+		"toJson": func(v any) (string, error) {
+			data, err := json.Marshal(v)
+			return string(data), err
+		},
+		"hasPrefix":  strings.HasPrefix,
+		"trimPrefix": strings.TrimPrefix,
+	}
+	tmpl, err := template.New(name).Funcs(funcs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, values); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// ExecuteFile renders the template stored in a file.
+func ExecuteFile(path string, values map[string]string) ([]byte, error) {
+	text, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Execute(filepath.Base(path), string(text), values)
+}
+
+// WriteFile replaces a file atomically, writing a temporary file in the same directory and renaming
+// it. It reports false without writing when the file already has the same content and permissions.
+func WriteFile(path string, data []byte, perm os.FileMode) (bool, error) {
+	if current, err := os.ReadFile(path); err == nil && bytes.Equal(current, data) {
+		if info, err := os.Stat(path); err == nil && info.Mode().Perm() == perm {
+			return false, nil
+		}
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return false, err
+	}
+	f, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return false, err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return false, err
+	}
+	if err := f.Close(); err != nil {
+		return false, err
+	}
+	if err := os.Chmod(f.Name(), perm); err != nil {
+		return false, err
+	}
+	return true, os.Rename(f.Name(), path)
+}
@@ -0,0 +1,121 @@
+package render
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExecute(t *testing.T) {
+	t.Setenv("STOOKV_TEST_HOST", "web-1")
+	values := map[string]string{"database.url": "postgres://db", "limits": `{"cpu":2}`, "a": "1"}
+	tests := []struct {
+		name    string
+		text    string
+		want    string
+		wantErr string
+	}{
+		{name: "value", text: `{{ value "database.url" }}`, want: "postgres://db"},
+		{name: "index", text: `{{ index . "database.url" }}`, want: "postgres://db"},
+		{name: "default", text: `{{ valueOr "pool_size" "10" }} {{ valueOr "a" "0" }}`, want: "10 1"},
+		{name: "range", text: `{{ range $k, $v := . }}{{ $k }};{{ end }}`, want: "a;database.url;limits;"},
+		{name: "json", text: `{{ (fromJson (value "limits")).cpu }} {{ toJson (fromJson (value "limits")) }}`, want: `2 {"cpu":2}`},
+		{name: "environment", text: `{{ env "STOOKV_TEST_HOST" }}`, want: "web-1"},
+		{name: "prefixes", text: `{{ if hasPrefix (value "database.url") "postgres" }}{{ trimPrefix (value "database.url") "postgres://" }}{{ end }}`, want: "db"},
+		{name: "missing value", text: `{{ value "missing" }}`, wantErr: "key missing not found"},
+		{name: "missing index", text: `{{ .missing }}`, wantErr: "map has no entry for key"},
+		{name: "invalid template", text: `{{ value "a" `, wantErr: "unclosed action"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Execute("test", test.text, values)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("got %q, %v, want error %s", got, err, test.wantErr)
+				}
+				return
+			}
+			if err != nil || string(got) != test.want {
+				t.Errorf("got %q, %v, want %q", got, err, test.want)
+			}
+		})
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "conf", "app.conf")
+	steps := []struct {
+		name        string
+		data        string
+		perm        os.FileMode
+		wantChanged bool
+	}{
+		{name: "new file in a new directory", data: "a=1\n", perm: 0644, wantChanged: true},
+		{name: "same content", data: "a=1\n", perm: 0644},
+		{name: "new permissions", data: "a=1\n", perm: 0600, wantChanged: true},
+		{name: "new content", data: "a=2\n", perm: 0600, wantChanged: true},
+		{name: "empty content", data: "", perm: 0600, wantChanged: true},
+	}
+	for _, step := range steps {
+		changed, err := WriteFile(path, []byte(step.data), step.perm)
+		if err != nil {
+			t.Fatalf("%s: WriteFile: %v", step.name, err)
+		}
+		if changed != step.wantChanged {
+			t.Errorf("%s: got changed %v, want %v", step.name, changed, step.wantChanged)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil || string(data) != step.data {
+			t.Fatalf("%s: got %q, %v, want %q", step.name, data, err, step.data)
+		}
+		if info, err := os.Stat(path); err != nil || info.Mode().Perm() != step.perm {
+			t.Errorf("%s: got mode %v, want %v", step.name, info.Mode().Perm(), step.perm)
+		}
+		// Temporary files are renamed or removed.
+		if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+			t.Errorf("%s: got %d files, want only the rendered file", step.name, len(entries))
+		}
+	}
+}
+
+func TestWriteFileReplacesAtomically(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.conf")
+	if _, err := WriteFile(path, []byte("old"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	// A reader holding the old file keeps reading it whole: the new content is a new file renamed over it.
+	reader, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer reader.Close()
+	if _, err := WriteFile(path, []byte("new content"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	old := make([]byte, 64)
+	n, _ := reader.Read(old)
+	if string(old[:n]) != "old" {
+		t.Errorf("open reader got %q, want the old content", old[:n])
+	}
+	if data, _ := os.ReadFile(path); string(data) != "new content" {
+		t.Errorf("got %q, want the new content", data)
+	}
+}
+
+func TestWriteFileCleansUpOnError(t *testing.T) {
+	dir := t.TempDir()
+	// The destination is a directory, which the rendered file cannot replace.
+	path := filepath.Join(dir, "app.conf")
+	if err := os.MkdirAll(filepath.Join(path, "kept"), 0755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	if _, err := WriteFile(path, []byte("new"), 0644); err == nil {
+		t.Fatalf("WriteFile over a directory: want an error")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 1 || !entries[0].IsDir() {
+		t.Errorf("got %v, %v, want the directory alone, without temporary files", entries, err)
+	}
+}
@@ -0,0 +1,73 @@
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"stoo-kv/internal/content"
+	"stoo-kv/internal/store"
+	"strings"
+)
+
+const schemaProfile = "schemas"
+
+// Registry keeps namespace schemas in the storage under the system namespace.
+type Registry struct {
+	storage store.Store
+}
+
+func NewRegistry(storage store.Store) *Registry {
+	return &Registry{storage: storage}
+}
+
+func (r *Registry) Get(namespace string) (*Schema, error) {
+	data, err := r.storage.Get(store.SystemKey(schemaProfile, namespace))
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return Parse([]byte(data))
+}
+
+func (r *Registry) Set(namespace string, s *Schema) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return r.storage.Set(store.SystemKey(schemaProfile, namespace), string(data))
+}
+
+func (r *Registry) Delete(namespace string) error {
+	return store.Remove(r.storage, store.SystemKey(schemaProfile, namespace))
+}
+
+// Validate checks a value against the schema of its namespace, if one is registered. A value
+// made of a single reference of a declared key is resolved with resolve and the value it stands
+// for is validated; text around references is validated as is.
+func (r *Registry) Validate(namespace, key, contentType, value string, resolve func(value string) (string, error)) error {
+	s, err := r.Get(namespace)
+	if err != nil || s == nil {
+		return err
+	}
+	if contentType != content.TypeText {
+		return s.ValidateStructured(key, contentType)
+	}
+	if _, ok := s.Fields[key]; ok && isReference(value) {
+		resolved, err := resolve(value)
+		if errors.Is(err, store.ErrUnavailable) {
+			return err
+		}
+		if err != nil {
+			return &ValidationError{Errors: []FieldError{{Field: key, Message: fmt.Sprintf("%s cannot be resolved: %v", value, err)}}}
+		}
+		value = resolved
+	}
+	return s.Validate(key, value)
+}
+
+// isReference reports whether value is a single reference such as ${database.port}.
+func isReference(value string) bool {
+	return strings.HasPrefix(value, "${") && strings.IndexByte(value, '}') == len(value)-1
+}
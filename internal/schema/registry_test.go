@@ -0,0 +1,87 @@
+package schema
+
+import (
+	"errors"
+	"fmt"
+	"stoo-kv/internal/content"
+	"stoo-kv/internal/provider"
+	"stoo-kv/internal/store"
+	"testing"
+)
+
+func TestRegistryValidate(t *testing.T) {
+	registry := NewRegistry(provider.NewMemory())
+	if err := registry.Validate("app", "port", content.TypeText, "ten", nil); err != nil {
+		t.Fatalf("Validate without a schema: %v", err)
+	}
+	s, err := Parse([]byte(`{"fields": {"port": {"type": "int", "max": 65535}}}`))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if err := registry.Set("app", s); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	referenced := map[string]string{"${defaults:prod:port}": "8080", "${big}": "70000", "${name}": "app"}
+	resolve := func(value string) (string, error) {
+		if value == "${down}" {
+			return "", provider.Mark(errors.New("connection refused"), store.ErrUnavailable)
+		}
+		if resolved, ok := referenced[value]; ok {
+			return resolved, nil
+		}
+		return "", fmt.Errorf("unresolved reference %s", value)
+	}
+
+	tests := []struct {
+		name        string
+		key         string
+		contentType string
+		value       string
+		wantErr     string
+		// wantIs is the error the validation error matches, store.ErrInvalid by default.
+		wantIs error
+	}{
+		{name: "literal", key: "port", value: "8080"},
+		{name: "invalid literal", key: "port", value: "ten", wantErr: `validation failed: port: "ten" is not a valid int`},
+		{name: "reference to a valid value", key: "port", value: "${defaults:prod:port}"},
+		{name: "reference to a value out of range", key: "port", value: "${big}", wantErr: "validation failed: port: must be at most 65535"},
+		{name: "reference to an invalid value", key: "port", value: "${name}", wantErr: `validation failed: port: "app" is not a valid int`},
+		{name: "unresolved reference", key: "port", value: "${missing}", wantErr: "validation failed: port: ${missing} cannot be resolved: unresolved reference ${missing}"},
+		{name: "text around a reference", key: "port", value: "ten${big}", wantErr: `validation failed: port: "ten${big}" is not a valid int`},
+		{name: "storage down", key: "port", value: "${down}", wantErr: "connection refused", wantIs: store.ErrUnavailable},
+		{name: "undeclared reference", key: "other", value: "${missing}"},
+		{name: "structured value", key: "port", contentType: content.TypeJSON, value: "{}", wantErr: "validation failed: port: application/json value is not allowed for int field"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			contentType := test.contentType
+			if contentType == "" {
+				contentType = content.TypeText
+			}
+			err := registry.Validate("app", test.key, contentType, test.value, resolve)
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validate: %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != test.wantErr {
+				t.Fatalf("got error %v, want %s", err, test.wantErr)
+			}
+			wantIs := test.wantIs
+			if wantIs == nil {
+				wantIs = store.ErrInvalid
+			}
+			if !errors.Is(err, wantIs) {
+				t.Errorf("error %v does not match %v", err, wantIs)
+			}
+		})
+	}
+
+	if err := registry.Delete("app"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if s, err := registry.Get("app"); s != nil || err != nil {
+		t.Errorf("Get after Delete: %v, %v", s, err)
+	}
+}
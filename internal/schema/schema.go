@@ -0,0 +1,222 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"sort"
+	"stoo-kv/internal/store"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TypeString   = "string"
+	TypeInt      = "int"
+	TypeFloat    = "float"
+	TypeBool     = "bool"
+	TypeDuration = "duration"
+	TypeURL      = "url"
+	TypeEnum     = "enum"
+)
+
+// Schema describes the values accepted by the keys of a namespace.
+type Schema struct {
+	Fields map[string]*Field `json:"fields"`
+	// Strict rejects keys that are not declared in Fields.
+	Strict bool `json:"strict"`
+}
+
+type Field struct {
+	Type    string   `json:"type"`
+	Values  []string `json:"values,omitempty"`
+	Min     *float64 `json:"min,omitempty"`
+	Max     *float64 `json:"max,omitempty"`
+	Pattern string   `json:"pattern,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, 0, len(e.Errors))
+	for _, fieldErr := range e.Errors {
+		messages = append(messages, fmt.Sprintf("%s: %s", fieldErr.Field, fieldErr.Message))
+	}
+	return "validation failed: " + strings.Join(messages, "; ")
+}
+
+func (e *ValidationError) Is(target error) bool {
+	return target == store.ErrInvalid
+}
+
+// UnmarshalJSON accepts either a field definition object or the type name alone,
+// e.g. "max_connections": "int".
+func (f *Field) UnmarshalJSON(data []byte) error {
+	var typeName string
+	if err := json.Unmarshal(data, &typeName); err == nil {
+		f.Type = typeName
+		return nil
+	}
+	type field Field
+	return json.Unmarshal(data, (*field)(f))
+}
+
+func Parse(data []byte) (*Schema, error) {
+	s := &Schema{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, store.Invalid(err)
+	}
+	if err := s.Compile(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Compile checks the schema definition and prepares it for validation.
+func (s *Schema) Compile() error {
+	var errs []FieldError
+	for _, key := range s.keys() {
+		field := s.Fields[key]
+		if field == nil {
+			errs = append(errs, FieldError{Field: key, Message: "field definition is missing"})
+			continue
+		}
+		switch field.Type {
+		case TypeString, TypeInt, TypeFloat, TypeBool, TypeDuration, TypeURL:
+		case TypeEnum:
+			if len(field.Values) == 0 {
+				errs = append(errs, FieldError{Field: key, Message: "enum requires at least one value"})
+			}
+		default:
+			errs = append(errs, FieldError{Field: key, Message: fmt.Sprintf("unsupported type %q", field.Type)})
+		}
+		if field.Min != nil || field.Max != nil {
+			switch {
+			case field.Type != TypeInt && field.Type != TypeFloat && field.Type != TypeDuration:
+				errs = append(errs, FieldError{Field: key, Message: fmt.Sprintf("min and max only apply to int, float and duration fields, not %s", field.Type)})
+			case field.Min != nil && field.Max != nil && *field.Min > *field.Max:
+				errs = append(errs, FieldError{Field: key, Message: fmt.Sprintf("min %v is greater than max %v", *field.Min, *field.Max)})
+			}
+		}
+		if field.Pattern != "" {
+			pattern, err := regexp.Compile(field.Pattern)
+			if err != nil {
+				errs = append(errs, FieldError{Field: key, Message: fmt.Sprintf("invalid pattern: %v", err)})
+				continue
+			}
+			field.pattern = pattern
+		}
+	}
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+func (s *Schema) Validate(key, value string) error {
+	field, ok := s.Fields[key]
+	if !ok {
+		if s.Strict {
+			return &ValidationError{Errors: []FieldError{{Field: key, Message: "key is not declared in the namespace schema"}}}
+		}
+		return nil
+	}
+	if err := field.validate(value); err != nil {
+		return &ValidationError{Errors: []FieldError{{Field: key, Message: err.Error()}}}
+	}
+	return nil
+}
+
+// ValidateStructured rejects JSON, list and binary values for keys declared with a scalar type.
+func (s *Schema) ValidateStructured(key, contentType string) error {
+	field, ok := s.Fields[key]
+	if !ok && !s.Strict {
+		return nil
+	}
+	if !ok {
+		return &ValidationError{Errors: []FieldError{{Field: key, Message: "key is not declared in the namespace schema"}}}
+	}
+	return &ValidationError{Errors: []FieldError{{Field: key, Message: fmt.Sprintf("%s value is not allowed for %s field", contentType, field.Type)}}}
+}
+
+// validate checks the value against the type of the field, then against its pattern.
+func (f *Field) validate(value string) error {
+	if err := f.validateType(value); err != nil {
+		return err
+	}
+	if f.pattern != nil && !f.pattern.MatchString(value) {
+		return fmt.Errorf("%q does not match pattern %s", value, f.Pattern)
+	}
+	return nil
+}
+
+func (f *Field) validateType(value string) error {
+	switch f.Type {
+	case TypeInt:
+		number, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a valid int", value)
+		}
+		return f.checkRange(float64(number))
+	case TypeFloat:
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(number) {
+			return fmt.Errorf("%q is not a valid float", value)
+		}
+		return f.checkRange(number)
+	case TypeBool:
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("%q is not a valid bool", value)
+		}
+	case TypeDuration:
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a valid duration, e.g. 30s or 5m", value)
+		}
+		return f.checkRange(duration.Seconds())
+	case TypeURL:
+		u, err := url.Parse(value)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("%q is not a valid absolute url", value)
+		}
+	case TypeEnum:
+		for _, allowed := range f.Values {
+			if value == allowed {
+				return nil
+			}
+		}
+		return fmt.Errorf("%q is not one of [%s]", value, strings.Join(f.Values, ", "))
+	}
+	return nil
+}
+
+func (f *Field) checkRange(number float64) error {
+	if f.Min != nil && number < *f.Min {
+		return fmt.Errorf("must be at least %v", *f.Min)
+	}
+	if f.Max != nil && number > *f.Max {
+		return fmt.Errorf("must be at most %v", *f.Max)
+	}
+	return nil
+}
+
+func (s *Schema) keys() []string {
+	keys := make([]string, 0, len(s.Fields))
+	for key := range s.Fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,105 @@
+package schema
+
+import (
+	"errors"
+	"stoo-kv/internal/store"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		schema  string
+		wantErr string
+	}{
+		{name: "type names", schema: `{"fields": {"port": "int", "debug": "bool", "url": "url"}}`},
+		{name: "field objects", schema: `{"fields": {"port": {"type": "int", "min": 1, "max": 65535, "pattern": "^[0-9]+$"}, "level": {"type": "enum", "values": ["info"]}}}`},
+		{name: "unsupported type", schema: `{"fields": {"port": "number"}}`, wantErr: `validation failed: port: unsupported type "number"`},
+		{name: "enum without values", schema: `{"fields": {"level": "enum"}}`, wantErr: "validation failed: level: enum requires at least one value"},
+		{name: "min of a string", schema: `{"fields": {"name": {"type": "string", "min": 1}}}`, wantErr: "validation failed: name: min and max only apply to int, float and duration fields, not string"},
+		{name: "max of an enum", schema: `{"fields": {"level": {"type": "enum", "values": ["info"], "max": 1}}}`, wantErr: "validation failed: level: min and max only apply to int, float and duration fields, not enum"},
+		{name: "min above max", schema: `{"fields": {"port": {"type": "int", "min": 10, "max": 1}}}`, wantErr: "validation failed: port: min 10 is greater than max 1"},
+		{name: "invalid pattern", schema: `{"fields": {"name": {"type": "string", "pattern": "("}}}`, wantErr: "validation failed: name: invalid pattern: error parsing regexp: missing closing ): `(`"},
+		{name: "missing field", schema: `{"fields": {"name": null}}`, wantErr: "validation failed: name: field definition is missing"},
+		{name: "not json", schema: `{`, wantErr: "unexpected end of JSON input"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Parse([]byte(test.schema))
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Parse: %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != test.wantErr {
+				t.Fatalf("got error %v, want %s", err, test.wantErr)
+			}
+			if !errors.Is(err, store.ErrInvalid) {
+				t.Errorf("error %v does not match ErrInvalid", err)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	s, err := Parse([]byte(`{"strict": true, "fields": {
+		"port": {"type": "int", "min": 1, "max": 65535},
+		"ratio": {"type": "float", "max": 1},
+		"debug": "bool",
+		"timeout": {"type": "duration", "max": 60},
+		"url": "url",
+		"level": {"type": "enum", "values": ["debug", "info"]},
+		"name": {"type": "string", "pattern": "^[a-z]+$"},
+		"code": {"type": "int", "pattern": "^[0-9]{3}$"}
+	}}`))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	tests := []struct {
+		key     string
+		value   string
+		wantErr string
+	}{
+		{key: "port", value: "8080"},
+		{key: "port", value: "0", wantErr: "must be at least 1"},
+		{key: "port", value: "65536", wantErr: "must be at most 65535"},
+		{key: "port", value: "ten", wantErr: `"ten" is not a valid int`},
+		{key: "port", value: "1.5", wantErr: `"1.5" is not a valid int`},
+		{key: "ratio", value: "0.5"},
+		{key: "ratio", value: "NaN", wantErr: `"NaN" is not a valid float`},
+		{key: "ratio", value: "2", wantErr: "must be at most 1"},
+		{key: "debug", value: "true"},
+		{key: "debug", value: "yes", wantErr: `"yes" is not a valid bool`},
+		{key: "timeout", value: "30s"},
+		{key: "timeout", value: "2m", wantErr: "must be at most 60"},
+		{key: "timeout", value: "30", wantErr: `"30" is not a valid duration, e.g. 30s or 5m`},
+		{key: "url", value: "https://example.com/path"},
+		{key: "url", value: "example.com", wantErr: `"example.com" is not a valid absolute url`},
+		{key: "level", value: "info"},
+		{key: "level", value: "trace", wantErr: `"trace" is not one of [debug, info]`},
+		{key: "name", value: "app"},
+		{key: "name", value: "App", wantErr: `"App" does not match pattern ^[a-z]+$`},
+		{key: "code", value: "404"},
+		{key: "code", value: "4040", wantErr: `"4040" does not match pattern ^[0-9]{3}$`},
+		{key: "undeclared", value: "x", wantErr: "key is not declared in the namespace schema"},
+	}
+	for _, test := range tests {
+		t.Run(test.key+"="+test.value, func(t *testing.T) {
+			err := s.Validate(test.key, test.value)
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validate: %v", err)
+				}
+				return
+			}
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) || len(validationErr.Errors) != 1 {
+				t.Fatalf("got error %v, want a single field error", err)
+			}
+			if got := validationErr.Errors[0]; got.Field != test.key || got.Message != test.wantErr {
+				t.Errorf("got %s: %s, want %s: %s", got.Field, got.Message, test.key, test.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,235 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"stoo-kv/config"
+	"stoo-kv/internal/crypto"
+	"stoo-kv/internal/store"
+	"stoo-kv/internal/webhook"
+	"strings"
+	"sync"
+	"time"
+)
+
+const leaseProfile = "leases"
+
+// Lease tracks generated values and when they expire.
+type Lease struct {
+	Namespace string     `json:"namespace"`
+	Profile   string     `json:"profile"`
+	Spec      *Spec      `json:"spec"`
+	Keys      []string   `json:"keys"`
+	IssuedAt  time.Time  `json:"issued_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+func (l *Lease) expired(now time.Time) bool {
+	return l.ExpiresAt != nil && !now.Before(*l.ExpiresAt)
+}
+
+// Engine generates secrets, stores them encrypted and rotates or revokes them when their lease expires.
+type Engine struct {
+	storage store.Store
+	config  *config.Config
+	sender  *webhook.Sender
+	mu      sync.Mutex
+}
+
+func NewEngine(storage store.Store, config *config.Config, sender *webhook.Sender) *Engine {
+	return &Engine{storage: storage, config: config, sender: sender}
+}
+
+// Generate creates the values of a spec, stores them encrypted and returns the lease with the plain values.
+func (e *Engine) Generate(namespace, profile string, spec *Spec) (*Lease, map[string]string, error) {
+	if err := spec.Validate(); err != nil {
+		return nil, nil, store.Invalid(err)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.issue(namespace, profile, spec)
+}
+
+func (e *Engine) issue(namespace, profile string, spec *Spec) (*Lease, map[string]string, error) {
+	values, err := Generate(spec)
+	if err != nil {
+		return nil, nil, err
+	}
+	for key, value := range values {
+		if err := e.StoreSecret(namespace, profile, key, value); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	ttl, _ := ParseDuration(spec.LeaseTTL)
+	lease := &Lease{Namespace: namespace, Profile: profile, Spec: spec, IssuedAt: time.Now().UTC()}
+	if ttl > 0 {
+		expiresAt := lease.IssuedAt.Add(ttl)
+		lease.ExpiresAt = &expiresAt
+	}
+	for key := range values {
+		lease.Keys = append(lease.Keys, key)
+	}
+	sort.Strings(lease.Keys)
+	return lease, values, e.saveLease(lease)
+}
+
+// StoreSecret encrypts a value and stores it the same way as the secrets API does.
+func (e *Engine) StoreSecret(namespace, profile, key, value string) error {
+	sealed, err := crypto.Seal(value, e.config.Application.EncryptKey, e.config.Application.EncryptPrefix)
+	if err != nil {
+		return err
+	}
+	return e.storage.Set(fmt.Sprintf("%s::%s::%s", namespace, profile, key), sealed)
+}
+
+func (e *Engine) Leases(namespace, profile string) ([]*Lease, error) {
+	leases, err := e.allLeases()
+	if err != nil {
+		return nil, err
+	}
+	var matched []*Lease
+	for _, lease := range leases {
+		if lease.Namespace == namespace && lease.Profile == profile {
+			matched = append(matched, lease)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool { return matched[i].Spec.Key < matched[j].Spec.Key })
+	return matched, nil
+}
+
+func (e *Engine) Lease(namespace, profile, key string) (*Lease, error) {
+	data, err := e.storage.Get(store.SystemKey(leaseProfile, leaseID(namespace, profile, key)))
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	lease := &Lease{}
+	return lease, json.Unmarshal([]byte(data), lease)
+}
+
+// Renew extends a lease by its TTL from now, keeping the generated values.
+func (e *Engine) Renew(namespace, profile, key string) (*Lease, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	lease, err := e.Lease(namespace, profile, key)
+	if err != nil || lease == nil {
+		return nil, err
+	}
+	ttl, _ := ParseDuration(lease.Spec.LeaseTTL)
+	if ttl > 0 {
+		expiresAt := time.Now().UTC().Add(ttl)
+		lease.ExpiresAt = &expiresAt
+	}
+	return lease, e.saveLease(lease)
+}
+
+// Rotate regenerates the values of a lease right away.
+func (e *Engine) Rotate(namespace, profile, key string) (*Lease, map[string]string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	lease, err := e.Lease(namespace, profile, key)
+	if err != nil || lease == nil {
+		return nil, nil, err
+	}
+	return e.issue(namespace, profile, lease.Spec)
+}
+
+// Revoke removes the generated values along with their lease.
+func (e *Engine) Revoke(namespace, profile, key string) (bool, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	lease, err := e.Lease(namespace, profile, key)
+	if err != nil || lease == nil {
+		return false, err
+	}
+	return true, e.revoke(lease)
+}
+
+func (e *Engine) revoke(lease *Lease) error {
+	for _, key := range lease.Keys {
+		if err := store.Remove(e.storage, fmt.Sprintf("%s::%s::%s", lease.Namespace, lease.Profile, key)); err != nil {
+			return err
+		}
+	}
+	return store.Remove(e.storage, store.SystemKey(leaseProfile, leaseID(lease.Namespace, lease.Profile, lease.Spec.Key)))
+}
+
+// Run checks the leases and rotation policies on every interval until the context is done.
+func (e *Engine) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			e.expire(time.Now())
+			e.mu.Lock()
+			notices := e.rotateDue(time.Now())
+			e.mu.Unlock()
+			e.notify(notices...)
+		}
+	}
+}
+
+func (e *Engine) expire(now time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	leases, err := e.allLeases()
+	if err != nil {
+		log.Printf("Failed to read secret leases: %v", err)
+		return
+	}
+	for _, lease := range leases {
+		if !lease.expired(now) {
+			continue
+		}
+		if lease.Spec.AutoRotate {
+			log.Printf("Rotating expired secret %s::%s::%s", lease.Namespace, lease.Profile, lease.Spec.Key)
+			_, _, err = e.issue(lease.Namespace, lease.Profile, lease.Spec)
+		} else {
+			log.Printf("Revoking expired secret %s::%s::%s", lease.Namespace, lease.Profile, lease.Spec.Key)
+			err = e.revoke(lease)
+		}
+		if err != nil {
+			log.Printf("Failed to process expired secret %s: %v", lease.Spec.Key, err)
+		}
+	}
+}
+
+func (e *Engine) saveLease(lease *Lease) error {
+	data, err := json.Marshal(lease)
+	if err != nil {
+		return err
+	}
+	return e.storage.Set(store.SystemKey(leaseProfile, leaseID(lease.Namespace, lease.Profile, lease.Spec.Key)), string(data))
+}
+
+func (e *Engine) allLeases() ([]*Lease, error) {
+	values, err := e.storage.GetByNameSpaceAndProfile(store.SystemNamespace, leaseProfile)
+	if err != nil {
+		return nil, err
+	}
+	leases := make([]*Lease, 0, len(values))
+	for id, data := range values {
+		lease := &Lease{}
+		if err := json.Unmarshal([]byte(data), lease); err != nil {
+			log.Printf("Skipping invalid secret lease %s: %v", id, err)
+			continue
+		}
+		leases = append(leases, lease)
+	}
+	return leases, nil
+}
+
+// leaseID names a lease after its key path; "::" separates key parts in storage, so it is avoided.
+func leaseID(namespace, profile, key string) string {
+	return strings.Join([]string{namespace, profile, key}, "/")
+}
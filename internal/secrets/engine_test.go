@@ -0,0 +1,170 @@
+package secrets
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"stoo-kv/config"
+	"stoo-kv/internal/provider"
+	"stoo-kv/internal/store"
+	"stoo-kv/internal/webhook"
+	"testing"
+	"time"
+)
+
+func newEngine(t *testing.T) (*Engine, store.Store) {
+	t.Helper()
+	cfg := &config.Config{Application: &config.ApplicationConfig{
+		EncryptKey:          "0123456789abcdef0123456789abcdef",
+		WebhookAllowedHosts: []string{"127.0.0.1"},
+	}}
+	storage := provider.NewMemory()
+	sender := webhook.NewSender(time.Second, func() []string { return cfg.Current().WebhookAllowedHosts })
+	return NewEngine(storage, cfg, sender), storage
+}
+
+func TestExpire(t *testing.T) {
+	tests := []struct {
+		name string
+		spec *Spec
+		// after is how long after issuing the leases are checked.
+		after       time.Duration
+		wantRotated bool
+		wantRevoked bool
+	}{
+		{name: "lease not expired", spec: &Spec{Key: "p", Type: TypePassword, LeaseTTL: "1h"}, after: 30 * time.Minute},
+		{name: "lease without ttl", spec: &Spec{Key: "p", Type: TypePassword}, after: 365 * 24 * time.Hour},
+		{name: "expired lease is revoked", spec: &Spec{Key: "p", Type: TypePassword, LeaseTTL: "1h"}, after: 2 * time.Hour, wantRevoked: true},
+		{name: "expired lease is rotated", spec: &Spec{Key: "p", Type: TypePassword, LeaseTTL: "1h", AutoRotate: true}, after: 2 * time.Hour, wantRotated: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			engine, storage := newEngine(t)
+			issued, _, err := engine.Generate("app", "prod", test.spec)
+			if err != nil {
+				t.Fatalf("Generate: %v", err)
+			}
+			sealed, err := storage.Get("app::prod::p")
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+
+			engine.expire(time.Now().Add(test.after))
+			current, err := storage.Get("app::prod::p")
+			lease, leaseErr := engine.Lease("app", "prod", "p")
+			if leaseErr != nil {
+				t.Fatalf("Lease: %v", leaseErr)
+			}
+			switch {
+			case test.wantRevoked:
+				if !errors.Is(err, store.ErrNotFound) || lease != nil {
+					t.Errorf("got value %q and lease %v, want both revoked", current, lease)
+				}
+			case test.wantRotated:
+				if err != nil || current == sealed {
+					t.Errorf("got value %q, %v; want a new value", current, err)
+				}
+				if lease == nil || !lease.ExpiresAt.After(*issued.ExpiresAt) {
+					t.Errorf("got lease %v, want one expiring after %v", lease, issued.ExpiresAt)
+				}
+			default:
+				if err != nil || current != sealed || lease == nil {
+					t.Errorf("got value %q, %v and lease %v; want them unchanged", current, err, lease)
+				}
+			}
+		})
+	}
+}
+
+func TestSetRotationSchedule(t *testing.T) {
+	engine, storage := newEngine(t)
+	before := time.Now().UTC()
+	policy, err := engine.SetRotation("app", "prod", &RotationPolicy{Key: "missing", Interval: "30d"})
+	if err != nil {
+		t.Fatalf("SetRotation: %v", err)
+	}
+	if policy.NextRotationAt.After(time.Now().UTC()) {
+		t.Errorf("got next rotation %v for a missing secret, want now", policy.NextRotationAt)
+	}
+
+	if err := storage.Set("app::prod::existing", "value"); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	policy, err = engine.SetRotation("app", "prod", &RotationPolicy{Key: "existing", Interval: "30d"})
+	if err != nil {
+		t.Fatalf("SetRotation: %v", err)
+	}
+	if want := before.Add(30 * 24 * time.Hour); policy.NextRotationAt.Before(want) {
+		t.Errorf("got next rotation %v, want one interval from now, %v", policy.NextRotationAt, want)
+	}
+
+	invalid := []*RotationPolicy{
+		{Key: "p", Interval: "-5d"},
+		{Key: "p", Interval: "30d", GracePeriod: "-1h"},
+		{Key: "p", Interval: "30d", WebhookURL: "http://169.254.169.254/latest"},
+		{Key: "p", Interval: "30d", Generator: &Spec{Type: TypeRSA, Bits: 1024}},
+	}
+	for _, policy := range invalid {
+		if _, err := engine.SetRotation("app", "prod", policy); !errors.Is(err, store.ErrInvalid) {
+			t.Errorf("SetRotation %+v: got %v, want ErrInvalid", policy, err)
+		}
+	}
+}
+
+func TestRotateDue(t *testing.T) {
+	engine, storage := newEngine(t)
+	events := make(chan *RotationEvent, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The webhook is posted once the engine is unlocked.
+		if !engine.mu.TryLock() {
+			t.Errorf("webhook posted while the engine is locked")
+		} else {
+			engine.mu.Unlock()
+		}
+		event := &RotationEvent{}
+		if err := json.NewDecoder(r.Body).Decode(event); err != nil {
+			t.Errorf("decoding the webhook: %v", err)
+		}
+		events <- event
+	}))
+	defer server.Close()
+
+	if err := storage.Set("app::prod::db", "old"); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if _, err := engine.SetRotation("app", "prod", &RotationPolicy{Key: "db", Interval: "1h", GracePeriod: "10m", WebhookURL: server.URL}); err != nil {
+		t.Fatalf("SetRotation: %v", err)
+	}
+
+	now := time.Now()
+	engine.mu.Lock()
+	if notices := engine.rotateDue(now); len(notices) != 0 {
+		t.Errorf("rotated %d secrets before their interval", len(notices))
+	}
+	notices := engine.rotateDue(now.Add(time.Hour + time.Minute))
+	engine.mu.Unlock()
+	engine.notify(notices...)
+
+	event := <-events
+	if len(event.Keys) != 1 || event.Keys[0] != "db" || len(event.PreviousKeys) != 1 || event.PreviousKeys[0] != "db.previous" {
+		t.Errorf("got event %+v, want db rotated and db.previous kept", event)
+	}
+	if previous, err := storage.Get("app::prod::db.previous"); err != nil || previous != "old" {
+		t.Errorf("got previous value %q, %v; want old", previous, err)
+	}
+	if current, err := storage.Get("app::prod::db"); err != nil || current == "old" {
+		t.Errorf("got value %q, %v; want a new value", current, err)
+	}
+
+	// Once the grace period is over the previous value is removed.
+	engine.mu.Lock()
+	notices = engine.rotateDue(now.Add(time.Hour + 20*time.Minute))
+	engine.mu.Unlock()
+	if len(notices) != 0 {
+		t.Errorf("rotated %d secrets before their interval", len(notices))
+	}
+	if _, err := storage.Get("app::prod::db.previous"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("previous value after the grace period: got %v, want ErrNotFound", err)
+	}
+}
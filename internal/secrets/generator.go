@@ -0,0 +1,230 @@
+package secrets
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+const (
+	TypePassword    = "password"
+	TypeToken       = "token"
+	TypeRSA         = "rsa"
+	TypeECDSA       = "ecdsa"
+	TypeCertificate = "certificate"
+)
+
+const (
+	// maxLength bounds the characters of a password and the bytes of a token.
+	maxLength = 1024
+	minBits   = 2048
+	maxBits   = 8192
+)
+
+const (
+	passwordCharset       = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	passwordSymbolCharset = passwordCharset + "!@#%^&*()-_=+[]{}<>?"
+)
+
+// Spec describes a secret to generate at a key path.
+type Spec struct {
+	Key  string `json:"key"`
+	Type string `json:"type"`
+	// Length of a password in characters or of a token in bytes.
+	Length  int  `json:"length,omitempty"`
+	Symbols bool `json:"symbols,omitempty"`
+	// Bits selects an RSA key, Curve (P256, P384 or P521) an ECDSA key.
+	Bits       int      `json:"bits,omitempty"`
+	Curve      string   `json:"curve,omitempty"`
+	CommonName string   `json:"common_name,omitempty"`
+	DNSNames   []string `json:"dns_names,omitempty"`
+	// Validity of a certificate, the lease TTL or one year by default.
+	Validity string `json:"validity,omitempty"`
+	// LeaseTTL is how long the generated values live, e.g. 720h or 30d; empty never expires.
+	LeaseTTL string `json:"lease_ttl,omitempty"`
+	// AutoRotate regenerates the values when the lease expires instead of revoking them.
+	AutoRotate bool `json:"auto_rotate,omitempty"`
+}
+
+func (s *Spec) Validate() error {
+	if s.Key == "" {
+		return fmt.Errorf("key cannot be empty")
+	}
+	switch s.Type {
+	case TypePassword, TypeToken, TypeRSA, TypeECDSA, TypeCertificate:
+	default:
+		return fmt.Errorf("unsupported secret type %q", s.Type)
+	}
+	if s.Length < 0 || s.Length > maxLength {
+		return fmt.Errorf("length must be between 1 and %d", maxLength)
+	}
+	if s.Bits != 0 && (s.Bits < minBits || s.Bits > maxBits) {
+		return fmt.Errorf("bits must be between %d and %d", minBits, maxBits)
+	}
+	if _, err := ParseDuration(s.LeaseTTL); err != nil {
+		return fmt.Errorf("invalid lease_ttl: %v", err)
+	}
+	if _, err := ParseDuration(s.Validity); err != nil {
+		return fmt.Errorf("invalid validity: %v", err)
+	}
+	if s.Type == TypeCertificate && s.CommonName == "" && len(s.DNSNames) == 0 {
+		return fmt.Errorf("certificate requires common_name or dns_names")
+	}
+	return nil
+}
+
+// Generate creates the values of a spec keyed by their key path. Key pairs are stored as the
+// private key at the key and the public key at "<key>.pub"; certificates as the certificate at
+// the key and its private key at "<key>.key".
+func Generate(spec *Spec) (map[string]string, error) {
+	switch spec.Type {
+	case TypePassword:
+		password, err := generatePassword(withDefault(spec.Length, 32), spec.Symbols)
+		return map[string]string{spec.Key: password}, err
+	case TypeToken:
+		token := make([]byte, withDefault(spec.Length, 32))
+		if _, err := rand.Read(token); err != nil {
+			return nil, err
+		}
+		return map[string]string{spec.Key: base64.RawURLEncoding.EncodeToString(token)}, nil
+	case TypeRSA, TypeECDSA:
+		key, err := generateKey(spec)
+		if err != nil {
+			return nil, err
+		}
+		privatePem, publicPem, err := encodeKeyPair(key)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]string{spec.Key: privatePem, spec.Key + ".pub": publicPem}, nil
+	case TypeCertificate:
+		return generateCertificate(spec)
+	default:
+		return nil, fmt.Errorf("unsupported secret type %q", spec.Type)
+	}
+}
+
+func generatePassword(length int, symbols bool) (string, error) {
+	charset := passwordCharset
+	if symbols {
+		charset = passwordSymbolCharset
+	}
+	password := make([]byte, length)
+	for i := range password {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(charset))))
+		if err != nil {
+			return "", err
+		}
+		password[i] = charset[n.Int64()]
+	}
+	return string(password), nil
+}
+
+func generateKey(spec *Spec) (crypto.Signer, error) {
+	if spec.Type == TypeRSA || (spec.Type == TypeCertificate && spec.Bits > 0) {
+		return rsa.GenerateKey(rand.Reader, withDefault(spec.Bits, 2048))
+	}
+	var curve elliptic.Curve
+	switch spec.Curve {
+	case "", "P256":
+		curve = elliptic.P256()
+	case "P384":
+		curve = elliptic.P384()
+	case "P521":
+		curve = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("unsupported curve %q", spec.Curve)
+	}
+	return ecdsa.GenerateKey(curve, rand.Reader)
+}
+
+func encodeKeyPair(key crypto.Signer) (string, string, error) {
+	privateDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return "", "", err
+	}
+	publicDer, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return "", "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDer})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDer})), nil
+}
+
+func generateCertificate(spec *Spec) (map[string]string, error) {
+	key, err := generateKey(spec)
+	if err != nil {
+		return nil, err
+	}
+	validity, _ := ParseDuration(spec.Validity)
+	if validity == 0 {
+		validity, _ = ParseDuration(spec.LeaseTTL)
+	}
+	if validity == 0 {
+		validity = 365 * 24 * time.Hour
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: spec.CommonName},
+		DNSNames:              spec.DNSNames,
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+	}
+	certDer, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	privatePem, _, err := encodeKeyPair(key)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		spec.Key:          string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDer})),
+		spec.Key + ".key": privatePem,
+	}, nil
+}
+
+// ParseDuration extends time.ParseDuration with a day unit, e.g. 90d. An empty value is zero, any
+// other value must be positive.
+func ParseDuration(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	var days int
+	duration, err := time.ParseDuration(value)
+	if _, scanErr := fmt.Sscanf(value, "%dd", &days); scanErr == nil && fmt.Sprintf("%dd", days) == value {
+		duration, err = time.Duration(days)*24*time.Hour, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if duration <= 0 {
+		return 0, fmt.Errorf("duration %s must be positive", value)
+	}
+	return duration, nil
+}
+
+func withDefault(value, fallback int) int {
+	if value == 0 {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,173 @@
+package secrets
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSpecValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    Spec
+		wantErr string
+	}{
+		{name: "password", spec: Spec{Key: "p", Type: TypePassword, Length: 1024}},
+		{name: "rsa", spec: Spec{Key: "k", Type: TypeRSA, Bits: 4096}},
+		{name: "missing key", spec: Spec{Type: TypePassword}, wantErr: "key cannot be empty"},
+		{name: "unsupported type", spec: Spec{Key: "k", Type: "pin"}, wantErr: `unsupported secret type "pin"`},
+		{name: "negative length", spec: Spec{Key: "p", Type: TypePassword, Length: -1}, wantErr: "length must be between 1 and 1024"},
+		{name: "long password", spec: Spec{Key: "p", Type: TypePassword, Length: 1025}, wantErr: "length must be between 1 and 1024"},
+		{name: "long token", spec: Spec{Key: "t", Type: TypeToken, Length: 1 << 30}, wantErr: "length must be between 1 and 1024"},
+		{name: "weak rsa key", spec: Spec{Key: "k", Type: TypeRSA, Bits: 1024}, wantErr: "bits must be between 2048 and 8192"},
+		{name: "large rsa key", spec: Spec{Key: "k", Type: TypeRSA, Bits: 16384}, wantErr: "bits must be between 2048 and 8192"},
+		{name: "weak certificate key", spec: Spec{Key: "c", Type: TypeCertificate, CommonName: "app", Bits: 512}, wantErr: "bits must be between 2048 and 8192"},
+		{name: "negative lease", spec: Spec{Key: "p", Type: TypePassword, LeaseTTL: "-5d"}, wantErr: "invalid lease_ttl: duration -5d must be positive"},
+		{name: "invalid validity", spec: Spec{Key: "c", Type: TypeCertificate, CommonName: "app", Validity: "soon"}, wantErr: "invalid validity"},
+		{name: "certificate without names", spec: Spec{Key: "c", Type: TypeCertificate}, wantErr: "certificate requires common_name or dns_names"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.spec.Validate()
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validate: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.HasPrefix(err.Error(), test.wantErr) {
+				t.Fatalf("got error %v, want %s", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{value: "", want: 0},
+		{value: "90d", want: 90 * 24 * time.Hour},
+		{value: "1h30m", want: 90 * time.Minute},
+		{value: "-5d", wantErr: true},
+		{value: "-1h", wantErr: true},
+		{value: "0d", wantErr: true},
+		{value: "0s", wantErr: true},
+		{value: "5 days", wantErr: true},
+		{value: "d", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			got, err := ParseDuration(test.value)
+			if (err != nil) != test.wantErr || got != test.want {
+				t.Errorf("got %v, %v; want %v, error %v", got, err, test.want, test.wantErr)
+			}
+		})
+	}
+}
+
+func decodePem(t *testing.T, data, wantType string) []byte {
+	t.Helper()
+	block, rest := pem.Decode([]byte(data))
+	if block == nil || block.Type != wantType || len(rest) != 0 {
+		t.Fatalf("got %q, want a single %s PEM block", data, wantType)
+	}
+	return block.Bytes
+}
+
+func TestGenerate(t *testing.T) {
+	t.Run("password", func(t *testing.T) {
+		values, err := Generate(&Spec{Key: "p", Type: TypePassword, Length: 64})
+		if err != nil {
+			t.Fatalf("Generate: %v", err)
+		}
+		if password := values["p"]; len(password) != 64 || strings.Trim(password, passwordCharset) != "" {
+			t.Errorf("got password %q, want 64 characters of %s", password, passwordCharset)
+		}
+		values, _ = Generate(&Spec{Key: "p", Type: TypePassword})
+		if len(values["p"]) != 32 {
+			t.Errorf("got default password %q, want 32 characters", values["p"])
+		}
+	})
+	t.Run("password with symbols", func(t *testing.T) {
+		values, err := Generate(&Spec{Key: "p", Type: TypePassword, Length: 512, Symbols: true})
+		if err != nil {
+			t.Fatalf("Generate: %v", err)
+		}
+		if password := values["p"]; strings.Trim(password, passwordSymbolCharset) != "" || strings.Trim(password, passwordCharset) == "" {
+			t.Errorf("got password %q, want symbols of %s", password, passwordSymbolCharset)
+		}
+	})
+	t.Run("token", func(t *testing.T) {
+		values, err := Generate(&Spec{Key: "t", Type: TypeToken, Length: 48})
+		if err != nil {
+			t.Fatalf("Generate: %v", err)
+		}
+		if token, err := base64.RawURLEncoding.DecodeString(values["t"]); err != nil || len(token) != 48 {
+			t.Errorf("got token %q, %v; want 48 base64url bytes", values["t"], err)
+		}
+	})
+	t.Run("rsa", func(t *testing.T) {
+		values, err := Generate(&Spec{Key: "k", Type: TypeRSA})
+		if err != nil {
+			t.Fatalf("Generate: %v", err)
+		}
+		key, err := x509.ParsePKCS8PrivateKey(decodePem(t, values["k"], "PRIVATE KEY"))
+		if err != nil {
+			t.Fatalf("ParsePKCS8PrivateKey: %v", err)
+		}
+		if rsaKey, ok := key.(*rsa.PrivateKey); !ok || rsaKey.N.BitLen() != 2048 {
+			t.Errorf("got %T, want a 2048 bits RSA key", key)
+		}
+		if _, err := x509.ParsePKIXPublicKey(decodePem(t, values["k.pub"], "PUBLIC KEY")); err != nil {
+			t.Errorf("ParsePKIXPublicKey: %v", err)
+		}
+	})
+	t.Run("ecdsa", func(t *testing.T) {
+		values, err := Generate(&Spec{Key: "k", Type: TypeECDSA, Curve: "P384"})
+		if err != nil {
+			t.Fatalf("Generate: %v", err)
+		}
+		key, err := x509.ParsePKCS8PrivateKey(decodePem(t, values["k"], "PRIVATE KEY"))
+		if err != nil {
+			t.Fatalf("ParsePKCS8PrivateKey: %v", err)
+		}
+		if ecdsaKey, ok := key.(*ecdsa.PrivateKey); !ok || ecdsaKey.Curve != elliptic.P384() {
+			t.Errorf("got %T, want a P384 key", key)
+		}
+		if _, err := Generate(&Spec{Key: "k", Type: TypeECDSA, Curve: "P224"}); err == nil {
+			t.Errorf("Generate with curve P224 succeeded")
+		}
+	})
+	t.Run("certificate", func(t *testing.T) {
+		values, err := Generate(&Spec{Key: "c", Type: TypeCertificate, CommonName: "app", DNSNames: []string{"app.local"}, Validity: "30d"})
+		if err != nil {
+			t.Fatalf("Generate: %v", err)
+		}
+		certificate, err := x509.ParseCertificate(decodePem(t, values["c"], "CERTIFICATE"))
+		if err != nil {
+			t.Fatalf("ParseCertificate: %v", err)
+		}
+		if err := certificate.VerifyHostname("app.local"); err != nil {
+			t.Errorf("VerifyHostname: %v", err)
+		}
+		if validity := certificate.NotAfter.Sub(certificate.NotBefore); validity < 30*24*time.Hour || validity > 30*24*time.Hour+2*time.Minute {
+			t.Errorf("got validity %v, want 30 days", validity)
+		}
+		key, err := x509.ParsePKCS8PrivateKey(decodePem(t, values["c.key"], "PRIVATE KEY"))
+		if err != nil {
+			t.Fatalf("ParsePKCS8PrivateKey: %v", err)
+		}
+		if !key.(*ecdsa.PrivateKey).PublicKey.Equal(certificate.PublicKey) {
+			t.Errorf("the private key does not match the certificate")
+		}
+	})
+}
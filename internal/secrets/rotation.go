@@ -0,0 +1,327 @@
+package secrets
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"stoo-kv/internal/crypto"
+	"stoo-kv/internal/store"
+	"stoo-kv/internal/webhook"
+	"time"
+)
+
+const (
+	rotationProfile = "rotations"
+	previousSuffix  = ".previous"
+	redactedSecret  = "********"
+
+	EventSecretRotated = "secret.rotated"
+)
+
+// RotationPolicy regenerates a secret on a schedule, keeping the previous value at "<key>.previous"
+// during the grace period and notifying the owning service through a signed webhook.
+type RotationPolicy struct {
+	Namespace   string `json:"namespace"`
+	Profile     string `json:"profile"`
+	Key         string `json:"key"`
+	Interval    string `json:"interval"`
+	GracePeriod string `json:"grace_period,omitempty"`
+	// Generator describes the new values, a 32 characters password by default.
+	Generator     *Spec  `json:"generator,omitempty"`
+	WebhookURL    string `json:"webhook_url,omitempty"`
+	WebhookSecret string `json:"webhook_secret,omitempty"`
+
+	LastRotatedAt     *time.Time `json:"last_rotated_at,omitempty"`
+	NextRotationAt    *time.Time `json:"next_rotation_at,omitempty"`
+	PreviousKeys      []string   `json:"previous_keys,omitempty"`
+	PreviousExpiresAt *time.Time `json:"previous_expires_at,omitempty"`
+}
+
+// rotationNotice is the webhook announcing a rotation, posted once the engine lock is released so
+// that a slow receiver does not hold up the engine.
+type rotationNotice struct {
+	url    string
+	secret string
+	key    string
+	event  *RotationEvent
+}
+
+type RotationEvent struct {
+	Event             string     `json:"event"`
+	Namespace         string     `json:"namespace"`
+	Profile           string     `json:"profile"`
+	Keys              []string   `json:"keys"`
+	PreviousKeys      []string   `json:"previous_keys"`
+	RotatedAt         time.Time  `json:"rotated_at"`
+	PreviousExpiresAt *time.Time `json:"previous_expires_at,omitempty"`
+}
+
+func (p *RotationPolicy) validate() error {
+	if p.Key == "" {
+		return fmt.Errorf("key cannot be empty")
+	}
+	interval, err := ParseDuration(p.Interval)
+	if err != nil || interval <= 0 {
+		return fmt.Errorf("interval must be a positive duration, e.g. 90d")
+	}
+	if _, err := ParseDuration(p.GracePeriod); err != nil {
+		return fmt.Errorf("invalid grace_period: %v", err)
+	}
+	if p.Generator == nil {
+		p.Generator = &Spec{Type: TypePassword}
+	}
+	p.Generator.Key = p.Key
+	return p.Generator.Validate()
+}
+
+// SetRotation saves a rotation policy. The first rotation happens one interval from now, or on the
+// next check when the secret does not exist yet.
+func (e *Engine) SetRotation(namespace, profile string, policy *RotationPolicy) (*RotationPolicy, error) {
+	policy.Namespace, policy.Profile = namespace, profile
+	if err := policy.validate(); err != nil {
+		return nil, store.Invalid(err)
+	}
+	if policy.WebhookURL != "" {
+		if err := webhook.CheckURL(policy.WebhookURL, e.config.Current().WebhookAllowedHosts); err != nil {
+			return nil, store.Invalid(fmt.Errorf("invalid webhook_url: %v", err))
+		}
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	_, err := e.storage.Get(fmt.Sprintf("%s::%s::%s", namespace, profile, policy.Key))
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return nil, err
+	}
+	next := time.Now().UTC()
+	if err == nil {
+		interval, _ := ParseDuration(policy.Interval)
+		next = next.Add(interval)
+	}
+	policy.NextRotationAt = &next
+
+	current, err := e.rotation(namespace, profile, policy.Key)
+	if err != nil {
+		return nil, err
+	}
+	if current != nil {
+		policy.LastRotatedAt, policy.PreviousKeys, policy.PreviousExpiresAt = current.LastRotatedAt, current.PreviousKeys, current.PreviousExpiresAt
+	}
+	switch {
+	case policy.WebhookSecret == redactedSecret && current != nil:
+		policy.WebhookSecret = current.WebhookSecret
+	case policy.WebhookSecret != "":
+		ciphertext, err := crypto.Encrypt([]byte(policy.WebhookSecret), e.config.Application.EncryptKey)
+		if err != nil {
+			return nil, err
+		}
+		policy.WebhookSecret = hex.EncodeToString(ciphertext)
+	case current != nil:
+		policy.WebhookSecret = current.WebhookSecret
+	}
+	return policy.redacted(), e.saveRotation(policy)
+}
+
+func (e *Engine) Rotations(namespace, profile string) ([]*RotationPolicy, error) {
+	policies, err := e.allRotations()
+	if err != nil {
+		return nil, err
+	}
+	var matched []*RotationPolicy
+	for _, policy := range policies {
+		if policy.Namespace == namespace && policy.Profile == profile {
+			matched = append(matched, policy.redacted())
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool { return matched[i].Key < matched[j].Key })
+	return matched, nil
+}
+
+// RotateNow rotates the secret of a policy right away.
+func (e *Engine) RotateNow(namespace, profile, key string) (*RotationPolicy, error) {
+	policy, notice, err := e.rotateNow(namespace, profile, key)
+	if err != nil || policy == nil {
+		return nil, err
+	}
+	e.notify(notice)
+	return policy.redacted(), nil
+}
+
+func (e *Engine) rotateNow(namespace, profile, key string) (*RotationPolicy, *rotationNotice, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	policy, err := e.rotation(namespace, profile, key)
+	if err != nil || policy == nil {
+		return nil, nil, err
+	}
+	notice, err := e.rotate(policy, time.Now().UTC())
+	return policy, notice, err
+}
+
+func (e *Engine) DeleteRotation(namespace, profile, key string) (bool, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	policy, err := e.rotation(namespace, profile, key)
+	if err != nil || policy == nil {
+		return false, err
+	}
+	return true, store.Remove(e.storage, store.SystemKey(rotationProfile, leaseID(namespace, profile, key)))
+}
+
+// rotateDue rotates the secrets whose policies are due and returns the webhooks to post.
+func (e *Engine) rotateDue(now time.Time) []*rotationNotice {
+	policies, err := e.allRotations()
+	if err != nil {
+		log.Printf("Failed to read secret rotation policies: %v", err)
+		return nil
+	}
+	var notices []*rotationNotice
+	for _, policy := range policies {
+		if policy.PreviousExpiresAt != nil && !now.Before(*policy.PreviousExpiresAt) {
+			if err := e.dropPrevious(policy); err != nil {
+				log.Printf("Failed to remove previous value of %s: %v", policy.Key, err)
+			}
+		}
+		if policy.NextRotationAt != nil && now.Before(*policy.NextRotationAt) {
+			continue
+		}
+		log.Printf("Rotating secret %s::%s::%s", policy.Namespace, policy.Profile, policy.Key)
+		notice, err := e.rotate(policy, now.UTC())
+		if err != nil {
+			log.Printf("Failed to rotate secret %s: %v", policy.Key, err)
+			continue
+		}
+		notices = append(notices, notice)
+	}
+	return notices
+}
+
+// rotate stores new values of a policy and returns the webhook announcing them, if any.
+func (e *Engine) rotate(policy *RotationPolicy, now time.Time) (*rotationNotice, error) {
+	values, err := Generate(policy.Generator)
+	if err != nil {
+		return nil, err
+	}
+
+	event := &RotationEvent{Event: EventSecretRotated, Namespace: policy.Namespace, Profile: policy.Profile, RotatedAt: now}
+	grace, _ := ParseDuration(policy.GracePeriod)
+	for key, value := range values {
+		fullKey := fmt.Sprintf("%s::%s::%s", policy.Namespace, policy.Profile, key)
+		previous, err := e.storage.Get(fullKey)
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			return nil, err
+		}
+		if err == nil && grace > 0 {
+			if err := e.storage.Set(fullKey+previousSuffix, previous); err != nil {
+				return nil, err
+			}
+			event.PreviousKeys = append(event.PreviousKeys, key+previousSuffix)
+		}
+		if err := e.StoreSecret(policy.Namespace, policy.Profile, key, value); err != nil {
+			return nil, err
+		}
+		event.Keys = append(event.Keys, key)
+	}
+	sort.Strings(event.Keys)
+	sort.Strings(event.PreviousKeys)
+
+	interval, _ := ParseDuration(policy.Interval)
+	next := now.Add(interval)
+	policy.LastRotatedAt, policy.NextRotationAt = &now, &next
+	policy.PreviousKeys, policy.PreviousExpiresAt = event.PreviousKeys, nil
+	if len(event.PreviousKeys) > 0 {
+		expiresAt := now.Add(grace)
+		policy.PreviousExpiresAt, event.PreviousExpiresAt = &expiresAt, &expiresAt
+	}
+	if err := e.saveRotation(policy); err != nil {
+		return nil, err
+	}
+	if policy.WebhookURL == "" {
+		return nil, nil
+	}
+	return &rotationNotice{url: policy.WebhookURL, secret: e.webhookSecret(policy), key: policy.Key, event: event}, nil
+}
+
+// notify posts the webhooks of rotations; the engine lock must not be held.
+func (e *Engine) notify(notices ...*rotationNotice) {
+	for _, notice := range notices {
+		if notice == nil {
+			continue
+		}
+		if err := e.sender.Post(notice.url, notice.secret, EventSecretRotated, notice.event); err != nil {
+			log.Printf("Failed to notify rotation of %s: %v", notice.key, err)
+		}
+	}
+}
+
+// dropPrevious removes the previous values once the grace period is over.
+func (e *Engine) dropPrevious(policy *RotationPolicy) error {
+	for _, key := range policy.PreviousKeys {
+		if err := store.Remove(e.storage, fmt.Sprintf("%s::%s::%s", policy.Namespace, policy.Profile, key)); err != nil {
+			return err
+		}
+	}
+	policy.PreviousKeys, policy.PreviousExpiresAt = nil, nil
+	return e.saveRotation(policy)
+}
+
+func (e *Engine) webhookSecret(policy *RotationPolicy) string {
+	if policy.WebhookSecret == "" {
+		return e.config.Current().WebhookSigningSecret
+	}
+	secret, err := crypto.Decrypt([]byte(policy.WebhookSecret), e.config.Application.EncryptKey)
+	if err != nil {
+		log.Printf("Failed to decrypt webhook secret of %s: %v", policy.Key, err)
+		return e.config.Current().WebhookSigningSecret
+	}
+	return string(secret)
+}
+
+func (e *Engine) rotation(namespace, profile, key string) (*RotationPolicy, error) {
+	data, err := e.storage.Get(store.SystemKey(rotationProfile, leaseID(namespace, profile, key)))
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	policy := &RotationPolicy{}
+	return policy, json.Unmarshal([]byte(data), policy)
+}
+
+// saveRotation stores the policy, whose webhook secret is kept encrypted.
+func (e *Engine) saveRotation(policy *RotationPolicy) error {
+	data, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+	return e.storage.Set(store.SystemKey(rotationProfile, leaseID(policy.Namespace, policy.Profile, policy.Key)), string(data))
+}
+
+func (e *Engine) allRotations() ([]*RotationPolicy, error) {
+	values, err := e.storage.GetByNameSpaceAndProfile(store.SystemNamespace, rotationProfile)
+	if err != nil {
+		return nil, err
+	}
+	policies := make([]*RotationPolicy, 0, len(values))
+	for id, data := range values {
+		policy := &RotationPolicy{}
+		if err := json.Unmarshal([]byte(data), policy); err != nil {
+			log.Printf("Skipping invalid rotation policy %s: %v", id, err)
+			continue
+		}
+		policies = append(policies, policy)
+	}
+	return policies, nil
+}
+
+func (p *RotationPolicy) redacted() *RotationPolicy {
+	redacted := *p
+	if redacted.WebhookSecret != "" {
+		redacted.WebhookSecret = redactedSecret
+	}
+	return &redacted
+}
@@ -0,0 +1,297 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"log"
+	"stoo-kv/config"
+	"stoo-kv/internal/provider"
+	"sync"
+	"time"
+)
+
+// Replica is a storage of a ReplicatedStore, named by its storage type.
+type Replica struct {
+	Name  string
+	Store Store
+}
+
+// ReplicatedStore writes to a primary storage and mirrors the writes to secondary storages. Reads
+// go to the primary and, while it is unavailable, to the secondaries in order and then to the
+// snapshot. Reconcile repairs the secondaries that diverged from the primary, e.g. after a failed
+// or reordered mirrored write.
+type ReplicatedStore struct {
+	primary     Replica
+	secondaries []Replica
+	mirror      string
+	snapshot    *Snapshot
+}
+
+func NewReplicatedStore(primary Replica, secondaries []Replica, mirror string, snapshot *Snapshot) *ReplicatedStore {
+	return &ReplicatedStore{primary: primary, secondaries: secondaries, mirror: mirror, snapshot: snapshot}
+}
+
+func (r *ReplicatedStore) Set(key string, value any) error {
+	if err := r.primary.Store.Set(key, value); err != nil {
+		return err
+	}
+	r.mirrorWrite("set", key, func(s Store) error {
+		return s.Set(key, value)
+	})
+	return nil
+}
+
+// Delete removes the key from the secondaries too when the primary has no such key, as they may
+// still hold a copy.
+func (r *ReplicatedStore) Delete(key string) error {
+	err := r.primary.Store.Delete(key)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	r.mirrorWrite("delete", key, func(s Store) error {
+		return Remove(s, key)
+	})
+	return err
+}
+
+func (r *ReplicatedStore) Get(key string) (string, error) {
+	return fallback(r, func(s Store) (string, error) {
+		return s.Get(key)
+	})
+}
+
+func (r *ReplicatedStore) GetByNameSpaceAndProfile(namespace, profile string) (map[string]string, error) {
+	return fallback(r, func(s Store) (map[string]string, error) {
+		return s.GetByNameSpaceAndProfile(namespace, profile)
+	})
+}
+
+// List falls back like the other reads. Cursors are those of the storage that answered, so paging
+// through a fallback may restart when the primary is back.
+func (r *ReplicatedStore) List(namespace, profile string, options ListOptions) (*Page, error) {
+	return fallback(r, func(s Store) (*Page, error) {
+		return s.List(namespace, profile, options)
+	})
+}
+
+func (r *ReplicatedStore) Namespaces() (map[string][]string, error) {
+	return fallback(r, func(s Store) (map[string][]string, error) {
+		return s.Namespaces()
+	})
+}
+
+// mirrorWrite applies a write of the primary to the secondaries and the snapshot. A failed write
+// is logged and left to Reconcile.
+func (r *ReplicatedStore) mirrorWrite(operation, key string, write func(Store) error) {
+	if r.snapshot != nil {
+		_ = write(r.snapshot)
+	}
+	mirror := func() {
+		for _, secondary := range r.secondaries {
+			if err := write(secondary.Store); err != nil {
+				log.Printf("Failed to %s key %s in secondary storage %s: %v", operation, key, secondary.Name, err)
+			}
+		}
+	}
+	switch r.mirror {
+	case config.MirrorWritesSync:
+		mirror()
+	case config.MirrorWritesAsync:
+		go mirror()
+	}
+}
+
+func fallback[T any](r *ReplicatedStore, read func(Store) (T, error)) (T, error) {
+	result, err := read(r.primary.Store)
+	if err == nil || !unavailable(err) {
+		return result, err
+	}
+	for _, secondary := range r.secondaries {
+		if result, secondaryErr := read(secondary.Store); answered(secondaryErr) {
+			return result, secondaryErr
+		}
+	}
+	if r.snapshot != nil {
+		if result, snapshotErr := read(r.snapshot); answered(snapshotErr) {
+			return result, snapshotErr
+		}
+	}
+	return result, err
+}
+
+func unavailable(err error) bool {
+	return errors.Is(err, ErrUnavailable) || provider.IsTransient(err)
+}
+
+// answered reports whether a fallback read is an answer, including a key that does not exist.
+func answered(err error) bool {
+	return err == nil || errors.Is(err, ErrNotFound)
+}
+
+// Run reconciles the secondaries and saves the snapshot right away and then at every interval.
+func (r *ReplicatedStore) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := r.Reconcile(); err != nil {
+			log.Printf("Failed to reconcile storages: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Reconcile copies the keys of the primary to each secondary that misses them or holds another
+// value, deletes the keys only a secondary holds and saves the primary's keys as the snapshot. A
+// secondary is read before the primary, so keys written meanwhile are copied rather than deleted;
+// a write racing with a repair is repaired on the next round. Since listing the primary may miss
+// keys, a key is only deleted from a secondary once reading it from the primary finds nothing, and
+// never while the primary lists no keys at all.
+func (r *ReplicatedStore) Reconcile() error {
+	for _, secondary := range r.secondaries {
+		current, err := allValues(secondary.Store)
+		if err != nil {
+			log.Printf("Failed to read secondary storage %s: %v", secondary.Name, err)
+			continue
+		}
+		values, err := allValues(r.primary.Store)
+		if err != nil {
+			return err
+		}
+		if len(values) == 0 && len(current) > 0 {
+			log.Printf("Primary storage %s lists no keys, keeping the %d keys of secondary storage %s", r.primary.Name, len(current), secondary.Name)
+			continue
+		}
+		repaired, err := repair(r.primary.Store, secondary.Store, current, values)
+		if repaired > 0 {
+			log.Printf("Repaired %d keys of secondary storage %s", repaired, secondary.Name)
+		}
+		if err != nil {
+			log.Printf("Failed to reconcile secondary storage %s: %v", secondary.Name, err)
+		}
+	}
+	if r.snapshot == nil {
+		return nil
+	}
+	values, err := allValues(r.primary.Store)
+	if err != nil {
+		return err
+	}
+	r.snapshot.Replace(values)
+	return r.snapshot.Save()
+}
+
+// repair brings the keys of a secondary, current, to the values listed from the primary. The keys
+// missing from values are read from the primary, and added to values when it holds them.
+func repair(primary, secondary Store, current, values map[string]string) (int, error) {
+	repaired := 0
+	for key := range current {
+		if _, ok := values[key]; ok {
+			continue
+		}
+		value, err := primary.Get(key)
+		if err == nil {
+			values[key] = value
+			continue
+		}
+		if !errors.Is(err, ErrNotFound) {
+			return repaired, err
+		}
+		if err := Remove(secondary, key); err != nil {
+			return repaired, err
+		}
+		repaired++
+	}
+	for key, value := range values {
+		if existing, ok := current[key]; !ok || existing != value {
+			if err := secondary.Set(key, value); err != nil {
+				return repaired, err
+			}
+			repaired++
+		}
+	}
+	return repaired, nil
+}
+
+// lazyStore opens its storage on first use, so a primary that is down when the server starts does
+// not keep it from reading the secondaries and the snapshot.
+type lazyStore struct {
+	open  func() (Store, error)
+	mu    sync.Mutex
+	store Store
+}
+
+func (l *lazyStore) get() (Store, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.store == nil {
+		store, err := l.open()
+		if err != nil {
+			return nil, err
+		}
+		l.store = store
+	}
+	return l.store, nil
+}
+
+// WithContext binds the operations of the storage to ctx once it is open. Opening it is not bound.
+func (l *lazyStore) WithContext(ctx context.Context) Store {
+	return &lazyStore{open: func() (Store, error) {
+		store, err := l.get()
+		if err != nil {
+			return nil, err
+		}
+		return bind(store, ctx), nil
+	}}
+}
+
+func (l *lazyStore) Set(key string, value any) error {
+	store, err := l.get()
+	if err != nil {
+		return err
+	}
+	return store.Set(key, value)
+}
+
+func (l *lazyStore) Get(key string) (string, error) {
+	store, err := l.get()
+	if err != nil {
+		return "", err
+	}
+	return store.Get(key)
+}
+
+func (l *lazyStore) Delete(key string) error {
+	store, err := l.get()
+	if err != nil {
+		return err
+	}
+	return store.Delete(key)
+}
+
+func (l *lazyStore) GetByNameSpaceAndProfile(namespace, profile string) (map[string]string, error) {
+	store, err := l.get()
+	if err != nil {
+		return nil, err
+	}
+	return store.GetByNameSpaceAndProfile(namespace, profile)
+}
+
+func (l *lazyStore) List(namespace, profile string, options ListOptions) (*Page, error) {
+	store, err := l.get()
+	if err != nil {
+		return nil, err
+	}
+	return store.List(namespace, profile, options)
+}
+
+func (l *lazyStore) Namespaces() (map[string][]string, error) {
+	store, err := l.get()
+	if err != nil {
+		return nil, err
+	}
+	return store.Namespaces()
+}
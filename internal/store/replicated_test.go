@@ -0,0 +1,131 @@
+package store
+
+import (
+	"errors"
+	"reflect"
+	"stoo-kv/config"
+	"stoo-kv/internal/provider"
+	"testing"
+)
+
+// partialStore lists the namespaces of its store without the hidden profile, as a listing that
+// missed keys would, while its keys are still read one by one.
+type partialStore struct {
+	Store
+	hidden string
+}
+
+func (p *partialStore) Namespaces() (map[string][]string, error) {
+	namespaces, err := p.Store.Namespaces()
+	if err != nil {
+		return nil, err
+	}
+	for namespace, profiles := range namespaces {
+		var kept []string
+		for _, profile := range profiles {
+			if profile != p.hidden {
+				kept = append(kept, profile)
+			}
+		}
+		namespaces[namespace] = kept
+	}
+	return namespaces, nil
+}
+
+func memoryWith(t *testing.T, values map[string]string) *provider.Memory {
+	t.Helper()
+	memory := provider.NewMemory()
+	for key, value := range values {
+		if err := memory.Set(key, value); err != nil {
+			t.Fatalf("Set %s: %v", key, err)
+		}
+	}
+	return memory
+}
+
+func TestReconcile(t *testing.T) {
+	prefixed := map[string]string{
+		"app::prod::a":        "1",
+		"app::prod::db::url":  "url",
+		"app::prod-eu::a":     "2",
+		"app::prod-eu::b":     "3",
+		"app-eu::prod::a":     "4",
+		"app::production::db": "5",
+	}
+	tests := []struct {
+		name      string
+		primary   map[string]string
+		secondary map[string]string
+		// hidden is a profile missing from the listing of the primary.
+		hidden string
+		want   map[string]string
+	}{
+		{
+			name:    "profiles prefixing others are copied apart",
+			primary: prefixed,
+			want:    prefixed,
+		},
+		{
+			name:      "profiles prefixing others are repaired apart",
+			primary:   prefixed,
+			secondary: map[string]string{"app::prod::a": "old", "app::prod-eu::stale": "x", "app::prod::stale": "y"},
+			want:      prefixed,
+		},
+		{
+			name:      "keys missing from the listing are kept",
+			primary:   prefixed,
+			secondary: map[string]string{"app::prod-eu::a": "old", "app::prod::stale": "y"},
+			hidden:    "prod-eu",
+			want: map[string]string{
+				"app::prod::a":        "1",
+				"app::prod::db::url":  "url",
+				"app::prod-eu::a":     "2",
+				"app-eu::prod::a":     "4",
+				"app::production::db": "5",
+			},
+		},
+		{
+			name:      "nothing is deleted while the primary lists no keys",
+			primary:   map[string]string{},
+			secondary: map[string]string{"app::prod::a": "1"},
+			want:      map[string]string{"app::prod::a": "1"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			secondary := memoryWith(t, test.secondary)
+			primary := &partialStore{Store: memoryWith(t, test.primary), hidden: test.hidden}
+			replicated := NewReplicatedStore(Replica{Name: "primary", Store: primary}, []Replica{{Name: "secondary", Store: secondary}}, "", nil)
+			if err := replicated.Reconcile(); err != nil {
+				t.Fatalf("Reconcile: %v", err)
+			}
+			got, err := allValues(secondary)
+			if err != nil {
+				t.Fatalf("allValues: %v", err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got secondary %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestReplicatedDelete(t *testing.T) {
+	primary := memoryWith(t, map[string]string{"app::prod::a": "1"})
+	secondary := memoryWith(t, map[string]string{"app::prod::a": "1", "app::prod::stale": "2"})
+	replicated := NewReplicatedStore(Replica{Name: "primary", Store: primary}, []Replica{{Name: "secondary", Store: secondary}}, config.MirrorWritesSync, nil)
+	if err := replicated.Delete("app::prod::a"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	// A key the primary does not have is reported missing and removed from the secondaries.
+	if err := replicated.Delete("app::prod::stale"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Delete of a key missing from the primary: got %v, want ErrNotFound", err)
+	}
+	got, err := allValues(secondary)
+	if err != nil {
+		t.Fatalf("allValues: %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("got secondary %v, want no keys", got)
+	}
+}
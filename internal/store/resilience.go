@@ -0,0 +1,195 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"stoo-kv/config"
+	"stoo-kv/internal/provider"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrTimeout is returned when an operation outlasts storage_timeout and its context cancelled
+	// it. A write the backend already received may still be applied. It matches ErrUnavailable.
+	ErrTimeout = provider.Mark(errors.New("storage operation timed out"), ErrUnavailable)
+	// ErrCircuitOpen is returned without calling the backend after it failed
+	// storage_breaker_threshold times in a row, until storage_breaker_cooldown passed. It matches
+	// ErrUnavailable.
+	ErrCircuitOpen = provider.Mark(errors.New("storage is unavailable, retrying after the cooldown"), ErrUnavailable)
+)
+
+// Policy is how operations of a backend are bounded, retried and cut off when it is down.
+type Policy struct {
+	Timeout          time.Duration
+	MaxAttempts      int
+	RetryBackoff     time.Duration
+	RetryMaxBackoff  time.Duration
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+}
+
+func NewPolicy(app *config.ApplicationConfig) (Policy, error) {
+	policy := Policy{MaxAttempts: app.StorageMaxAttempts, BreakerThreshold: app.StorageBreakerThreshold}
+	for _, d := range []struct {
+		name  string
+		value string
+		to    *time.Duration
+	}{
+		{"storage_timeout", app.StorageTimeout, &policy.Timeout},
+		{"storage_retry_backoff", app.StorageRetryBackoff, &policy.RetryBackoff},
+		{"storage_retry_max_backoff", app.StorageRetryMaxBackoff, &policy.RetryMaxBackoff},
+		{"storage_breaker_cooldown", app.StorageBreakerCooldown, &policy.BreakerCooldown},
+	} {
+		duration, err := time.ParseDuration(d.value)
+		if err != nil {
+			return Policy{}, fmt.Errorf("invalid %s %q", d.name, d.value)
+		}
+		*d.to = duration
+	}
+	return policy, nil
+}
+
+// resilientStore applies a Policy to every operation of a Store. Only transient errors are
+// retried and count towards the circuit breaker; Set and Delete are idempotent, so retrying them
+// is safe. Errors are returned classified, see provider.Classify.
+type resilientStore struct {
+	store  Store
+	policy Policy
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+func NewResilientStore(store Store, policy Policy) Store {
+	return &resilientStore{store: store, policy: policy}
+}
+
+func (r *resilientStore) Set(key string, value any) error {
+	_, err := run(r, func(store Store) (struct{}, error) {
+		return struct{}{}, store.Set(key, value)
+	})
+	return err
+}
+
+func (r *resilientStore) Get(key string) (string, error) {
+	return run(r, func(store Store) (string, error) {
+		return store.Get(key)
+	})
+}
+
+func (r *resilientStore) Delete(key string) error {
+	_, err := run(r, func(store Store) (struct{}, error) {
+		return struct{}{}, store.Delete(key)
+	})
+	return err
+}
+
+func (r *resilientStore) GetByNameSpaceAndProfile(namespace, profile string) (map[string]string, error) {
+	return run(r, func(store Store) (map[string]string, error) {
+		return store.GetByNameSpaceAndProfile(namespace, profile)
+	})
+}
+
+func (r *resilientStore) List(namespace, profile string, options ListOptions) (*Page, error) {
+	return run(r, func(store Store) (*Page, error) {
+		return store.List(namespace, profile, options)
+	})
+}
+
+func (r *resilientStore) Namespaces() (map[string][]string, error) {
+	return run(r, func(store Store) (map[string][]string, error) {
+		return store.Namespaces()
+	})
+}
+
+func run[T any](r *resilientStore, op func(store Store) (T, error)) (T, error) {
+	var zero T
+	if err := r.allow(); err != nil {
+		return zero, err
+	}
+	backoff := r.policy.RetryBackoff
+	for attempt := 1; ; attempt++ {
+		result, err := withTimeout(r.store, r.policy.Timeout, op)
+		transient := errors.Is(err, ErrTimeout) || provider.IsTransient(err)
+		if err == nil || !transient || attempt >= r.policy.MaxAttempts {
+			r.record(transient)
+			return result, provider.Classify(err)
+		}
+		// Full jitter keeps retrying clients from hitting a recovering backend at once.
+		time.Sleep(time.Duration(rand.Int63n(int64(backoff) + 1)))
+		if backoff *= 2; backoff > r.policy.RetryMaxBackoff {
+			backoff = r.policy.RetryMaxBackoff
+		}
+	}
+}
+
+// withTimeout runs op on the store bound to a context cancelled after timeout. Stores that do not
+// take a context run op unbounded.
+func withTimeout[T any](store Store, timeout time.Duration, op func(store Store) (T, error)) (T, error) {
+	if timeout <= 0 {
+		return op(store)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	result, err := op(bind(store, ctx))
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		var zero T
+		return zero, ErrTimeout
+	}
+	return result, err
+}
+
+// bind returns the store with its operations bound to ctx, or the store itself when it does not
+// take a context.
+func bind(store Store, ctx context.Context) Store {
+	if contextStore, ok := store.(ContextStore); ok {
+		return contextStore.WithContext(ctx)
+	}
+	return store
+}
+
+// allow fails fast while the circuit is open. Once the cooldown passed, a single operation probes
+// the backend and the others keep failing fast until it returns.
+func (r *resilientStore) allow() error {
+	if r.policy.BreakerThreshold <= 0 {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failures < r.policy.BreakerThreshold {
+		return nil
+	}
+	if r.probing || time.Now().Before(r.openUntil) {
+		return ErrCircuitOpen
+	}
+	r.probing = true
+	return nil
+}
+
+// record counts consecutive transient failures and opens the circuit at the threshold.
+func (r *resilientStore) record(failed bool) {
+	if r.policy.BreakerThreshold <= 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.probing = false
+	if !failed {
+		if r.failures >= r.policy.BreakerThreshold {
+			log.Printf("Storage recovered, closing the circuit")
+		}
+		r.failures = 0
+		return
+	}
+	r.failures++
+	if r.failures >= r.policy.BreakerThreshold {
+		r.openUntil = time.Now().Add(r.policy.BreakerCooldown)
+		log.Printf("Storage failed %d times in a row, failing fast for %s", r.failures, r.policy.BreakerCooldown)
+	}
+}
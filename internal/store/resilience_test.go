@@ -0,0 +1,118 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// errBlock makes flakyStore wait for its context to be cancelled.
+var errBlock = errors.New("block until cancelled")
+
+// flakyStore fails its Get calls with errs, one per call, and succeeds once they are used up.
+type flakyStore struct {
+	Store
+	errs  []error
+	calls int
+	ctx   context.Context
+}
+
+func (f *flakyStore) WithContext(ctx context.Context) Store {
+	f.ctx = ctx
+	return f
+}
+
+func (f *flakyStore) Get(key string) (string, error) {
+	f.calls++
+	if len(f.errs) == 0 {
+		return "value", nil
+	}
+	err := f.errs[0]
+	f.errs = f.errs[1:]
+	if err == errBlock {
+		<-f.ctx.Done()
+		return "", f.ctx.Err()
+	}
+	return "", err
+}
+
+func testPolicy() Policy {
+	return Policy{Timeout: 20 * time.Millisecond, MaxAttempts: 3, RetryBackoff: time.Millisecond, RetryMaxBackoff: 2 * time.Millisecond}
+}
+
+func TestResilientStoreRetries(t *testing.T) {
+	invalid := Invalid(errors.New("invalid key"))
+	tests := []struct {
+		name      string
+		errs      []error
+		wantCalls int
+		wantErr   error
+	}{
+		{name: "success", wantCalls: 1},
+		{name: "transient error, then success", errs: []error{syscall.ECONNREFUSED}, wantCalls: 2},
+		{name: "transient errors up to the attempts", errs: []error{syscall.ECONNRESET, syscall.ECONNRESET, syscall.ECONNRESET}, wantCalls: 3, wantErr: ErrUnavailable},
+		{name: "timeout, then success", errs: []error{errBlock}, wantCalls: 2},
+		{name: "timeouts up to the attempts", errs: []error{errBlock, errBlock, errBlock}, wantCalls: 3, wantErr: ErrTimeout},
+		{name: "not found", errs: []error{ErrNotFound}, wantCalls: 1, wantErr: ErrNotFound},
+		{name: "invalid", errs: []error{invalid}, wantCalls: 1, wantErr: ErrInvalid},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			flaky := &flakyStore{errs: test.errs}
+			_, err := NewResilientStore(flaky, testPolicy()).Get("app::prod::key")
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("got error %v, want %v", err, test.wantErr)
+			}
+			if flaky.calls != test.wantCalls {
+				t.Errorf("got %d calls, want %d", flaky.calls, test.wantCalls)
+			}
+		})
+	}
+}
+
+func TestResilientStoreBreaker(t *testing.T) {
+	policy := testPolicy()
+	policy.MaxAttempts = 1
+	policy.BreakerThreshold = 2
+	policy.BreakerCooldown = 30 * time.Millisecond
+	flaky := &flakyStore{}
+	store := NewResilientStore(flaky, policy)
+
+	steps := []struct {
+		name string
+		// cooldown waits for the breaker cooldown before the step.
+		cooldown  bool
+		errs      []error
+		wantErr   error
+		wantCalls int
+	}{
+		{name: "first failure", errs: []error{syscall.ECONNREFUSED}, wantErr: ErrUnavailable, wantCalls: 1},
+		{name: "failure at the threshold opens", errs: []error{syscall.ECONNREFUSED}, wantErr: ErrUnavailable, wantCalls: 2},
+		{name: "open fails fast", wantErr: ErrCircuitOpen, wantCalls: 2},
+		{name: "failed probe reopens", cooldown: true, errs: []error{syscall.ECONNREFUSED}, wantErr: ErrUnavailable, wantCalls: 3},
+		{name: "reopened fails fast", wantErr: ErrCircuitOpen, wantCalls: 3},
+		{name: "successful probe closes", cooldown: true, wantCalls: 4},
+		{name: "closed calls the backend", wantCalls: 5},
+		{name: "invalid request", errs: []error{Invalid(errors.New("invalid key"))}, wantErr: ErrInvalid, wantCalls: 6},
+		{name: "invalid requests do not count", errs: []error{Invalid(errors.New("invalid key"))}, wantErr: ErrInvalid, wantCalls: 7},
+		{name: "closed after invalid requests", wantCalls: 8},
+	}
+	for _, step := range steps {
+		if step.cooldown {
+			time.Sleep(policy.BreakerCooldown)
+		}
+		flaky.errs = step.errs
+		_, err := store.Get("app::prod::key")
+		if !errors.Is(err, step.wantErr) {
+			t.Errorf("%s: got error %v, want %v", step.name, err, step.wantErr)
+		}
+		if flaky.calls != step.wantCalls {
+			t.Errorf("%s: got %d calls, want %d", step.name, flaky.calls, step.wantCalls)
+		}
+	}
+	if !errors.Is(ErrCircuitOpen, ErrUnavailable) || !errors.Is(ErrTimeout, ErrUnavailable) {
+		t.Errorf("ErrCircuitOpen and ErrTimeout must match ErrUnavailable")
+	}
+}
@@ -0,0 +1,122 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"stoo-kv/internal/provider"
+	"sync"
+	"sync/atomic"
+)
+
+// Snapshot is a local copy of every key, read last when the primary and secondary storages fail.
+// Values are kept as stored, so secrets stay encrypted in the file. Writes update the copy in
+// memory; Save writes it to the file.
+type Snapshot struct {
+	file   string
+	memory atomic.Pointer[provider.Memory]
+	mu     sync.Mutex
+}
+
+// LoadSnapshot reads the snapshot file, starting empty when it does not exist yet.
+func LoadSnapshot(file string) (*Snapshot, error) {
+	s := &Snapshot{file: file}
+	values := make(map[string]string)
+	data, err := os.ReadFile(file)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &values); err != nil {
+			return nil, err
+		}
+	}
+	s.Replace(values)
+	return s, nil
+}
+
+// Replace swaps the copy for values, keyed by namespace::profile::key.
+func (s *Snapshot) Replace(values map[string]string) {
+	memory := provider.NewMemory()
+	for key, value := range values {
+		_ = memory.Set(key, value)
+	}
+	s.memory.Store(memory)
+}
+
+// Save writes the copy to the file, replacing it at once so readers never see half of it.
+func (s *Snapshot) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	values, err := allValues(s.memory.Load())
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	temp, err := os.CreateTemp(filepath.Dir(s.file), filepath.Base(s.file)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), s.file)
+}
+
+func (s *Snapshot) Set(key string, value any) error {
+	return s.memory.Load().Set(key, value)
+}
+
+func (s *Snapshot) Get(key string) (string, error) {
+	return s.memory.Load().Get(key)
+}
+
+func (s *Snapshot) Delete(key string) error {
+	return s.memory.Load().Delete(key)
+}
+
+func (s *Snapshot) GetByNameSpaceAndProfile(namespace, profile string) (map[string]string, error) {
+	return s.memory.Load().GetByNameSpaceAndProfile(namespace, profile)
+}
+
+func (s *Snapshot) List(namespace, profile string, options ListOptions) (*Page, error) {
+	return s.memory.Load().List(namespace, profile, options)
+}
+
+func (s *Snapshot) Namespaces() (map[string][]string, error) {
+	return s.memory.Load().Namespaces()
+}
+
+// allValues reads every key of a storage, keyed by namespace::profile::key.
+func allValues(storage Store) (map[string]string, error) {
+	namespaces, err := storage.Namespaces()
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]string)
+	for namespace, profiles := range namespaces {
+		for _, profile := range profiles {
+			keyValues, err := storage.GetByNameSpaceAndProfile(namespace, profile)
+			if err != nil {
+				return nil, err
+			}
+			for key, value := range keyValues {
+				values[fullKey(namespace, profile, key)] = value
+			}
+		}
+	}
+	return values, nil
+}
+
+func fullKey(namespace, profile, key string) string {
+	return namespace + "::" + profile + "::" + key
+}
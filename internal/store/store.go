@@ -2,26 +2,93 @@ package store
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log"
 	"stoo-kv/config"
 	"stoo-kv/internal/provider"
 )
 
-type Store interface {
-	Set(key string, value any) error
-	Get(key string) (string, error)
-	Delete(key string) error
-	//GetAll() (map[string]string, error)
-	GetByNameSpaceAndProfile(namespace, profile string) (map[string]string, error)
+// SystemNamespace is reserved for stookv's own data such as namespace schemas.
+const SystemNamespace = "_stookv"
+
+type (
+	Store        = provider.Store
+	ContextStore = provider.ContextStore
+	ListOptions  = provider.ListOptions
+	KeyValue     = provider.KeyValue
+	Page         = provider.Page
+)
+
+// Errors of every Store, matched with errors.Is. Errors of a backend that failed for good are
+// marked as ErrUnavailable or ErrConflict, keeping their message.
+var (
+	ErrNotFound    = provider.ErrNotFound
+	ErrConflict    = provider.ErrConflict
+	ErrUnavailable = provider.ErrUnavailable
+	ErrInvalid     = provider.ErrInvalid
+)
+
+// Invalid marks an error of a rejected request as ErrInvalid.
+func Invalid(err error) error {
+	return provider.Mark(err, ErrInvalid)
 }
 
+// NewStorage opens the backend of storage_type and applies the storage timeout, retry and circuit
+// breaker settings to it. With secondary_storage_types or a snapshot_file, it is the primary of a
+// ReplicatedStore.
 func NewStorage(config *config.Config) (Store, error) {
-	switch config.Application.StorageType {
+	policy, err := NewPolicy(config.Application)
+	if err != nil {
+		return nil, err
+	}
+	app := config.Application
+	if len(app.SecondaryStorageTypes) == 0 && app.SnapshotFile == "" {
+		return openStorage(config, app.StorageType, policy)
+	}
+
+	lazy := &lazyStore{open: func() (Store, error) {
+		return openProvider(config, app.StorageType)
+	}}
+	if _, err := lazy.get(); err != nil {
+		log.Printf("Primary storage %s is unavailable, reading from the secondary storages until it opens: %v", app.StorageType, err)
+	}
+	// Opening the primary is retried and cut off by the circuit breaker like its operations.
+	primary := NewResilientStore(lazy, policy)
+	var secondaries []Replica
+	for _, storageType := range app.SecondaryStorageTypes {
+		secondary, err := openStorage(config, storageType, policy)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open secondary storage %s: %v", storageType, err)
+		}
+		secondaries = append(secondaries, Replica{Name: storageType, Store: secondary})
+	}
+	var snapshot *Snapshot
+	if app.SnapshotFile != "" {
+		if snapshot, err = LoadSnapshot(app.SnapshotFile); err != nil {
+			return nil, fmt.Errorf("failed to load snapshot_file: %v", err)
+		}
+	}
+	return NewReplicatedStore(Replica{Name: app.StorageType, Store: primary}, secondaries, app.MirrorWrites, snapshot), nil
+}
+
+func openStorage(config *config.Config, storageType string, policy Policy) (Store, error) {
+	storage, err := openProvider(config, storageType)
+	if err != nil {
+		return nil, err
+	}
+	if storageType == "" || storageType == "memory" {
+		return storage, nil
+	}
+	return NewResilientStore(storage, policy), nil
+}
+
+func openProvider(config *config.Config, storageType string) (Store, error) {
+	switch storageType {
 	case "redis":
-		return provider.NewRedisClient(context.Background(), config), nil
-	case "mysql":
-		return provider.NewMySql(config)
-	case "postgres":
-		return provider.NewPostgres(config)
+		return provider.NewRedisClient(context.Background(), config)
+	case "mysql", "postgres", "sqlite":
+		return provider.NewRdbms(config, storageType)
 	case "mongo":
 		return provider.NewMongoClient(context.TODO(), config)
 	case "etcd":
@@ -30,3 +97,23 @@ func NewStorage(config *config.Config) (Store, error) {
 		return provider.NewMemory(), nil
 	}
 }
+
+// Remove deletes a key that may not exist.
+func Remove(storage Store, key string) error {
+	if err := storage.Delete(key); err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	return nil
+}
+
+func SystemKey(profile, key string) string {
+	return fmt.Sprintf("%s::%s::%s", SystemNamespace, profile, key)
+}
+
+// FeatureNamespace is the first path segment of the REST endpoints of stookv's features, such as
+// /stoo-kv/_/schemas, so it cannot name a namespace of keys.
+const FeatureNamespace = "_"
+
+func IsReserved(namespace string) bool {
+	return namespace == SystemNamespace || namespace == FeatureNamespace
+}
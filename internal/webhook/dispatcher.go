@@ -0,0 +1,494 @@
+package webhook
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"stoo-kv/config"
+	"stoo-kv/internal/crypto"
+	"stoo-kv/internal/store"
+	"sync"
+	"time"
+)
+
+const (
+	subscriptionProfile = "webhooks"
+	historyProfile      = "webhook_history"
+	deadLetterProfile   = "webhook_dead_letters"
+
+	EventKeySet     = "key.set"
+	EventKeyDeleted = "key.deleted"
+
+	DeliverySucceeded = "delivered"
+	DeliveryFailed    = "failed"
+
+	// historySize is the number of deliveries kept per subscription.
+	historySize    = 100
+	redactedSecret = "********"
+	// subscriptionRefresh is how often cached subscriptions are read again, picking up the changes
+	// made by other servers sharing the storage.
+	subscriptionRefresh = time.Minute
+)
+
+// Subscription sends the changes of a namespace to a URL. An empty profile matches every profile
+// and empty events match every event.
+type Subscription struct {
+	ID        string    `json:"id"`
+	Namespace string    `json:"namespace"`
+	Profile   string    `json:"profile,omitempty"`
+	Events    []string  `json:"events,omitempty"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Event is the payload posted to subscribers. Values are never sent, only the changed key.
+type Event struct {
+	ID        string    `json:"id"`
+	Event     string    `json:"event"`
+	Namespace string    `json:"namespace"`
+	Profile   string    `json:"profile"`
+	Key       string    `json:"key"`
+	Secret    bool      `json:"secret,omitempty"`
+	Actor     string    `json:"actor,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Delivery records the attempts made to post an event to a subscription.
+type Delivery struct {
+	ID             string    `json:"id"`
+	SubscriptionID string    `json:"subscription_id"`
+	Event          *Event    `json:"event"`
+	Status         string    `json:"status"`
+	Attempts       int       `json:"attempts"`
+	Error          string    `json:"error,omitempty"`
+	DeliveredAt    time.Time `json:"delivered_at"`
+}
+
+func (s *Subscription) validate(allowedHosts []string) error {
+	if s.Namespace == "" {
+		return fmt.Errorf("namespace cannot be empty")
+	}
+	if s.URL == "" {
+		return fmt.Errorf("url cannot be empty")
+	}
+	if err := CheckURL(s.URL, allowedHosts); err != nil {
+		return err
+	}
+	for _, event := range s.Events {
+		if event != EventKeySet && event != EventKeyDeleted {
+			return fmt.Errorf("unsupported event %q", event)
+		}
+	}
+	return nil
+}
+
+func (s *Subscription) matches(event *Event) bool {
+	if s.Namespace != event.Namespace || (s.Profile != "" && s.Profile != event.Profile) {
+		return false
+	}
+	if len(s.Events) == 0 {
+		return true
+	}
+	for _, name := range s.Events {
+		if name == event.Event {
+			return true
+		}
+	}
+	return false
+}
+
+// Dispatcher posts key change events to the matching subscriptions from a bounded queue, retrying
+// failed deliveries with exponential backoff before moving them to the dead-letter list.
+// Subscriptions are cached and read again when they change.
+type Dispatcher struct {
+	storage     store.Store
+	config      *config.Config
+	sender      *Sender
+	maxAttempts int
+	backoff     time.Duration
+	queue       chan *Delivery
+	mu          sync.Mutex
+
+	cacheMu sync.Mutex
+	cache   []*Subscription
+	// version counts the changes of subscriptions, so that a read started before a change is not cached.
+	version int
+}
+
+func NewDispatcher(storage store.Store, config *config.Config, sender *Sender, maxAttempts int, backoff time.Duration, queueSize int) *Dispatcher {
+	return &Dispatcher{
+		storage:     storage,
+		config:      config,
+		sender:      sender,
+		maxAttempts: maxAttempts,
+		backoff:     backoff,
+		queue:       make(chan *Delivery, queueSize),
+	}
+}
+
+// Run delivers the queued events with the given number of workers and refreshes the cached
+// subscriptions until the context is done.
+func (d *Dispatcher) Run(ctx context.Context, workers int) {
+	for i := 0; i < workers; i++ {
+		go d.work(ctx)
+	}
+	ticker := time.NewTicker(subscriptionRefresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := d.refresh(); err != nil {
+				log.Printf("Failed to refresh webhook subscriptions: %v", err)
+			}
+		}
+	}
+}
+
+// Notify queues an event for the subscriptions of its namespace and profile.
+func (d *Dispatcher) Notify(event *Event) {
+	if d == nil {
+		return
+	}
+	subscriptions, err := d.cached()
+	if err != nil {
+		log.Printf("Failed to read webhook subscriptions: %v", err)
+		return
+	}
+	event.ID, event.Timestamp = newID(), time.Now().UTC()
+	for _, subscription := range subscriptions {
+		if subscription.matches(event) {
+			d.enqueue(&Delivery{ID: newID(), SubscriptionID: subscription.ID, Event: event})
+		}
+	}
+}
+
+// Subscribed reports whether a subscription matches the event, for callers to skip work done only
+// for the subscribers.
+func (d *Dispatcher) Subscribed(event *Event) bool {
+	if d == nil {
+		return false
+	}
+	subscriptions, err := d.cached()
+	if err != nil {
+		log.Printf("Failed to read webhook subscriptions: %v", err)
+		return false
+	}
+	for _, subscription := range subscriptions {
+		if subscription.matches(event) {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Dispatcher) Subscribe(subscription *Subscription) (*Subscription, error) {
+	if err := subscription.validate(d.config.Current().WebhookAllowedHosts); err != nil {
+		return nil, store.Invalid(err)
+	}
+	subscription.ID, subscription.CreatedAt = newID(), time.Now().UTC()
+	if subscription.Secret != "" {
+		ciphertext, err := crypto.Encrypt([]byte(subscription.Secret), d.config.Application.EncryptKey)
+		if err != nil {
+			return nil, err
+		}
+		subscription.Secret = hex.EncodeToString(ciphertext)
+	}
+	data, err := json.Marshal(subscription)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.storage.Set(store.SystemKey(subscriptionProfile, subscription.ID), string(data)); err != nil {
+		return nil, err
+	}
+	d.invalidate()
+	return subscription.redacted(), nil
+}
+
+func (d *Dispatcher) Subscription(id string) (*Subscription, error) {
+	subscription, err := d.subscription(id)
+	if err != nil || subscription == nil {
+		return nil, err
+	}
+	return subscription.redacted(), nil
+}
+
+// Subscriptions lists the subscriptions of a namespace, or all of them when namespace is empty.
+func (d *Dispatcher) Subscriptions(namespace string) ([]*Subscription, error) {
+	all, err := d.refresh()
+	if err != nil {
+		return nil, err
+	}
+	subscriptions := make([]*Subscription, 0, len(all))
+	for _, subscription := range all {
+		if namespace == "" || subscription.Namespace == namespace {
+			subscriptions = append(subscriptions, subscription.redacted())
+		}
+	}
+	return subscriptions, nil
+}
+
+// Unsubscribe removes a subscription together with its history and dead letters.
+func (d *Dispatcher) Unsubscribe(id string) (bool, error) {
+	subscription, err := d.subscription(id)
+	if err != nil || subscription == nil {
+		return false, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, key := range []string{
+		store.SystemKey(historyProfile, id),
+		store.SystemKey(deadLetterProfile, id),
+		store.SystemKey(subscriptionProfile, id),
+	} {
+		if err := store.Remove(d.storage, key); err != nil {
+			return false, err
+		}
+	}
+	d.invalidate()
+	return true, nil
+}
+
+// History returns the latest deliveries of a subscription, newest first.
+func (d *Dispatcher) History(id string) ([]*Delivery, error) {
+	return d.deliveries(historyProfile, id)
+}
+
+func (d *Dispatcher) DeadLetters(id string) ([]*Delivery, error) {
+	return d.deliveries(deadLetterProfile, id)
+}
+
+// Redeliver takes a delivery off the dead-letter list and posts its event again.
+func (d *Dispatcher) Redeliver(id, deliveryID string) (*Delivery, error) {
+	d.mu.Lock()
+	deadLetters, err := d.deliveries(deadLetterProfile, id)
+	if err != nil {
+		d.mu.Unlock()
+		return nil, err
+	}
+	var found *Delivery
+	remaining := deadLetters[:0]
+	for _, delivery := range deadLetters {
+		if delivery.ID == deliveryID {
+			found = delivery
+			continue
+		}
+		remaining = append(remaining, delivery)
+	}
+	if found != nil {
+		err = d.saveDeliveries(deadLetterProfile, id, remaining)
+	}
+	d.mu.Unlock()
+	if found == nil || err != nil {
+		return nil, err
+	}
+	d.enqueue(&Delivery{ID: newID(), SubscriptionID: id, Event: found.Event})
+	return found, nil
+}
+
+// enqueue queues a delivery attempt without blocking; when the queue is full the delivery is
+// dead-lettered right away, so that it can be redelivered later.
+func (d *Dispatcher) enqueue(delivery *Delivery) {
+	select {
+	case d.queue <- delivery:
+	default:
+		log.Printf("Webhook delivery queue is full, dead-lettering delivery %s", delivery.ID)
+		delivery.Status, delivery.Error = DeliveryFailed, "webhook delivery queue is full"
+		d.finish(delivery)
+	}
+}
+
+func (d *Dispatcher) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case delivery := <-d.queue:
+			d.attempt(delivery)
+		}
+	}
+}
+
+// attempt posts a delivery once. A failed attempt is queued again after the backoff, doubled on
+// each attempt, until maxAttempts is reached.
+func (d *Dispatcher) attempt(delivery *Delivery) {
+	subscription, err := d.cachedSubscription(delivery.SubscriptionID)
+	if err == nil && subscription == nil {
+		log.Printf("Dropping delivery of %s to removed subscription %s", delivery.Event.ID, delivery.SubscriptionID)
+		return
+	}
+	delivery.Attempts++
+	if err == nil {
+		err = d.sender.Post(subscription.URL, d.secret(subscription), delivery.Event.Event, delivery.Event)
+	}
+	if err == nil {
+		delivery.Status, delivery.Error = DeliverySucceeded, ""
+		d.finish(delivery)
+		return
+	}
+	delivery.Status, delivery.Error = DeliveryFailed, err.Error()
+	log.Printf("Webhook delivery %s to subscription %s failed (attempt %d): %v", delivery.ID, delivery.SubscriptionID, delivery.Attempts, err)
+	if delivery.Attempts >= d.maxAttempts {
+		d.finish(delivery)
+		return
+	}
+	time.AfterFunc(d.backoff<<(delivery.Attempts-1), func() {
+		d.enqueue(delivery)
+	})
+}
+
+// finish records a delivery in the history of its subscription, and in its dead letters when it failed.
+func (d *Dispatcher) finish(delivery *Delivery) {
+	delivery.DeliveredAt = time.Now().UTC()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.record(historyProfile, delivery.SubscriptionID, delivery); err != nil {
+		log.Printf("Failed to record webhook delivery %s: %v", delivery.ID, err)
+	}
+	if delivery.Status == DeliveryFailed {
+		if err := d.record(deadLetterProfile, delivery.SubscriptionID, delivery); err != nil {
+			log.Printf("Failed to dead-letter webhook delivery %s: %v", delivery.ID, err)
+		}
+	}
+}
+
+// cached returns the cached subscriptions, reading them when they are not cached yet.
+func (d *Dispatcher) cached() ([]*Subscription, error) {
+	d.cacheMu.Lock()
+	cache := d.cache
+	d.cacheMu.Unlock()
+	if cache != nil {
+		return cache, nil
+	}
+	return d.refresh()
+}
+
+func (d *Dispatcher) cachedSubscription(id string) (*Subscription, error) {
+	subscriptions, err := d.cached()
+	if err != nil {
+		return nil, err
+	}
+	for _, subscription := range subscriptions {
+		if subscription.ID == id {
+			return subscription, nil
+		}
+	}
+	return nil, nil
+}
+
+// refresh reads every subscription from the storage, oldest first, and caches them.
+func (d *Dispatcher) refresh() ([]*Subscription, error) {
+	d.cacheMu.Lock()
+	version := d.version
+	d.cacheMu.Unlock()
+	values, err := d.storage.GetByNameSpaceAndProfile(store.SystemNamespace, subscriptionProfile)
+	if err != nil {
+		return nil, err
+	}
+	subscriptions := make([]*Subscription, 0, len(values))
+	for id, data := range values {
+		subscription := &Subscription{}
+		if err := json.Unmarshal([]byte(data), subscription); err != nil {
+			log.Printf("Skipping invalid webhook subscription %s: %v", id, err)
+			continue
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	sort.Slice(subscriptions, func(i, j int) bool { return subscriptions[i].CreatedAt.Before(subscriptions[j].CreatedAt) })
+	d.cacheMu.Lock()
+	if d.version == version {
+		d.cache = subscriptions
+	}
+	d.cacheMu.Unlock()
+	return subscriptions, nil
+}
+
+func (d *Dispatcher) invalidate() {
+	d.cacheMu.Lock()
+	d.cache = nil
+	d.version++
+	d.cacheMu.Unlock()
+}
+
+func (d *Dispatcher) secret(subscription *Subscription) string {
+	if subscription.Secret == "" {
+		return d.config.Current().WebhookSigningSecret
+	}
+	secret, err := crypto.Decrypt([]byte(subscription.Secret), d.config.Application.EncryptKey)
+	if err == nil {
+		return string(secret)
+	}
+	log.Printf("Failed to decrypt secret of webhook subscription %s: %v", subscription.ID, err)
+	return d.config.Current().WebhookSigningSecret
+}
+
+func (d *Dispatcher) subscription(id string) (*Subscription, error) {
+	data, err := d.storage.Get(store.SystemKey(subscriptionProfile, id))
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	subscription := &Subscription{}
+	return subscription, json.Unmarshal([]byte(data), subscription)
+}
+
+// record prepends a delivery to a list, keeping at most historySize entries. Callers hold d.mu.
+func (d *Dispatcher) record(profile, id string, delivery *Delivery) error {
+	deliveries, err := d.deliveries(profile, id)
+	if err != nil {
+		return err
+	}
+	deliveries = append([]*Delivery{delivery}, deliveries...)
+	if len(deliveries) > historySize {
+		deliveries = deliveries[:historySize]
+	}
+	return d.saveDeliveries(profile, id, deliveries)
+}
+
+func (d *Dispatcher) deliveries(profile, id string) ([]*Delivery, error) {
+	data, err := d.storage.Get(store.SystemKey(profile, id))
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var deliveries []*Delivery
+	return deliveries, json.Unmarshal([]byte(data), &deliveries)
+}
+
+func (d *Dispatcher) saveDeliveries(profile, id string, deliveries []*Delivery) error {
+	if len(deliveries) == 0 {
+		return store.Remove(d.storage, store.SystemKey(profile, id))
+	}
+	data, err := json.Marshal(deliveries)
+	if err != nil {
+		return err
+	}
+	return d.storage.Set(store.SystemKey(profile, id), string(data))
+}
+
+func (s *Subscription) redacted() *Subscription {
+	redacted := *s
+	if redacted.Secret != "" {
+		redacted.Secret = redactedSecret
+	}
+	return &redacted
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,217 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"stoo-kv/config"
+	"stoo-kv/internal/provider"
+	"stoo-kv/internal/store"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyServer fails the first failures requests with a 500 and records when each request arrived.
+type flakyServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	failures int
+	arrivals []time.Time
+}
+
+func newFlakyServer(t *testing.T, failures int) *flakyServer {
+	t.Helper()
+	server := &flakyServer{failures: failures}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		server.arrivals = append(server.arrivals, time.Now())
+		if len(server.arrivals) <= server.failures {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newDispatcher(t *testing.T, allowedHosts []string, maxAttempts, queueSize int) *Dispatcher {
+	t.Helper()
+	cfg := &config.Config{Application: &config.ApplicationConfig{
+		EncryptKey:          "0123456789abcdef0123456789abcdef",
+		WebhookAllowedHosts: allowedHosts,
+	}}
+	sender := NewSender(time.Second, func() []string { return cfg.Current().WebhookAllowedHosts })
+	return NewDispatcher(provider.NewMemory(), cfg, sender, maxAttempts, 10*time.Millisecond, queueSize)
+}
+
+// waitFor polls the deliveries of a subscription until there are want of them.
+func waitFor(t *testing.T, deliveries func(string) ([]*Delivery, error), id string, want int) []*Delivery {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got, err := deliveries(id)
+		if err != nil {
+			t.Fatalf("deliveries: %v", err)
+		}
+		if len(got) >= want || time.Now().After(deadline) {
+			if len(got) != want {
+				t.Fatalf("got %d deliveries, want %d", len(got), want)
+			}
+			return got
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func keySet() *Event {
+	return &Event{Event: EventKeySet, Namespace: "app", Profile: "prod", Key: "db"}
+}
+
+func TestDispatcherRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int
+		wantStatus   string
+		wantAttempts int
+	}{
+		{name: "delivered at once", wantStatus: DeliverySucceeded, wantAttempts: 1},
+		{name: "delivered after retries", failures: 2, wantStatus: DeliverySucceeded, wantAttempts: 3},
+		{name: "dead-lettered after the last attempt", failures: 3, wantStatus: DeliveryFailed, wantAttempts: 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newFlakyServer(t, test.failures)
+			dispatcher := newDispatcher(t, []string{"127.0.0.1"}, 3, 10)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go dispatcher.Run(ctx, 1)
+			subscription, err := dispatcher.Subscribe(&Subscription{Namespace: "app", URL: server.URL})
+			if err != nil {
+				t.Fatalf("Subscribe: %v", err)
+			}
+
+			dispatcher.Notify(keySet())
+			history := waitFor(t, dispatcher.History, subscription.ID, 1)
+			if history[0].Status != test.wantStatus || history[0].Attempts != test.wantAttempts {
+				t.Errorf("got %s after %d attempts, want %s after %d", history[0].Status, history[0].Attempts, test.wantStatus, test.wantAttempts)
+			}
+			wantDeadLetters := 0
+			if test.wantStatus == DeliveryFailed {
+				wantDeadLetters = 1
+			}
+			waitFor(t, dispatcher.DeadLetters, subscription.ID, wantDeadLetters)
+
+			// Retries wait for the backoff, doubled on each attempt.
+			server.mu.Lock()
+			arrivals := server.arrivals
+			server.mu.Unlock()
+			if len(arrivals) != test.wantAttempts {
+				t.Fatalf("got %d requests, want %d", len(arrivals), test.wantAttempts)
+			}
+			for i := 1; i < len(arrivals); i++ {
+				if gap, backoff := arrivals[i].Sub(arrivals[i-1]), dispatcher.backoff<<(i-1); gap < backoff {
+					t.Errorf("attempt %d came %v after the previous one, want at least %v", i+1, gap, backoff)
+				}
+			}
+		})
+	}
+}
+
+func TestDispatcherRedeliver(t *testing.T) {
+	server := newFlakyServer(t, 1)
+	dispatcher := newDispatcher(t, []string{"127.0.0.1"}, 1, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go dispatcher.Run(ctx, 1)
+	subscription, err := dispatcher.Subscribe(&Subscription{Namespace: "app", URL: server.URL})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	dispatcher.Notify(keySet())
+	deadLetters := waitFor(t, dispatcher.DeadLetters, subscription.ID, 1)
+	if _, err := dispatcher.Redeliver(subscription.ID, deadLetters[0].ID); err != nil {
+		t.Fatalf("Redeliver: %v", err)
+	}
+	history := waitFor(t, dispatcher.History, subscription.ID, 2)
+	if history[0].Status != DeliverySucceeded || history[0].Event.ID != deadLetters[0].Event.ID {
+		t.Errorf("got redelivery %+v, want event %s delivered", history[0], deadLetters[0].Event.ID)
+	}
+	waitFor(t, dispatcher.DeadLetters, subscription.ID, 0)
+}
+
+func TestDispatcherQueueFull(t *testing.T) {
+	server := newFlakyServer(t, 0)
+	// Without workers, the queue holds one delivery and the next one is dead-lettered.
+	dispatcher := newDispatcher(t, []string{"127.0.0.1"}, 3, 1)
+	subscription, err := dispatcher.Subscribe(&Subscription{Namespace: "app", URL: server.URL})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	dispatcher.Notify(keySet())
+	dispatcher.Notify(keySet())
+	deadLetters := waitFor(t, dispatcher.DeadLetters, subscription.ID, 1)
+	if deadLetters[0].Error != "webhook delivery queue is full" || deadLetters[0].Attempts != 0 {
+		t.Errorf("got dead letter %+v, want the full queue reported without attempts", deadLetters[0])
+	}
+	if len(dispatcher.queue) != 1 {
+		t.Errorf("got %d queued deliveries, want 1", len(dispatcher.queue))
+	}
+}
+
+func TestDispatcherSubscribe(t *testing.T) {
+	tests := []struct {
+		name         string
+		subscription *Subscription
+		allowedHosts []string
+		wantErr      string
+	}{
+		{name: "public host", subscription: &Subscription{Namespace: "app", URL: "https://hooks.example.com"}},
+		{name: "loopback refused by default", subscription: &Subscription{Namespace: "app", URL: "http://127.0.0.1:8080"}, wantErr: "is not public"},
+		{name: "metadata address refused by default", subscription: &Subscription{Namespace: "app", URL: "http://169.254.169.254"}, wantErr: "is not public"},
+		{name: "allowed loopback", subscription: &Subscription{Namespace: "app", URL: "http://127.0.0.1:8080"}, allowedHosts: []string{"127.0.0.1"}},
+		{name: "missing namespace", subscription: &Subscription{URL: "https://hooks.example.com"}, wantErr: "namespace cannot be empty"},
+		{name: "unsupported event", subscription: &Subscription{Namespace: "app", URL: "https://hooks.example.com", Events: []string{"key.read"}}, wantErr: `unsupported event "key.read"`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := newDispatcher(t, test.allowedHosts, 3, 1).Subscribe(test.subscription)
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Subscribe: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) || !errors.Is(err, store.ErrInvalid) {
+				t.Fatalf("got error %v, want an invalid subscription: %s", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestSubscribed(t *testing.T) {
+	dispatcher := newDispatcher(t, nil, 3, 1)
+	if dispatcher.Subscribed(keySet()) {
+		t.Fatalf("Subscribed without subscriptions")
+	}
+	if _, err := dispatcher.Subscribe(&Subscription{Namespace: "app", Profile: "prod", Events: []string{EventKeyDeleted}, URL: "https://hooks.example.com"}); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	tests := []struct {
+		event *Event
+		want  bool
+	}{
+		{event: &Event{Event: EventKeyDeleted, Namespace: "app", Profile: "prod"}, want: true},
+		{event: &Event{Event: EventKeySet, Namespace: "app", Profile: "prod"}},
+		{event: &Event{Event: EventKeyDeleted, Namespace: "app", Profile: "dev"}},
+		{event: &Event{Event: EventKeyDeleted, Namespace: "other", Profile: "prod"}},
+	}
+	for _, test := range tests {
+		if got := dispatcher.Subscribed(test.event); got != test.want {
+			t.Errorf("Subscribed(%+v) = %v, want %v", test.event, got, test.want)
+		}
+	}
+}
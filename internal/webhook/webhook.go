@@ -0,0 +1,156 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	SignatureHeader = "X-Stoo-Signature"
+	TimestampHeader = "X-Stoo-Timestamp"
+	EventHeader     = "X-Stoo-Event"
+)
+
+// Sign computes the signature sent in the X-Stoo-Signature header: the hex HMAC-SHA256 of
+// "<timestamp>.<body>" keyed with the webhook secret, prefixed with "sha256=".
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a signature produced by Sign, e.g. in a receiving service.
+func Verify(secret string, timestamp int64, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+// nonPublicNetworks are ranges that net.IP does not classify, though they do not reach the internet:
+// "this network" and the carrier-grade NAT range, where some clouds serve instance metadata.
+var nonPublicNetworks = []*net.IPNet{
+	{IP: net.IPv4(0, 0, 0, 0), Mask: net.CIDRMask(8, 32)},
+	{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)},
+}
+
+// publicIP reports whether an address is on the internet rather than loopback, private, link-local
+// (such as the 169.254.169.254 metadata service), unspecified or multicast.
+func publicIP(ip net.IP) bool {
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, network := range nonPublicNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckURL accepts http and https URLs whose host is allowed: when allowedHosts is empty any host
+// but localhost and addresses that are not public, else a host listed in it or, for entries like
+// "*.example.com", one of its subdomains. Host names resolving to addresses that are not public are
+// refused when the Sender connects.
+func CheckURL(target string, allowedHosts []string) error {
+	u, err := url.Parse(target)
+	if err != nil {
+		return fmt.Errorf("invalid url: %v", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return fmt.Errorf("url must be an absolute http or https url")
+	}
+	host := strings.ToLower(u.Hostname())
+	if len(allowedHosts) == 0 {
+		if ip := net.ParseIP(host); (ip != nil && !publicIP(ip)) || host == "localhost" || strings.HasSuffix(host, ".localhost") {
+			return fmt.Errorf("host %s is not public; list it in webhook_allowed_hosts to allow it", u.Hostname())
+		}
+		return nil
+	}
+	for _, allowed := range allowedHosts {
+		allowed = strings.ToLower(allowed)
+		if host == allowed || (strings.HasPrefix(allowed, "*.") && strings.HasSuffix(host, allowed[1:])) {
+			return nil
+		}
+	}
+	return fmt.Errorf("host %s is not listed in webhook_allowed_hosts", u.Hostname())
+}
+
+// Sender posts webhooks to the URLs allowed by allowedHosts, without following redirects or going
+// through proxies. While allowedHosts is empty it only connects to public addresses.
+type Sender struct {
+	client       *http.Client
+	allowedHosts func() []string
+}
+
+func NewSender(timeout time.Duration, allowedHosts func() []string) *Sender {
+	s := &Sender{allowedHosts: allowedHosts}
+	dialer := &net.Dialer{Timeout: timeout, Control: s.checkAddress}
+	s.client = &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: timeout},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return s
+}
+
+// checkAddress refuses connections to addresses that are not public unless hosts are allowed
+// explicitly, including host names resolving to them.
+func (s *Sender) checkAddress(network, address string, _ syscall.RawConn) error {
+	if len(s.allowedHosts()) > 0 {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if !publicIP(net.ParseIP(host)) {
+		return fmt.Errorf("address %s is not public; list its host in webhook_allowed_hosts to allow it", host)
+	}
+	return nil
+}
+
+// Post sends a JSON payload signed with the secret; responses other than 2xx are errors.
+func (s *Sender) Post(url, secret, event string, payload any) error {
+	if err := CheckURL(url, s.allowedHosts()); err != nil {
+		return err
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := time.Now().Unix()
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(EventHeader, event)
+	request.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	if secret != "" {
+		request.Header.Set(SignatureHeader, Sign(secret, timestamp, body))
+	}
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("webhook %s responded with status %d", url, response.StatusCode)
+	}
+	return nil
+}
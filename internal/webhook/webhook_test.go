@@ -0,0 +1,95 @@
+package webhook
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckURL(t *testing.T) {
+	allowed := []string{"hooks.example.com", "*.internal.example.com", "127.0.0.1"}
+	tests := []struct {
+		url          string
+		allowedHosts []string
+		wantErr      string
+	}{
+		{url: "https://hooks.example.com/stookv"},
+		{url: "http://93.184.216.34:8080/hook"},
+		{url: "ftp://hooks.example.com", wantErr: "url must be an absolute http or https url"},
+		{url: "/relative", wantErr: "url must be an absolute http or https url"},
+		{url: "http://localhost:8080", wantErr: "host localhost is not public"},
+		{url: "http://api.localhost", wantErr: "host api.localhost is not public"},
+		{url: "http://127.0.0.1", wantErr: "host 127.0.0.1 is not public"},
+		{url: "http://[::1]:9098", wantErr: "host ::1 is not public"},
+		{url: "http://10.1.2.3", wantErr: "host 10.1.2.3 is not public"},
+		{url: "http://192.168.0.10", wantErr: "host 192.168.0.10 is not public"},
+		{url: "http://169.254.169.254/latest/meta-data", wantErr: "host 169.254.169.254 is not public"},
+		{url: "http://100.100.100.200", wantErr: "host 100.100.100.200 is not public"},
+		{url: "http://0.0.0.0", wantErr: "host 0.0.0.0 is not public"},
+		{url: "http://[fd00::1]", wantErr: "host fd00::1 is not public"},
+		{url: "http://[::ffff:127.0.0.1]", wantErr: "host ::ffff:127.0.0.1 is not public"},
+		{url: "https://hooks.example.com", allowedHosts: allowed},
+		{url: "https://HOOKS.example.com", allowedHosts: allowed},
+		{url: "https://a.internal.example.com", allowedHosts: allowed},
+		{url: "http://127.0.0.1:8080", allowedHosts: allowed},
+		{url: "https://internal.example.com", allowedHosts: allowed, wantErr: "host internal.example.com is not listed in webhook_allowed_hosts"},
+		{url: "https://hooks.example.com.evil.com", allowedHosts: allowed, wantErr: "is not listed in webhook_allowed_hosts"},
+		{url: "http://169.254.169.254", allowedHosts: allowed, wantErr: "is not listed in webhook_allowed_hosts"},
+	}
+	for _, test := range tests {
+		t.Run(test.url, func(t *testing.T) {
+			err := CheckURL(test.url, test.allowedHosts)
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatalf("CheckURL: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Fatalf("got error %v, want %s", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"event":"key.set"}`)
+	signature := Sign("secret", 1714558800, body)
+	if !strings.HasPrefix(signature, "sha256=") || !Verify("secret", 1714558800, body, signature) {
+		t.Fatalf("signature %s does not verify", signature)
+	}
+	if Verify("other", 1714558800, body, signature) || Verify("secret", 1714558801, body, signature) || Verify("secret", 1714558800, []byte("{}"), signature) {
+		t.Errorf("signature %s verifies another secret, timestamp or body", signature)
+	}
+}
+
+func TestSenderRefusesAddresses(t *testing.T) {
+	received := make(chan *http.Request, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r
+	}))
+	defer server.Close()
+	var allowedHosts []string
+	sender := NewSender(time.Second, func() []string { return allowedHosts })
+	if err := sender.Post(server.URL, "", EventKeySet, &Event{}); err == nil || !strings.Contains(err.Error(), "host 127.0.0.1 is not public") {
+		t.Fatalf("Post to %s: got %v, want the host refused", server.URL, err)
+	}
+	// Host names that resolve to addresses that are not public are refused when connecting.
+	if err := sender.checkAddress("tcp", "127.0.0.1:443", nil); err == nil || !strings.Contains(err.Error(), "address 127.0.0.1 is not public") {
+		t.Fatalf("checkAddress: got %v, want the address refused", err)
+	}
+	if err := sender.checkAddress("tcp", "93.184.216.34:443", nil); err != nil {
+		t.Fatalf("checkAddress of a public address: %v", err)
+	}
+
+	allowedHosts = []string{"127.0.0.1"}
+	if err := sender.Post(server.URL, "secret", EventKeySet, &Event{Event: EventKeySet}); err != nil {
+		t.Fatalf("Post to an allowed host: %v", err)
+	}
+	request := <-received
+	if request.Header.Get(EventHeader) != EventKeySet || !strings.HasPrefix(request.Header.Get(SignatureHeader), "sha256=") {
+		t.Errorf("got headers %v, want the event and its signature", request.Header)
+	}
+}
@@ -2,10 +2,30 @@ package main
 
 import (
 	"log"
+	"os"
 	"stoo-kv/cmd"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "run" {
+		code, err := cmd.Run(os.Args[2:])
+		if err != nil {
+			log.Printf("Run failed: %v", err)
+		}
+		os.Exit(code)
+	}
+	if len(os.Args) > 1 && os.Args[1] == "agent" {
+		if err := cmd.Agent(os.Args[2:]); err != nil {
+			log.Fatalf("Agent failed: %v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := cmd.Migrate(os.Args[2:]); err != nil {
+			log.Fatalf("Migrate failed: %v", err)
+		}
+		return
+	}
 	if err := cmd.Start(); err != nil {
 		log.Fatalf("Server failed to start: %v", err)
 	}